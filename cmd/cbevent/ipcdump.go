@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/couchbase/eventing/consumer"
+)
+
+func ipcdump(requestsfn string, responsesfn string, capturefn string) {
+	if requestsfn != "" {
+		f, err := os.Open(requestsfn)
+		if err != nil {
+			log.Fatalf("Error opening requests capture %v: %v", requestsfn, err)
+		}
+		defer f.Close()
+
+		err = consumer.DumpWorkerRequests(f, os.Stdout)
+		if err != nil {
+			log.Fatalf("Error decoding requests capture %v: %v", requestsfn, err)
+		}
+	}
+
+	if responsesfn != "" {
+		f, err := os.Open(responsesfn)
+		if err != nil {
+			log.Fatalf("Error opening responses capture %v: %v", responsesfn, err)
+		}
+		defer f.Close()
+
+		err = consumer.DumpWorkerResponses(f, os.Stdout)
+		if err != nil {
+			log.Fatalf("Error decoding responses capture %v: %v", responsesfn, err)
+		}
+	}
+
+	if capturefn != "" {
+		files, err := consumer.IPCCaptureFiles(capturefn)
+		if err != nil {
+			log.Fatalf("Error locating capture %v: %v", capturefn, err)
+		}
+
+		err = consumer.DumpIPCCapture(files, os.Stdout)
+		if err != nil {
+			log.Fatalf("Error decoding capture %v: %v", capturefn, err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 type Command struct {
@@ -20,6 +21,16 @@ type Command struct {
 	Handler  string
 	CodeIn   string
 	CodeOut  string
+
+	IPCDump   bool
+	Requests  string
+	Responses string
+	Capture   string
+
+	Replay string
+	Worker string
+	Speed  float64
+	Linger time.Duration
 }
 
 func usage(fset *flag.FlagSet) {
@@ -35,6 +46,12 @@ Examples:
 - Pack/Unpack
     cbevent -unpack -handler handler.json -codeout code.js
     cbevent -pack -handler handler.json -codein code.js
+
+- Worker IPC
+    cbevent -ipcdump -requests producer_to_worker.bin -responses worker_to_producer.bin
+    cbevent -ipcdump -capture worker_myfunc_0.ipccap
+    cbevent -replay worker_myfunc_0.ipccap
+    cbevent -replay worker_myfunc_0.ipccap -worker /opt/couchbase/bin/eventing-consumer -speed 1
     `)
 }
 
@@ -47,19 +64,30 @@ func validate(fset *flag.FlagSet, cmd *Command) error {
 
 	case cmd.List, cmd.Dump:
 		have = []string{"list", "user", "password", "host"}
-		dont = []string{"flush", "unpack", "pack", "codein", "codeout", "handler"}
+		dont = []string{"flush", "unpack", "pack", "codein", "codeout", "handler", "ipcdump", "requests", "responses", "capture", "replay", "worker", "speed", "linger"}
 
 	case cmd.Flush:
 		have = []string{"flush", "user", "password", "host"}
-		dont = []string{"list", "unpack", "pack", "codein", "codeout", "handler"}
+		dont = []string{"list", "unpack", "pack", "codein", "codeout", "handler", "ipcdump", "requests", "responses", "capture", "replay", "worker", "speed", "linger"}
 
 	case cmd.Unpack:
 		have = []string{"unpack", "codeout", "handler"}
-		dont = []string{"user", "password", "host", "list", "flush", "pack", "codein"}
+		dont = []string{"user", "password", "host", "list", "flush", "pack", "codein", "ipcdump", "requests", "responses", "capture", "replay", "worker", "speed", "linger"}
 
 	case cmd.Pack:
 		have = []string{"pack", "codein", "handler"}
-		dont = []string{"user", "password", "host", "list", "flush", "unpack", "codeout"}
+		dont = []string{"user", "password", "host", "list", "flush", "unpack", "codeout", "ipcdump", "requests", "responses", "capture", "replay", "worker", "speed", "linger"}
+
+	case cmd.IPCDump:
+		if cmd.Requests == "" && cmd.Responses == "" && cmd.Capture == "" {
+			return fmt.Errorf("Invalid flags. Flag 'requests', 'responses' or 'capture' is required for this operation")
+		}
+		have = []string{"ipcdump"}
+		dont = []string{"user", "password", "host", "list", "flush", "unpack", "pack", "codein", "codeout", "handler", "replay", "worker", "speed", "linger"}
+
+	case cmd.Replay != "":
+		have = []string{"replay"}
+		dont = []string{"user", "password", "host", "list", "flush", "unpack", "pack", "codein", "codeout", "handler", "ipcdump", "requests", "responses", "capture"}
 
 	default:
 		return fmt.Errorf("No operation specified")
@@ -130,6 +158,16 @@ func main() {
 	fset.StringVar(&cmd.CodeIn, "codein", "", "code to read and pack into handler")
 	fset.StringVar(&cmd.CodeOut, "codeout", "", "filename to write extracted code into")
 
+	fset.BoolVar(&cmd.IPCDump, "ipcdump", false, "decode captured messages exchanged between producer and worker")
+	fset.StringVar(&cmd.Requests, "requests", "", "capture of byte stream written by producer to worker")
+	fset.StringVar(&cmd.Responses, "responses", "", "capture of byte stream written by worker to producer")
+	fset.StringVar(&cmd.Capture, "capture", "", "capture recorded by a function with ipc_capture setting enabled")
+
+	fset.StringVar(&cmd.Replay, "replay", "", "capture to replay, recorded by a function with ipc_capture setting enabled")
+	fset.StringVar(&cmd.Worker, "worker", "", "eventing-consumer binary to replay into, a fake worker that decodes messages is used if unset")
+	fset.Float64Var(&cmd.Speed, "speed", 0, "replay speed relative to capture, 0 replays messages back to back")
+	fset.DurationVar(&cmd.Linger, "linger", 10*time.Second, "time given to worker to finish up after replay, before it's stopped")
+
 	if len(os.Args) <= 1 {
 		usage(fset)
 		os.Exit(0)
@@ -174,5 +212,9 @@ func main() {
 		pack(cmd.Handler, cmd.CodeIn)
 	case cmd.Unpack:
 		unpack(cmd.Handler, cmd.CodeOut)
+	case cmd.IPCDump:
+		ipcdump(cmd.Requests, cmd.Responses, cmd.Capture)
+	case cmd.Replay != "":
+		replay(cmd.Replay, cmd.Worker, cmd.Speed, cmd.Linger)
 	}
 }
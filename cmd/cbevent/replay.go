@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/couchbase/eventing/consumer"
+)
+
+// lineWriter lets several decoders share stdout without interleaving their lines
+type lineWriter struct {
+	mu     *sync.Mutex
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf.Write(p)
+
+	for {
+		idx := bytes.IndexByte(lw.buf.Bytes(), '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+
+		lw.mu.Lock()
+		fmt.Fprintf(os.Stdout, "%s%s", lw.prefix, lw.buf.Next(idx+1))
+		lw.mu.Unlock()
+	}
+}
+
+func replay(capture, worker string, speed float64, linger time.Duration) {
+	files, err := consumer.IPCCaptureFiles(capture)
+	if err != nil {
+		log.Fatalf("Error locating capture %v: %v", capture, err)
+	}
+
+	if worker == "" {
+		replayToFakeWorker(files, speed)
+	} else {
+		replayToWorker(files, worker, speed, linger)
+	}
+}
+
+// replayToFakeWorker feeds capture to an in-process worker that only decodes
+// messages, which is enough to check what a worker would have been handed
+func replayToFakeWorker(files []string, speed float64) {
+	r, w := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		err := consumer.DumpWorkerRequests(r, os.Stdout)
+		r.CloseWithError(err)
+		done <- err
+	}()
+
+	replayed, err := consumer.ReplayIPCCapture(files, w, speed)
+	w.Close()
+	if derr := <-done; derr != nil {
+		log.Fatalf("Fake worker failed to decode message: %d, err: %v", replayed, derr)
+	}
+	if err != nil {
+		log.Fatalf("Error replaying capture after %d messages: %v", replayed, err)
+	}
+
+	log.Printf("Replayed %d messages to fake worker", replayed)
+}
+
+// replayToWorker spawns a fresh eventing-consumer and feeds capture to it the way
+// producer would, printing out everything worker responds with
+func replayToWorker(files []string, worker string, speed float64, linger time.Duration) {
+	dir, err := ioutil.TempDir("", "cbevent_replay")
+	if err != nil {
+		log.Fatalf("Error creating scratch directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sockPath := filepath.Join(dir, "replay.sock")
+	feedbackSockPath := filepath.Join(dir, "f_replay.sock")
+
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		log.Fatalf("Error listening on %v: %v", sockPath, err)
+	}
+	defer listener.Close()
+
+	feedbackListener, err := net.Listen("unix", feedbackSockPath)
+	if err != nil {
+		log.Fatalf("Error listening on %v: %v", feedbackSockPath, err)
+	}
+	defer feedbackListener.Close()
+
+	// Arguments in the order producer passes them, config that matters to
+	// handler execution comes from init message in the capture itself
+	cmd := exec.Command(worker, "replay", "af_unix", sockPath, feedbackSockPath, "worker_replay_0",
+		"1", "1", dir, "ipv4", "false", "0", "eventing", "8091", "1024", "8096")
+	cmd.Env = append(os.Environ(), "CBEVT_CALLBACK_USR=replay", "CBEVT_CALLBACK_KEY=replay")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	// Worker exits once its stdin is closed
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Fatalf("Error opening worker stdin: %v", err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		log.Fatalf("Error spawning worker %v: %v", worker, err)
+	}
+
+	exitCh := make(chan error, 1)
+	go func() {
+		exitCh <- cmd.Wait()
+	}()
+
+	conns := make(chan net.Conn, 2)
+	for _, l := range []net.Listener{feedbackListener, listener} {
+		go func(l net.Listener) {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("Error accepting worker connection on %v: %v", l.Addr(), err)
+				return
+			}
+			conns <- conn
+		}(l)
+	}
+
+	mu := &sync.Mutex{}
+	var conn net.Conn
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-conns:
+			defer c.Close()
+			prefix := "feedback: "
+			if c.LocalAddr().String() == sockPath {
+				prefix = "main: "
+				conn = c
+			}
+			go consumer.DumpWorkerResponses(c, &lineWriter{mu: mu, prefix: prefix})
+
+		case err = <-exitCh:
+			reportWorkerExit(err, 0)
+			return
+		}
+	}
+
+	replayed, err := consumer.ReplayIPCCapture(files, conn, speed)
+	if err != nil {
+		log.Printf("Error replaying capture after %d messages: %v", replayed, err)
+	} else {
+		log.Printf("Replayed %d messages to worker pid: %d, waiting %v for it to settle", replayed, cmd.Process.Pid, linger)
+	}
+
+	select {
+	case err = <-exitCh:
+	case <-time.After(linger):
+		stdin.Close()
+		err = <-exitCh
+	}
+	reportWorkerExit(err, replayed)
+}
+
+func reportWorkerExit(err error, replayed int) {
+	if err == nil {
+		log.Printf("Worker exited cleanly after %d messages", replayed)
+		return
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			log.Fatalf("Worker crashed with signal: %v after %d messages", status.Signal(), replayed)
+		}
+	}
+	log.Fatalf("Worker exited after %d messages, err: %v", replayed, err)
+}
@@ -18,6 +18,7 @@ func main() {
 	logging.Infof("Started eventing producer version: %v", util.EventingVer())
 
 	util.SetIPv6(flags.ipv6)
+	util.SetIPCType(flags.ipcType)
 
 	audit.Init(flags.restPort)
 
@@ -93,6 +94,18 @@ func main() {
 		}
 	}(s)
 
+	// For draining and restarting workers of a function
+	go func(s *supervisor.SuperSupervisor) {
+		cancelCh := make(chan struct{})
+		for {
+			err := metakv.RunObserveChildren(supervisor.MetakvAppsRestartWorkersPath, s.AppsRestartWorkersCallback, cancelCh)
+			if err != nil {
+				logging.Errorf("Eventing::main metakv observe error for apps restart workers, err: %v. Retrying.", err)
+				time.Sleep(2 * time.Second)
+			}
+		}
+	}(s)
+
 	// For starting debugger
 	go func(s *supervisor.SuperSupervisor) {
 		cancelCh := make(chan struct{})
@@ -22,6 +22,7 @@ type Flags struct {
 	uuid          string
 	diagDir       string
 	ipv6          bool
+	ipcType       string
 	numVbuckets   int
 }
 
@@ -75,6 +76,10 @@ func initFlags() {
 		"ipv6", false,
 		"Enable ipv6 mode")
 
+	fset.StringVar(&flags.ipcType,
+		"ipcType", "",
+		"Transport to eventing-consumer: af_inet, af_unix or shm, picked based on platform if unset")
+
 	fset.IntVar(&flags.numVbuckets,
 		"vbuckets", 1024,
 		"Number of vbuckets configured in Couchbase")
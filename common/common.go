@@ -3,6 +3,7 @@ package common
 import (
 	"errors"
 	"net"
+	"time"
 
 	"github.com/couchbase/eventing/dcp"
 )
@@ -15,6 +16,12 @@ const (
 	DcpFromPrior  = DcpStreamBoundary("from_prior")
 )
 
+// Event sources a function can be fed from
+const (
+	EventSourceDcp  = "dcp"
+	EventSourceFile = "file"
+)
+
 var MetakvMaxRetries int64 = 60
 var LanguageCompatibility = []string{"6.0.0", "6.5.0"}
 
@@ -124,11 +131,15 @@ type EventingProducer interface {
 	AppendCurlLatencyStats(deltas StatsData)
 	AppendLatencyStats(deltas StatsData)
 	BootstrapStatus() bool
+	CancelTimers(query *TimerQuery) (int, error)
+	CancelTimersInRange(query *TimerQuery) error
 	CfgData() string
+	CheckTimers(request *TimerCheckRequest) error
 	CheckpointBlobDump() map[string]interface{}
 	CleanupMetadataBucket(skipCheckpointBlobs bool) error
 	CleanupUDSs()
 	ClearEventStats()
+	CreateTimer(request *TimerRequest) (*TimerInfo, error)
 	DcpFeedBoundary() string
 	GetAppCode() string
 	GetAppLog(sz int64) []string
@@ -140,6 +151,7 @@ type EventingProducer interface {
 	GetFailureStats() map[string]interface{}
 	GetLatencyStats() StatsData
 	GetCurlLatencyStats() StatsData
+	GetTimerFiringStats() map[int]*TimerFiringStats
 	GetInsight() *Insight
 	GetLcbExceptionsStats() map[string]uint64
 	GetMetaStoreStats() map[string]uint64
@@ -155,6 +167,7 @@ type EventingProducer interface {
 	KillAndRespawnEventingConsumer(consumer EventingConsumer)
 	KvHostPorts() []string
 	LenRunningConsumers() int
+	ListTimers(query *TimerQuery) (*TimerPage, error)
 	MetadataBucket() string
 	NotifyInit()
 	NotifyPrepareTopologyChange(ejectNodes, keepNodes []string)
@@ -168,6 +181,7 @@ type EventingProducer interface {
 	RebalanceStatus() bool
 	RebalanceTaskProgress() *RebalanceProgress
 	RemoveConsumerToken(workerName string)
+	RestartWorkers()
 	SignalBootstrapFinish()
 	SignalStartDebugger(token string) error
 	SignalStopDebugger() error
@@ -179,6 +193,8 @@ type EventingProducer interface {
 	StopProducer()
 	StopRunningConsumers()
 	String() string
+	TimerCancelStatus() *TimerCancelReport
+	TimerCheckStatus() *TimerCheckReport
 	TimerDebugStats() map[int]map[string]interface{}
 	IsTrapEvent() bool
 	SetTrapEvent(value bool)
@@ -199,6 +215,7 @@ type EventingConsumer interface {
 	CloseAllRunningDcpFeeds()
 	ConsumerName() string
 	DcpEventsRemainingToProcess() uint64
+	DrainForRestart(timeout time.Duration) error
 	EventingNodeUUIDs() []string
 	EventsProcessedPSec() *EventProcessingStats
 	GetEventProcessingStats() map[string]uint64
@@ -207,6 +224,7 @@ type EventingConsumer interface {
 	GetInsight() *Insight
 	GetLcbExceptionsStats() map[string]uint64
 	GetMetaStoreStats() map[string]uint64
+	GetTimerFiringStats() map[int]*TimerFiringStats
 	HandleV8Worker() error
 	HostPortAddr() string
 	Index() int
@@ -253,9 +271,13 @@ type EventingSuperSup interface {
 	BootstrapAppList() map[string]string
 	BootstrapAppStatus(appName string) bool
 	BootstrapStatus() bool
+	CancelTimers(appName string, query *TimerQuery) (int, error)
+	CancelTimersInRange(appName string, query *TimerQuery) error
 	CheckpointBlobDump(appName string) (interface{}, error)
+	CheckTimers(appName string, request *TimerCheckRequest) error
 	ClearEventStats()
 	CleanupProducer(appName string, skipMetaCleanup bool, updateMetakv bool) error
+	CreateTimer(appName string, request *TimerRequest) (*TimerInfo, error)
 	DcpFeedBoundary(fnName string) (string, error)
 	DeployedAppList() []string
 	GetEventProcessingStats(appName string) map[string]uint64
@@ -270,6 +292,7 @@ type EventingSuperSup interface {
 	GetFailureStats(appName string) map[string]interface{}
 	GetLatencyStats(appName string) StatsData
 	GetCurlLatencyStats(appName string) StatsData
+	GetTimerFiringStats(appName string) map[int]*TimerFiringStats
 	GetInsight(appName string) *Insight
 	GetLcbExceptionsStats(appName string) map[string]uint64
 	GetLocallyDeployedApps() map[string]string
@@ -278,8 +301,11 @@ type EventingSuperSup interface {
 	GetSeqsProcessed(appName string) map[int]int64
 	InternalVbDistributionStats(appName string) map[string]string
 	KillAllConsumers()
+	ListTimers(appName string, query *TimerQuery) (*TimerPage, error)
 	NotifyPrepareTopologyChange(ejectNodes, keepNodes []string)
+	PauseFunction(appName string) error
 	PlannerStats(appName string) []*PlannerNodeVbMapping
+	PurgeTimers(appName string) error
 	RebalanceStatus() bool
 	RebalanceTaskProgress(appName string) (*RebalanceProgress, error)
 	UnwatchBucket(bucketName string)
@@ -288,6 +314,8 @@ type EventingSuperSup interface {
 	SignalStopDebugger(appName string) error
 	SpanBlobDump(appName string) (interface{}, error)
 	StopProducer(appName string, skipMetaCleanup bool, updateMetakv bool)
+	TimerCancelStatus(appName string) (*TimerCancelReport, error)
+	TimerCheckStatus(appName string) (*TimerCheckReport, error)
 	TimerDebugStats(appName string) (map[int]map[string]interface{}, error)
 	VbDcpEventsRemainingToProcess(appName string) map[int]int64
 	VbDistributionStatsFromMetadata(appName string) map[string]map[string]string
@@ -351,36 +379,163 @@ type PlannerNodeVbMapping struct {
 	VbsCount int    `json:"vb_count"`
 }
 
+// TimerInfo describes a pending timer of a function
+type TimerInfo struct {
+	Partition  int         `json:"partition"`
+	Due        int64       `json:"due"`
+	Seq        int64       `json:"seq"`
+	Reference  string      `json:"reference,omitempty"`
+	Context    interface{} `json:"context"`
+	Schedule   interface{} `json:"schedule,omitempty"`
+	AlarmKey   string      `json:"alarm_key"`
+	ContextKey string      `json:"context_key"`
+}
+
+// TimerQuery selects pending timers by due time range, partition or reference.
+// Partition of -1 stands for all partitions. Series selects recurring timers.
+type TimerQuery struct {
+	From      int64
+	To        int64
+	Partition int
+	Reference string
+	Limit     int
+	Cursor    string
+	Series    bool
+}
+
+// TimerRequest sets a timer of a function from outside its handler. Due is in
+// unix seconds, and Callback names the handler function called when it fires.
+// With Schedule set, timer recurs on it rather than firing once at Due.
+type TimerRequest struct {
+	Callback  string      `json:"callback"`
+	Due       int64       `json:"due"`
+	Reference string      `json:"reference"`
+	Context   interface{} `json:"context"`
+	Schedule  interface{} `json:"schedule,omitempty"`
+}
+
+// TimerPage is one page of timers listed by due time, Next is the cursor to
+// fetch the page after it
+type TimerPage struct {
+	Timers []TimerInfo `json:"timers"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// TimerCancelReport tallies timers cancelled so far by a cancellation of those
+// due within a range, which runs in background
+type TimerCancelReport struct {
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	Partition int    `json:"partition"`
+	Running   bool   `json:"running"`
+	Started   string `json:"started,omitempty"`
+	Finished  string `json:"finished,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Cancelled int    `json:"cancelled"`
+}
+
+// TimerCheckRequest starts timer store checker of a function. Margin is the
+// seconds either side of span of a partition to look for timers left out of it.
+type TimerCheckRequest struct {
+	DryRun    bool
+	Partition int
+	Margin    int64
+}
+
+// TimerCheckReport tallies problems found by timer store checker, and how many
+// of them were repaired unless it's a dry run
+type TimerCheckReport struct {
+	DryRun         bool              `json:"dry_run"`
+	Running        bool              `json:"running"`
+	Started        string            `json:"started,omitempty"`
+	Finished       string            `json:"finished,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	Partitions     int               `json:"partitions"`
+	Rows           int               `json:"rows"`
+	Timers         int               `json:"timers"`
+	OrphanAlarms   int               `json:"orphan_alarms"`
+	StaleAlarms    int               `json:"stale_alarms"`
+	OrphanContexts int               `json:"orphan_contexts"`
+	MissingChunks  int               `json:"missing_chunks"`
+	EmptyRows      int               `json:"empty_rows"`
+	SpanGaps       int               `json:"span_gaps"`
+	Repaired       int               `json:"repaired"`
+	Issues         []TimerCheckIssue `json:"issues"`
+}
+
+type TimerCheckIssue struct {
+	Partition int    `json:"partition"`
+	Kind      string `json:"kind"`
+	Key       string `json:"key"`
+	Repaired  bool   `json:"repaired"`
+}
+
+// TimerFiringStats describes timers of a partition as they are fired. Fire lag
+// is in milliseconds past due, scan time in microseconds, and both are binned
+// as latency stats are.
+type TimerFiringStats struct {
+	Pending     int64     `json:"pending"`
+	FireLag     StatsData `json:"fire_lag"`
+	ScanTime    StatsData `json:"scan_time"`
+	ScanLookups StatsData `json:"scan_lookups"`
+}
+
 type HandlerConfig struct {
 	N1qlPrepareAll           bool
 	LanguageCompatibility    string
+	AdoptTimers              bool
 	AggDCPFeedMemCap         int64
+	AutoscaleEventBacklog    uint64
+	AutoscaleCPUThreshold    int
 	CheckpointInterval       int
 	IdleCheckpointInterval   int
 	CleanupTimers            bool
 	CPPWorkerThrCount        int
+	DcpEventBatchSize        int
+	EnrichedMeta             bool
+	EventSource              string
+	EventSourceFile          string
 	ExecuteTimerRoutineCount int
 	ExecutionTimeout         int
 	FeedbackBatchSize        int
 	FeedbackQueueCap         int64
 	FeedbackReadBufferSize   int
+	IdempotencyKeys          bool
+	IPCCapture               bool
+	IPCCaptureMaxFiles       int
+	IPCCaptureMaxSize        int64
 	HandlerHeaders           []string
 	HandlerFooters           []string
 	LcbInstCapacity          int
 	N1qlConsistency          string
+	PriorityField            string
+	PriorityFieldValue       string
+	PriorityKeyPrefixes      []string
 	LogLevel                 string
+	MaxCPPWorkerThrCount     int
+	MaxWorkerCount           int
+	MinCPPWorkerThrCount     int // cpp_worker_thread_count as set, which autoscaled thread count doesn't go below
+	MinWorkerCount           int
+	MissedTimerPolicy        string
+	MissedTimerRate          int64
+	MissedTimerThreshold     int64
+	RollbackPolicy           string
 	SocketWriteBatchSize     int
 	SocketTimeout            int
 	SourceBucket             string
 	StatsLogInterval         int
 	StreamBoundary           DcpStreamBoundary
+	TimerContextOverflow     string
 	TimerContextSize         int64
+	TimerInPastPolicy        string
+	TimerResolution          int64
 	TimerStorageRoutineCount int
 	TimerStorageChanSize     int
 	TimerQueueMemCap         uint64
 	TimerQueueSize           uint64
 	UndeployRoutineCount     int
 	UsingTimer               bool
+	WorkerAutoscale          bool
 	WorkerCount              int
 	WorkerQueueCap           int64
 	WorkerQueueMemCap        int64
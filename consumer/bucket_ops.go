@@ -411,7 +411,7 @@ var updateCheckpointCallback = func(args ...interface{}) error {
 
 retryUpdateCheckpoint:
 
-	_, err := c.gocbMetaBucket.MutateIn(vbKey.Raw(), 0, uint32(0)).
+	builder := c.gocbMetaBucket.MutateIn(vbKey.Raw(), 0, uint32(0)).
 		UpsertEx("assigned_worker", vbBlob.AssignedWorker, gocb.SubdocFlagCreatePath).
 		UpsertEx("bootstrap_stream_req_done", vbBlob.BootstrapStreamReqDone, gocb.SubdocFlagCreatePath).
 		UpsertEx("current_vb_owner", vbBlob.CurrentVBOwner, gocb.SubdocFlagCreatePath).
@@ -425,8 +425,14 @@ retryUpdateCheckpoint:
 		UpsertEx("previous_node_uuid", vbBlob.PreviousNodeUUID, gocb.SubdocFlagCreatePath).
 		UpsertEx("previous_vb_owner", vbBlob.PreviousVBOwner, gocb.SubdocFlagCreatePath).
 		UpsertEx("worker_requested_vb_stream", "", gocb.SubdocFlagCreatePath).
-		UpsertEx("last_processed_seq_no", vbBlob.LastSeqNoProcessed, gocb.SubdocFlagCreatePath).
-		Execute()
+		UpsertEx("last_processed_seq_no", vbBlob.LastSeqNoProcessed, gocb.SubdocFlagCreatePath)
+
+	// Handover state is left alone unless caller is moving it along
+	if vbBlob.Handover.State != "" {
+		builder = builder.UpsertEx("handover", vbBlob.Handover, gocb.SubdocFlagCreatePath)
+	}
+
+	_, err := builder.Execute()
 
 	if err == gocb.ErrKeyNotFound {
 		var vbBlob vbucketKVBlob
@@ -628,6 +634,46 @@ retrySRFUpdate:
 	return err
 }
 
+// Called when DCP Producer responds to STREAMREQ with ROLLBACK
+var addOwnershipHistoryRollbackCallback = func(args ...interface{}) error {
+	logPrefix := "Consumer::addOwnershipHistoryRollbackCallback"
+
+	c := args[0].(*Consumer)
+	vbKey := args[1].(common.Key)
+	ownershipEntry := args[2].(*OwnershipEntry)
+	rollbackPending := args[3].(bool)
+
+retryRollbackUpdate:
+	_, err := c.gocbMetaBucket.MutateIn(vbKey.Raw(), 0, uint32(0)).
+		ArrayAppend("ownership_history", ownershipEntry, true).
+		UpsertEx("last_checkpoint_time", time.Now().String(), gocb.SubdocFlagCreatePath).
+		UpsertEx("rollback_pending", rollbackPending, gocb.SubdocFlagCreatePath).
+		Execute()
+
+	if err == gocb.ErrShutdown {
+		return nil
+	}
+
+	if err == gocb.ErrKeyNotFound {
+		var vbBlob vbucketKVBlob
+
+		err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, recreateCheckpointBlobCallback, c, vbKey, &vbBlob)
+		if err == common.ErrRetryTimeout {
+			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+			return err
+		}
+
+		goto retryRollbackUpdate
+	}
+
+	if err != nil {
+		logging.Errorf("%s [%s:%s:%d] Key: %rm, subdoc operation failed post STREAMREQ ROLLBACK from Producer, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vbKey.Raw(), err)
+	}
+
+	return err
+}
+
 // Called when STREAMREQ success response is received from DCP Producer
 var addOwnershipHistorySRSCallback = func(args ...interface{}) error {
 	logPrefix := "Consumer::addOwnershipHistorySRSCallback"
@@ -649,6 +695,7 @@ retrySRSUpdate:
 		UpsertEx("node_requested_vb_stream", "", gocb.SubdocFlagCreatePath).
 		UpsertEx("node_uuid", vbBlob.NodeUUID, gocb.SubdocFlagCreatePath).
 		UpsertEx("node_uuid_requested_vb_stream", "", gocb.SubdocFlagCreatePath).
+		UpsertEx("rollback_pending", false, gocb.SubdocFlagCreatePath).
 		UpsertEx("vb_uuid", vbBlob.VBuuid, gocb.SubdocFlagCreatePath).
 		UpsertEx("worker_requested_vb_stream", "", gocb.SubdocFlagCreatePath).
 		Execute()
@@ -718,6 +765,33 @@ retrySEUpdate:
 	return err
 }
 
+var markVbHandoverPendingCallback = func(args ...interface{}) error {
+	logPrefix := "Consumer::markVbHandoverPendingCallback"
+
+	c := args[0].(*Consumer)
+	vbKey := args[1].(common.Key)
+
+	handover := vbHandover{
+		RequestTime: time.Now().Format(time.RFC3339),
+		State:       vbHandoverPending,
+	}
+
+	_, err := c.gocbMetaBucket.MutateIn(vbKey.Raw(), 0, uint32(0)).
+		UpsertEx("handover", handover, gocb.SubdocFlagCreatePath).
+		Execute()
+
+	if err == gocb.ErrShutdown || err == gocb.ErrKeyNotFound {
+		return nil
+	}
+
+	if err != nil {
+		logging.Errorf("%s [%s:%s:%d] Key: %rm, subdoc operation failed while marking vb handover pending, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vbKey.Raw(), err)
+	}
+
+	return err
+}
+
 var getFailoverLogOpCallback = func(args ...interface{}) error {
 	logPrefix := "Consumer::getFailoverLogOpCallback"
 
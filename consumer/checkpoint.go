@@ -136,6 +136,12 @@ func (c *Consumer) updateCheckpointInfo(vbKey string, vb uint16, vbBlob *vbucket
 	vbBlob.NextCronTimerToProcess = c.vbProcessingStats.getVbStat(vb, "next_cron_timer_to_process").(string)
 	vbBlob.VBuuid = c.vbProcessingStats.getVbStat(vb, "vb_uuid").(uint64)
 
+	c.eventSource.Ack(vb, vbBlob.LastSeqNoProcessed)
+	if pos, err := c.eventSource.CheckpointToken(vb); err == nil {
+		vbBlob.VBuuid = pos.VBuuid
+		vbBlob.LastSeqNoProcessed = pos.SeqNo
+	}
+
 	err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, periodicCheckpointCallback,
 		c, c.producer.AddMetadataPrefix(vbKey), vbBlob)
 	if err == common.ErrRetryTimeout {
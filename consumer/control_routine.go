@@ -171,15 +171,24 @@ func (c *Consumer) controlRoutine() error {
 					continue
 				}
 
+				vbKey := fmt.Sprintf("%s::vb::%d", c.app.AppName, vb)
+
+				// New owner holds off streaming vb until STREAMEND is handled and
+				// handover is confirmed
+				err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, markVbHandoverPendingCallback,
+					c, c.producer.AddMetadataPrefix(vbKey))
+				if err == common.ErrRetryTimeout {
+					logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+					return err
+				}
+
 				logging.Infof("%s [%s:%s:%d] vb: %d Issuing dcp close stream", logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
 				c.dcpCloseStreamCounter++
-				c.RLock()
-				err := c.vbDcpFeedMap[vb].DcpCloseStream(vb, vb)
-				c.RUnlock()
-				if err != nil {
+				closeErr := c.closeVbStream(vb)
+				if closeErr != nil {
 					c.dcpCloseStreamErrCounter++
 					logging.Errorf("%s [%s:%s:%d] vb: %v Failed to close dcp stream, err: %v",
-						logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, err)
+						logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, closeErr)
 				} else {
 					logging.Infof("%s [%s:%s:%d] vb: %v Issued dcp close stream as current worker isn't supposed to own per plan",
 						logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
@@ -190,7 +199,16 @@ func (c *Consumer) controlRoutine() error {
 				c.vbProcessingStats.updateVbStat(vb, "timestamp", time.Now().Format(time.RFC3339))
 
 				var vbBlob vbucketKVBlob
-				vbKey := fmt.Sprintf("%s::vb::%d", c.app.AppName, vb)
+
+				// No STREAMEND is coming to confirm handover, so confirm with what
+				// worker has processed so far
+				if closeErr != nil {
+					vbBlob.Handover = vbHandover{
+						RequestTime: time.Now().Format(time.RFC3339),
+						SeqNo:       c.vbProcessingStats.getVbStat(vb, "last_processed_seq_no").(uint64),
+						State:       vbHandoverConfirmed,
+					}
+				}
 
 				err = c.updateCheckpoint(vbKey, vb, &vbBlob)
 				if err == common.ErrRetryTimeout {
@@ -0,0 +1,119 @@
+package consumer
+
+import (
+	"sync/atomic"
+
+	"github.com/couchbase/eventing/gen/flatbuf/payload"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// dcpEventBatch accumulates dcp events bound for the same cpp worker thread,
+// which are written out as a single message once batch is full
+type dcpEventBatch struct {
+	builder   *flatbuffers.Builder
+	events    []flatbuffers.UOffsetT
+	partition int16 // Partition of first event in batch, worker routes the batch using it
+}
+
+// batchingDcpEvents reports whether dcp events are to be sent to worker in batches.
+// Worker has to speak protocol version 3 or above to be able to unpack them.
+func (c *Consumer) batchingDcpEvents() bool {
+	return c.dcpEventBatchSize > 1 && atomic.LoadInt32(&c.workerProtocolVersion) >= 3
+}
+
+// addToDcpEventBatch appends the event to batch of cpp worker thread owning the
+// partition and returns encoded size of the event
+func (c *Consumer) addToDcpEventBatch(opcode int8, partition int16, meta string, key, value []byte) int {
+	c.dcpEventBatchMutex.Lock()
+	defer c.dcpEventBatchMutex.Unlock()
+
+	thrIndex := c.cppPartitionThrMap[partition]
+
+	batch, ok := c.dcpEventBatches[thrIndex]
+	if !ok {
+		batch = &dcpEventBatch{
+			builder:   c.getBuilder(),
+			events:    make([]flatbuffers.UOffsetT, 0, c.dcpEventBatchSize),
+			partition: partition,
+		}
+		c.dcpEventBatches[thrIndex] = batch
+	}
+
+	// Payload of each event is encoded exactly as it would be for an unbatched
+	// event, so that worker can queue it up as is
+	scratch := c.dcpEventScratchBuilder
+	scratch.Reset()
+
+	keyPos := scratch.CreateByteString(key)
+	valPos := scratch.CreateByteString(value)
+	payload.PayloadStart(scratch)
+	payload.PayloadAddKey(scratch, keyPos)
+	payload.PayloadAddValue(scratch, valPos)
+	scratch.Finish(payload.PayloadEnd(scratch))
+	encodedPayload := scratch.FinishedBytes()
+
+	builder := batch.builder
+	payloadPos := builder.CreateByteVector(encodedPayload)
+	metaPos := builder.CreateString(meta)
+
+	payload.DcpEventStart(builder)
+	payload.DcpEventAddOpcode(builder, opcode)
+	payload.DcpEventAddMetadata(builder, metaPos)
+	payload.DcpEventAddPayload(builder, payloadPos)
+	batch.events = append(batch.events, payload.DcpEventEnd(builder))
+
+	atomic.AddInt32(&c.dcpBatchedEventCount, 1)
+
+	if len(batch.events) >= c.dcpEventBatchSize {
+		c.sendDcpEventBatchLocked(thrIndex, batch)
+	}
+
+	return len(encodedPayload)
+}
+
+// flushDcpEventBatches sends out partially filled batches. It's called before any
+// other message is sent to worker, as worker must see events in the order they
+// were read from DCP relative to control messages like vbucket filters.
+func (c *Consumer) flushDcpEventBatches() {
+	if atomic.LoadInt32(&c.dcpBatchedEventCount) == 0 {
+		return
+	}
+
+	c.dcpEventBatchMutex.Lock()
+	defer c.dcpEventBatchMutex.Unlock()
+
+	for thrIndex, batch := range c.dcpEventBatches {
+		c.sendDcpEventBatchLocked(thrIndex, batch)
+	}
+}
+
+func (c *Consumer) sendDcpEventBatchLocked(thrIndex int, batch *dcpEventBatch) {
+	delete(c.dcpEventBatches, thrIndex)
+	atomic.AddInt32(&c.dcpBatchedEventCount, -int32(len(batch.events)))
+
+	builder := batch.builder
+	payload.PayloadStartDcpEventsVector(builder, len(batch.events))
+	for i := len(batch.events) - 1; i >= 0; i-- {
+		builder.PrependUOffsetT(batch.events[i])
+	}
+	eventsPos := builder.EndVector(len(batch.events))
+
+	payload.PayloadStart(builder)
+	payload.PayloadAddDcpEvents(builder, eventsPos)
+	builder.Finish(payload.PayloadEnd(builder))
+
+	header, hBuilder := c.makeDcpHeader(dcpBatch, batch.partition, "", false)
+
+	m := &msgToTransmit{
+		msg: &message{
+			Header:  header,
+			Payload: builder.FinishedBytes(),
+		},
+		dcpEventBatch:  true,
+		headerBuilder:  hBuilder,
+		payloadBuilder: builder,
+	}
+
+	atomic.AddUint64(&c.dcpEventBatchesSent, 1)
+	c.sendMessage(m)
+}
@@ -0,0 +1,199 @@
+package consumer
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/dcp"
+	"github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/eventsource"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+)
+
+// dcpSource is the default event source, streaming vbuckets from the KV nodes
+// hosting them over a DCP feed per KV node. Feeds are fanned into aggDCPFeed
+// as they're started. KV flow control is handled within feeds, and checkpoints
+// are kept by consumer, so acks have nothing to do.
+type dcpSource struct {
+	c *Consumer
+}
+
+func newDcpSource(c *Consumer) *dcpSource {
+	return &dcpSource{c: c}
+}
+
+func (s *dcpSource) Name() string {
+	return fmt.Sprintf("dcp:%s", s.c.bucket)
+}
+
+func (s *dcpSource) Events() <-chan *memcached.DcpEvent {
+	return s.c.aggDCPFeed
+}
+
+// start spawns DCP feeds against all KV nodes up front
+func (s *dcpSource) start() error {
+	logPrefix := "dcpSource::start"
+
+	c := s.c
+	for _, kvHostPort := range c.getKvNodes() {
+		if atomic.LoadUint32(&c.isTerminateRunning) == 1 {
+			continue
+		}
+
+		feedName := couchbase.NewDcpFeedName(c.HostPortAddr() + "_" + kvHostPort + "_" + c.workerName)
+
+		c.hostDcpFeedRWMutex.Lock()
+		err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, startDCPFeedOpCallback, c, feedName, kvHostPort)
+		if err == common.ErrRetryTimeout {
+			c.hostDcpFeedRWMutex.Unlock()
+			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+			return err
+		}
+
+		logging.Infof("%s [%s:%s:%d] vbKvAddr: %s Spawned aggChan routine",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), kvHostPort)
+
+		c.addToAggChan(c.kvHostDcpFeedMap[kvHostPort])
+		c.hostDcpFeedRWMutex.Unlock()
+	}
+	return nil
+}
+
+// StartStream issues STREAMREQ for vb on feed of the KV node currently hosting
+// it, starting that feed if needed. Feed is dropped if the request fails for
+// any reason other than vb having moved away, so that it's started afresh.
+func (s *dcpSource) StartStream(vb uint16, pos eventsource.Position) error {
+	logPrefix := "dcpSource::StartStream"
+
+	c := s.c
+	refreshMap := func() error {
+		c.cbBucketRWMutex.Lock()
+		defer c.cbBucketRWMutex.Unlock()
+
+		var err error
+		c.cbBucket, err = c.superSup.GetBucket(c.cbBucket.Name)
+		if err != nil {
+			logging.Infof("%s [%s:%s:%d] vb: %d failed to refresh vbmap",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
+			return err
+		}
+		return nil
+	}
+
+	err := refreshMap()
+	if err != nil {
+		return err
+	}
+
+	err = util.Retry(util.NewFixedBackoff(clusterOpRetryInterval), c.retryCount, getKvVbMap, c)
+	if err == common.ErrRetryTimeout {
+		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+		return err
+	}
+
+	vbKvAddr := c.kvVbMap[vb]
+
+	// Closing feeds for KV hosts which are no more present in kv vb map
+	err = c.cleanupStaleDcpFeedHandles()
+	if err == common.ErrRetryTimeout {
+		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+		return err
+	}
+
+	c.hostDcpFeedRWMutex.Lock()
+	dcpFeed, ok := c.kvHostDcpFeedMap[vbKvAddr]
+	if !ok {
+		feedName := couchbase.NewDcpFeedName(c.HostPortAddr() + "_" + vbKvAddr + "_" + c.workerName)
+		err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, startDCPFeedOpCallback, c, feedName, vbKvAddr)
+		if err == common.ErrRetryTimeout {
+			c.hostDcpFeedRWMutex.Unlock()
+			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+			return err
+		}
+
+		dcpFeed = c.kvHostDcpFeedMap[vbKvAddr]
+
+		c.addToAggChan(dcpFeed)
+
+		logging.Infof("%s [%s:%s:%d] vb: %d kvAddr: %s Started up new dcp feed. Spawned aggChan routine",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbKvAddr)
+	}
+	c.hostDcpFeedRWMutex.Unlock()
+
+	c.Lock()
+	c.vbDcpFeedMap[vb] = dcpFeed
+	c.Unlock()
+
+	opaque, flags := uint16(vb), uint32(0)
+	end := uint64(0xFFFFFFFFFFFFFFFF)
+
+	logging.Infof("%s [%s:%s:%d] vb: %d DCP stream start vbKvAddr: %rs vbuuid: %d startSeq: %d snapshotStart: %d snapshotEnd: %d",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbKvAddr, pos.VBuuid, pos.SeqNo, pos.SnapStart, pos.SnapEnd)
+
+	err = refreshMap()
+	if err != nil {
+		return err
+	}
+
+	if atomic.LoadUint32(&c.isTerminateRunning) == 1 {
+		return fmt.Errorf("function is terminating")
+	}
+
+	err = dcpFeed.DcpRequestStream(vb, opaque, flags, pos.VBuuid, pos.SeqNo, end, pos.SnapStart, pos.SnapEnd)
+	if err == nil || err == couchbase.ErrorInvalidVbucket {
+		return err
+	}
+
+	logging.Errorf("%s [%s:%s:%d] vb: %d STREAMREQ call failed on dcpFeed: %v, err: %v",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, dcpFeed.GetName(), err)
+
+	dcpFeed.Close()
+
+	c.hostDcpFeedRWMutex.Lock()
+	delete(c.kvHostDcpFeedMap, vbKvAddr)
+	c.hostDcpFeedRWMutex.Unlock()
+
+	logging.Infof("%s [%s:%s:%d] vb: %d Closed and deleted dcpfeed mapping to kvAddr: %s",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbKvAddr)
+	return err
+}
+
+func (s *dcpSource) CloseStream(vb uint16) error {
+	c := s.c
+
+	c.RLock()
+	defer c.RUnlock()
+	return c.vbDcpFeedMap[vb].DcpCloseStream(vb, vb)
+}
+
+func (s *dcpSource) Ack(vb uint16, seqNo uint64) {
+}
+
+// CheckpointToken is where vb stream resumes from as per consumer's vb stats
+func (s *dcpSource) CheckpointToken(vb uint16) (eventsource.Position, error) {
+	stats := s.c.vbProcessingStats
+	seqNo := stats.getVbStat(vb, "last_processed_seq_no").(uint64)
+	return eventsource.Position{
+		VBuuid:    stats.getVbStat(vb, "vb_uuid").(uint64),
+		SeqNo:     seqNo,
+		SnapStart: seqNo,
+		SnapEnd:   seqNo,
+	}, nil
+}
+
+// Close closes feeds against all KV nodes
+func (s *dcpSource) Close() error {
+	c := s.c
+
+	c.hostDcpFeedRWMutex.RLock()
+	defer c.hostDcpFeedRWMutex.RUnlock()
+
+	for _, dcpFeed := range c.kvHostDcpFeedMap {
+		if dcpFeed != nil {
+			dcpFeed.Close()
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package consumer
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"hash/crc32"
 	"net"
 	"os/exec"
@@ -14,6 +15,7 @@ import (
 	"github.com/couchbase/eventing/dcp"
 	mcd "github.com/couchbase/eventing/dcp/transport"
 	cb "github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/eventsource"
 	"github.com/couchbase/eventing/suptree"
 	"github.com/google/flatbuffers/go"
 	"gopkg.in/couchbase/gocb.v1"
@@ -57,6 +59,21 @@ const (
 	socketWriteTimerInterval = time.Duration(100) * time.Millisecond
 
 	updateCPPStatsTickInterval = time.Duration(1000) * time.Millisecond
+
+	// Time to wait for C++ worker to respond to protocol version handshake
+	workerHandshakeTimeout = time.Duration(30) * time.Second
+
+	// Interval for polling worker queues while draining it ahead of restart
+	workerDrainPollInterval = time.Duration(1000) * time.Millisecond
+
+	// Time after which a vbucket handover that previous owner never confirmed is
+	// taken over regardless
+	vbHandoverTimeout = time.Duration(300) * time.Second
+
+	// Limits on events held back in consumer while worker queues are full, so that
+	// priority events behind them in DCP stream can still be read and sent
+	heldDcpEventsCap    = 10 * 1000
+	heldDcpEventsMemCap = 64 * 1024 * 1024
 )
 
 const (
@@ -64,6 +81,7 @@ const (
 	dcpStreamBootstrap             = "bootstrap"
 	dcpStreamRequested             = "stream_requested"
 	dcpStreamRequestFailed         = "stream_request_failed"
+	dcpStreamRollback              = "stream_rollback"
 	dcpStreamRunning               = "running"
 	dcpStreamStopped               = "stopped"
 	dcpStreamUninitialised         = ""
@@ -75,6 +93,22 @@ const (
 	xattrPrefix                    = "_eventing"
 )
 
+// States of vbucket handover between owners, recorded in metadata blob. Previous
+// owner marks it pending once it closes the stream, and confirmed once worker is
+// done with every event it was handed for the vbucket. New owner streams only from
+// a confirmed handover seq no.
+const (
+	vbHandoverPending   = "pending"
+	vbHandoverConfirmed = "confirmed"
+)
+
+// Actions taken when DCP producer asks for a rollback on stream request
+const (
+	rollbackPolicyReplay = "replay"
+	rollbackPolicySkip   = "skip"
+	rollbackPolicyPause  = "pause"
+)
+
 type xattrMetadata struct {
 	FunctionInstanceID string `json:"fiid"`
 	SeqNo              string `json:"seqno"`
@@ -91,18 +125,29 @@ type vbFlogEntry struct {
 }
 
 type dcpMetadata struct {
-	Cas     uint64 `json:"cas"`
-	DocID   string `json:"id"`
-	Expiry  uint32 `json:"expiration"`
-	Flag    uint32 `json:"flags"`
-	Vbucket uint16 `json:"vb"`
-	SeqNo   uint64 `json:"seq"`
+	Cas            uint64 `json:"cas"`
+	DocID          string `json:"id"`
+	Expiry         uint32 `json:"expiration"`
+	Flag           uint32 `json:"flags"`
+	Vbucket        uint16 `json:"vb"`
+	SeqNo          uint64 `json:"seq"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	*dcpEnrichedMetadata
+}
+
+// dcpEnrichedMetadata is added to meta only when enriched_meta is on
+type dcpEnrichedMetadata struct {
+	Datatype string                     `json:"datatype"`
+	LockTime uint32                     `json:"lock_time"`
+	RevSeqNo uint64                     `json:"rev_seqno"`
+	Xattrs   map[string]json.RawMessage `json:"xattrs"`
 }
 
 type vbSeqNo struct {
 	SeqNo   uint64 `json:"seq"`
 	SkipAck int    `json:"skip_ack"` // 0: false 1: true
 	Vbucket uint16 `json:"vb"`
+	Drain   int    `json:"drain,omitempty"` // 1: ack once worker is done with all events on vb
 }
 
 // Consumer is responsible interacting with c++ v8 worker over local tcp port
@@ -125,13 +170,14 @@ type Consumer struct {
 	// Captures aggregate of items in queue maintained for each V8Worker instance.
 	// Within a single CPP worker process, the number of V8Worker instance is equal
 	// to number of worker threads spawned
-	cppQueueSizes     *cppQueueSize
+	cppQueueSizes     *cppQueueSize // Access controlled by statsRWMutex, replaced rather than updated
 	feedbackQueueCap  int64
 	workerQueueCap    int64
 	workerQueueMemCap int64
 
 	cppThrPartitionMap    map[int][]uint16
-	cppWorkerThrCount     int // No. of worker threads per CPP worker process
+	cppPartitionThrMap    []int // Reverse of cppThrPartitionMap, indexed by partition
+	cppWorkerThrCount     int   // No. of worker threads per CPP worker process
 	crcTable              *crc32.Table
 	debugConn             net.Conn // Interface to support communication between Go and C++ worker spawned for debugging
 	debugFeedbackConn     net.Conn
@@ -158,6 +204,9 @@ type Consumer struct {
 	eventingSSLPort               string
 	eventingNodeAddrs             []string
 	eventingNodeUUIDs             []string
+	eventSource                   eventsource.EventSource
+	eventSourceFile               string
+	eventSourceType               string
 	executeTimerRoutineCount      int
 	executionTimeout              int
 	lcbRetryCount                 int
@@ -184,10 +233,14 @@ type Consumer struct {
 	nsServerPort                  string
 	reqStreamCh                   chan *streamRequestInfo
 	resetBootstrapDone            bool
+	rollbackPolicy                string
+	rollbackPauseRequested        uint32 // Set once function pause has been requested on account of a rollback
 	statsTickDuration             time.Duration
 	streamReqRWMutex              *sync.RWMutex
 	stoppingConsumer              bool
 	isPausing                     bool
+	drainingForRestart            uint32 // Set while worker is being drained ahead of a restart
+	dcpEventsParked               uint32 // Set once DCP event loop has stopped handing events to worker for drain
 	superSup                      common.EventingSuperSup
 	timerContextSize              int64
 	usingTimer                    bool
@@ -214,9 +267,10 @@ type Consumer struct {
 	workerVbucketMap              map[string][]uint16 // Access controlled by workerVbucketMapRWMutex
 	workerVbucketMapRWMutex       *sync.RWMutex
 
-	executionStats    map[string]interface{} // Access controlled by statsRWMutex
-	failureStats      map[string]interface{} // Access controlled by statsRWMutex
-	lcbExceptionStats map[string]uint64      // Access controlled by statsRWMutex
+	executionStats    map[string]interface{}           // Access controlled by statsRWMutex
+	failureStats      map[string]interface{}           // Access controlled by statsRWMutex
+	lcbExceptionStats map[string]uint64                // Access controlled by statsRWMutex
+	timerFiringStats  map[int]*common.TimerFiringStats // Access controlled by statsRWMutex
 	statsRWMutex      *sync.RWMutex
 
 	// Time when last response from CPP worker was received on main loop
@@ -285,6 +339,10 @@ type Consumer struct {
 	signalConnectedCh         chan struct{}
 	signalFeedbackConnectedCh chan struct{}
 
+	// Outcome of protocol version handshake with C++ worker
+	handshakeCh           chan *workerHandshake
+	workerProtocolVersion int32 // Negotiated protocol version, 0 until handshake completes
+
 	// Chan used by signal update of app handler settings
 	signalSettingsChangeCh chan struct{}
 
@@ -320,6 +378,65 @@ type Consumer struct {
 	dcpStreamReqCounter      uint64
 	dcpStreamReqErrCounter   uint64
 
+	dcpRollbackCounter        uint64
+	dcpRollbackPausedCounter  uint64
+	dcpRollbackSkippedCounter uint64
+
+	// DCP events batched per cpp worker thread, before being sent as a single message
+	dcpEventBatchSize      int
+	dcpEventBatches        map[int]*dcpEventBatch // Access controlled by dcpEventBatchMutex
+	dcpEventBatchMutex     *sync.Mutex
+	dcpEventScratchBuilder *flatbuffers.Builder // Access controlled by dcpEventBatchMutex
+	dcpBatchedEventCount   int32                // Events waiting in batches
+	dcpEventBatchesSent    uint64
+
+	// Stamps every event with a key that stays the same across replays of it
+	idempotencyKeys bool
+
+	// Passes xattrs and other document metadata on to handler's meta object
+	enrichedMeta bool
+
+	// Whether createTimer fires timers due in the past or rejects them
+	timerInPastPolicy string
+	timerResolution   int64
+
+	// What becomes of timer contexts over timerContextSize, as per timers.ContextOverflow
+	timerContextOverflow string
+
+	// What becomes of timers found overdue by worker, as per timers.MissedPolicy
+	missedTimerPolicy    string
+	missedTimerThreshold int64
+	missedTimerRate      int64
+
+	// Rules picking out events that go through priority lane
+	priorityKeyPrefixes [][]byte
+	priorityFieldPath   []string
+	priorityFieldValue  string
+
+	// Events held back while worker queues are full, in the order they were read
+	heldDcpEvents        []*heldDcpEvent     // Access controlled by heldDcpEventsMutex
+	heldDcpEventKeys     map[string]int      // Access controlled by heldDcpEventsMutex
+	heldDcpEventSeqNos   map[uint16][]uint64 // Access controlled by heldDcpEventsMutex
+	heldDcpEventsSize    int64               // Access controlled by heldDcpEventsMutex
+	heldDcpEventsMutex   *sync.Mutex
+	dcpEventsHeld        uint64
+	dcpPriorityEventSent uint64
+
+	// Seq nos of non-priority events sent to worker that it hasn't yet acked, and
+	// acks held back because of them
+	unackedDcpEventSeqNos map[uint16][]uint64 // Access controlled by heldDcpEventsMutex
+	clampedProcessedSeqNo map[uint16]uint64   // Access controlled by heldDcpEventsMutex
+
+	// Tee of messages exchanged with worker, for replaying them later on
+	ipcCapture         bool
+	ipcCaptureMaxFiles int
+	ipcCaptureMaxSize  int64
+	ipcCaptureWriter   *ipcCaptureWriter // Access controlled by ipcCaptureRWMutex
+	ipcCaptureRWMutex  *sync.RWMutex
+
+	workerRejectedMsgCounter     uint64 // Messages from producer that worker couldn't interpret
+	workerUnknownResponseCounter uint64 // Responses from worker that producer couldn't interpret
+
 	adhocTimerResponsesRecieved uint64
 	timerMessagesProcessed      uint64
 
@@ -334,8 +451,8 @@ type Consumer struct {
 	timerMessagesProcessedPSec   int
 	suppressedDCPDeletionCounter uint64
 	suppressedDCPMutationCounter uint64
-	sentEventsSize               int64
-	numSentEvents                int64
+	sentEventsSize               int64 // Accessed atomically, also read by DCP feed
+	numSentEvents                int64 // Accessed atomically, also read by DCP feed
 
 	// metastore related timer stats
 	metastoreDeleteCounter      uint64
@@ -405,6 +522,7 @@ type vbucketKVBlob struct {
 	CurrentVBOwner            string           `json:"current_vb_owner"`
 	DCPStreamStatus           string           `json:"dcp_stream_status"`
 	DCPStreamRequested        bool             `json:"dcp_stream_requested"`
+	Handover                  vbHandover       `json:"handover"`
 	LastCheckpointTime        string           `json:"last_checkpoint_time"`
 	LastDocTimerFeedbackSeqNo uint64           `json:"last_doc_timer_feedback_seqno"`
 	LastSeqNoProcessed        uint64           `json:"last_processed_seq_no"`
@@ -415,6 +533,7 @@ type vbucketKVBlob struct {
 	PreviousAssignedWorker    string           `json:"previous_assigned_worker"`
 	PreviousNodeUUID          string           `json:"previous_node_uuid"`
 	PreviousVBOwner           string           `json:"previous_vb_owner"`
+	RollbackPending           bool             `json:"rollback_pending"`
 	VBId                      uint16           `json:"vb_id"`
 	VBuuid                    uint64           `json:"vb_uuid"`
 	WorkerRequestedVbStream   string           `json:"worker_requested_vb_stream"`
@@ -430,6 +549,12 @@ type vbucketKVBlob struct {
 	NextCronTimerToProcess      string `json:"next_cron_timer_to_process"`
 }
 
+type vbHandover struct {
+	RequestTime string `json:"request_time"`
+	SeqNo       uint64 `json:"seq_no"` // Seq no upto which previous owner processed all events
+	State       string `json:"state"`
+}
+
 type vbucketKVBlobVer struct {
 	vbucketKVBlob
 	EventingVersion string `json:"version"`
@@ -442,16 +567,29 @@ type OwnershipEntry struct {
 	Operation      string `json:"operation"`
 	SeqNo          uint64 `json:"seq_no"`
 	Timestamp      string `json:"timestamp"`
+
+	// Populated only for rollback entries
+	RollbackPolicy string `json:"rollback_policy,omitempty"`
+	PrevSeqNo      uint64 `json:"prev_seq_no,omitempty"`
+	ResumeSeqNo    uint64 `json:"resume_seq_no,omitempty"`
 }
 
 type msgToTransmit struct {
 	msg            *message
 	sendToDebugger bool
 	prioritize     bool
+	dcpEventBatch  bool
 	headerBuilder  *flatbuffers.Builder
 	payloadBuilder *flatbuffers.Builder
 }
 
+type workerHandshake struct {
+	accepted           bool
+	protocolVersion    int16
+	minProtocolVersion int16
+	msg                string
+}
+
 type cppQueueSize struct {
 	AggQueueSize        int64 `json:"agg_queue_size"`
 	AggQueueMemory      int64 `json:"agg_queue_memory"`
@@ -0,0 +1,82 @@
+package consumer
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/eventsource"
+	"github.com/couchbase/eventing/logging"
+)
+
+// startEventSource sets up event source configured for the function. DCP
+// source fans its feeds straight into aggDCPFeed, events of others are
+// funnelled into it.
+func (c *Consumer) startEventSource() error {
+	logPrefix := "Consumer::startEventSource"
+
+	switch c.eventSourceType {
+	case common.EventSourceDcp, "":
+		source := newDcpSource(c)
+		if err := source.start(); err != nil {
+			return err
+		}
+		c.eventSource = source
+
+	case common.EventSourceFile:
+		source, err := eventsource.NewFileSource(c.eventSourceFile)
+		if err != nil {
+			logging.Errorf("%s [%s:%s:%d] Failed to load event source file: %s, err: %v",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), c.eventSourceFile, err)
+			return err
+		}
+		c.eventSource = source
+		go c.addEventSourceToAggChan(source)
+
+	default:
+		return fmt.Errorf("unknown event source: %s", c.eventSourceType)
+	}
+
+	logging.Infof("%s [%s:%s:%d] Using event source: %s",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), c.eventSource.Name())
+	return nil
+}
+
+func (c *Consumer) addEventSourceToAggChan(source eventsource.EventSource) {
+	logPrefix := "Consumer::addEventSourceToAggChan"
+
+	defer func() {
+		if r := recover(); r != nil {
+			trace := debug.Stack()
+			logging.Errorf("%s [%s:%s:%d] addEventSourceToAggChan: recover %rm stack trace: %rm",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), r, string(trace))
+		}
+	}()
+
+	for {
+		select {
+		case e, ok := <-source.Events():
+			if !ok {
+				logging.Infof("%s [%s:%s:%d] Event source: %s has been closed, bailing out",
+					logPrefix, c.workerName, c.tcpPort, c.Pid(), source.Name())
+				return
+			}
+
+			atomic.AddInt64(&c.aggDCPFeedMem, int64(len(e.Value)))
+			select {
+			case c.aggDCPFeed <- e:
+			case <-c.stopConsumerCh:
+				return
+			}
+
+		case <-c.stopConsumerCh:
+			return
+		}
+	}
+}
+
+// closeVbStream issues close stream against the source serving the vbucket
+func (c *Consumer) closeVbStream(vb uint16) error {
+	return c.eventSource.CloseStream(vb)
+}
@@ -58,11 +58,15 @@ func (c *Consumer) GetEventProcessingStats() map[string]uint64 {
 		stats["adhoc_timer_response_received"] = c.adhocTimerResponsesRecieved
 	}
 
-	if c.cppQueueSizes != nil {
-		stats["agg_queue_memory"] = uint64(c.cppQueueSizes.AggQueueMemory)
-		stats["agg_queue_size"] = uint64(c.cppQueueSizes.AggQueueSize)
-		stats["processed_events_size"] = uint64(c.cppQueueSizes.ProcessedEventsSize)
-		stats["num_processed_events"] = uint64(c.cppQueueSizes.NumProcessedEvents)
+	if queueSizes := c.getCppQueueSizes(); queueSizes != nil {
+		stats["agg_queue_memory"] = uint64(queueSizes.AggQueueMemory)
+		stats["agg_queue_size"] = uint64(queueSizes.AggQueueSize)
+		stats["processed_events_size"] = uint64(queueSizes.ProcessedEventsSize)
+		stats["num_processed_events"] = uint64(queueSizes.NumProcessedEvents)
+	}
+
+	for stat, value := range c.dcpFlowControlStats() {
+		stats[stat] = value
 	}
 
 	stats["agg_timer_feedback_queue_cap"] = uint64(c.feedbackQueueCap)
@@ -113,6 +117,38 @@ func (c *Consumer) GetEventProcessingStats() map[string]uint64 {
 		stats["dcp_stream_req_err_counter"] = c.dcpStreamReqErrCounter
 	}
 
+	if c.dcpRollbackCounter > 0 {
+		stats["dcp_rollback_counter"] = c.dcpRollbackCounter
+	}
+
+	if c.dcpRollbackPausedCounter > 0 {
+		stats["dcp_rollback_paused_counter"] = c.dcpRollbackPausedCounter
+	}
+
+	if c.dcpRollbackSkippedCounter > 0 {
+		stats["dcp_rollback_skipped_counter"] = c.dcpRollbackSkippedCounter
+	}
+
+	if val := atomic.LoadUint64(&c.dcpEventBatchesSent); val > 0 {
+		stats["dcp_event_batches_sent"] = val
+	}
+
+	if val := atomic.LoadUint64(&c.dcpPriorityEventSent); val > 0 {
+		stats["dcp_priority_events_sent"] = val
+	}
+
+	if val := atomic.LoadUint64(&c.dcpEventsHeld); val > 0 {
+		stats["dcp_events_held"] = val
+	}
+
+	if val := atomic.LoadUint64(&c.workerRejectedMsgCounter); val > 0 {
+		stats["worker_rejected_msg_count"] = val
+	}
+
+	if val := atomic.LoadUint64(&c.workerUnknownResponseCounter); val > 0 {
+		stats["worker_unknown_response_count"] = val
+	}
+
 	if c.timerResponsesRecieved > 0 {
 		stats["timer_responses_received"] = c.timerResponsesRecieved
 	}
@@ -365,6 +401,75 @@ func (c *Consumer) GetFailureStats() map[string]interface{} {
 	return failureStats
 }
 
+// GetTimerFiringStats returns lateness and scan cost of timers fired by cpp
+// worker, by partition
+func (c *Consumer) GetTimerFiringStats() map[int]*common.TimerFiringStats {
+	c.statsRWMutex.RLock()
+	defer c.statsRWMutex.RUnlock()
+
+	firingStats := make(map[int]*common.TimerFiringStats, len(c.timerFiringStats))
+	for partn, stats := range c.timerFiringStats {
+		firingStats[partn] = &common.TimerFiringStats{
+			Pending:     stats.Pending,
+			FireLag:     copyStatsData(stats.FireLag),
+			ScanTime:    copyStatsData(stats.ScanTime),
+			ScanLookups: make(common.StatsData),
+		}
+	}
+	return firingStats
+}
+
+// appendTimerFiringStats adds firing stats worker sent along with execution
+// stats, as a stat group named <partition>:pending, <partition>:fire_lag:<bin>
+// and <partition>:scan_time:<bin>. Bins count fires since stats were last sent,
+// while pending is reported as a whole, for partitions worker knows it of.
+// Caller holds statsRWMutex.
+func (c *Consumer) appendTimerFiringStats(group interface{}) {
+	deltas, ok := group.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if c.timerFiringStats == nil {
+		c.timerFiringStats = make(map[int]*common.TimerFiringStats)
+	}
+	for _, stats := range c.timerFiringStats {
+		stats.Pending = 0
+	}
+
+	for name, v := range deltas {
+		value, ok := v.(float64)
+		if !ok {
+			continue
+		}
+
+		fields := strings.SplitN(name, ":", 3)
+		partn, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) < 2 {
+			continue
+		}
+
+		stats, ok := c.timerFiringStats[partn]
+		if !ok {
+			stats = &common.TimerFiringStats{
+				FireLag:     make(common.StatsData),
+				ScanTime:    make(common.StatsData),
+				ScanLookups: make(common.StatsData),
+			}
+			c.timerFiringStats[partn] = stats
+		}
+
+		switch {
+		case fields[1] == "pending":
+			stats.Pending = int64(value)
+		case fields[1] == "fire_lag" && len(fields) == 3:
+			stats.FireLag[fields[2]] += uint64(value)
+		case fields[1] == "scan_time" && len(fields) == 3:
+			stats.ScanTime[fields[2]] += uint64(value)
+		}
+	}
+}
+
 // Pid returns the process id of CPP V8 worker
 func (c *Consumer) Pid() int {
 	pid, ok := c.osPid.Load().(int)
@@ -709,6 +814,12 @@ func (c *Consumer) WorkerVbMapUpdate(workerVbucketMap map[string][]uint16) {
 	for workerName, assignedVbs := range workerVbucketMap {
 		c.workerVbucketMap[workerName] = assignedVbs
 	}
+
+	// Worker count changes when producer autoscales workers, nil map is sent
+	// while pausing and leaves it as is
+	if len(workerVbucketMap) > 0 {
+		c.workerCount = len(workerVbucketMap)
+	}
 }
 
 func (c *Consumer) GetAssignedVbs(workerName string) ([]uint16, error) {
@@ -419,6 +419,17 @@ func (c *Consumer) sendGetLcbExceptionStats(sendToDebugger bool) {
 }
 
 func (c *Consumer) sendDcpEvent(e *memcached.DcpEvent, sendToDebugger bool) {
+	c.sendDcpEventToLane(e, sendToDebugger, false)
+}
+
+// sendPriorityDcpEvent sends the event on its own rather than in a batch, flagged
+// for worker thread to queue it in its priority lane
+func (c *Consumer) sendPriorityDcpEvent(e *memcached.DcpEvent) {
+	atomic.AddUint64(&c.dcpPriorityEventSent, 1)
+	c.sendDcpEventToLane(e, false, true)
+}
+
+func (c *Consumer) sendDcpEventToLane(e *memcached.DcpEvent, sendToDebugger, priority bool) {
 	m := dcpMetadata{
 		Cas:     e.Cas,
 		DocID:   string(e.Key),
@@ -428,6 +439,14 @@ func (c *Consumer) sendDcpEvent(e *memcached.DcpEvent, sendToDebugger bool) {
 		SeqNo:   e.Seqno,
 	}
 
+	if c.idempotencyKeys {
+		m.IdempotencyKey = c.idempotencyKey(e.VBucket, e.Seqno)
+	}
+
+	if c.enrichedMeta {
+		m.dcpEnrichedMetadata = c.enrichedMetadata(e)
+	}
+
 	metadata, err := json.Marshal(&m)
 
 	if err != nil {
@@ -438,10 +457,15 @@ func (c *Consumer) sendDcpEvent(e *memcached.DcpEvent, sendToDebugger bool) {
 
 	partition := int16(util.VbucketByKey(e.Key, cppWorkerPartitionCount))
 
+	if !sendToDebugger && !priority && c.batchingDcpEvents() {
+		c.sendBatchedDcpEvent(e, partition, string(metadata))
+		return
+	}
+
 	var dcpHeader, payload []byte
 	var hBuilder, pBuilder *flatbuffers.Builder
 	if e.Opcode == mcd.DCP_MUTATION {
-		dcpHeader, hBuilder = c.makeDcpMutationHeader(partition, string(metadata))
+		dcpHeader, hBuilder = c.makeDcpMutationHeader(partition, string(metadata), priority)
 		payload, pBuilder = c.makeDcpPayload(e.Key, e.Value)
 	} else if e.Opcode == mcd.DCP_DELETION || e.Opcode == mcd.DCP_EXPIRATION {
 		optionMap := map[string]interface{}{
@@ -454,7 +478,7 @@ func (c *Consumer) sendDcpEvent(e *memcached.DcpEvent, sendToDebugger bool) {
 			return
 		}
 
-		dcpHeader, hBuilder = c.makeDcpDeletionHeader(partition, string(metadata))
+		dcpHeader, hBuilder = c.makeDcpDeletionHeader(partition, string(metadata), priority)
 		payload, pBuilder = c.makeDcpPayload(e.Key, options)
 	}
 
@@ -464,19 +488,43 @@ func (c *Consumer) sendDcpEvent(e *memcached.DcpEvent, sendToDebugger bool) {
 			Payload: payload,
 		},
 		sendToDebugger: sendToDebugger,
-		prioritize:     false,
+		prioritize:     priority,
 		headerBuilder:  hBuilder,
 		payloadBuilder: pBuilder,
 	}
 	if !sendToDebugger {
-		c.vbProcessingStats.updateVbStat(e.VBucket, "last_sent_seq_no", e.Seqno)
+		c.updateLastSentSeqNo(e.VBucket, e.Seqno)
+	}
+	atomic.AddInt64(&c.sentEventsSize, int64(len(dcpHeader)+len(payload)))
+	atomic.AddInt64(&c.numSentEvents, 1)
+	if !sendToDebugger && !priority {
+		c.trackUnackedSeqNo(e.VBucket, e.Seqno)
 	}
-	c.sentEventsSize += int64(len(dcpHeader) + len(payload))
-	c.numSentEvents++
 	c.sendMessage(msg)
 }
 
-func (c *Consumer) sendVbFilterData(vb uint16, seqNo uint64, skipAck bool) {
+func (c *Consumer) sendBatchedDcpEvent(e *memcached.DcpEvent, partition int16, metadata string) {
+	var size int
+	if e.Opcode == mcd.DCP_MUTATION {
+		size = c.addToDcpEventBatch(dcpMutation, partition, metadata, e.Key, e.Value)
+	} else if e.Opcode == mcd.DCP_DELETION || e.Opcode == mcd.DCP_EXPIRATION {
+		options := []byte(fmt.Sprintf(`{"expired":%t}`, e.Opcode == mcd.DCP_EXPIRATION))
+		size = c.addToDcpEventBatch(dcpDeletion, partition, metadata, e.Key, options)
+	} else {
+		return
+	}
+
+	c.updateLastSentSeqNo(e.VBucket, e.Seqno)
+	atomic.AddInt64(&c.sentEventsSize, int64(size))
+	atomic.AddInt64(&c.numSentEvents, 1)
+	c.trackUnackedSeqNo(e.VBucket, e.Seqno)
+}
+
+// sendVbFilterData has worker drop events on vb up to seqNo that it hasn't yet
+// processed. With drain set, worker acks only once the event it's running for vb
+// is done and the dropped ones are out of its queue, which is what handing vb
+// over to another owner relies on.
+func (c *Consumer) sendVbFilterData(vb uint16, seqNo uint64, skipAck, drain bool) {
 	logPrefix := "Consumer::sendVbFilterData"
 
 	data := vbSeqNo{
@@ -488,6 +536,10 @@ func (c *Consumer) sendVbFilterData(vb uint16, seqNo uint64, skipAck bool) {
 		data.SkipAck = 1
 	}
 
+	if drain {
+		data.Drain = 1
+	}
+
 	metadata, err := json.Marshal(&data)
 	if err != nil {
 		logging.Errorf("[%s:%s:%s:%d] Failed to marshal metadata",
@@ -507,8 +559,8 @@ func (c *Consumer) sendVbFilterData(vb uint16, seqNo uint64, skipAck bool) {
 	}
 
 	c.sendMessage(msg)
-	logging.Infof("%s [%s:%s:%d] vb: %d seqNo: %d sending filter data to C++",
-		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, seqNo)
+	logging.Infof("%s [%s:%s:%d] vb: %d seqNo: %d drain: %t sending filter data to C++",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, seqNo, drain)
 }
 
 func (c *Consumer) sendPauseConsumer() {
@@ -581,6 +633,8 @@ func (c *Consumer) sendMessageLoop() {
 	for {
 		select {
 		case <-c.socketWriteTicker.C:
+			c.flushDcpEventBatches()
+
 			if c.sendMsgCounter > 0 && c.conn != nil {
 				if atomic.LoadUint32(&c.isTerminateRunning) == 1 || c.stoppingConsumer {
 					c.socketWriteLoopStopAckCh <- struct{}{}
@@ -646,9 +700,15 @@ func (c *Consumer) sendMessage(m *msgToTransmit) error {
 		return fmt.Errorf("Eventing.Consumer instance is terminating")
 	}
 
+	if !m.dcpEventBatch {
+		c.flushDcpEventBatches()
+	}
+
 	// Protocol encoding format:
 	//<headerSize><payloadSize><Header><Payload>
 
+	frameSize := 2*headerFragmentSize + len(m.msg.Header) + len(m.msg.Payload)
+
 	c.sendMsgBufferRWMutex.Lock()
 	defer c.sendMsgBufferRWMutex.Unlock()
 	err := binary.Write(&c.sendMsgBuffer, binary.LittleEndian, uint32(len(m.msg.Header)))
@@ -679,6 +739,10 @@ func (c *Consumer) sendMessage(m *msgToTransmit) error {
 		return err
 	}
 
+	if !m.sendToDebugger {
+		c.captureIPCMessage(CaptureToWorker, c.sendMsgBuffer.Bytes()[c.sendMsgBuffer.Len()-frameSize:])
+	}
+
 	c.sendMsgCounter++
 
 	if c.sendMsgCounter >= uint64(c.socketWriteBatchSize) || m.prioritize || m.sendToDebugger {
@@ -776,6 +840,7 @@ func (c *Consumer) feedbackReadMessageLoop(feedbackReader *bufio.Reader) {
 
 			if len(buffer) >= int(headerFragmentSize+headerSize) {
 
+				c.captureIPCMessage(CaptureFromWorker, buffer[:headerFragmentSize+headerSize])
 				c.parseWorkerResponse(buffer[headerFragmentSize : headerFragmentSize+headerSize])
 				buffer = buffer[headerFragmentSize+headerSize:]
 
@@ -0,0 +1,101 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/logging"
+)
+
+var (
+	errWorkerHandshakeTimeout = errors.New("timed out waiting for protocol handshake response from worker")
+	errWorkerProtocolMismatch = errors.New("worker doesn't support a compatible protocol version")
+)
+
+// negotiateProtocolVersion agrees on the IPC protocol version with C++ worker before
+// any other message is sent to it. Worker binaries older than the handshake don't
+// respond at all, so a timeout is reported as a mismatch as well.
+func (c *Consumer) negotiateProtocolVersion() error {
+	logPrefix := "Consumer::negotiateProtocolVersion"
+
+	c.sendHandshake()
+
+	var err error
+	var msg string
+
+	select {
+	case resp := <-c.handshakeCh:
+		if resp.accepted {
+			atomic.StoreInt32(&c.workerProtocolVersion, int32(resp.protocolVersion))
+			logging.Infof("%s [%s:%s:%d] Negotiated protocol version: %d with worker",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), resp.protocolVersion)
+			return nil
+		}
+
+		err = errWorkerProtocolMismatch
+		msg = fmt.Sprintf("worker supports protocol versions %d-%d, producer supports %d-%d, reason: %s",
+			resp.minProtocolVersion, resp.protocolVersion, minWorkerProtocolVersion, workerProtocolVersion, resp.msg)
+
+	case <-time.After(workerHandshakeTimeout):
+		err = errWorkerHandshakeTimeout
+		msg = fmt.Sprintf("no response in %v, worker binary likely predates protocol version %d supported by producer",
+			workerHandshakeTimeout, workerProtocolVersion)
+
+	case <-c.stopConsumerCh:
+		return fmt.Errorf("consumer stopped during protocol handshake")
+	}
+
+	logging.Errorf("%s [%s:%s:%d] Protocol handshake with worker failed, err: %v %s",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), err, msg)
+	c.producer.WriteAppLog(fmt.Sprintf("Function: %s worker %s failed protocol handshake, "+
+		"eventing producer and worker binaries are from different builds: %s", c.app.AppName, c.workerName, msg))
+	return err
+}
+
+func (c *Consumer) sendHandshake() {
+	header, hBuilder := c.makeHandshakeHeader()
+	payload, pBuilder := c.makeHandshakePayload()
+
+	m := &msgToTransmit{
+		msg: &message{
+			Header:  header,
+			Payload: payload,
+		},
+		prioritize:     true,
+		headerBuilder:  hBuilder,
+		payloadBuilder: pBuilder,
+	}
+
+	c.sendMessage(m)
+}
+
+func (c *Consumer) handleHandshakeResponse(opcode int8, protocolVersion, minProtocolVersion int16, msg string) {
+	logPrefix := "Consumer::handleHandshakeResponse"
+
+	resp := &workerHandshake{
+		protocolVersion:    protocolVersion,
+		minProtocolVersion: minProtocolVersion,
+		msg:                msg,
+	}
+
+	switch opcode {
+	case handshakeAccepted:
+		resp.accepted = protocolVersion >= minWorkerProtocolVersion && protocolVersion <= workerProtocolVersion
+		if !resp.accepted {
+			resp.msg = fmt.Sprintf("worker picked unsupported protocol version %d", protocolVersion)
+		}
+	case handshakeRejected:
+	default:
+		c.rejectResponse(handshakeResponse, opcode)
+		return
+	}
+
+	select {
+	case c.handshakeCh <- resp:
+	default:
+		logging.Errorf("%s [%s:%s:%d] Unexpected handshake response, opcode: %s protocol version: %d",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), responseOpcodeName(handshakeResponse, opcode), protocolVersion)
+	}
+}
@@ -89,10 +89,10 @@ func (c *Consumer) CheckIfQueuesAreDrained() error {
 		return errTimerQueueNotDrained
 	}
 
-	if c.cppQueueSizes.AggQueueSize > 0 {
+	if queueSizes := c.getCppQueueSizes(); queueSizes != nil && queueSizes.AggQueueSize > 0 {
 		c.GetExecutionStats()
 		logging.Infof("%s [%s:%s:%d] AggQueueSize: %d",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), c.cppQueueSizes.AggQueueSize)
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), queueSizes.AggQueueSize)
 		return errTimerQueueNotDrained
 	}
 	return nil
@@ -0,0 +1,311 @@
+package consumer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/eventing/logging"
+)
+
+// Capture file format:
+// <magic> followed by records of <timestamp><direction><frameSize><frame>
+//
+// Frame is the message exactly as it went over the wire, i.e.
+// <headerSize><payloadSize><Header><Payload> for messages to worker and
+// <size><Response> for feedback messages from worker
+//
+// Frames aren't redacted or encrypted, so captures hold document bodies and
+// handler code in the clear. They're created readable by owner and group only,
+// and it's up to whoever turns capture on to keep diagDir at least as
+// restricted as source bucket.
+const (
+	ipcCaptureMagic          = "EVIPCAP1"
+	ipcCaptureRecordOverhead = 8 + 1 + 4
+)
+
+// CaptureDirection tells which way a captured message was headed
+type CaptureDirection uint8
+
+// Directions of captured messages
+const (
+	CaptureToWorker CaptureDirection = iota + 1
+	CaptureFromWorker
+
+	// Bootstrap message to worker written again at the start of a rotated file
+	CaptureBootstrap
+)
+
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureToWorker:
+		return "to_worker"
+	case CaptureFromWorker:
+		return "from_worker"
+	case CaptureBootstrap:
+		return "bootstrap"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(d))
+	}
+}
+
+// ipcCaptureWriter appends captured messages to a file, which is rotated into
+// <path>.<index> once it grows beyond maxSize. Records are written out unbuffered
+// so that capture is complete up to the message a crashed worker died on.
+//
+// Messages sent to worker till endBootstrap are kept aside, and written again at
+// the start of every file rotated in, so that each file can be replayed by itself.
+type ipcCaptureWriter struct {
+	sync.Mutex
+	path          string
+	file          *os.File
+	maxSize       int64
+	maxFiles      int
+	size          int64
+	lowIndex      int64
+	highIndex     int64
+	record        []byte
+	bootstrap     [][]byte
+	bootstrapDone bool
+}
+
+func ipcCapturePath(diagDir, workerName string) string {
+	return filepath.Join(diagDir, fmt.Sprintf("%s.ipccap", workerName))
+}
+
+func openIPCCapture(path string, maxSize int64, maxFiles int) (*ipcCaptureWriter, error) {
+	wc := &ipcCaptureWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		lowIndex: 1,
+	}
+
+	rotated, err := rotatedIPCCaptures(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rotated) > 0 {
+		wc.lowIndex = rotated[0]
+		wc.highIndex = rotated[len(rotated)-1]
+	}
+
+	// Capture left behind by previous worker is rotated out rather than appended
+	// to, so that every file starts at a worker bootstrap. Bootstrap of this
+	// worker is yet to be captured, so there's nothing to write again.
+	if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+		if err = wc.rotateLocked(); err != nil {
+			return nil, err
+		}
+		return wc, nil
+	}
+
+	if err = wc.createLocked(); err != nil {
+		return nil, err
+	}
+	return wc, nil
+}
+
+// rotatedIPCCaptures returns indexes of rotated capture files at path in ascending order
+func rotatedIPCCaptures(path string) ([]int64, error) {
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int64, 0, len(files))
+	for _, file := range files {
+		index, err := strconv.ParseInt(strings.TrimPrefix(file, path+"."), 10, 64)
+		if err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes, nil
+}
+
+func (wc *ipcCaptureWriter) createLocked() error {
+	file, err := os.OpenFile(wc.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write([]byte(ipcCaptureMagic)); err != nil {
+		file.Close()
+		return err
+	}
+
+	wc.file = file
+	wc.size = int64(len(ipcCaptureMagic))
+	return nil
+}
+
+func (wc *ipcCaptureWriter) rotateLocked() error {
+	logPrefix := "ipcCaptureWriter::rotate"
+
+	if wc.file != nil {
+		wc.file.Close()
+		wc.file = nil
+	}
+
+	if err := os.Rename(wc.path, fmt.Sprintf("%s.%d", wc.path, wc.highIndex+1)); err != nil {
+		return err
+	}
+	wc.highIndex++
+
+	for ; wc.lowIndex+int64(wc.maxFiles) <= wc.highIndex; wc.lowIndex++ {
+		if err := os.Remove(fmt.Sprintf("%s.%d", wc.path, wc.lowIndex)); err != nil && !os.IsNotExist(err) {
+			logging.Errorf("%s Failed to remove capture: %s.%d, err: %v", logPrefix, wc.path, wc.lowIndex, err)
+		}
+	}
+
+	if err := wc.createLocked(); err != nil {
+		return err
+	}
+
+	for _, frame := range wc.bootstrap {
+		if err := wc.writeLocked(CaptureBootstrap, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endBootstrap marks that messages setting up worker have all been captured
+func (wc *ipcCaptureWriter) endBootstrap() {
+	wc.Lock()
+	defer wc.Unlock()
+	wc.bootstrapDone = true
+}
+
+// capture appends a record made up of the given fragments, which together form
+// the frame as it appears on the wire
+func (wc *ipcCaptureWriter) capture(direction CaptureDirection, fragments ...[]byte) error {
+	wc.Lock()
+	defer wc.Unlock()
+
+	if wc.file == nil {
+		return nil
+	}
+
+	if direction == CaptureToWorker && !wc.bootstrapDone {
+		var frame []byte
+		for _, fragment := range fragments {
+			frame = append(frame, fragment...)
+		}
+		wc.bootstrap = append(wc.bootstrap, frame)
+	}
+
+	if err := wc.writeLocked(direction, fragments...); err != nil {
+		return err
+	}
+
+	if wc.size >= wc.maxSize {
+		return wc.rotateLocked()
+	}
+	return nil
+}
+
+func (wc *ipcCaptureWriter) writeLocked(direction CaptureDirection, fragments ...[]byte) error {
+	frameSize := 0
+	for _, fragment := range fragments {
+		frameSize += len(fragment)
+	}
+
+	var prefix [ipcCaptureRecordOverhead]byte
+	binary.LittleEndian.PutUint64(prefix[0:8], uint64(time.Now().UnixNano()))
+	prefix[8] = byte(direction)
+	binary.LittleEndian.PutUint32(prefix[9:13], uint32(frameSize))
+
+	record := append(wc.record[:0], prefix[:]...)
+	for _, fragment := range fragments {
+		record = append(record, fragment...)
+	}
+	wc.record = record
+
+	if _, err := wc.file.Write(record); err != nil {
+		return err
+	}
+
+	wc.size += int64(len(record))
+	return nil
+}
+
+func (wc *ipcCaptureWriter) Close() error {
+	wc.Lock()
+	defer wc.Unlock()
+
+	if wc.file == nil {
+		return nil
+	}
+
+	err := wc.file.Close()
+	wc.file = nil
+	return err
+}
+
+func (c *Consumer) openIPCCapture() {
+	logPrefix := "Consumer::openIPCCapture"
+
+	if !c.ipcCapture {
+		return
+	}
+
+	path := ipcCapturePath(c.diagDir, c.workerName)
+	writer, err := openIPCCapture(path, c.ipcCaptureMaxSize, c.ipcCaptureMaxFiles)
+	if err != nil {
+		logging.Errorf("%s [%s:%s:%d] Failed to open IPC capture: %s, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), path, err)
+		return
+	}
+
+	logging.Infof("%s [%s:%s:%d] Capturing IPC traffic with worker, document bodies included unencrypted, to: %s max size: %d max files: %d",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), path, c.ipcCaptureMaxSize, c.ipcCaptureMaxFiles)
+
+	c.ipcCaptureRWMutex.Lock()
+	c.ipcCaptureWriter = writer
+	c.ipcCaptureRWMutex.Unlock()
+}
+
+func (c *Consumer) closeIPCCapture() {
+	c.ipcCaptureRWMutex.Lock()
+	defer c.ipcCaptureRWMutex.Unlock()
+
+	if c.ipcCaptureWriter != nil {
+		c.ipcCaptureWriter.Close()
+		c.ipcCaptureWriter = nil
+	}
+}
+
+func (c *Consumer) endIPCCaptureBootstrap() {
+	c.ipcCaptureRWMutex.RLock()
+	defer c.ipcCaptureRWMutex.RUnlock()
+
+	if c.ipcCaptureWriter != nil {
+		c.ipcCaptureWriter.endBootstrap()
+	}
+}
+
+func (c *Consumer) captureIPCMessage(direction CaptureDirection, fragments ...[]byte) {
+	logPrefix := "Consumer::captureIPCMessage"
+
+	c.ipcCaptureRWMutex.RLock()
+	defer c.ipcCaptureRWMutex.RUnlock()
+
+	if c.ipcCaptureWriter == nil {
+		return
+	}
+
+	if err := c.ipcCaptureWriter.capture(direction, fragments...); err != nil {
+		logging.Errorf("%s [%s:%s:%d] Failed to capture message, stopping capture, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), err)
+		c.ipcCaptureWriter.Close()
+	}
+}
@@ -0,0 +1,203 @@
+package consumer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newCaptureDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ipccap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// readCaptureRecords returns records of a single capture file
+func readCaptureRecords(t *testing.T, file string) []*CaptureRecord {
+	var records []*CaptureRecord
+	err := ReadIPCCapture([]string{file}, func(record *CaptureRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read %s, err: %v", file, err)
+	}
+	return records
+}
+
+// writeCapture captures bootstrap frames to worker, followed by messages
+// alternating between to and from worker
+func writeCapture(t *testing.T, path string, maxSize int64, maxFiles int, bootstrap []string, messages int) {
+	wc, err := openIPCCapture(path, maxSize, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wc.Close()
+
+	for _, frame := range bootstrap {
+		if err = wc.capture(CaptureToWorker, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wc.endBootstrap()
+
+	for i := 0; i < messages; i++ {
+		direction := CaptureToWorker
+		if i%2 == 1 {
+			direction = CaptureFromWorker
+		}
+		// Frames are written in fragments, as headers and payloads are on the wire
+		frame := []byte(fmt.Sprintf("m%03d", i))
+		if err = wc.capture(direction, frame[:1], frame[1:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIPCCaptureRotation(t *testing.T) {
+	dir := newCaptureDir(t)
+	defer os.RemoveAll(dir)
+
+	path := ipcCapturePath(dir, "worker_fn_0")
+	bootstrap := []string{"boot", "init"}
+	writeCapture(t, path, 60, 2, bootstrap, 20)
+
+	rotated, err := rotatedIPCCaptures(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 || rotated[1] != rotated[0]+1 || rotated[0] == 1 {
+		t.Fatalf("expected only the latest 2 rotated captures to be kept, got %v", rotated)
+	}
+
+	files, err := IPCCaptureFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[2] != path {
+		t.Fatalf("expected rotated captures followed by current one, got %v", files)
+	}
+
+	// Every file rotated in starts with bootstrap written again
+	for _, file := range files {
+		records := readCaptureRecords(t, file)
+		if len(records) < len(bootstrap) {
+			t.Fatalf("expected %s to start with bootstrap, got %d records", file, len(records))
+		}
+		for i, frame := range bootstrap {
+			if records[i].Direction != CaptureBootstrap || string(records[i].Frame) != frame {
+				t.Errorf("expected record: %d of %s to be bootstrap %s, got %s %s",
+					i, file, frame, records[i].Direction, records[i].Frame)
+			}
+		}
+	}
+}
+
+func TestIPCCaptureReopen(t *testing.T) {
+	dir := newCaptureDir(t)
+	defer os.RemoveAll(dir)
+
+	path := ipcCapturePath(dir, "worker_fn_0")
+	writeCapture(t, path, 1024*1024, 4, []string{"boot"}, 2)
+	writeCapture(t, path, 1024*1024, 4, []string{"boot"}, 1)
+
+	files, err := IPCCaptureFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != path+".1" {
+		t.Fatalf("expected capture of previous worker to be rotated out, got %v", files)
+	}
+
+	if records := readCaptureRecords(t, files[0]); len(records) != 3 {
+		t.Errorf("expected previous capture to be kept whole, got %d records", len(records))
+	}
+
+	// Bootstrap of new worker is captured as it happens, not written again
+	records := readCaptureRecords(t, path)
+	if len(records) != 2 || records[0].Direction != CaptureToWorker || string(records[0].Frame) != "boot" {
+		t.Errorf("expected new capture to start with bootstrap of new worker, got %d records", len(records))
+	}
+}
+
+func TestReplayIPCCapture(t *testing.T) {
+	dir := newCaptureDir(t)
+	defer os.RemoveAll(dir)
+
+	path := ipcCapturePath(dir, "worker_fn_0")
+	writeCapture(t, path, 60, 100, []string{"boot", "init"}, 10)
+
+	files, err := IPCCaptureFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 3 {
+		t.Fatalf("expected capture to be rotated, got %v", files)
+	}
+
+	var replay bytes.Buffer
+	replayed, err := ReplayIPCCapture(files, &replay, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bootstrap written again in rotated files isn't replayed, nor are messages
+	// from worker
+	expected := "bootinitm000m002m004m006m008"
+	if replayed != 7 || replay.String() != expected {
+		t.Errorf("expected 7 messages %s to be replayed, got %d %s", expected, replayed, replay.String())
+	}
+
+	// Files rotated in carry bootstrap, so that replay can start from any of them
+	replay.Reset()
+	replayed, err = ReplayIPCCapture(files[1:], &replay, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(replay.String(), "bootinit") || strings.Count(replay.String(), "boot") != 1 {
+		t.Errorf("expected replay from rotated file to start with bootstrap once, got %d %s", replayed, replay.String())
+	}
+}
+
+func TestReadIPCCaptureTruncated(t *testing.T) {
+	dir := newCaptureDir(t)
+	defer os.RemoveAll(dir)
+
+	path := ipcCapturePath(dir, "worker_fn_0")
+	writeCapture(t, path, 1024*1024, 4, []string{"boot"}, 3)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path, fi.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []string
+	err = ReadIPCCapture([]string{path}, func(record *CaptureRecord) error {
+		frames = append(frames, string(record.Frame))
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "record: 3 truncated") {
+		t.Errorf("expected last record to be reported truncated, got %v", err)
+	}
+	if len(frames) != 3 || frames[2] != "m001" {
+		t.Errorf("expected records ahead of truncated one to be read, got %v", frames)
+	}
+
+	notCapture := filepath.Join(dir, "not_capture")
+	if err = ioutil.WriteFile(notCapture, []byte("EVIPCAP"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	err = ReadIPCCapture([]string{notCapture}, func(*CaptureRecord) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), errNotIPCCapture.Error()) {
+		t.Errorf("expected file without magic to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package consumer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var errNotIPCCapture = errors.New("not an IPC capture file")
+
+// CaptureRecord is a message read back from an IPC capture
+type CaptureRecord struct {
+	Timestamp time.Time
+	Direction CaptureDirection
+	Frame     []byte // Message exactly as it went over the wire, including size prefixes
+}
+
+// IPCCaptureFiles returns capture at path preceded by its rotated parts, oldest first
+func IPCCaptureFiles(path string) ([]string, error) {
+	indexes, err := rotatedIPCCaptures(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(indexes)+1)
+	for _, index := range indexes {
+		files = append(files, fmt.Sprintf("%s.%d", path, index))
+	}
+
+	if _, err = os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no IPC capture found at %s", path)
+	}
+	return files, nil
+}
+
+// ReadIPCCapture calls fn with every record from capture files in the order
+// they were written, stopping at the first error returned by fn
+func ReadIPCCapture(files []string, fn func(*CaptureRecord) error) error {
+	for _, file := range files {
+		if err := readIPCCaptureFile(file, fn); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func readIPCCaptureFile(file string, fn func(*CaptureRecord) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	magic := make([]byte, len(ipcCaptureMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != ipcCaptureMagic {
+		return errNotIPCCapture
+	}
+
+	for seq := 0; ; seq++ {
+		var prefix [ipcCaptureRecordOverhead]byte
+		_, err = io.ReadFull(reader, prefix[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Capture of a crashed producer may end with a partial record
+			return fmt.Errorf("record: %d truncated, err: %v", seq, err)
+		}
+
+		record := &CaptureRecord{
+			Timestamp: time.Unix(0, int64(binary.LittleEndian.Uint64(prefix[0:8]))),
+			Direction: CaptureDirection(prefix[8]),
+			Frame:     make([]byte, binary.LittleEndian.Uint32(prefix[9:13])),
+		}
+
+		if _, err = io.ReadFull(reader, record.Frame); err != nil {
+			return fmt.Errorf("record: %d truncated, err: %v", seq, err)
+		}
+
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplayIPCCapture writes messages that were captured on their way to worker
+// into w, in the order they were originally sent. With speed above 0 gaps
+// between messages are reproduced scaled down by speed, otherwise messages are
+// written back to back. Bootstrap written again at the start of rotated files
+// is replayed only if nothing precedes it. Returns number of messages replayed.
+func ReplayIPCCapture(files []string, w io.Writer, speed float64) (int, error) {
+	var replayed int
+	var last time.Time
+	var bootstrapped bool
+
+	err := ReadIPCCapture(files, func(record *CaptureRecord) error {
+		switch record.Direction {
+		case CaptureToWorker:
+			bootstrapped = true
+		case CaptureBootstrap:
+			if bootstrapped {
+				return nil
+			}
+		default:
+			return nil
+		}
+
+		if speed > 0 && !last.IsZero() {
+			if gap := record.Timestamp.Sub(last); gap > 0 {
+				time.Sleep(time.Duration(float64(gap) / speed))
+			}
+		}
+		last = record.Timestamp
+
+		if _, err := w.Write(record.Frame); err != nil {
+			return fmt.Errorf("replay of message: %d failed, err: %v", replayed, err)
+		}
+		replayed++
+		return nil
+	})
+
+	return replayed, err
+}
+
+// DumpIPCCapture decodes capture files and writes out one line per message in
+// human readable form, prefixed with the time and direction it was captured in
+func DumpIPCCapture(files []string, w io.Writer) error {
+	seq := 0
+
+	return ReadIPCCapture(files, func(record *CaptureRecord) error {
+		prefix := fmt.Sprintf("%d %s %s", seq, record.Timestamp.Format(time.RFC3339Nano), record.Direction)
+		seq++
+
+		frame := record.Frame
+		switch record.Direction {
+		case CaptureToWorker, CaptureBootstrap:
+			if len(frame) < 2*headerFragmentSize {
+				return fmt.Errorf("message: %d has malformed frame of size: %d", seq-1, len(frame))
+			}
+			headerSize := binary.LittleEndian.Uint32(frame[:headerFragmentSize])
+			payloadSize := binary.LittleEndian.Uint32(frame[headerFragmentSize : 2*headerFragmentSize])
+			if uint64(len(frame)) != uint64(2*headerFragmentSize)+uint64(headerSize)+uint64(payloadSize) {
+				return fmt.Errorf("message: %d has malformed frame of size: %d", seq-1, len(frame))
+			}
+			body := frame[2*headerFragmentSize:]
+			dumpWorkerRequest(w, prefix, body[:headerSize], body[headerSize:])
+
+		case CaptureFromWorker:
+			if len(frame) < headerFragmentSize {
+				return fmt.Errorf("message: %d has malformed frame of size: %d", seq-1, len(frame))
+			}
+			dumpWorkerResponse(w, prefix, frame[headerFragmentSize:])
+
+		default:
+			fmt.Fprintf(w, "%s frame_size: %d\n", prefix, len(frame))
+		}
+		return nil
+	})
+}
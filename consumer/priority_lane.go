@@ -0,0 +1,281 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/logging"
+)
+
+// heldDcpEvent is a DCP event held back in consumer as worker queues were full
+// when it was read, or as an older event on the same key was still held
+type heldDcpEvent struct {
+	e        *memcached.DcpEvent
+	priority bool
+}
+
+func (c *Consumer) priorityLanesEnabled() bool {
+	return len(c.priorityKeyPrefixes) > 0 || len(c.priorityFieldPath) > 0
+}
+
+// isPriorityEvent checks event against key prefix and document field rules. Field
+// rule only applies to mutations, as deletions and expirations carry no body.
+func (c *Consumer) isPriorityEvent(e *memcached.DcpEvent) bool {
+	for _, prefix := range c.priorityKeyPrefixes {
+		if bytes.HasPrefix(e.Key, prefix) {
+			return true
+		}
+	}
+
+	if len(c.priorityFieldPath) == 0 || e.Opcode != mcd.DCP_MUTATION {
+		return false
+	}
+
+	// Cheap check to avoid parsing documents which can't have the field
+	if !bytes.Contains(e.Value, []byte(c.priorityFieldPath[len(c.priorityFieldPath)-1])) {
+		return false
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(e.Value, &doc); err != nil {
+		return false
+	}
+
+	for _, field := range c.priorityFieldPath {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if doc, ok = obj[field]; !ok {
+			return false
+		}
+	}
+
+	if c.priorityFieldValue == "" {
+		val, ok := doc.(bool)
+		return ok && val
+	}
+	return fmt.Sprintf("%v", doc) == c.priorityFieldValue
+}
+
+func (c *Consumer) workerQueuesFull() bool {
+	queueSizes := c.getCppQueueSizes()
+	if queueSizes == nil {
+		return false
+	}
+
+	return c.workerQueueCap < (atomic.LoadInt64(&c.numSentEvents)-queueSizes.NumProcessedEvents) ||
+		c.workerQueueMemCap < (atomic.LoadInt64(&c.sentEventsSize)-queueSizes.ProcessedEventsSize)
+}
+
+// canHoldDcpEvents reports whether DCP event loop can keep reading events while
+// worker queues are full, holding back all but the priority ones
+func (c *Consumer) canHoldDcpEvents() bool {
+	if !c.priorityLanesEnabled() {
+		return false
+	}
+
+	c.heldDcpEventsMutex.Lock()
+	defer c.heldDcpEventsMutex.Unlock()
+
+	return len(c.heldDcpEvents) < heldDcpEventsCap && c.heldDcpEventsSize < heldDcpEventsMemCap
+}
+
+// sendOrHoldDcpEvent sends priority events to worker right away, even if its queues
+// are full. Other events are held back while worker queues are full. An event is
+// also held if an older event on the same key is, so that events on a key are
+// always handed to worker in the order they were read.
+func (c *Consumer) sendOrHoldDcpEvent(e *memcached.DcpEvent) {
+	priority := c.isPriorityEvent(e)
+	key := string(e.Key)
+
+	c.heldDcpEventsMutex.Lock()
+	hold := c.heldDcpEventKeys[key] > 0
+	if !priority && !hold {
+		hold = len(c.heldDcpEvents) > 0 || (c.workerQueuesFull() && !c.isRebalanceOngoing && !c.isPausing)
+	}
+
+	if hold {
+		c.heldDcpEvents = append(c.heldDcpEvents, &heldDcpEvent{e: e, priority: priority})
+		c.heldDcpEventKeys[key]++
+		c.heldDcpEventSeqNos[e.VBucket] = append(c.heldDcpEventSeqNos[e.VBucket], e.Seqno)
+		c.heldDcpEventsSize += int64(len(e.Value))
+		c.heldDcpEventsMutex.Unlock()
+
+		atomic.AddUint64(&c.dcpEventsHeld, 1)
+		return
+	}
+	c.heldDcpEventsMutex.Unlock()
+
+	if priority {
+		c.sendPriorityDcpEvent(e)
+	} else {
+		c.sendDcpEvent(e, false)
+	}
+}
+
+// releaseHeldDcpEvents sends out held events in the order they were read, for as
+// long as worker queues have room. With force set all of them are sent, which is
+// needed before worker is handed control messages that rely on ordering w.r.t.
+// events, or when consumer is about to stop reading from DCP.
+func (c *Consumer) releaseHeldDcpEvents(force bool) {
+	logPrefix := "Consumer::releaseHeldDcpEvents"
+
+	if !c.priorityLanesEnabled() {
+		return
+	}
+
+	released := 0
+	for {
+		c.heldDcpEventsMutex.Lock()
+		if len(c.heldDcpEvents) == 0 || (!force && c.workerQueuesFull()) {
+			c.heldDcpEventsMutex.Unlock()
+			break
+		}
+
+		held := c.heldDcpEvents[0]
+		c.heldDcpEvents[0] = nil
+		c.heldDcpEvents = c.heldDcpEvents[1:]
+		if len(c.heldDcpEvents) == 0 {
+			c.heldDcpEvents = nil
+		}
+
+		key := string(held.e.Key)
+		if c.heldDcpEventKeys[key]--; c.heldDcpEventKeys[key] <= 0 {
+			delete(c.heldDcpEventKeys, key)
+		}
+
+		vb := held.e.VBucket
+		if c.heldDcpEventSeqNos[vb] = c.heldDcpEventSeqNos[vb][1:]; len(c.heldDcpEventSeqNos[vb]) == 0 {
+			delete(c.heldDcpEventSeqNos, vb)
+		}
+		c.heldDcpEventsSize -= int64(len(held.e.Value))
+		c.heldDcpEventsMutex.Unlock()
+
+		if held.priority {
+			c.sendPriorityDcpEvent(held.e)
+		} else {
+			c.sendDcpEvent(held.e, false)
+		}
+		released++
+	}
+
+	if force && released > 0 {
+		logging.Infof("%s [%s:%s:%d] Released %d held events",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), released)
+	}
+}
+
+// clampToHeldSeqNo keeps seq no acked for a vbucket below that of its oldest held
+// event. Priority events may be processed ahead of held ones, and a checkpoint
+// past held events would have them skipped if worker were to restart.
+func (c *Consumer) clampToHeldSeqNo(vb uint16, seqNo uint64) uint64 {
+	if !c.priorityLanesEnabled() {
+		return seqNo
+	}
+
+	c.heldDcpEventsMutex.Lock()
+	defer c.heldDcpEventsMutex.Unlock()
+
+	if seqNos, ok := c.heldDcpEventSeqNos[vb]; ok && seqNo >= seqNos[0] {
+		return seqNos[0] - 1
+	}
+	return seqNo
+}
+
+// trackUnackedSeqNo notes a non-priority event sent to worker. It must be called
+// after the event is counted in numSentEvents, for releaseUnackedSeqNos to
+// be able to tell it apart from events worker has already processed.
+func (c *Consumer) trackUnackedSeqNo(vb uint16, seqNo uint64) {
+	if !c.priorityLanesEnabled() {
+		return
+	}
+
+	c.heldDcpEventsMutex.Lock()
+	defer c.heldDcpEventsMutex.Unlock()
+
+	c.unackedDcpEventSeqNos[vb] = append(c.unackedDcpEventSeqNos[vb], seqNo)
+}
+
+// clampToUnackedSeqNo keeps seq no acked for a vbucket below that of its oldest
+// non-priority event worker hasn't acked yet. Priority events overtake those
+// queued in normal lane of worker thread, so an ack for one says nothing about
+// events with lower seq nos. An ack for a non-priority event does cover the
+// ones sent before it, as normal lane is drained in order. Acks held back are
+// handed out once events ahead of them are acked.
+func (c *Consumer) clampToUnackedSeqNo(vb uint16, seqNo uint64) uint64 {
+	if !c.priorityLanesEnabled() {
+		return seqNo
+	}
+
+	c.heldDcpEventsMutex.Lock()
+	defer c.heldDcpEventsMutex.Unlock()
+
+	seqNos := c.unackedDcpEventSeqNos[vb]
+	i := sort.Search(len(seqNos), func(i int) bool { return seqNos[i] > seqNo })
+	if i > 0 && seqNos[i-1] == seqNo {
+		seqNos = seqNos[i:]
+		if len(seqNos) == 0 {
+			delete(c.unackedDcpEventSeqNos, vb)
+		} else {
+			c.unackedDcpEventSeqNos[vb] = seqNos
+		}
+	}
+
+	if clamped := c.clampedProcessedSeqNo[vb]; clamped > seqNo {
+		seqNo = clamped
+	}
+
+	if len(seqNos) > 0 && seqNo >= seqNos[0] {
+		c.clampedProcessedSeqNo[vb] = seqNo
+		return seqNos[0] - 1
+	}
+	delete(c.clampedProcessedSeqNo, vb)
+	return seqNo
+}
+
+// releaseUnackedSeqNos hands out acks held back by clampToUnackedSeqNo once
+// worker has processed every event sent to it. Worker acks a vbucket only when
+// it processes an event on it, so without this an ack for a priority event that
+// came last would be held back until the next event on that vbucket.
+func (c *Consumer) releaseUnackedSeqNos() {
+	if !c.priorityLanesEnabled() {
+		return
+	}
+
+	queueSizes := c.getCppQueueSizes()
+	if queueSizes == nil {
+		return
+	}
+
+	c.heldDcpEventsMutex.Lock()
+	if queueSizes.NumProcessedEvents < atomic.LoadInt64(&c.numSentEvents) {
+		c.heldDcpEventsMutex.Unlock()
+		return
+	}
+
+	clamped := c.clampedProcessedSeqNo
+	c.unackedDcpEventSeqNos = make(map[uint16][]uint64)
+	c.clampedProcessedSeqNo = make(map[uint16]uint64)
+	c.heldDcpEventsMutex.Unlock()
+
+	for vb, seqNo := range clamped {
+		c.updateLastProcessedSeqNo(vb, seqNo)
+	}
+}
+
+// updateLastSentSeqNo doesn't let last sent seq no go backwards when a held event
+// is sent after priority events that were read later on the same vbucket
+func (c *Consumer) updateLastSentSeqNo(vb uint16, seqNo uint64) {
+	if c.priorityLanesEnabled() {
+		if lastSentSeqNo, ok := c.vbProcessingStats.getVbStat(vb, "last_sent_seq_no").(uint64); ok && seqNo < lastSentSeqNo {
+			return
+		}
+	}
+	c.vbProcessingStats.updateVbStat(vb, "last_sent_seq_no", seqNo)
+}
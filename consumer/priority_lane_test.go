@@ -0,0 +1,106 @@
+package consumer
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newPriorityLaneConsumer() *Consumer {
+	return &Consumer{
+		priorityKeyPrefixes:   [][]byte{[]byte("urgent::")},
+		heldDcpEventKeys:      make(map[string]int),
+		heldDcpEventSeqNos:    make(map[uint16][]uint64),
+		heldDcpEventsMutex:    &sync.Mutex{},
+		unackedDcpEventSeqNos: make(map[uint16][]uint64),
+		clampedProcessedSeqNo: make(map[uint16]uint64),
+		statsRWMutex:          &sync.RWMutex{},
+		vbProcessingStats:     newVbProcessingStats("test", 4, "", "worker_test_0"),
+	}
+}
+
+// testSend mimics accounting done by sendDcpEventToLane
+func (c *Consumer) testSend(vb uint16, seqNo uint64, priority bool) {
+	c.updateLastSentSeqNo(vb, seqNo)
+	atomic.AddInt64(&c.numSentEvents, 1)
+	if !priority {
+		c.trackUnackedSeqNo(vb, seqNo)
+	}
+}
+
+func (c *Consumer) lastProcessedSeqNo(vb uint16) uint64 {
+	return c.vbProcessingStats.getVbStat(vb, "last_processed_seq_no").(uint64)
+}
+
+func TestPriorityAckDoesNotSkipQueuedEvents(t *testing.T) {
+	c := newPriorityLaneConsumer()
+
+	// Events 1 and 2 wait in normal lane while priority event 3 overtakes them
+	c.testSend(1, 1, false)
+	c.testSend(1, 2, false)
+	c.testSend(1, 3, true)
+
+	steps := []struct {
+		ack      uint64
+		expected uint64
+	}{
+		{3, 0},
+		{1, 1},
+		{2, 3},
+	}
+
+	for _, step := range steps {
+		c.updateLastProcessedSeqNo(1, step.ack)
+		if got := c.lastProcessedSeqNo(1); got != step.expected {
+			t.Fatalf("after ack %d expected last_processed_seq_no %d, got %d",
+				step.ack, step.expected, got)
+		}
+	}
+
+	if len(c.unackedDcpEventSeqNos) != 0 || len(c.clampedProcessedSeqNo) != 0 {
+		t.Errorf("expected nothing left to track, got unacked: %v clamped: %v",
+			c.unackedDcpEventSeqNos, c.clampedProcessedSeqNo)
+	}
+}
+
+func TestPriorityAckReleasedOnceWorkerIdle(t *testing.T) {
+	c := newPriorityLaneConsumer()
+
+	c.testSend(2, 10, false)
+	c.testSend(2, 11, true)
+
+	// Worker checkpoints after priority event, normal event gets processed
+	// afterwards but worker has nothing newer to ack on the vbucket
+	c.updateLastProcessedSeqNo(2, 11)
+	if got := c.lastProcessedSeqNo(2); got != 9 {
+		t.Fatalf("expected ack to be held back at 9, got %d", got)
+	}
+
+	c.setCppQueueSizes(&cppQueueSize{NumProcessedEvents: 1})
+	c.releaseUnackedSeqNos()
+	if got := c.lastProcessedSeqNo(2); got != 9 {
+		t.Fatalf("expected ack to be held back while events are queued, got %d", got)
+	}
+
+	c.setCppQueueSizes(&cppQueueSize{NumProcessedEvents: 2})
+	c.releaseUnackedSeqNos()
+	if got := c.lastProcessedSeqNo(2); got != 11 {
+		t.Fatalf("expected held back ack to be released, got %d", got)
+	}
+}
+
+func TestNormalAcksUnaffected(t *testing.T) {
+	c := newPriorityLaneConsumer()
+
+	c.testSend(3, 5, false)
+	c.testSend(3, 6, false)
+	c.testSend(3, 7, false)
+
+	c.updateLastProcessedSeqNo(3, 6)
+	if got := c.lastProcessedSeqNo(3); got != 6 {
+		t.Fatalf("expected 6, got %d", got)
+	}
+	if seqNos := c.unackedDcpEventSeqNos[3]; len(seqNos) != 1 || seqNos[0] != 7 {
+		t.Errorf("expected only 7 to be unacked, got %v", seqNos)
+	}
+}
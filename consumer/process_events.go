@@ -1,7 +1,6 @@
 package consumer
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"runtime"
@@ -16,6 +15,7 @@ import (
 	"github.com/couchbase/eventing/dcp"
 	mcd "github.com/couchbase/eventing/dcp/transport"
 	cb "github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/eventsource"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/util"
 	"gopkg.in/couchbase/gocb.v1"
@@ -26,25 +26,47 @@ func (c *Consumer) processDCPEvents() {
 
 	functionInstanceID := strconv.Itoa(int(c.app.FunctionID)) + "-" + c.app.FunctionInstanceID
 
+	// Held events have to be released even if nothing more comes in over DCP
+	releaseTicker := time.NewTicker(socketWriteTimerInterval)
+	defer releaseTicker.Stop()
+
 	for {
-		if c.cppQueueSizes != nil {
-			if c.workerQueueCap < (c.numSentEvents-c.cppQueueSizes.NumProcessedEvents) ||
-				c.workerQueueMemCap < (c.sentEventsSize-c.cppQueueSizes.ProcessedEventsSize) {
-				logging.Debugf("%s [%s:%s:%d] Throttling, cpp queue sizes: %+v, num sent event: %d, events size: %d",
-					logPrefix, c.workerName, c.tcpPort, c.Pid(), c.cppQueueSizes, c.numSentEvents, c.sentEventsSize)
-
-				// avoid throttling when consumer is pausing
-				if !c.isPausing {
-					time.Sleep(10 * time.Millisecond)
-				}
+		// Events read past this point would be lost once worker is restarted, so
+		// they're left with DCP and get streamed again from the drain checkpoint
+		if atomic.LoadUint32(&c.drainingForRestart) == 1 {
+			c.releaseHeldDcpEvents(true)
+			atomic.StoreUint32(&c.dcpEventsParked, 1)
+			select {
+			case <-c.stopConsumerCh:
+				logging.Infof("%s [%s:%s:%d] Exiting processDCPEvents routine",
+					logPrefix, c.workerName, c.tcpPort, c.Pid())
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			continue
+		}
+		atomic.StoreUint32(&c.dcpEventsParked, 0)
 
-				// If rebalance in ongoing, it's important to read dcp mutations as STREAMBEGIN/END messages could be behind them.
-				// And it is also important to not queue up mutations in consumer to contain rss growth when cpp queues are full.
-				// So *continue* only when there is no rebalance on going
-				if !(c.isRebalanceOngoing || c.isPausing) {
-					continue
-				}
+		c.releaseHeldDcpEvents(c.isRebalanceOngoing || c.isPausing)
+
+		if c.workerQueuesFull() {
+			logging.Debugf("%s [%s:%s:%d] Throttling, cpp queue sizes: %+v, num sent event: %d, events size: %d",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), c.getCppQueueSizes(), atomic.LoadInt64(&c.numSentEvents), atomic.LoadInt64(&c.sentEventsSize))
+
+			// Priority events can only get past the ones ahead of them in DCP stream
+			// if those are read and held back, up to a limit
+			holding := c.canHoldDcpEvents()
 
+			// avoid throttling when consumer is pausing
+			if !c.isPausing && !holding {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// If rebalance in ongoing, it's important to read dcp mutations as STREAMBEGIN/END messages could be behind them.
+			// And it is also important to not queue up mutations in consumer to contain rss growth when cpp queues are full.
+			// So *continue* only when there is no rebalance on going
+			if !(c.isRebalanceOngoing || c.isPausing || holding) {
+				continue
 			}
 		}
 
@@ -90,7 +112,6 @@ func (c *Consumer) processDCPEvents() {
 					c.dcpMutationCounter++
 					c.sendEvent(e)
 				case dcpDatatypeJSONXattr:
-					xattrLen := binary.BigEndian.Uint32(e.Value[0:4])
 					if c.app.SrcMutationEnabled {
 						if isRecursive, err := c.isRecursiveDCPEvent(e, functionInstanceID); err == nil && isRecursive == true {
 							c.suppressedDCPMutationCounter++
@@ -98,14 +119,14 @@ func (c *Consumer) processDCPEvents() {
 							logging.Tracef("%s [%s:%s:%d] No IntraHandlerRecursion, sending key: %ru to be processed by JS handlers",
 								logPrefix, c.workerName, c.tcpPort, c.Pid(), string(e.Key))
 							c.dcpMutationCounter++
-							e.Value = e.Value[xattrLen+4:]
+							c.stripXattrs(e)
 							c.sendEvent(e)
 						}
 					} else {
 						logging.Tracef("%s [%s:%s:%d] Sending key: %ru to be processed by JS handlers",
 							logPrefix, c.workerName, c.tcpPort, c.Pid(), string(e.Key))
 						c.dcpMutationCounter++
-						e.Value = e.Value[xattrLen+4:]
+						c.stripXattrs(e)
 						c.sendEvent(e)
 					}
 				}
@@ -297,6 +318,9 @@ func (c *Consumer) processDCPEvents() {
 			case mcd.DCP_STREAMEND:
 				logging.Infof("%s [%s:%s:%d] vb: %d got STREAMEND", logPrefix, c.workerName, c.tcpPort, c.Pid(), e.VBucket)
 
+				// Vb filter sent to worker has to come after every event read on the vb
+				c.releaseHeldDcpEvents(true)
+
 				c.vbProcessingStats.updateVbStat(e.VBucket, "vb_stream_request_metadata_updated", false)
 				lastReadSeqNo := c.vbProcessingStats.getVbStat(e.VBucket, "last_read_seq_no").(uint64)
 				c.vbProcessingStats.updateVbStat(e.VBucket, "seq_no_at_stream_end", lastReadSeqNo)
@@ -307,12 +331,14 @@ func (c *Consumer) processDCPEvents() {
 					logging.Infof("STREAMEND without streaming any mutation last_read_seqno: %d last_sent_seqno: %d", lastReadSeqNo, lastSentSeqNo)
 					c.handleStreamEnd(e.VBucket, lastReadSeqNo)
 				} else {
-					c.sendVbFilterData(e.VBucket, lastSentSeqNo, false)
+					c.sendVbFilterData(e.VBucket, lastSentSeqNo, false, true)
 				}
 
 			default:
 			}
 
+		case <-releaseTicker.C:
+
 		case <-c.stopConsumerCh:
 			logging.Infof("%s [%s:%s:%d] Exiting processDCPEvents routine",
 				logPrefix, c.workerName, c.tcpPort, c.Pid())
@@ -779,103 +805,34 @@ func (c *Consumer) dcpRequestStreamHandle(vb uint16, vbBlob *vbucketKVBlob, star
 		return nil
 	}
 
-	refreshMap := func() error {
-		c.cbBucketRWMutex.Lock()
-		defer c.cbBucketRWMutex.Unlock()
-
-		var err error
-		c.cbBucket, err = c.superSup.GetBucket(c.cbBucket.Name)
-		if err != nil {
-			logging.Infof("%s [%s:%s:%d] vb: %d failed to refresh vbmap",
-				logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
-			return err
-		}
-		return nil
-	}
-
-	err := refreshMap()
-	if err != nil {
-		return err
-	}
-
-	err = util.Retry(util.NewFixedBackoff(clusterOpRetryInterval), c.retryCount, getKvVbMap, c)
-	if err == common.ErrRetryTimeout {
-		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
-		return err
-	}
-
-	vbKvAddr := c.kvVbMap[vb]
-
-	// Closing feeds for KV hosts which are no more present in kv vb map
-	err = c.cleanupStaleDcpFeedHandles()
-	if err == common.ErrRetryTimeout {
-		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
-		return err
-	}
-
-	c.hostDcpFeedRWMutex.Lock()
-	dcpFeed, ok := c.kvHostDcpFeedMap[vbKvAddr]
-	if !ok {
-		feedName := couchbase.NewDcpFeedName(c.HostPortAddr() + "_" + vbKvAddr + "_" + c.workerName)
-		err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, startDCPFeedOpCallback, c, feedName, vbKvAddr)
-		if err == common.ErrRetryTimeout {
-			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
-			return err
-		}
-
-		dcpFeed = c.kvHostDcpFeedMap[vbKvAddr]
-
-		c.addToAggChan(dcpFeed)
-
-		logging.Infof("%s [%s:%s:%d] vb: %d kvAddr: %s Started up new dcp feed. Spawned aggChan routine",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbKvAddr)
-	}
-	c.hostDcpFeedRWMutex.Unlock()
-
-	c.Lock()
-	c.vbDcpFeedMap[vb] = dcpFeed
-	c.Unlock()
-
-	opaque, flags := uint16(vb), uint32(0)
-	end := uint64(0xFFFFFFFFFFFFFFFF)
-
-	snapStart, snapEnd := start, start
-
-	logging.Infof("%s [%s:%s:%d] vb: %d DCP stream start vbKvAddr: %rs vbuuid: %d startSeq: %d snapshotStart: %d snapshotEnd: %d",
-		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbKvAddr, vbBlob.VBuuid, start, snapStart, snapEnd)
-
 	if c.dcpFeedsClosed {
 		return errDcpFeedsClosed
 	}
 
 	c.vbsStreamRRWMutex.Lock()
-	if _, ok := c.vbStreamRequested[vb]; !ok {
-		c.vbStreamRequested[vb] = start
-		logging.Infof("%s [%s:%s:%d] vb: %v Going to make DcpRequestStream call",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
-	} else {
+	if _, ok := c.vbStreamRequested[vb]; ok {
 		c.vbsStreamRRWMutex.Unlock()
-		logging.Infof("%s [%s:%s:%d] vb: %v skipping DcpRequestStream call as one is already in-progress",
+		logging.Infof("%s [%s:%s:%d] vb: %v skipping StartStream call as one is already in-progress",
 			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
 		return nil
 	}
+	c.vbStreamRequested[vb] = start
 	c.vbsStreamRRWMutex.Unlock()
 
-	err = refreshMap()
-	if err != nil {
-		return err
-	}
-
-	if atomic.LoadUint32(&c.isTerminateRunning) == 1 {
-		return fmt.Errorf("function is terminating")
-	}
+	logging.Infof("%s [%s:%s:%d] vb: %d Event source: %s stream start vbuuid: %d startSeq: %d",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, c.eventSource.Name(), vbBlob.VBuuid, start)
 
 	c.dcpStreamReqCounter++
-	err = dcpFeed.DcpRequestStream(vb, opaque, flags, vbBlob.VBuuid, start, end, snapStart, snapEnd)
+	err := c.eventSource.StartStream(vb, eventsource.Position{
+		VBuuid:    vbBlob.VBuuid,
+		SeqNo:     start,
+		SnapStart: start,
+		SnapEnd:   start,
+	})
 	if err != nil {
 		c.dcpStreamReqErrCounter++
-		logging.Errorf("%s [%s:%s:%d] vb: %d STREAMREQ call failed on dcpFeed: %v, err: %v",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, dcpFeed.GetName(), err)
+		logging.Errorf("%s [%s:%s:%d] vb: %d StartStream call failed on event source: %s, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, c.eventSource.Name(), err)
 
 		c.purgeVbStreamRequested(logPrefix, vb)
 
@@ -884,59 +841,54 @@ func (c *Consumer) dcpRequestStreamHandle(vb uint16, vbBlob *vbucketKVBlob, star
 			c.vbsRemainingToRestream = append(c.vbsRemainingToRestream, vb)
 			c.Unlock()
 		}
+		return err
+	}
 
-		if err == couchbase.ErrorInvalidVbucket {
-			return err
-		}
-
-		dcpFeed.Close()
-
-		c.hostDcpFeedRWMutex.Lock()
-		delete(c.kvHostDcpFeedMap, vbKvAddr)
-		c.hostDcpFeedRWMutex.Unlock()
+	return c.updateStreamRequestedStats(vb, start)
+}
 
-		logging.Infof("%s [%s:%s:%d] vb: %d Closed and deleted dcpfeed mapping to kvAddr: %s",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbKvAddr)
-	} else {
+// updateStreamRequestedStats records a successfully issued STREAMREQ in vb stats
+// and ownership history, irrespective of the event source serving the stream
+func (c *Consumer) updateStreamRequestedStats(vb uint16, start uint64) error {
+	logPrefix := "Consumer::updateStreamRequestedStats"
 
-		c.vbProcessingStats.updateVbStat(vb, "last_read_seq_no", start)
-		c.vbProcessingStats.updateVbStat(vb, "last_processed_seq_no", start)
-		c.vbProcessingStats.updateVbStat(vb, "last_sent_seq_no", uint64(0))
+	c.vbProcessingStats.updateVbStat(vb, "last_read_seq_no", start)
+	c.vbProcessingStats.updateVbStat(vb, "last_processed_seq_no", start)
+	c.vbProcessingStats.updateVbStat(vb, "last_sent_seq_no", uint64(0))
 
-		logging.Infof("%s [%s:%s:%d] vb: %d Adding entry into inflightDcpStreams",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
+	logging.Infof("%s [%s:%s:%d] vb: %d Adding entry into inflightDcpStreams",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
 
-		c.inflightDcpStreamsRWMutex.Lock()
-		c.inflightDcpStreams[vb] = struct{}{}
-		c.inflightDcpStreamsRWMutex.Unlock()
+	c.inflightDcpStreamsRWMutex.Lock()
+	c.inflightDcpStreams[vb] = struct{}{}
+	c.inflightDcpStreamsRWMutex.Unlock()
 
-		entry := OwnershipEntry{
-			AssignedWorker: c.ConsumerName(),
-			CurrentVBOwner: c.HostPortAddr(),
-			Operation:      dcpStreamRequested,
-			SeqNo:          start,
-			Timestamp:      time.Now().String(),
-		}
+	entry := OwnershipEntry{
+		AssignedWorker: c.ConsumerName(),
+		CurrentVBOwner: c.HostPortAddr(),
+		Operation:      dcpStreamRequested,
+		SeqNo:          start,
+		Timestamp:      time.Now().String(),
+	}
 
-		vbKey := fmt.Sprintf("%s::vb::%d", c.app.AppName, vb)
-		err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, addOwnershipHistorySRRCallback,
-			c, c.producer.AddMetadataPrefix(vbKey), &entry)
-		if err == common.ErrRetryTimeout {
-			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
-			return err
-		}
+	vbKey := fmt.Sprintf("%s::vb::%d", c.app.AppName, vb)
+	err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, addOwnershipHistorySRRCallback,
+		c, c.producer.AddMetadataPrefix(vbKey), &entry)
+	if err == common.ErrRetryTimeout {
+		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+		return err
+	}
 
-		c.vbProcessingStats.updateVbStat(vb, "vb_stream_request_metadata_updated", true)
+	c.vbProcessingStats.updateVbStat(vb, "vb_stream_request_metadata_updated", true)
 
-		c.vbProcessingStats.updateVbStat(vb, "dcp_stream_requested", true)
-		c.vbProcessingStats.updateVbStat(vb, "dcp_stream_requested_worker", c.ConsumerName())
-		c.vbProcessingStats.updateVbStat(vb, "dcp_stream_requested_node_uuid", c.NodeUUID())
+	c.vbProcessingStats.updateVbStat(vb, "dcp_stream_requested", true)
+	c.vbProcessingStats.updateVbStat(vb, "dcp_stream_requested_worker", c.ConsumerName())
+	c.vbProcessingStats.updateVbStat(vb, "dcp_stream_requested_node_uuid", c.NodeUUID())
 
-		logging.Infof("%s [%s:%s:%d] vb: %d Updated checkpoint blob to indicate STREAMREQ was issued",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
-	}
+	logging.Infof("%s [%s:%s:%d] vb: %d Updated checkpoint blob to indicate STREAMREQ was issued",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), vb)
 
-	return err
+	return nil
 }
 
 func (c *Consumer) handleFailoverLog() {
@@ -1011,13 +963,24 @@ func (c *Consumer) handleFailoverLog() {
 					logging.Infof("%s [%s:%s:%d] vb: %d rollback requested by DCP. Retrying DCP stream start vbuuid: %d startSeq: %d flog startSeqNo: %d",
 						logPrefix, c.workerName, c.tcpPort, c.Pid(), vbFlog.vb, vbBlob.VBuuid, vbFlog.seqNo, startSeqNo)
 
+					var restream bool
+					vbuuid, startSeqNo, restream, err = c.applyRollbackPolicy(vbKey, vbFlog, &vbBlob, flogs[vbFlog.vb], vbuuid)
+					if err == common.ErrRetryTimeout {
+						return
+					}
+
+					if !restream {
+						c.purgeVbStreamRequested(logPrefix, vbFlog.vb)
+						continue
+					}
+
 					// update in-memory stats to reflect rollback seqno so that periodicCheckPoint picks up the latest data
-					c.vbProcessingStats.updateVbStat(vbFlog.vb, "last_processed_seq_no", vbFlog.seqNo)
+					c.vbProcessingStats.updateVbStat(vbFlog.vb, "last_processed_seq_no", startSeqNo)
 					c.vbProcessingStats.updateVbStat(vbFlog.vb, "vb_uuid", vbuuid)
 
 					// update check point blob to let a racing doVbTakeover during rebalance try with correct <vbuuid, seqno> on next attempt
 					vbBlob.VBuuid = vbuuid
-					vbBlob.LastSeqNoProcessed = vbFlog.seqNo
+					vbBlob.LastSeqNoProcessed = startSeqNo
 					err = c.updateCheckpoint(vbKey, vbFlog.vb, &vbBlob)
 					if err != nil {
 						logging.Errorf("%s [%s:%s:%d] updateCheckpoint failed, err: %v", logPrefix, c.workerName, c.tcpPort, c.Pid(), err)
@@ -1039,11 +1002,11 @@ func (c *Consumer) handleFailoverLog() {
 					// from DCP producer is because we don't precisely know the start_seq_no for
 					// for stream in later case, unless we maintain another data structure to
 					// maintain that information
-					c.sendVbFilterData(vbFlog.vb, vbFlog.seqNo, true)
+					c.sendVbFilterData(vbFlog.vb, startSeqNo, true, false)
 					streamInfo := &streamRequestInfo{
 						vb:         vbFlog.vb,
 						vbBlob:     &vbBlob,
-						startSeqNo: vbFlog.seqNo,
+						startSeqNo: startSeqNo,
 					}
 
 					select {
@@ -1051,7 +1014,7 @@ func (c *Consumer) handleFailoverLog() {
 					case <-c.stopConsumerCh:
 						return
 					}
-					c.vbProcessingStats.updateVbStat(vbFlog.vb, "start_seq_no", vbFlog.seqNo)
+					c.vbProcessingStats.updateVbStat(vbFlog.vb, "start_seq_no", startSeqNo)
 					c.vbProcessingStats.updateVbStat(vbFlog.vb, "timestamp", time.Now().Format(time.RFC3339))
 				} else {
 
@@ -1131,13 +1094,24 @@ func (c *Consumer) cppWorkerThrPartitionMap() {
 	}
 
 	c.cppThrPartitionMap = util.VbucketDistribution(partitions, c.cppWorkerThrCount)
+
+	c.cppPartitionThrMap = make([]int, cppWorkerPartitionCount)
+	for thrIndex, thrPartitions := range c.cppThrPartitionMap {
+		for _, partition := range thrPartitions {
+			c.cppPartitionThrMap[partition] = thrIndex
+		}
+	}
 }
 
 func (c *Consumer) sendEvent(e *cb.DcpEvent) error {
 	logPrefix := "Consumer::processTrappedEvent"
 
 	if !c.producer.IsTrapEvent() {
-		c.sendDcpEvent(e, false)
+		if c.priorityLanesEnabled() {
+			c.sendOrHoldDcpEvent(e)
+		} else {
+			c.sendDcpEvent(e, false)
+		}
 		return nil
 	}
 
@@ -1306,6 +1280,11 @@ func (c *Consumer) handleStreamEnd(vBucket uint16, last_processed_seqno uint64)
 	}
 
 	vbBlob.LastSeqNoProcessed = last_processed_seqno
+	vbBlob.Handover = vbHandover{
+		RequestTime: vbBlob.Handover.RequestTime,
+		SeqNo:       last_processed_seqno,
+		State:       vbHandoverConfirmed,
+	}
 	err = c.updateCheckpoint(vbKey, vBucket, &vbBlob)
 	if err == common.ErrRetryTimeout {
 		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
@@ -1353,13 +1332,12 @@ func (c *Consumer) processAndSendDcpDelOrExpMessage(e *cb.DcpEvent, functionInst
 	c.vbProcessingStats.updateVbStat(e.VBucket, "last_read_seq_no", e.Seqno)
 	switch e.Datatype {
 	case dcpDatatypeJSONXattr:
-		xattrLen := binary.BigEndian.Uint32(e.Value[0:4])
 		if c.app.SrcMutationEnabled && checkRecursiveEvent {
 			if isRecursive, err := c.isRecursiveDCPEvent(e, functionInstanceID); err == nil && isRecursive == true {
 				return false
 			}
 		}
-		e.Value = e.Value[xattrLen+4:]
+		c.stripXattrs(e)
 		logging.Tracef("%s [%s:%s:%d] Sending key: %ru to be processed by JS handlers",
 			logPrefix, c.workerName, c.tcpPort, c.Pid(), string(e.Key))
 		c.sendEvent(e)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/eventing/common"
@@ -11,6 +12,7 @@ import (
 	"github.com/couchbase/eventing/gen/flatbuf/payload"
 	"github.com/couchbase/eventing/gen/flatbuf/response"
 	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/timers"
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
@@ -24,6 +26,21 @@ const (
 	filterEvent
 	reservedEvent
 	pauseConsumer
+	handshakeEvent
+)
+
+// Version of the protocol spoken between Go producer and C++ worker. Bump it on any
+// change to message framing, enums or flatbuffer schemas that older binaries can't
+// interpret. Version 2 introduced the handshake and typed stats responses, version 3
+// batched dcp events and checkpoint acks.
+const (
+	workerProtocolVersion    int16 = 3
+	minWorkerProtocolVersion int16 = 2
+)
+
+const (
+	handshakeOpcode int8 = iota
+	handshakeRequest
 )
 
 const (
@@ -64,6 +81,7 @@ const (
 	dcpOpcode int8 = iota
 	dcpDeletion
 	dcpMutation
+	dcpBatch
 )
 
 const (
@@ -84,6 +102,8 @@ const (
 	bucketOpsResponse
 	bucketOpsFilterAck
 	pauseAck
+	handshakeResponse
+	protocolError
 )
 
 const (
@@ -108,12 +128,25 @@ const (
 
 const (
 	bucketOpsResponseOpcode int8 = iota
+	bucketOpsBatchCheckpoint
 )
 
 const (
 	bucketOpsFilterAckOpCode int8 = iota
 )
 
+const (
+	handshakeResponseOpcode int8 = iota
+	handshakeAccepted
+	handshakeRejected
+)
+
+const (
+	protocolErrorOpcode int8 = iota
+	unknownEvent
+	unknownOpcode
+)
+
 type message struct {
 	Header  []byte
 	Payload []byte
@@ -123,16 +156,16 @@ func (c *Consumer) makeTimerEventHeader(partition int16) ([]byte, *flatbuffers.B
 	return c.makeHeader(timerEvent, timer, partition, "")
 }
 
-func (c *Consumer) makeDcpMutationHeader(partition int16, mutationMeta string) ([]byte, *flatbuffers.Builder) {
-	return c.makeDcpHeader(dcpMutation, partition, mutationMeta)
+func (c *Consumer) makeDcpMutationHeader(partition int16, mutationMeta string, priority bool) ([]byte, *flatbuffers.Builder) {
+	return c.makeDcpHeader(dcpMutation, partition, mutationMeta, priority)
 }
 
-func (c *Consumer) makeDcpDeletionHeader(partition int16, deletionMeta string) ([]byte, *flatbuffers.Builder) {
-	return c.makeDcpHeader(dcpDeletion, partition, deletionMeta)
+func (c *Consumer) makeDcpDeletionHeader(partition int16, deletionMeta string, priority bool) ([]byte, *flatbuffers.Builder) {
+	return c.makeDcpHeader(dcpDeletion, partition, deletionMeta, priority)
 }
 
-func (c *Consumer) makeDcpHeader(opcode int8, partition int16, meta string) ([]byte, *flatbuffers.Builder) {
-	return c.makeHeader(dcpEvent, opcode, partition, meta)
+func (c *Consumer) makeDcpHeader(opcode int8, partition int16, meta string, priority bool) ([]byte, *flatbuffers.Builder) {
+	return c.makeHeaderWithPriority(dcpEvent, opcode, partition, meta, priority)
 }
 
 func (c *Consumer) filterEventHeader(opcode int8, partition int16, meta string) ([]byte, *flatbuffers.Builder) {
@@ -150,6 +183,23 @@ func (c *Consumer) makeProcessedSeqNoHeader(partition int16, meta string) ([]byt
 	return c.filterEventHeader(processedSeqNo, partition, meta)
 }
 
+func (c *Consumer) makeHandshakeHeader() ([]byte, *flatbuffers.Builder) {
+	return c.makeHeader(handshakeEvent, handshakeRequest, 0, "")
+}
+
+func (c *Consumer) makeHandshakePayload() (encodedPayload []byte, builder *flatbuffers.Builder) {
+	builder = c.getBuilder()
+
+	payload.PayloadStart(builder)
+	payload.PayloadAddProtocolVersion(builder, workerProtocolVersion)
+	payload.PayloadAddMinProtocolVersion(builder, minWorkerProtocolVersion)
+	payloadPos := payload.PayloadEnd(builder)
+	builder.Finish(payloadPos)
+
+	encodedPayload = builder.FinishedBytes()
+	return
+}
+
 func (c *Consumer) makeV8DebuggerStartHeader() ([]byte, *flatbuffers.Builder) {
 	return c.makeV8DebuggerHeader(startDebug, "")
 }
@@ -199,6 +249,12 @@ func (c *Consumer) makeVbMapHeader() ([]byte, *flatbuffers.Builder) {
 }
 
 func (c *Consumer) makeHeader(event int8, opcode int8, partition int16, meta string) (encodedHeader []byte, builder *flatbuffers.Builder) {
+	return c.makeHeaderWithPriority(event, opcode, partition, meta, false)
+}
+
+// makeHeaderWithPriority sets priority flag on header, which has worker thread
+// queue the message in its priority lane
+func (c *Consumer) makeHeaderWithPriority(event int8, opcode int8, partition int16, meta string, priority bool) (encodedHeader []byte, builder *flatbuffers.Builder) {
 	builder = c.getBuilder()
 
 	metadata := builder.CreateString(meta)
@@ -209,6 +265,11 @@ func (c *Consumer) makeHeader(event int8, opcode int8, partition int16, meta str
 	header.HeaderAddOpcode(builder, opcode)
 	header.HeaderAddPartition(builder, partition)
 	header.HeaderAddMetadata(builder, metadata)
+	if priority {
+		priorityFlag := make([]byte, 1)
+		flatbuffers.WriteBool(priorityFlag, priority)
+		header.HeaderAddPriority(builder, priorityFlag[0])
+	}
 
 	headerPos := header.HeaderEnd(builder)
 	builder.Finish(headerPos)
@@ -322,6 +383,8 @@ func (c *Consumer) makeV8InitPayload(appName, debuggerPort, currHost, eventingDi
 	handlerFooters := c.createHandlerFooters(builder)
 	n1qlConsistency := builder.CreateString(c.n1qlConsistency)
 	languageCompatibility := builder.CreateString(c.languageCompatibility)
+	missedTimerPolicy := builder.CreateString(c.missedTimerPolicy)
+	timerContextOverflow := builder.CreateString(c.timerContextOverflow)
 
 	lcb := make([]byte, 1)
 	flatbuffers.WriteBool(lcb, skipLcbBootstrap)
@@ -329,6 +392,12 @@ func (c *Consumer) makeV8InitPayload(appName, debuggerPort, currHost, eventingDi
 	usingTimer := make([]byte, 1)
 	flatbuffers.WriteBool(usingTimer, c.usingTimer)
 
+	idempotencyKeys := make([]byte, 1)
+	flatbuffers.WriteBool(idempotencyKeys, c.idempotencyKeys)
+
+	rejectTimersInPast := make([]byte, 1)
+	flatbuffers.WriteBool(rejectTimersInPast, c.timerInPastPolicy == string(timers.InPastReject))
+
 	payload.PayloadStart(builder)
 
 	payload.PayloadAddAppName(builder, app)
@@ -346,6 +415,13 @@ func (c *Consumer) makeV8InitPayload(appName, debuggerPort, currHost, eventingDi
 	payload.PayloadAddFunctionInstanceId(builder, fiid)
 	payload.PayloadAddSkipLcbBootstrap(builder, lcb[0])
 	payload.PayloadAddUsingTimer(builder, usingTimer[0])
+	payload.PayloadAddIdempotencyKeys(builder, idempotencyKeys[0])
+	payload.PayloadAddRejectTimersInPast(builder, rejectTimersInPast[0])
+	payload.PayloadAddTimerResolution(builder, c.timerResolution)
+	payload.PayloadAddTimerContextOverflow(builder, timerContextOverflow)
+	payload.PayloadAddMissedTimerPolicy(builder, missedTimerPolicy)
+	payload.PayloadAddMissedTimerThreshold(builder, c.missedTimerThreshold)
+	payload.PayloadAddMissedTimerRate(builder, c.missedTimerRate)
 	payload.PayloadAddHandlerHeaders(builder, handlerHeaders)
 	payload.PayloadAddHandlerFooters(builder, handlerFooters)
 	payload.PayloadAddN1qlConsistency(builder, n1qlConsistency)
@@ -388,9 +464,162 @@ func (c *Consumer) parseWorkerResponse(msg []byte) {
 
 	msgType := r.MsgType()
 	opcode := r.Opcode()
-	message := string(r.Msg())
 
-	c.routeResponse(msgType, opcode, message)
+	switch msgType {
+	case handshakeResponse:
+		c.handleHandshakeResponse(opcode, r.ProtocolVersion(), r.MinProtocolVersion(), string(r.Msg()))
+		return
+	case protocolError:
+		c.handleProtocolError(opcode, string(r.Msg()))
+		return
+	case bucketOpsResponse:
+		if opcode == bucketOpsBatchCheckpoint {
+			c.handleBatchCheckpoint(r)
+			return
+		}
+	}
+
+	if stats := r.Stats(nil); stats != nil {
+		c.routeStatsResponse(msgType, opcode, stats)
+		return
+	}
+
+	c.routeResponse(msgType, opcode, string(r.Msg()))
+}
+
+// routeStatsResponse handles stats sent by worker as typed flatbuffer tables
+func (c *Consumer) routeStatsResponse(msgType, opcode int8, stats *response.Stats) {
+	logPrefix := "Consumer::routeStatsResponse"
+
+	if msgType != respV8WorkerConfig {
+		c.rejectResponse(msgType, opcode)
+		return
+	}
+
+	switch opcode {
+	case latencyStats:
+		c.workerRespMainLoopTs.Store(time.Now())
+		c.producer.AppendLatencyStats(statsCounters(stats))
+
+	case curlLatencyStats:
+		c.workerRespMainLoopTs.Store(time.Now())
+		c.producer.AppendCurlLatencyStats(statsCounters(stats))
+
+	case failureStats:
+		c.workerRespMainLoopTs.Store(time.Now())
+
+		c.statsRWMutex.Lock()
+		defer c.statsRWMutex.Unlock()
+		c.failureStats = statsToMap(stats)
+
+	case executionStats:
+		c.workerRespMainLoopTs.Store(time.Now())
+
+		counters := statsCounters(stats)
+
+		c.statsRWMutex.Lock()
+		defer c.statsRWMutex.Unlock()
+		c.executionStats = statsToMap(stats)
+		c.appendTimerFiringStats(c.executionStats["timer_firing"])
+		if val, ok := counters["timer_create_counter"]; ok {
+			c.timerResponsesRecieved = val
+		}
+		if val, ok := counters["timer_msg_counter"]; ok {
+			c.timerMessagesProcessed = val
+		}
+
+	case queueSize:
+		c.workerRespMainLoopTs.Store(time.Now())
+
+		counters := statsCounters(stats)
+		c.setCppQueueSizes(&cppQueueSize{
+			AggQueueSize:        int64(counters["agg_queue_size"]),
+			AggQueueMemory:      int64(counters["agg_queue_memory"]),
+			ProcessedEventsSize: int64(counters["processed_events_size"]),
+			NumProcessedEvents:  int64(counters["num_processed_events"]),
+		})
+		c.releaseUnackedSeqNos()
+
+	case lcbExceptions:
+		c.workerRespMainLoopTs.Store(time.Now())
+
+		c.statsRWMutex.Lock()
+		defer c.statsRWMutex.Unlock()
+		c.lcbExceptionStats = statsCounters(stats)
+
+	default:
+		logging.Errorf("%s [%s:%s:%d] Typed stats not expected for opcode: %d",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), opcode)
+		c.rejectResponse(msgType, opcode)
+	}
+}
+
+// statsCounters returns top level counters in stats table
+func statsCounters(stats *response.Stats) common.StatsData {
+	counters := make(common.StatsData)
+
+	var stat response.Stat
+	for i := 0; i < stats.CountersLength(); i++ {
+		if stats.Counters(&stat, i) {
+			counters[string(stat.Name())] = uint64(stat.Value())
+		}
+	}
+	return counters
+}
+
+// statsToMap lays out stats table the way JSON encoded stats from worker used to
+// decode i.e. numbers as float64 and stat groups as nested maps, so that
+// consumers of execution and failure stats see the same shape either way
+func statsToMap(stats *response.Stats) map[string]interface{} {
+	m := make(map[string]interface{})
+
+	var stat response.Stat
+	for i := 0; i < stats.CountersLength(); i++ {
+		if stats.Counters(&stat, i) {
+			m[string(stat.Name())] = float64(stat.Value())
+		}
+	}
+
+	var group response.StatGroup
+	for i := 0; i < stats.GroupsLength(); i++ {
+		if !stats.Groups(&group, i) {
+			continue
+		}
+
+		gm := make(map[string]interface{})
+		for j := 0; j < group.StatsLength(); j++ {
+			if group.Stats(&stat, j) {
+				gm[string(stat.Name())] = float64(stat.Value())
+			}
+		}
+		m[string(group.Name())] = gm
+	}
+
+	if ts := stats.Timestamp(); len(ts) > 0 {
+		m["timestamp"] = string(ts)
+	}
+	return m
+}
+
+// rejectResponse drops a response worker isn't expected to send with the negotiated
+// protocol version, which points to mismatched producer and worker binaries
+func (c *Consumer) rejectResponse(msgType, opcode int8) {
+	logPrefix := "Consumer::rejectResponse"
+
+	atomic.AddUint64(&c.workerUnknownResponseCounter, 1)
+	logging.Errorf("%s [%s:%s:%d] Dropping unknown response from worker, msg type: %s opcode: %s protocol version: %d",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), responseTypeName(msgType),
+		responseOpcodeName(msgType, opcode), atomic.LoadInt32(&c.workerProtocolVersion))
+}
+
+// handleProtocolError logs a message worker couldn't interpret and dropped
+func (c *Consumer) handleProtocolError(opcode int8, msg string) {
+	logPrefix := "Consumer::handleProtocolError"
+
+	atomic.AddUint64(&c.workerRejectedMsgCounter, 1)
+	logging.Errorf("%s [%s:%s:%d] Worker rejected message, reason: %s details: %s protocol version: %d",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), responseOpcodeName(protocolError, opcode),
+		msg, atomic.LoadInt32(&c.workerProtocolVersion))
 }
 
 func (c *Consumer) routeResponse(msgType, opcode int8, msg string) {
@@ -453,6 +682,7 @@ func (c *Consumer) routeResponse(msgType, opcode int8, msg string) {
 				logging.Errorf("%s [%s:%s:%d] Failed to unmarshal execution stats, msg: %v err: %v",
 					logPrefix, c.workerName, c.tcpPort, c.Pid(), msg, err)
 			} else {
+				c.appendTimerFiringStats(c.executionStats["timer_firing"])
 				if val, ok := c.executionStats["timer_create_counter"]; ok {
 					c.timerResponsesRecieved = uint64(val.(float64))
 				}
@@ -469,10 +699,14 @@ func (c *Consumer) routeResponse(msgType, opcode int8, msg string) {
 		case queueSize:
 			c.workerRespMainLoopTs.Store(time.Now())
 
-			err := json.Unmarshal([]byte(msg), &c.cppQueueSizes)
+			queueSizes := &cppQueueSize{}
+			err := json.Unmarshal([]byte(msg), queueSizes)
 			if err != nil {
 				logging.Errorf("%s [%s:%s:%d] Failed to unmarshal cpp queue sizes, msg: %v err: %v",
 					logPrefix, c.workerName, c.tcpPort, c.Pid(), msg, err)
+			} else {
+				c.setCppQueueSizes(queueSizes)
+				c.releaseUnackedSeqNos()
 			}
 		case lcbExceptions:
 			c.workerRespMainLoopTs.Store(time.Now())
@@ -484,6 +718,8 @@ func (c *Consumer) routeResponse(msgType, opcode int8, msg string) {
 				logging.Errorf("%s [%s:%s:%d] Failed to unmarshal lcb exception stats, msg: %v err: %v",
 					logPrefix, c.workerName, c.tcpPort, c.Pid(), msg, err)
 			}
+		default:
+			c.rejectResponse(msgType, opcode)
 		}
 
 	case bucketOpsResponse:
@@ -507,12 +743,8 @@ func (c *Consumer) routeResponse(msgType, opcode int8, msg string) {
 				logPrefix, c.workerName, c.tcpPort, c.Pid(), seqNoStr, msg, err)
 			return
 		}
-		prevSeqNo := c.vbProcessingStats.getVbStat(uint16(vb), "last_processed_seq_no").(uint64)
-		if seqNo > prevSeqNo {
-			c.vbProcessingStats.updateVbStat(uint16(vb), "last_processed_seq_no", seqNo)
-			logging.Tracef("%s [%s:%s:%d] vb: %d Updating last_processed_seq_no to seqNo: %d",
-				logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, seqNo)
-		}
+		c.updateLastProcessedSeqNo(uint16(vb), seqNo)
+
 	case bucketOpsFilterAck:
 		var ack vbSeqNo
 		err := json.Unmarshal([]byte(msg), &ack)
@@ -543,5 +775,29 @@ func (c *Consumer) routeResponse(msgType, opcode int8, msg string) {
 	default:
 		logging.Infof("%s [%s:%s:%d] Unknown message %s",
 			logPrefix, c.workerName, c.tcpPort, c.Pid(), msg)
+		c.rejectResponse(msgType, opcode)
+	}
+}
+
+// handleBatchCheckpoint applies the single ack worker sends for all vbuckets it
+// processed events for since the last one
+func (c *Consumer) handleBatchCheckpoint(r *response.Response) {
+	var entry response.VbSeqNo
+	for i := 0; i < r.VbSeqNosLength(); i++ {
+		if r.VbSeqNos(&entry, i) {
+			c.updateLastProcessedSeqNo(uint16(entry.Vb()), entry.SeqNo())
+		}
+	}
+}
+
+func (c *Consumer) updateLastProcessedSeqNo(vb uint16, seqNo uint64) {
+	logPrefix := "Consumer::updateLastProcessedSeqNo"
+
+	seqNo = c.clampToHeldSeqNo(vb, c.clampToUnackedSeqNo(vb, seqNo))
+	prevSeqNo := c.vbProcessingStats.getVbStat(vb, "last_processed_seq_no").(uint64)
+	if seqNo > prevSeqNo {
+		c.vbProcessingStats.updateVbStat(vb, "last_processed_seq_no", seqNo)
+		logging.Tracef("%s [%s:%s:%d] vb: %d Updating last_processed_seq_no to seqNo: %d",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, seqNo)
 	}
 }
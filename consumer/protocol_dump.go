@@ -0,0 +1,258 @@
+package consumer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/couchbase/eventing/gen/flatbuf/header"
+	"github.com/couchbase/eventing/gen/flatbuf/payload"
+	"github.com/couchbase/eventing/gen/flatbuf/response"
+)
+
+var eventNames = map[int8]string{
+	dcpEvent:         "dcp",
+	v8WorkerEvent:    "v8_worker",
+	appWorkerSetting: "app_worker_setting",
+	timerEvent:       "timer",
+	debuggerEvent:    "debugger",
+	filterEvent:      "filter",
+	reservedEvent:    "reserved",
+	pauseConsumer:    "pause_consumer",
+	handshakeEvent:   "handshake",
+}
+
+var requestOpcodeNames = map[int8]map[int8]string{
+	dcpEvent: {
+		dcpDeletion: "deletion",
+		dcpMutation: "mutation",
+		dcpBatch:    "batch",
+	},
+	v8WorkerEvent: {
+		v8WorkerDispose:          "dispose",
+		v8WorkerInit:             "init",
+		v8WorkerLoad:             "load",
+		v8WorkerTerminate:        "terminate",
+		v8WorkerLatencyStats:     "latency_stats",
+		v8WorkerFailureStats:     "failure_stats",
+		v8WorkerExecutionStats:   "execution_stats",
+		v8WorkerCompile:          "compile",
+		v8WorkerLcbExceptions:    "lcb_exceptions",
+		v8WorkerCurlLatencyStats: "curl_latency_stats",
+		v8WorkerInsight:          "insight",
+	},
+	appWorkerSetting: {
+		logLevel:                 "log_level",
+		workerThreadCount:        "thr_count",
+		workerThreadPartitionMap: "thr_map",
+		timerContextSize:         "timer_context_size",
+		vbMap:                    "vb_map",
+		workerThreadMemQuota:     "mem_quota",
+	},
+	timerEvent: {
+		timer: "timer",
+	},
+	debuggerEvent: {
+		startDebug: "start",
+		stopDebug:  "stop",
+	},
+	filterEvent: {
+		vbFilter:       "vb_filter",
+		processedSeqNo: "processed_seq_no",
+	},
+	pauseConsumer: {
+		0: "pause",
+	},
+	handshakeEvent: {
+		handshakeRequest: "request",
+	},
+}
+
+var responseTypeNames = map[int8]string{
+	respV8WorkerConfig: "v8_worker_config",
+	docTimerResponse:   "doc_timer",
+	bucketOpsResponse:  "bucket_ops",
+	bucketOpsFilterAck: "filter_ack",
+	pauseAck:           "pause_ack",
+	handshakeResponse:  "handshake",
+	protocolError:      "protocol_error",
+}
+
+var responseOpcodeNames = map[int8]map[int8]string{
+	respV8WorkerConfig: {
+		appLogMessage:    "app_log",
+		sysLogMessage:    "sys_log",
+		latencyStats:     "latency_stats",
+		failureStats:     "failure_stats",
+		executionStats:   "execution_stats",
+		compileInfo:      "compile_info",
+		queueSize:        "queue_size",
+		lcbExceptions:    "lcb_exceptions",
+		curlLatencyStats: "curl_latency_stats",
+		insight:          "insight",
+	},
+	bucketOpsResponse: {
+		bucketOpsResponseOpcode:  "checkpoint",
+		bucketOpsBatchCheckpoint: "batch_checkpoint",
+	},
+	handshakeResponse: {
+		handshakeAccepted: "accepted",
+		handshakeRejected: "rejected",
+	},
+	protocolError: {
+		unknownEvent:  "unknown_event",
+		unknownOpcode: "unknown_opcode",
+	},
+}
+
+func eventName(event int8) string {
+	if name, ok := eventNames[event]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", event)
+}
+
+func requestOpcodeName(event, opcode int8) string {
+	if name, ok := requestOpcodeNames[event][opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", opcode)
+}
+
+func responseTypeName(msgType int8) string {
+	if name, ok := responseTypeNames[msgType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", msgType)
+}
+
+func responseOpcodeName(msgType, opcode int8) string {
+	if name, ok := responseOpcodeNames[msgType][opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", opcode)
+}
+
+// DumpWorkerRequests decodes a captured stream of messages written by producer to
+// C++ worker and writes them out one per line in human readable form
+func DumpWorkerRequests(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+
+	for seq := 0; ; seq++ {
+		// Protocol encoding format:
+		//<headerSize><payloadSize><Header><Payload>
+		var sizes [2]uint32
+		err := binary.Read(reader, binary.LittleEndian, &sizes)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("message: %d failed to read frame sizes, err: %v", seq, err)
+		}
+
+		headerBuf := make([]byte, sizes[0])
+		if _, err = io.ReadFull(reader, headerBuf); err != nil {
+			return fmt.Errorf("message: %d failed to read header of size: %d, err: %v", seq, sizes[0], err)
+		}
+
+		payloadBuf := make([]byte, sizes[1])
+		if _, err = io.ReadFull(reader, payloadBuf); err != nil {
+			return fmt.Errorf("message: %d failed to read payload of size: %d, err: %v", seq, sizes[1], err)
+		}
+
+		dumpWorkerRequest(w, fmt.Sprintf("%d", seq), headerBuf, payloadBuf)
+	}
+}
+
+func dumpWorkerRequest(w io.Writer, prefix string, headerBuf, payloadBuf []byte) {
+	h := header.GetRootAsHeader(headerBuf, 0)
+	fmt.Fprintf(w, "%s event: %s opcode: %s partition: %d meta: %q",
+		prefix, eventName(h.Event()), requestOpcodeName(h.Event(), h.Opcode()), h.Partition(), h.Metadata())
+
+	if len(payloadBuf) > 0 {
+		p := payload.GetRootAsPayload(payloadBuf, 0)
+		switch {
+		case h.Event() == handshakeEvent:
+			fmt.Fprintf(w, " protocol_version: %d min_protocol_version: %d",
+				p.ProtocolVersion(), p.MinProtocolVersion())
+		case h.Event() == dcpEvent && h.Opcode() == dcpBatch:
+			dumpDcpEventBatch(w, p)
+		case h.Event() == dcpEvent:
+			fmt.Fprintf(w, " key: %q value_size: %d", p.Key(), len(p.Value()))
+		case h.Event() == v8WorkerEvent && h.Opcode() == v8WorkerInit:
+			fmt.Fprintf(w, " app_name: %q curr_host: %q", p.AppName(), p.CurrHost())
+		default:
+			fmt.Fprintf(w, " payload_size: %d", len(payloadBuf))
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// DumpWorkerResponses decodes a captured stream of responses written by C++ worker
+// to producer and writes them out one per line in human readable form
+func DumpWorkerResponses(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+
+	for seq := 0; ; seq++ {
+		// Protocol encoding format:
+		//<size><Response>
+		var size uint32
+		err := binary.Read(reader, binary.LittleEndian, &size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("response: %d failed to read frame size, err: %v", seq, err)
+		}
+
+		buf := make([]byte, size)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return fmt.Errorf("response: %d failed to read response of size: %d, err: %v", seq, size, err)
+		}
+
+		dumpWorkerResponse(w, fmt.Sprintf("%d", seq), buf)
+	}
+}
+
+func dumpWorkerResponse(w io.Writer, prefix string, buf []byte) {
+	resp := response.GetRootAsResponse(buf, 0)
+	fmt.Fprintf(w, "%s msg_type: %s opcode: %s", prefix, responseTypeName(resp.MsgType()),
+		responseOpcodeName(resp.MsgType(), resp.Opcode()))
+
+	if resp.MsgType() == handshakeResponse {
+		fmt.Fprintf(w, " protocol_version: %d min_protocol_version: %d",
+			resp.ProtocolVersion(), resp.MinProtocolVersion())
+	}
+
+	var entry response.VbSeqNo
+	for i := 0; i < resp.VbSeqNosLength(); i++ {
+		if resp.VbSeqNos(&entry, i) {
+			fmt.Fprintf(w, " vb: %d seq_no: %d", entry.Vb(), entry.SeqNo())
+		}
+	}
+
+	if stats := resp.Stats(nil); stats != nil {
+		fmt.Fprintf(w, " stats: %v", statsToMap(stats))
+	}
+
+	if msg := resp.Msg(); len(msg) > 0 {
+		fmt.Fprintf(w, " msg: %s", msg)
+	}
+	fmt.Fprintln(w)
+}
+
+func dumpDcpEventBatch(w io.Writer, p *payload.Payload) {
+	fmt.Fprintf(w, " events: %d", p.DcpEventsLength())
+
+	var event payload.DcpEvent
+	for i := 0; i < p.DcpEventsLength(); i++ {
+		if !p.DcpEvents(&event, i) {
+			continue
+		}
+
+		ep := payload.GetRootAsPayload(event.PayloadBytes(), 0)
+		fmt.Fprintf(w, "\n  opcode: %s meta: %q key: %q value_size: %d",
+			requestOpcodeName(dcpEvent, event.Opcode()), event.Metadata(), ep.Key(), len(ep.Value()))
+	}
+}
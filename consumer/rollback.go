@@ -0,0 +1,132 @@
+package consumer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	cb "github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+)
+
+// bucketSeqnos fetches current seq nos of source bucket, for skip policy to
+// resume from. Swapped out by tests.
+var bucketSeqnos = util.BucketSeqnos
+
+// applyRollbackPolicy decides the <vbuuid, seqno> from which stream for the vbucket
+// resumes after DCP producer asked for a rollback, and records the rollback in
+// ownership history. Returns false when the vbucket mustn't be streamed again for now.
+func (c *Consumer) applyRollbackPolicy(vbKey string, vbFlog *vbFlogEntry, vbBlob *vbucketKVBlob,
+	flog cb.FailoverLog, vbuuid uint64) (uint64, uint64, bool, error) {
+	logPrefix := "Consumer::applyRollbackPolicy"
+
+	c.dcpRollbackCounter++
+
+	policy := c.rollbackPolicy
+	if vbBlob.RollbackPending && policy == rollbackPolicyPause {
+		// Function was paused on this rollback earlier and has since been resumed
+		logging.Infof("%s [%s:%s:%d] vb: %d function resumed after pausing on rollback, replaying from seq no: %d",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vbFlog.vb, vbFlog.seqNo)
+		policy = rollbackPolicyReplay
+	}
+
+	entry := &OwnershipEntry{
+		AssignedWorker: c.ConsumerName(),
+		CurrentVBOwner: c.HostPortAddr(),
+		Operation:      dcpStreamRollback,
+		SeqNo:          vbFlog.seqNo,
+		Timestamp:      time.Now().String(),
+		RollbackPolicy: policy,
+		PrevSeqNo:      vbBlob.LastSeqNoProcessed,
+	}
+
+	startSeqNo := vbFlog.seqNo
+
+	switch policy {
+	case rollbackPolicySkip:
+		vbSeqNos, err := bucketSeqnos(c.producer.NsServerHostPort(), "default", c.bucket)
+		if err == nil && int(vbFlog.vb) >= len(vbSeqNos) {
+			err = fmt.Errorf("seq nos missing for vb: %d", vbFlog.vb)
+		}
+
+		var latestVbuuid uint64
+		if err == nil {
+			latestVbuuid, _, err = flog.Latest()
+		}
+
+		if err != nil {
+			logging.Errorf("%s [%s:%s:%d] vb: %d failed to fetch current position to skip rollback, err: %v",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), vbFlog.vb, err)
+
+			c.Lock()
+			c.vbsRemainingToRestream = append(c.vbsRemainingToRestream, vbFlog.vb)
+			c.Unlock()
+			return 0, 0, false, nil
+		}
+
+		startSeqNo = vbSeqNos[vbFlog.vb]
+		vbuuid = latestVbuuid
+		c.dcpRollbackSkippedCounter++
+
+		c.alertRollback(vbFlog.vb, fmt.Sprintf("rollback to seq no: %d requested by Data service, last processed seq no: %d."+
+			" Skipping mutations until seq no: %d as dcp_rollback_policy is %s",
+			vbFlog.seqNo, vbBlob.LastSeqNoProcessed, startSeqNo, policy))
+
+	case rollbackPolicyPause:
+		err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, addOwnershipHistoryRollbackCallback,
+			c, c.producer.AddMetadataPrefix(vbKey), entry, true)
+		if err == common.ErrRetryTimeout {
+			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+			return 0, 0, false, err
+		}
+
+		c.dcpRollbackPausedCounter++
+
+		c.alertRollback(vbFlog.vb, fmt.Sprintf("rollback to seq no: %d requested by Data service, last processed seq no: %d."+
+			" Pausing function as dcp_rollback_policy is %s, mutations from seq no: %d will be replayed on resume",
+			vbFlog.seqNo, vbBlob.LastSeqNoProcessed, policy, vbFlog.seqNo))
+		c.pauseOnRollback()
+		return 0, 0, false, nil
+	}
+
+	entry.ResumeSeqNo = startSeqNo
+
+	err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, addOwnershipHistoryRollbackCallback,
+		c, c.producer.AddMetadataPrefix(vbKey), entry, false)
+	if err == common.ErrRetryTimeout {
+		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
+		return 0, 0, false, err
+	}
+
+	vbBlob.RollbackPending = false
+	return vbuuid, startSeqNo, true, nil
+}
+
+// alertRollback surfaces a rollback that alters the set of mutations seen by handler,
+// in eventing logs as well as in the function's app log
+func (c *Consumer) alertRollback(vb uint16, msg string) {
+	logPrefix := "Consumer::alertRollback"
+
+	logging.Errorf("%s [%s:%s:%d] vb: %d %s", logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, msg)
+	c.producer.WriteAppLog(fmt.Sprintf("Function: %s vb: %d %s", c.app.AppName, vb, msg))
+}
+
+// pauseOnRollback asks super supervisor to pause the function. It's requested
+// once per consumer, as pausing tears down the consumer itself.
+func (c *Consumer) pauseOnRollback() {
+	logPrefix := "Consumer::pauseOnRollback"
+
+	if !atomic.CompareAndSwapUint32(&c.rollbackPauseRequested, 0, 1) {
+		return
+	}
+
+	go func() {
+		err := c.superSup.PauseFunction(c.app.AppName)
+		if err != nil {
+			logging.Errorf("%s [%s:%s:%d] Failed to pause function on rollback, err: %v",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), err)
+		}
+	}()
+}
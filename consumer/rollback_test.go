@@ -0,0 +1,186 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	cb "github.com/couchbase/eventing/dcp/transport/client"
+)
+
+type rollbackProducer struct {
+	common.EventingProducer
+	appLogs []string
+}
+
+func (p *rollbackProducer) AddMetadataPrefix(key string) common.Key {
+	return common.NewKey("", "", key)
+}
+
+func (p *rollbackProducer) NsServerHostPort() string { return "localhost:8091" }
+
+func (p *rollbackProducer) WriteAppLog(log string) { p.appLogs = append(p.appLogs, log) }
+
+type rollbackSuperSup struct {
+	common.EventingSuperSup
+	paused chan string
+}
+
+func (s *rollbackSuperSup) PauseFunction(appName string) error {
+	s.paused <- appName
+	return nil
+}
+
+type historyEntry struct {
+	entry           OwnershipEntry
+	rollbackPending bool
+}
+
+// withRollbackSeams records ownership history entries and serves seqnos
+// instead of reaching metadata and source buckets
+func withRollbackSeams(seqnos []uint64, seqnosErr error) (*[]historyEntry, func()) {
+	history := &[]historyEntry{}
+
+	prevHistory, prevSeqnos := addOwnershipHistoryRollbackCallback, bucketSeqnos
+	addOwnershipHistoryRollbackCallback = func(args ...interface{}) error {
+		*history = append(*history, historyEntry{*args[2].(*OwnershipEntry), args[3].(bool)})
+		return nil
+	}
+	bucketSeqnos = func(cluster, pooln, bucketn string) ([]uint64, error) {
+		return seqnos, seqnosErr
+	}
+
+	return history, func() {
+		addOwnershipHistoryRollbackCallback, bucketSeqnos = prevHistory, prevSeqnos
+	}
+}
+
+func newRollbackConsumer(policy string) (*Consumer, *rollbackProducer, *rollbackSuperSup) {
+	retryCount := int64(1)
+	producer := &rollbackProducer{}
+	superSup := &rollbackSuperSup{paused: make(chan string, 2)}
+
+	c := &Consumer{
+		app:            &common.AppConfig{AppName: "fn"},
+		producer:       producer,
+		superSup:       superSup,
+		retryCount:     &retryCount,
+		rollbackPolicy: policy,
+		workerName:     "worker_fn_0",
+	}
+	return c, producer, superSup
+}
+
+func TestRollbackReplay(t *testing.T) {
+	history, restore := withRollbackSeams(nil, nil)
+	defer restore()
+
+	c, _, _ := newRollbackConsumer(rollbackPolicyReplay)
+	vbBlob := &vbucketKVBlob{LastSeqNoProcessed: 90, RollbackPending: true}
+
+	vbuuid, seqNo, restream, err := c.applyRollbackPolicy("vb_5", &vbFlogEntry{vb: 5, seqNo: 40}, vbBlob, nil, 11)
+	if err != nil || !restream || vbuuid != 11 || seqNo != 40 {
+		t.Fatalf("expected restream from 11:40, got %d:%d restream: %v err: %v", vbuuid, seqNo, restream, err)
+	}
+	if vbBlob.RollbackPending {
+		t.Error("expected pending rollback to be cleared")
+	}
+	if len(*history) != 1 || (*history)[0].entry.RollbackPolicy != rollbackPolicyReplay ||
+		(*history)[0].entry.PrevSeqNo != 90 || (*history)[0].entry.ResumeSeqNo != 40 {
+		t.Errorf("unexpected ownership history: %+v", *history)
+	}
+}
+
+func TestRollbackSkip(t *testing.T) {
+	history, restore := withRollbackSeams([]uint64{0, 0, 0, 0, 0, 120}, nil)
+	defer restore()
+
+	c, producer, _ := newRollbackConsumer(rollbackPolicySkip)
+	flog := cb.FailoverLog{{22, 120}, {11, 0}}
+
+	vbuuid, seqNo, restream, err := c.applyRollbackPolicy("vb_5", &vbFlogEntry{vb: 5, seqNo: 40},
+		&vbucketKVBlob{LastSeqNoProcessed: 90}, flog, 11)
+	if err != nil || !restream || vbuuid != 22 || seqNo != 120 {
+		t.Fatalf("expected restream from 22:120, got %d:%d restream: %v err: %v", vbuuid, seqNo, restream, err)
+	}
+	if c.dcpRollbackSkippedCounter != 1 || len(producer.appLogs) != 1 {
+		t.Errorf("expected skipped rollback to be counted and alerted, counter: %d logs: %v",
+			c.dcpRollbackSkippedCounter, producer.appLogs)
+	}
+	if len(*history) != 1 || (*history)[0].entry.ResumeSeqNo != 120 || (*history)[0].rollbackPending {
+		t.Errorf("unexpected ownership history: %+v", *history)
+	}
+}
+
+func TestRollbackSkipWithoutSeqnos(t *testing.T) {
+	history, restore := withRollbackSeams(nil, errors.New("unreachable"))
+	defer restore()
+
+	c, _, _ := newRollbackConsumer(rollbackPolicySkip)
+
+	_, _, restream, err := c.applyRollbackPolicy("vb_5", &vbFlogEntry{vb: 5, seqNo: 40},
+		&vbucketKVBlob{}, cb.FailoverLog{{22, 120}}, 11)
+	if err != nil || restream {
+		t.Fatalf("expected stream to be held back, restream: %v err: %v", restream, err)
+	}
+	if len(c.vbsRemainingToRestream) != 1 || c.vbsRemainingToRestream[0] != 5 {
+		t.Errorf("expected vb to be retried later, got %v", c.vbsRemainingToRestream)
+	}
+	if len(*history) != 0 {
+		t.Errorf("expected no ownership history, got %+v", *history)
+	}
+}
+
+func TestRollbackPause(t *testing.T) {
+	history, restore := withRollbackSeams(nil, nil)
+	defer restore()
+
+	c, _, superSup := newRollbackConsumer(rollbackPolicyPause)
+
+	for _, vb := range []uint16{5, 6} {
+		_, _, restream, err := c.applyRollbackPolicy("vb", &vbFlogEntry{vb: vb, seqNo: 40},
+			&vbucketKVBlob{LastSeqNoProcessed: 90}, nil, 11)
+		if err != nil || restream {
+			t.Fatalf("expected vb: %d to be held back, restream: %v err: %v", vb, restream, err)
+		}
+	}
+
+	select {
+	case appName := <-superSup.paused:
+		if appName != "fn" {
+			t.Errorf("expected fn to be paused, got %s", appName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected function to be paused")
+	}
+	select {
+	case <-superSup.paused:
+		t.Error("expected pause to be requested once")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if c.dcpRollbackPausedCounter != 2 || len(*history) != 2 || !(*history)[0].rollbackPending {
+		t.Errorf("expected rollbacks to be recorded as pending, counter: %d history: %+v",
+			c.dcpRollbackPausedCounter, *history)
+	}
+}
+
+func TestRollbackPauseResumed(t *testing.T) {
+	history, restore := withRollbackSeams(nil, nil)
+	defer restore()
+
+	c, _, superSup := newRollbackConsumer(rollbackPolicyPause)
+	vbBlob := &vbucketKVBlob{LastSeqNoProcessed: 90, RollbackPending: true}
+
+	vbuuid, seqNo, restream, err := c.applyRollbackPolicy("vb_5", &vbFlogEntry{vb: 5, seqNo: 40}, vbBlob, nil, 11)
+	if err != nil || !restream || vbuuid != 11 || seqNo != 40 {
+		t.Fatalf("expected replay from 11:40 on resume, got %d:%d restream: %v err: %v", vbuuid, seqNo, restream, err)
+	}
+	if len(superSup.paused) != 0 || vbBlob.RollbackPending {
+		t.Error("expected resumed function to replay rather than pause again")
+	}
+	if len(*history) != 1 || (*history)[0].entry.RollbackPolicy != rollbackPolicyReplay {
+		t.Errorf("unexpected ownership history: %+v", *history)
+	}
+}
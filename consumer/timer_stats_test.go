@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/eventing/common"
+)
+
+func TestTimerFiringStatsFromWorker(t *testing.T) {
+	c := &Consumer{statsRWMutex: &sync.RWMutex{}}
+
+	c.appendTimerFiringStats(map[string]interface{}{
+		"5:pending":        float64(1),
+		"5:fire_lag:2300":  float64(2),
+		"5:scan_time:100":  float64(1),
+		"5:scan_time:200":  float64(1),
+		"7:pending":        float64(4),
+		"7:fire_lag:100":   float64(1),
+		"7:scan_time:9900": float64(1),
+		"bad:pending":      float64(4),
+		"8":                float64(4),
+	})
+	// Bins count fires since stats were last sent, pending is sent whole
+	c.appendTimerFiringStats(map[string]interface{}{
+		"5:pending":       float64(3),
+		"5:fire_lag:2300": float64(1),
+		"5:scan_time:100": float64(1),
+	})
+
+	expected := map[int]*common.TimerFiringStats{
+		5: {
+			Pending:     3,
+			FireLag:     common.StatsData{"2300": 3},
+			ScanTime:    common.StatsData{"100": 2, "200": 1},
+			ScanLookups: common.StatsData{},
+		},
+		7: {
+			FireLag:     common.StatsData{"100": 1},
+			ScanTime:    common.StatsData{"9900": 1},
+			ScanLookups: common.StatsData{},
+		},
+	}
+
+	stats := c.GetTimerFiringStats()
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	// Stats returned are a copy
+	stats[5].FireLag["2300"] = 0
+	if stats = c.GetTimerFiringStats(); stats[5].FireLag["2300"] != 3 {
+		t.Errorf("expected stats to be left alone, got %+v", stats[5])
+	}
+}
+
+func TestTimerFiringStatsWithoutTimers(t *testing.T) {
+	c := &Consumer{statsRWMutex: &sync.RWMutex{}}
+	c.appendTimerFiringStats(nil)
+
+	if stats := c.GetTimerFiringStats(); len(stats) != 0 {
+		t.Errorf("expected no stats, got %+v", stats)
+	}
+}
@@ -1,10 +1,14 @@
 package consumer
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"hash/crc32"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/dcp/transport/client"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/util"
@@ -93,6 +97,60 @@ func (c *Consumer) isRecursiveDCPEvent(evt *memcached.DcpEvent, functionInstance
 	return false, nil
 }
 
+// stripXattrs drops xattrs section off value of an event, keeping it aside if
+// handler is to be passed xattrs
+func (c *Consumer) stripXattrs(e *memcached.DcpEvent) {
+	xattrLen := binary.BigEndian.Uint32(e.Value[0:4])
+	if c.enrichedMeta {
+		e.RawXattrs = e.Value[:xattrLen+4]
+	}
+	e.Value = e.Value[xattrLen+4:]
+}
+
+func (c *Consumer) enrichedMetadata(e *memcached.DcpEvent) *dcpEnrichedMetadata {
+	logPrefix := "Consumer::enrichedMetadata"
+
+	m := &dcpEnrichedMetadata{
+		Datatype: "binary",
+		LockTime: e.LockTime,
+		RevSeqNo: e.RevSeqno,
+		Xattrs:   make(map[string]json.RawMessage),
+	}
+
+	if e.Datatype&dcpDatatypeJSON != 0 {
+		m.Datatype = "json"
+	}
+
+	if len(e.RawXattrs) == 0 {
+		return m
+	}
+
+	xattrs, err := util.ParseXattrs(e.RawXattrs)
+	if err != nil {
+		c.dcpXattrParseError++
+		logging.Errorf("%s [%s:%s:%d] key: %ru failed to parse xattrs, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), string(e.Key), err)
+		return m
+	}
+
+	for key, val := range xattrs {
+		// Eventing's own xattr is an implementation detail
+		if key == xattrPrefix || !json.Valid(val) {
+			continue
+		}
+		m.Xattrs[key] = json.RawMessage(val)
+	}
+	return m
+}
+
+// idempotencyKey identifies handler invocation for a DCP event. It's derived from
+// function instance and event's position in the vbucket, so replays of the
+// same event after a restart carry the same key.
+func (c *Consumer) idempotencyKey(vb uint16, seqNo uint64) string {
+	return strconv.Itoa(int(c.app.FunctionID)) + "-" + c.app.FunctionInstanceID + "::" +
+		strconv.Itoa(int(vb)) + "::" + strconv.FormatUint(seqNo, 10)
+}
+
 func (c *Consumer) purgeVbStreamRequested(logPrefix string, vb uint16) {
 	c.vbsStreamRRWMutex.Lock()
 	if _, ok := c.vbStreamRequested[vb]; ok {
@@ -102,3 +160,89 @@ func (c *Consumer) purgeVbStreamRequested(logPrefix string, vb uint16) {
 	}
 	c.vbsStreamRRWMutex.Unlock()
 }
+
+// getCppQueueSizes returns last queue sizes reported by cpp worker, which may
+// be read by DCP feed goroutines
+func (c *Consumer) getCppQueueSizes() *cppQueueSize {
+	c.statsRWMutex.RLock()
+	defer c.statsRWMutex.RUnlock()
+	return c.cppQueueSizes
+}
+
+func (c *Consumer) setCppQueueSizes(queueSizes *cppQueueSize) {
+	c.statsRWMutex.Lock()
+	defer c.statsRWMutex.Unlock()
+	c.cppQueueSizes = queueSizes
+}
+
+// dcpFlowControlFillLevel reports how full the queues between KV and the
+// cpp worker are, as fraction of their caps. Used by DCP feeds to withhold
+// buffer acks while V8 is unable to keep up.
+func (c *Consumer) dcpFlowControlFillLevel() float64 {
+	var fill float64
+
+	if c.aggDCPFeedMemCap > 0 {
+		fill = float64(atomic.LoadInt64(&c.aggDCPFeedMem)) / float64(c.aggDCPFeedMemCap)
+	}
+
+	queueSizes := c.getCppQueueSizes()
+	if queueSizes == nil {
+		return fill
+	}
+
+	if c.workerQueueCap > 0 {
+		queueFill := float64(atomic.LoadInt64(&c.numSentEvents)-queueSizes.NumProcessedEvents) / float64(c.workerQueueCap)
+		if queueFill > fill {
+			fill = queueFill
+		}
+	}
+
+	if c.workerQueueMemCap > 0 {
+		memFill := float64(atomic.LoadInt64(&c.sentEventsSize)-queueSizes.ProcessedEventsSize) / float64(c.workerQueueMemCap)
+		if memFill > fill {
+			fill = memFill
+		}
+	}
+
+	return fill
+}
+
+func (c *Consumer) dcpFlowControlStats() map[string]uint64 {
+	logPrefix := "Consumer::dcpFlowControlStats"
+
+	c.hostDcpFeedRWMutex.RLock()
+	defer c.hostDcpFeedRWMutex.RUnlock()
+
+	stats := make(map[string]uint64)
+	for kvHost, dcpFeed := range c.kvHostDcpFeedMap {
+		feedStats, err := dcpFeed.FlowControlStats()
+		if err != nil {
+			logging.Errorf("%s [%s:%s:%d] kv node: %rs failed to fetch flow control stats, err: %v",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), kvHost, err)
+			continue
+		}
+
+		for _, connStats := range feedStats {
+			stats["dcp_feed_bytes_outstanding"] += connStats["bytes_outstanding"]
+			stats["dcp_feed_connection_buffer_size"] += connStats["connection_buffer_size"]
+			stats["dcp_feed_ack_throttled_count"] += connStats["ack_throttled_count"]
+			stats["dcp_feed_ack_throttled_time_ms"] += connStats["ack_throttled_time"] / uint64(time.Millisecond)
+			stats["dcp_feed_blocked_time_ms"] += connStats["blocked_time"] / uint64(time.Millisecond)
+
+			ackLatency := connStats["last_ack_latency"] / uint64(time.Millisecond)
+			if ackLatency > stats["dcp_feed_max_ack_latency_ms"] {
+				stats["dcp_feed_max_ack_latency_ms"] = ackLatency
+			}
+		}
+	}
+
+	return stats
+}
+
+func copyStatsData(data common.StatsData) common.StatsData {
+	copied := make(common.StatsData, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
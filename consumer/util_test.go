@@ -0,0 +1,140 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/dcp/transport/client"
+)
+
+// withXattrs lays out xattrs ahead of body the way KV sends them over DCP
+func withXattrs(body []byte, xattrs [][2]string) []byte {
+	var section bytes.Buffer
+	for _, xattr := range xattrs {
+		pair := xattr[0] + "\x00" + xattr[1] + "\x00"
+		binary.Write(&section, binary.BigEndian, uint32(len(pair)))
+		section.WriteString(pair)
+	}
+
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, uint32(section.Len()))
+	value.Write(section.Bytes())
+	value.Write(body)
+	return value.Bytes()
+}
+
+func TestEnrichedMetadata(t *testing.T) {
+	c := &Consumer{enrichedMeta: true}
+
+	e := &memcached.DcpEvent{
+		Key:      []byte("doc"),
+		Datatype: dcpDatatypeJSONXattr,
+		RevSeqno: 7,
+		LockTime: 15,
+		Value: withXattrs([]byte(`{"a":1}`), [][2]string{
+			{"_sync", `{"rev":"2-abc"}`},
+			{xattrPrefix, `{"fiid":"1"}`},
+			{"meta", `not json`},
+			{"tags", `["x","y"]`},
+		}),
+	}
+
+	c.stripXattrs(e)
+	if string(e.Value) != `{"a":1}` {
+		t.Fatalf("expected body with xattrs stripped, got %s", e.Value)
+	}
+
+	m := c.enrichedMetadata(e)
+	if m.Datatype != "json" || m.RevSeqNo != 7 || m.LockTime != 15 {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+
+	expected := map[string]string{
+		"_sync": `{"rev":"2-abc"}`,
+		"tags":  `["x","y"]`,
+	}
+	if len(m.Xattrs) != len(expected) {
+		t.Fatalf("expected xattrs %v, got %v", expected, m.Xattrs)
+	}
+	for key, val := range expected {
+		if string(m.Xattrs[key]) != val {
+			t.Errorf("xattr %s: expected %s, got %s", key, val, m.Xattrs[key])
+		}
+	}
+	if c.dcpXattrParseError != 0 {
+		t.Errorf("unexpected xattr parse errors: %d", c.dcpXattrParseError)
+	}
+}
+
+func TestEnrichedMetadataWithoutXattrs(t *testing.T) {
+	c := &Consumer{enrichedMeta: true}
+
+	e := &memcached.DcpEvent{
+		Key:      []byte("doc"),
+		Datatype: 0,
+		Value:    []byte{0x01, 0x02},
+	}
+
+	m := c.enrichedMetadata(e)
+	if m.Datatype != "binary" || len(m.Xattrs) != 0 {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+}
+
+func TestEnrichedMetadataMalformedXattrs(t *testing.T) {
+	c := &Consumer{enrichedMeta: true}
+
+	value := withXattrs([]byte(`{}`), [][2]string{{"tags", `[1]`}})
+	// Claim first pair runs past end of xattrs section
+	binary.BigEndian.PutUint32(value[4:8], uint32(len(value)))
+
+	e := &memcached.DcpEvent{
+		Key:       []byte("doc"),
+		Datatype:  dcpDatatypeJSONXattr,
+		RawXattrs: value,
+	}
+
+	m := c.enrichedMetadata(e)
+	if len(m.Xattrs) != 0 || c.dcpXattrParseError != 1 {
+		t.Errorf("expected parse error and no xattrs, got %+v errors: %d", m, c.dcpXattrParseError)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	c := &Consumer{app: &common.AppConfig{FunctionID: 42, FunctionInstanceID: "xyz"}}
+
+	key := c.idempotencyKey(5, 1001)
+	if key != "42-xyz::5::1001" {
+		t.Fatalf("expected key 42-xyz::5::1001, got %s", key)
+	}
+
+	// Replays of the event carry the same key, other events and deployments don't
+	if c.idempotencyKey(5, 1001) != key {
+		t.Error("expected same key for replay of the event")
+	}
+	redeployed := &Consumer{app: &common.AppConfig{FunctionID: 42, FunctionInstanceID: "abc"}}
+	for _, other := range []string{c.idempotencyKey(6, 1001), c.idempotencyKey(5, 1002), redeployed.idempotencyKey(5, 1001)} {
+		if other == key {
+			t.Errorf("expected key other than %s", key)
+		}
+	}
+
+	// Worker looks key up by this name in event metadata
+	encoded, err := json.Marshal(&dcpMetadata{IdempotencyKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err = json.Unmarshal(encoded, &decoded); err != nil || decoded["idempotency_key"] != key {
+		t.Errorf("expected idempotency_key in metadata, got %s", encoded)
+	}
+
+	encoded, _ = json.Marshal(&dcpMetadata{})
+	if strings.Contains(string(encoded), "idempotency_key") {
+		t.Errorf("expected no idempotency_key in metadata when disabled, got %s", encoded)
+	}
+}
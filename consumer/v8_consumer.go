@@ -6,6 +6,7 @@ import (
 	"net"
 	"runtime/debug"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -46,9 +47,12 @@ func NewConsumer(hConfig *common.HandlerConfig, pConfig *common.ProcessConfig, r
 		connMutex:                       &sync.RWMutex{},
 		controlRoutineWg:                &sync.WaitGroup{},
 		cppThrPartitionMap:              make(map[int][]uint16),
+		dcpEventBatches:                 make(map[int]*dcpEventBatch),
+		dcpEventBatchMutex:              &sync.Mutex{},
+		dcpEventBatchSize:               hConfig.DcpEventBatchSize,
+		dcpEventScratchBuilder:          flatbuffers.NewBuilder(0),
 		cppWorkerThrCount:               hConfig.CPPWorkerThrCount,
 		crcTable:                        crc32.MakeTable(crc32.Castagnoli),
-		dcpConfig:                       dcpConfig,
 		dcpFeedVbMap:                    make(map[*couchbase.DcpFeed][]uint16),
 		dcpStreamBoundary:               hConfig.StreamBoundary,
 		diagDir:                         pConfig.DiagDir,
@@ -57,12 +61,26 @@ func NewConsumer(hConfig *common.HandlerConfig, pConfig *common.ProcessConfig, r
 		eventingSSLPort:                 pConfig.EventingSSLPort,
 		eventingDir:                     pConfig.EventingDir,
 		eventingNodeUUIDs:               eventingNodeUUIDs,
+		eventSourceFile:                 hConfig.EventSourceFile,
+		eventSourceType:                 hConfig.EventSource,
 		executeTimerRoutineCount:        hConfig.ExecuteTimerRoutineCount,
 		executionTimeout:                hConfig.ExecutionTimeout,
 		lcbRetryCount:                   hConfig.LcbRetryCount,
 		feedbackQueueCap:                hConfig.FeedbackQueueCap,
 		feedbackReadBufferSize:          hConfig.FeedbackReadBufferSize,
 		feedbackTCPPort:                 pConfig.FeedbackSockIdentifier,
+		heldDcpEventKeys:                make(map[string]int),
+		heldDcpEventSeqNos:              make(map[uint16][]uint64),
+		heldDcpEventsMutex:              &sync.Mutex{},
+		unackedDcpEventSeqNos:           make(map[uint16][]uint64),
+		clampedProcessedSeqNo:           make(map[uint16]uint64),
+		enrichedMeta:                    hConfig.EnrichedMeta,
+		idempotencyKeys:                 hConfig.IdempotencyKeys,
+		ipcCapture:                      hConfig.IPCCapture,
+		ipcCaptureMaxFiles:              hConfig.IPCCaptureMaxFiles,
+		ipcCaptureMaxSize:               hConfig.IPCCaptureMaxSize,
+		ipcCaptureRWMutex:               &sync.RWMutex{},
+		priorityFieldValue:              hConfig.PriorityFieldValue,
 		feedbackWriteBatchSize:          hConfig.FeedbackBatchSize,
 		filterVbEvents:                  make(map[uint16]struct{}),
 		filterVbEventsRWMutex:           &sync.RWMutex{},
@@ -70,6 +88,7 @@ func NewConsumer(hConfig *common.HandlerConfig, pConfig *common.ProcessConfig, r
 		gracefulShutdownChan:            make(chan struct{}, 1),
 		handlerFooters:                  hConfig.HandlerFooters,
 		handlerHeaders:                  hConfig.HandlerHeaders,
+		handshakeCh:                     make(chan *workerHandshake, 1),
 		index:                           index,
 		ipcType:                         pConfig.IPCType,
 		inflightDcpStreams:              make(map[uint16]struct{}),
@@ -89,6 +108,7 @@ func NewConsumer(hConfig *common.HandlerConfig, pConfig *common.ProcessConfig, r
 		reqStreamCh:                     make(chan *streamRequestInfo, numVbuckets*10),
 		restartVbDcpStreamTicker:        time.NewTicker(restartVbDcpStreamTickInterval),
 		retryCount:                      retryCount,
+		rollbackPolicy:                  hConfig.RollbackPolicy,
 		sendMsgBufferRWMutex:            &sync.RWMutex{},
 		sendMsgCounter:                  0,
 		signalBootstrapFinishCh:         make(chan struct{}, 1),
@@ -108,6 +128,12 @@ func NewConsumer(hConfig *common.HandlerConfig, pConfig *common.ProcessConfig, r
 		superSup:                        s,
 		tcpPort:                         pConfig.SockIdentifier,
 		timerContextSize:                hConfig.TimerContextSize,
+		timerInPastPolicy:               hConfig.TimerInPastPolicy,
+		timerResolution:                 hConfig.TimerResolution,
+		timerContextOverflow:            hConfig.TimerContextOverflow,
+		missedTimerPolicy:               hConfig.MissedTimerPolicy,
+		missedTimerThreshold:            hConfig.MissedTimerThreshold,
+		missedTimerRate:                 hConfig.MissedTimerRate,
 		updateStatsTicker:               time.NewTicker(updateCPPStatsTickInterval),
 		loadStatsTicker:                 time.NewTicker(updateCPPStatsTickInterval),
 		usingTimer:                      hConfig.UsingTimer,
@@ -141,6 +167,30 @@ func NewConsumer(hConfig *common.HandlerConfig, pConfig *common.ProcessConfig, r
 		workerVbucketMapRWMutex:         &sync.RWMutex{},
 	}
 
+	// DCP config is shared across consumers, so make a private copy before
+	// wiring up consumer specific flow control hooks
+	consumer.dcpConfig = make(map[string]interface{})
+	for k, v := range dcpConfig {
+		consumer.dcpConfig[k] = v
+	}
+
+	if val, ok := dcpConfig["adaptiveAck"]; ok && val.(bool) {
+		consumer.dcpConfig["ackThrottleFn"] = consumer.dcpFlowControlFillLevel
+	}
+
+	for _, prefix := range hConfig.PriorityKeyPrefixes {
+		if prefix != "" {
+			consumer.priorityKeyPrefixes = append(consumer.priorityKeyPrefixes, []byte(prefix))
+		}
+	}
+
+	if hConfig.PriorityField != "" {
+		consumer.priorityFieldPath = strings.Split(hConfig.PriorityField, ".")
+	}
+
+	// Replaced by configured source on Serve
+	consumer.eventSource = newDcpSource(consumer)
+
 	consumer.builderPool = &sync.Pool{
 		New: func() interface{} {
 			return flatbuffers.NewBuilder(0)
@@ -220,33 +270,17 @@ func (c *Consumer) Serve() {
 	logging.Infof("%s [%s:%s:%d] Spawning worker corresponding to producer, node addr: %rs",
 		logPrefix, c.workerName, c.tcpPort, c.Pid(), c.HostPortAddr())
 
-	var feedName couchbase.DcpFeedName
-
 	err = util.Retry(util.NewFixedBackoff(clusterOpRetryInterval), c.retryCount, getKvNodesFromVbMap, c)
 	if err == common.ErrRetryTimeout {
 		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
 		return
 	}
 
-	for _, kvHostPort := range c.getKvNodes() {
-		if atomic.LoadUint32(&c.isTerminateRunning) == 1 {
-			continue
-		}
-
-		feedName = couchbase.NewDcpFeedName(c.HostPortAddr() + "_" + kvHostPort + "_" + c.workerName)
-
-		c.hostDcpFeedRWMutex.Lock()
-		err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, startDCPFeedOpCallback, c, feedName, kvHostPort)
-		if err == common.ErrRetryTimeout {
-			logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
-			return
-		}
-
-		logging.Infof("%s [%s:%s:%d] vbKvAddr: %s Spawned aggChan routine",
-			logPrefix, c.workerName, c.tcpPort, c.Pid(), kvHostPort)
-
-		c.addToAggChan(c.kvHostDcpFeedMap[kvHostPort])
-		c.hostDcpFeedRWMutex.Unlock()
+	err = c.startEventSource()
+	if err != nil {
+		logging.Errorf("%s [%s:%s:%d] Failed to start event source, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), err)
+		return
 	}
 
 	if atomic.LoadUint32(&c.isTerminateRunning) == 0 {
@@ -305,12 +339,19 @@ func (c *Consumer) HandleV8Worker() error {
 	<-c.signalConnectedCh
 	<-c.signalFeedbackConnectedCh
 
+	c.openIPCCapture()
+
+	err := c.negotiateProtocolVersion()
+	if err != nil {
+		return err
+	}
+
 	logging.SetLogLevel(util.GetLogLevel(c.logLevel))
 	c.sendLogLevel(c.logLevel, false)
 	c.sendWorkerThrMap(nil, false)
 	c.sendWorkerThrCount(0, false)
 	c.sendWorkerMemQuota(c.aggDCPFeedMemCap * int64(2))
-	err := util.Retry(util.NewFixedBackoff(clusterOpRetryInterval), c.retryCount, getEventingNodeAddrOpCallback, c)
+	err = util.Retry(util.NewFixedBackoff(clusterOpRetryInterval), c.retryCount, getEventingNodeAddrOpCallback, c)
 	if err == common.ErrRetryTimeout {
 		logging.Errorf("%s [%s:%s:%d] Exiting due to timeout", logPrefix, c.workerName, c.tcpPort, c.Pid())
 		return err
@@ -334,6 +375,7 @@ func (c *Consumer) HandleV8Worker() error {
 	c.sendInitV8Worker(payload, false, pBuilder)
 
 	c.sendLoadV8Worker(c.app.ParsedAppCode, false)
+	c.endIPCCaptureBootstrap()
 
 	c.workerExited = false
 
@@ -435,20 +477,9 @@ func (c *Consumer) Stop(context string) {
 
 	c.dcpFeedsClosed = true
 
-	func() {
-		c.hostDcpFeedRWMutex.RLock()
-		defer c.hostDcpFeedRWMutex.RUnlock()
-
-		if c.kvHostDcpFeedMap != nil {
-			for _, dcpFeed := range c.kvHostDcpFeedMap {
-				if dcpFeed != nil {
-					dcpFeed.Close()
-				}
-			}
-		}
-	}()
-
-	logging.Infof("%s [%s:%s:%d] Closed all dcpfeed handles", logPrefix, c.workerName, c.tcpPort, c.Pid())
+	c.eventSource.Close()
+	logging.Infof("%s [%s:%s:%d] Closed event source: %s",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), c.eventSource.Name())
 
 	close(c.stopConsumerCh)
 
@@ -456,6 +487,8 @@ func (c *Consumer) Stop(context string) {
 		c.conn.Close()
 	}
 
+	c.closeIPCCapture()
+
 	if c.debugConn != nil {
 		c.debugConn.Close()
 	}
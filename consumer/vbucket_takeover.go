@@ -20,6 +20,7 @@ var (
 	errUnexpectedVbStreamStatus = errors.New("unexpected vbucket stream status")
 	errVbOwnedByAnotherWorker   = errors.New("vbucket is owned by another worker on same node")
 	errVbOwnedByAnotherNode     = errors.New("vbucket is owned by another node")
+	errVbHandoverPending        = errors.New("previous owner yet to confirm vbucket handover")
 )
 
 func (c *Consumer) checkAndUpdateMetadata() {
@@ -229,8 +230,12 @@ func (c *Consumer) doVbTakeover(vb uint16) error {
 		return err
 	}
 
+	c.workerVbucketMapRWMutex.RLock()
+	workerCount := c.workerCount
+	c.workerVbucketMapRWMutex.RUnlock()
+
 	var possibleConsumers []string
-	for i := 0; i < c.workerCount; i++ {
+	for i := 0; i < workerCount; i++ {
 		possibleConsumers = append(possibleConsumers, fmt.Sprintf("worker_%s_%d", c.app.AppName, i))
 	}
 
@@ -295,6 +300,10 @@ func (c *Consumer) doVbTakeover(vb uint16) error {
 
 	case dcpStreamStopped, dcpStreamUninitialised:
 
+		if !c.vbHandoverDone(vb, &vbBlob) {
+			return errVbHandoverPending
+		}
+
 		if vbBlob.DCPStreamRequested {
 			if (vbBlob.NodeUUIDRequestedVbStream == c.NodeUUID() && vbBlob.WorkerRequestedVbStream == c.ConsumerName()) ||
 				(vbBlob.NodeUUIDRequestedVbStream == "" && vbBlob.WorkerRequestedVbStream == "") {
@@ -326,6 +335,42 @@ func (c *Consumer) doVbTakeover(vb uint16) error {
 	}
 }
 
+// vbHandoverDone reports whether previous owner has handled STREAMEND for vb and
+// confirmed the last seq no it processed, which new owner must resume from to
+// avoid running events on a key out of order. Handover is given up on if
+// previous owner has left the cluster or has taken too long to confirm.
+func (c *Consumer) vbHandoverDone(vb uint16, vbBlob *vbucketKVBlob) bool {
+	logPrefix := "Consumer::vbHandoverDone"
+
+	switch vbBlob.Handover.State {
+	case vbHandoverConfirmed:
+		if vbBlob.Handover.SeqNo > vbBlob.LastSeqNoProcessed {
+			vbBlob.LastSeqNoProcessed = vbBlob.Handover.SeqNo
+		}
+		return true
+
+	case vbHandoverPending:
+		if !c.producer.IsEventingNodeAlive(vbBlob.PreviousVBOwner, vbBlob.PreviousNodeUUID) {
+			logging.Infof("%s [%s:%s:%d] vb: %d previous owner: %rs isn't alive, not waiting for handover",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbBlob.PreviousVBOwner)
+			return true
+		}
+
+		requestTime, err := time.Parse(time.RFC3339, vbBlob.Handover.RequestTime)
+		if err != nil || time.Since(requestTime) > vbHandoverTimeout {
+			logging.Errorf("%s [%s:%s:%d] vb: %d previous owner: %rs didn't confirm handover requested at: %s, taking over",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbBlob.PreviousVBOwner, vbBlob.Handover.RequestTime)
+			return true
+		}
+
+		logging.Infof("%s [%s:%s:%d] vb: %d waiting on previous owner: %rs to confirm handover",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbBlob.PreviousVBOwner)
+		return false
+	}
+
+	return true
+}
+
 func (c *Consumer) checkIfCurrentNodeShouldOwnVb(vb uint16) bool {
 	c.vbEventingNodeAssignRWMutex.RLock()
 	defer c.vbEventingNodeAssignRWMutex.RUnlock()
@@ -0,0 +1,131 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+	"gopkg.in/couchbase/gocb.v1"
+)
+
+var errWorkerDrainTimeout = errors.New("timed out draining worker queues")
+
+// DrainForRestart stops handing events to C++ worker, waits for it to finish
+// everything already handed over and checkpoints seqnos it actually processed.
+// Worker respawned afterwards streams from those seqnos, so no event is run
+// twice or skipped. If drain fails events start flowing to worker again.
+func (c *Consumer) DrainForRestart(timeout time.Duration) (err error) {
+	logPrefix := "Consumer::DrainForRestart"
+
+	atomic.StoreUint32(&c.drainingForRestart, 1)
+	defer func() {
+		if err != nil {
+			atomic.StoreUint32(&c.drainingForRestart, 0)
+		}
+	}()
+
+	logging.Infof("%s [%s:%s:%d] Draining worker ahead of restart, timeout: %v",
+		logPrefix, c.workerName, c.tcpPort, c.Pid(), timeout)
+
+	deadline := time.Now().Add(timeout)
+
+	// Event being sent while drain was requested has to be accounted for
+	// before queues are looked at
+	for atomic.LoadUint32(&c.dcpEventsParked) == 0 {
+		if time.Now().After(deadline) {
+			logging.Errorf("%s [%s:%s:%d] DCP event loop didn't stop sending events to worker",
+				logPrefix, c.workerName, c.tcpPort, c.Pid())
+			return errWorkerDrainTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.flushDcpEventBatches()
+
+	for {
+		if c.workerExited || c.stoppingConsumer {
+			return fmt.Errorf("worker exited while draining")
+		}
+
+		c.sendGetExecutionStats(false)
+
+		pendingVbs := c.vbsPendingProcessedSeqNo()
+		if len(pendingVbs) == 0 && c.CheckIfQueuesAreDrained() == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			logging.Errorf("%s [%s:%s:%d] Worker queues not drained, vbs yet to be acked len: %d dump: %s",
+				logPrefix, c.workerName, c.tcpPort, c.Pid(), len(pendingVbs), util.Condense(pendingVbs))
+			return errWorkerDrainTimeout
+		}
+		time.Sleep(workerDrainPollInterval)
+	}
+
+	if err = c.checkpointForRestart(); err != nil {
+		logging.Errorf("%s [%s:%s:%d] Failed to checkpoint drained vbs, err: %v",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), err)
+		return err
+	}
+
+	logging.Infof("%s [%s:%s:%d] Worker drained and checkpointed, ready to restart",
+		logPrefix, c.workerName, c.tcpPort, c.Pid())
+	return nil
+}
+
+// vbsPendingProcessedSeqNo returns owned vbs for which worker hasn't yet acked
+// the last event it was sent
+func (c *Consumer) vbsPendingProcessedSeqNo() []uint16 {
+	var vbs []uint16
+	for _, vb := range c.getCurrentlyOwnedVbs() {
+		lastSentSeqNo := c.vbProcessingStats.getVbStat(vb, "last_sent_seq_no").(uint64)
+		lastProcessedSeqNo := c.vbProcessingStats.getVbStat(vb, "last_processed_seq_no").(uint64)
+		if lastProcessedSeqNo < lastSentSeqNo {
+			vbs = append(vbs, vb)
+		}
+	}
+	return vbs
+}
+
+func (c *Consumer) checkpointForRestart() error {
+	logPrefix := "Consumer::checkpointForRestart"
+
+	var vbBlob vbucketKVBlob
+	var cas gocb.Cas
+	var isNoEnt bool
+
+	for _, vb := range c.getCurrentlyOwnedVbs() {
+		if c.vbProcessingStats.getVbStat(vb, "dcp_stream_status").(string) != dcpStreamRunning {
+			continue
+		}
+
+		vbKey := fmt.Sprintf("%s::vb::%d", c.app.AppName, vb)
+
+		isNoEnt = false
+		err := util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, getOpCallback,
+			c, c.producer.AddMetadataPrefix(vbKey), &vbBlob, &cas, true, &isNoEnt)
+		if err == common.ErrRetryTimeout {
+			return err
+		}
+
+		if isNoEnt {
+			err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), c.retryCount, recreateCheckpointBlobsFromVbStatsCallback, c,
+				c.producer.AddMetadataPrefix(vbKey), &vbBlob)
+			if err == common.ErrRetryTimeout {
+				return err
+			}
+		}
+
+		if err = c.updateCheckpointInfo(vbKey, vb, &vbBlob); err != nil {
+			return err
+		}
+
+		logging.Debugf("%s [%s:%s:%d] vb: %d checkpointed last_processed_seq_no: %d",
+			logPrefix, c.workerName, c.tcpPort, c.Pid(), vb, vbBlob.LastSeqNoProcessed)
+	}
+
+	return nil
+}
@@ -53,7 +53,7 @@ var ConnPoolCallback func(host string, source string, start time.Time, err error
 func defaultMkConn(
 	host string, ah AuthHandler) (conn *memcached.Client, err error) {
 
-	conn, err = memcached.Connect("tcp", host)
+	conn, err = dialMemcached(host)
 	if err != nil {
 		return nil, err
 	}
@@ -223,10 +223,15 @@ func (cp *connectionPool) StartDcpFeed(
 	// Dont' count it against the connection pool capacity
 	<-cp.createsem
 
+	bufsize := DEFAULT_WINDOW_SIZE
+	if val, ok := config["connectionBufferSize"]; ok && val != nil {
+		bufsize = uint32(val.(int))
+	}
+
 	dcpf, err := memcached.NewDcpFeed(mc, name.Raw(), outch, opaque, config)
 	if err == nil {
 		err = dcpf.DcpOpen(
-			name.Raw(), sequence, flags, DEFAULT_WINDOW_SIZE, opaque,
+			name.Raw(), sequence, flags, bufsize, opaque,
 		)
 		if err == nil {
 			return dcpf, err
@@ -550,6 +550,7 @@ func (b *Bucket) init(nb *Bucket) {
 			nb.VBSMJson.ServerList[i],
 			b.authHandler(), PoolSize, PoolOverflow)
 	}
+	b.refreshKVSSLAddrs(nb.VBSMJson.ServerList)
 	b.replaceConnPools(newcps)
 	atomic.StorePointer(&b.vBucketServerMap, unsafe.Pointer(&nb.VBSMJson))
 	atomic.StorePointer(&b.nodeList, unsafe.Pointer(&nb.NodesJSON))
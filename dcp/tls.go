@@ -0,0 +1,117 @@
+package couchbase
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/logging"
+)
+
+var errNoKVSSLAddr = errors.New("TLS address of data service node not known")
+
+// KVTLSConfigCallback supplies TLS configuration for memcached connections
+// to data service nodes. Connections are made in plain text when it's unset
+// or returns a nil config.
+var KVTLSConfigCallback func() (*tls.Config, error)
+
+// Plain text memcached address of data service nodes to their TLS address
+var kvSSLAddrs = struct {
+	sync.RWMutex
+	addrs map[string]string
+}{addrs: make(map[string]string)}
+
+func kvTLSConfig() (*tls.Config, error) {
+	if KVTLSConfigCallback == nil {
+		return nil, nil
+	}
+	return KVTLSConfigCallback()
+}
+
+// KVSSLAddr returns TLS address of the data service node listening on addr
+func KVSSLAddr(addr string) (string, bool) {
+	kvSSLAddrs.RLock()
+	defer kvSSLAddrs.RUnlock()
+
+	sslAddr := kvSSLAddrs.addrs[addr]
+	return sslAddr, sslAddr != ""
+}
+
+// kvSSLAddrsKnown tells if node services have been seen for all of servers,
+// including those without a TLS port
+func kvSSLAddrsKnown(servers []string) bool {
+	kvSSLAddrs.RLock()
+	defer kvSSLAddrs.RUnlock()
+
+	for _, server := range servers {
+		if _, ok := kvSSLAddrs.addrs[server]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshKVSSLAddrs learns TLS ports of data service nodes from node services
+// of the pool. They're learnt even while connections to KV are in plain text,
+// so that connections made after TLS is turned on find them. Node services are
+// only fetched when servers has a node not seen before, i.e. on topology change.
+func (b *Bucket) refreshKVSSLAddrs(servers []string) {
+	if kvSSLAddrsKnown(servers) {
+		return
+	}
+
+	ps, err := b.pool.client.GetPoolServices("default")
+	if err != nil {
+		logging.Errorf("Bucket::refreshKVSSLAddrs Failed to fetch node services, err: %v", err)
+		return
+	}
+
+	connHost, _, _ := net.SplitHostPort(b.pool.client.BaseURL.Host)
+
+	kvSSLAddrs.Lock()
+	defer kvSSLAddrs.Unlock()
+
+	for _, ns := range ps.NodesExt {
+		kvPort, ok := ns.Services["kv"]
+		if !ok {
+			continue
+		}
+
+		// Hostname is omitted for the node serving the request
+		hostname := ns.Hostname
+		if hostname == "" {
+			hostname = connHost
+		}
+
+		addr := net.JoinHostPort(hostname, strconv.Itoa(kvPort))
+		kvSSLPort, ok := ns.Services["kvSSL"]
+		if !ok {
+			// Noted as seen, so that it doesn't trigger a fetch on every refresh
+			kvSSLAddrs.addrs[addr] = ""
+			continue
+		}
+		kvSSLAddrs.addrs[addr] = net.JoinHostPort(hostname, strconv.Itoa(kvSSLPort))
+	}
+}
+
+// dialMemcached connects to the data service node, over TLS when configured
+func dialMemcached(host string) (*memcached.Client, error) {
+	config, err := kvTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return memcached.Connect("tcp", host)
+	}
+
+	sslAddr, ok := KVSSLAddr(host)
+	if !ok {
+		logging.Errorf("dialMemcached TLS address not known for host: %rs", host)
+		return nil, errNoKVSSLAddr
+	}
+	return memcached.ConnectTLS("tcp", sslAddr, config)
+}
@@ -0,0 +1,56 @@
+package couchbase
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRefreshKVSSLAddrsOnTopologyChange(t *testing.T) {
+	var fetches int32
+	services := `{"rev":1,"nodesExt":[
+		{"services":{"kv":11210,"kvSSL":11207},"hostname":"10.0.0.1"},
+		{"services":{"kv":11210},"hostname":"10.0.0.2"},
+		{"services":{"n1ql":8093},"hostname":"10.0.0.3"}]}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pools/default/nodeServices" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		w.Write([]byte(services))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+	b := &Bucket{pool: &Pool{client: Client{
+		BaseURL: baseURL,
+		Info:    Pools{Pools: []RestPool{{Name: "default"}}},
+	}}}
+
+	kvSSLAddrs.Lock()
+	kvSSLAddrs.addrs = make(map[string]string)
+	kvSSLAddrs.Unlock()
+
+	servers := []string{"10.0.0.1:11210", "10.0.0.2:11210"}
+	b.refreshKVSSLAddrs(servers)
+	b.refreshKVSSLAddrs(servers)
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected node services to be fetched once for unchanged servers, got %d", n)
+	}
+
+	if addr, ok := KVSSLAddr("10.0.0.1:11210"); !ok || addr != "10.0.0.1:11207" {
+		t.Errorf("expected TLS address 10.0.0.1:11207, got %s, %v", addr, ok)
+	}
+	if addr, ok := KVSSLAddr("10.0.0.2:11210"); ok {
+		t.Errorf("expected no TLS address for node without kvSSL, got %s", addr)
+	}
+
+	b.refreshKVSSLAddrs(append(servers, "10.0.0.4:11210"))
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected node services to be fetched again for new server, got %d", n)
+	}
+}
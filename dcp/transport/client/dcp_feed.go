@@ -24,6 +24,13 @@ const openConnFlag = uint32(0x1)
 const bufferAckPeriod = 20
 const includeDeleteTime = uint32(0x20)
 
+// Interval at which withheld buffer acks are re-evaluated, so that KV is
+// un-throttled even if no further packets arrive on the connection
+const bufferAckCheckInterval = 100 * time.Millisecond
+
+// Default consumer fill level beyond which buffer acks are withheld
+const defaultAckThrottleWatermark = 0.8
+
 // error codes
 var ErrorInvalidLog = errors.New("couchbase.errorInvalidLog")
 
@@ -44,6 +51,12 @@ type DcpFeed struct {
 	reqch     chan []interface{}
 	finch     chan bool
 	logPrefix string
+	// flow control
+	ackThreshold         float32        // fraction of connection buffer after which BufferAck is sent
+	ackThrottleFn        func() float64 // reports consumer fill level, nil disables adaptive acking
+	ackThrottleWatermark float64        // fill level beyond which BufferAck is withheld
+	firstUnackedTime     time.Time      // when the oldest un-acked byte was read
+	throttleStartTime    time.Time      // when BufferAck started being withheld
 	// stats
 	toAckBytes         uint32    // bytes client has read
 	maxAckBytes        uint32    // Max buffer control ack bytes
@@ -69,6 +82,19 @@ func NewDcpFeed(
 		// TODO: would be nice to add host-addr as part of prefix.
 		logPrefix:  fmt.Sprintf("DCPT[%s]", name),
 		dcplatency: &Average{},
+
+		ackThreshold:         bufferAckThreshold,
+		ackThrottleWatermark: defaultAckThrottleWatermark,
+	}
+
+	if val, ok := config["bufferAckThreshold"]; ok && val != nil {
+		feed.ackThreshold = float32(val.(float64))
+	}
+	if val, ok := config["ackThrottleFn"]; ok && val != nil {
+		feed.ackThrottleFn = val.(func() float64)
+	}
+	if val, ok := config["ackThrottleWatermark"]; ok && val != nil {
+		feed.ackThrottleWatermark = val.(float64)
 	}
 
 	mc.Hijack()
@@ -182,13 +208,19 @@ func (feed *DcpFeed) genServer(
 		latencyTick = int64(val.(int)) // in milli-seconds
 	}
 	latencyTm := time.NewTicker(time.Duration(latencyTick) * time.Millisecond)
+	ackTm := time.NewTicker(bufferAckCheckInterval)
 	defer func() {
 		latencyTm.Stop()
+		ackTm.Stop()
 	}()
 
 loop:
 	for {
 		select {
+		case <-ackTm.C:
+			// Flush acks withheld while consumer was throttling KV
+			feed.sendBufferAck(true, 0)
+
 		case <-latencyTm.C:
 
 			fmsg := "%v dcp latency stats %v\n"
@@ -617,7 +649,8 @@ func (feed *DcpFeed) doDcpOpen(
 		if err := feed.doControlRequest(opaque, "connection_buffer_size", []byte(strconv.Itoa(int(bufsize))), rcvch); err != nil {
 			return err
 		}
-		feed.maxAckBytes = uint32(bufferAckThreshold * float32(bufsize))
+		feed.maxAckBytes = uint32(feed.ackThreshold * float32(bufsize))
+		atomic.StoreUint64(&feed.stats.ConnectionBufferSize, uint64(bufsize))
 	}
 
 	// send a DCP control message to enable_noop
@@ -809,6 +842,22 @@ func (feed *DcpFeed) sendBufferAck(sendAck bool, bytes uint32) {
 	prefix := feed.logPrefix
 	if sendAck {
 		totalBytes := feed.toAckBytes + bytes
+		if totalBytes == 0 {
+			return
+		}
+
+		if feed.toAckBytes == 0 {
+			feed.firstUnackedTime = time.Now()
+		}
+
+		// Withhold acks while eventing is unable to keep up, so that KV stops
+		// sending once the connection buffer fills up
+		if feed.isAckThrottled() {
+			feed.toAckBytes = totalBytes
+			atomic.StoreUint64(&feed.stats.BytesOutstanding, uint64(totalBytes))
+			return
+		}
+
 		if totalBytes > feed.maxAckBytes || time.Since(feed.lastAckTime).Seconds() > bufferAckPeriod {
 			bufferAck := &transport.MCRequest{
 				Opcode: transport.DCP_BUFFERACK,
@@ -822,18 +871,55 @@ func (feed *DcpFeed) sendBufferAck(sendAck bool, bytes uint32) {
 				defer feed.conn.ResetMcdConnectionWriteDeadline()
 
 				if err := feed.conn.Transmit(bufferAck); err != nil {
+					feed.toAckBytes = totalBytes
 					logging.Errorf("%v buffer-ack Transmit(): %v, lastAckTime: %v", prefix, err, feed.lastAckTime.UnixNano())
 				} else {
 					// Reset the counters only on a successful BufferAck
 					feed.toAckBytes = 0
 					feed.lastAckTime = time.Now()
 					feed.stats.LastAckTime = feed.lastAckTime.UnixNano()
+					atomic.StoreUint64(&feed.stats.LastAckLatency, uint64(feed.lastAckTime.Sub(feed.firstUnackedTime)))
 					logging.Tracef("%v buffer-ack %v, lastAckTime: %v\n", prefix, totalBytes, feed.lastAckTime.UnixNano())
 				}
 			}()
 		} else {
-			feed.toAckBytes += bytes
+			feed.toAckBytes = totalBytes
+		}
+		atomic.StoreUint64(&feed.stats.BytesOutstanding, uint64(feed.toAckBytes))
+	}
+}
+
+// isAckThrottled reports whether buffer acks should be withheld because the
+// consumer queues are beyond the configured watermark. Also accounts for time
+// spent throttling KV.
+func (feed *DcpFeed) isAckThrottled() bool {
+	if feed.ackThrottleFn == nil {
+		return false
+	}
+
+	throttle := feed.ackThrottleFn() >= feed.ackThrottleWatermark
+	if throttle {
+		if feed.throttleStartTime.IsZero() {
+			feed.throttleStartTime = time.Now()
+			atomic.AddUint64(&feed.stats.TotalAckThrottled, 1)
 		}
+	} else if !feed.throttleStartTime.IsZero() {
+		atomic.AddUint64(&feed.stats.ThrottledTime, uint64(time.Since(feed.throttleStartTime)))
+		feed.throttleStartTime = time.Time{}
+	}
+	return throttle
+}
+
+// FlowControlStats returns point in time flow control stats for the feed.
+// Safe to be called from a routine other than genServer.
+func (feed *DcpFeed) FlowControlStats() map[string]uint64 {
+	return map[string]uint64{
+		"bytes_outstanding":      atomic.LoadUint64(&feed.stats.BytesOutstanding),
+		"connection_buffer_size": atomic.LoadUint64(&feed.stats.ConnectionBufferSize),
+		"last_ack_latency":       atomic.LoadUint64(&feed.stats.LastAckLatency),
+		"ack_throttled_count":    atomic.LoadUint64(&feed.stats.TotalAckThrottled),
+		"ack_throttled_time":     atomic.LoadUint64(&feed.stats.ThrottledTime),
+		"blocked_time":           atomic.LoadUint64(&feed.stats.BlockedTime),
 	}
 }
 
@@ -874,6 +960,7 @@ type DcpEvent struct {
 	VBuuid     uint64                // This field is set by downstream
 	Key, Value []byte                // Item key/value
 	OldValue   []byte                // TODO: TBD: old document value
+	RawXattrs  []byte                // Xattrs section, if kept aside when stripped off Value
 	Cas        uint64                // CAS value of the item
 	// meta fields
 	Seqno uint64 // seqno. of the mutation, doubles as rollback-seqno
@@ -958,16 +1045,29 @@ type DcpStats struct {
 	TotalStreamReq     uint64
 	TotalStreamEnd     uint64
 	LastAckTime        int64
+
+	// Flow control stats, accessed atomically as they are read outside of genServer
+	BytesOutstanding     uint64 // bytes read but not yet acked
+	ConnectionBufferSize uint64 // DCP connection buffer size negotiated with KV
+	LastAckLatency       uint64 // ns between oldest un-acked byte and BufferAck
+	TotalAckThrottled    uint64 // times BufferAck was withheld as consumer was backed up
+	ThrottledTime        uint64 // ns for which BufferAck was withheld
+	BlockedTime          uint64 // ns for which DCP socket reader was blocked on eventing
 }
 
 func (stats *DcpStats) String(feed *DcpFeed) string {
 	return fmt.Sprintf(
 		"bytes: %v buffacks: %v toAckBytes: %v streamreqs: %v "+
 			"snapshots: %v mutations: %v streamends: %v closestreams: %v"+
-			"lastAckTime: %v",
+			"lastAckTime: %v lastAckLatency: %v ackThrottled: %v throttledTime: %v "+
+			"blockedTime: %v",
 		stats.TotalBytes, stats.TotalBufferAckSent, feed.toAckBytes,
 		stats.TotalStreamReq, stats.TotalSnapShot, stats.TotalMutation,
 		stats.TotalStreamEnd, stats.TotalCloseStream, stats.LastAckTime,
+		time.Duration(atomic.LoadUint64(&stats.LastAckLatency)),
+		atomic.LoadUint64(&stats.TotalAckThrottled),
+		time.Duration(atomic.LoadUint64(&stats.ThrottledTime)),
+		time.Duration(atomic.LoadUint64(&stats.BlockedTime)),
 	)
 }
 
@@ -1168,6 +1268,7 @@ loop:
 		if blocked {
 			blockedTs := time.Since(start)
 			duration += blockedTs
+			atomic.AddUint64(&feed.stats.BlockedTime, uint64(blockedTs))
 			blocked = false
 			select {
 			case <-tick.C:
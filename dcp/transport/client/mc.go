@@ -2,6 +2,7 @@
 package memcached
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -51,6 +52,15 @@ func Connect(prot, dest string) (rv *Client, err error) {
 	return Wrap(conn)
 }
 
+// ConnectTLS connects to a memcached server over TLS.
+func ConnectTLS(prot, dest string, config *tls.Config) (rv *Client, err error) {
+	conn, err := tls.Dial(prot, dest, config)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn)
+}
+
 // Wrap an existing transport.
 func Wrap(rwc io.ReadWriteCloser) (rv *Client, err error) {
 	return &Client{
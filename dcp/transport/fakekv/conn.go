@@ -0,0 +1,479 @@
+package fakekv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Statuses not defined in dcp/transport
+const (
+	statusAuthError = mcd.Status(0x20)
+	statusNoBucket  = mcd.Status(0x8c)
+)
+
+// DCP_OPEN flags
+const (
+	dcpOpenProducer       = uint32(0x01)
+	dcpOpenIncludeXattrs  = uint32(0x04)
+	dcpOpenIncludeDelTime = uint32(0x20)
+)
+
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	writeMu sync.Mutex
+
+	authenticated  bool
+	bucketSelected bool
+
+	dcpName  string
+	dcpFlags uint32
+	controls map[string]string
+
+	// DCP flow control, guarded by fcMu
+	fcMu       sync.Mutex
+	fcCond     *sync.Cond
+	bufferSize uint32
+	unacked    uint32
+	closed     bool
+
+	streams   map[uint16]*dcpStream // Access controlled by streamsMu
+	streamsMu sync.Mutex
+	streamsWg sync.WaitGroup
+
+	closeOnce sync.Once
+}
+
+func newConn(server *Server, netConn net.Conn) *conn {
+	c := &conn{
+		server:   server,
+		netConn:  netConn,
+		controls: make(map[string]string),
+		streams:  make(map[uint16]*dcpStream),
+	}
+	c.fcCond = sync.NewCond(&c.fcMu)
+	return c
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		c.fcMu.Lock()
+		c.closed = true
+		c.fcCond.Broadcast()
+		c.fcMu.Unlock()
+
+		c.netConn.Close()
+	})
+}
+
+func (c *conn) serve() {
+	logPrefix := "FakeKV::serve"
+
+	logging.Infof("%s Accepted connection from: %s", logPrefix, c.netConn.RemoteAddr())
+
+	defer func() {
+		c.close()
+
+		c.streamsMu.Lock()
+		for _, st := range c.streams {
+			st.stop()
+		}
+		c.streamsMu.Unlock()
+		c.streamsWg.Wait()
+
+		logging.Infof("%s Closed connection from: %s dcp name: %s",
+			logPrefix, c.netConn.RemoteAddr(), c.dcpName)
+	}()
+
+	var hdrBytes [mcd.HDR_LEN]byte
+	for {
+		req := &mcd.MCRequest{}
+		_, err := req.Receive(c.netConn, hdrBytes[:])
+		if err != nil {
+			return
+		}
+
+		// Responses from client, e.g. to DCP_NOOP, need no handling
+		if hdrBytes[0] == mcd.RES_MAGIC {
+			continue
+		}
+
+		if !c.handle(req) {
+			return
+		}
+	}
+}
+
+func (c *conn) respond(req *mcd.MCRequest, status mcd.Status, res *mcd.MCResponse) bool {
+	if res == nil {
+		res = &mcd.MCResponse{}
+	}
+	res.Opcode, res.Opaque, res.Status = req.Opcode, req.Opaque, status
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := res.Transmit(c.netConn)
+	return err == nil
+}
+
+func (c *conn) transmit(req *mcd.MCRequest) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := req.Transmit(c.netConn)
+	return err
+}
+
+func (c *conn) handle(req *mcd.MCRequest) bool {
+	switch req.Opcode {
+	case mcd.NOOP:
+		return c.respond(req, mcd.SUCCESS, nil)
+
+	case mcd.QUIT:
+		c.respond(req, mcd.SUCCESS, nil)
+		return false
+
+	case mcd.SASL_LIST_MECHS:
+		return c.respond(req, mcd.SUCCESS, &mcd.MCResponse{Body: []byte("PLAIN")})
+
+	case mcd.SASL_AUTH, mcd.SASL_STEP:
+		return c.handleAuth(req)
+	}
+
+	if !c.authenticated && len(c.server.config.Users) > 0 {
+		return c.respond(req, statusAuthError, nil)
+	}
+
+	switch req.Opcode {
+	case mcd.SELECT_BUCKET:
+		if c.server.config.Bucket != "" && string(req.Key) != c.server.config.Bucket {
+			return c.respond(req, mcd.KEY_ENOENT, nil)
+		}
+		c.bucketSelected = true
+		return c.respond(req, mcd.SUCCESS, nil)
+
+	case mcd.STAT:
+		// Only the terminating packet, no stats are tracked
+		return c.respond(req, mcd.SUCCESS, nil)
+	}
+
+	if !c.bucketSelected && c.server.config.Bucket != "" {
+		return c.respond(req, statusNoBucket, nil)
+	}
+
+	switch req.Opcode {
+	case mcd.GET, mcd.GETK:
+		return c.handleGet(req)
+
+	case mcd.SET, mcd.ADD, mcd.REPLACE:
+		return c.handleStore(req)
+
+	case mcd.DELETE:
+		return c.handleDelete(req)
+
+	case mcd.DCP_OPEN:
+		return c.handleDcpOpen(req)
+
+	case mcd.DCP_CONTROL:
+		return c.handleDcpControl(req)
+
+	case mcd.DCP_BUFFERACK:
+		c.handleBufferAck(req)
+		return true
+
+	case mcd.DCP_FAILOVERLOG:
+		vb, ok := c.server.vbucket(req.VBucket)
+		if !ok {
+			return c.respond(req, mcd.NOT_MY_VBUCKET, nil)
+		}
+		return c.respond(req, mcd.SUCCESS, &mcd.MCResponse{Body: vb.failoverLogBytes()})
+
+	case mcd.DCP_GET_SEQNO:
+		body := make([]byte, 0, 10*len(c.server.vbuckets))
+		for _, vb := range c.server.vbuckets {
+			var entry [10]byte
+			binary.BigEndian.PutUint16(entry[0:2], vb.id)
+			binary.BigEndian.PutUint64(entry[2:10], vb.highSeqNo())
+			body = append(body, entry[:]...)
+		}
+		return c.respond(req, mcd.SUCCESS, &mcd.MCResponse{Body: body})
+
+	case mcd.DCP_STREAMREQ:
+		return c.handleStreamRequest(req)
+
+	case mcd.DCP_CLOSESTREAM:
+		return c.handleCloseStream(req)
+
+	default:
+		return c.respond(req, mcd.UNKNOWN_COMMAND, nil)
+	}
+}
+
+func (c *conn) handleAuth(req *mcd.MCRequest) bool {
+	if string(req.Key) != "PLAIN" {
+		return c.respond(req, statusAuthError, nil)
+	}
+
+	// PLAIN payload is "authzid\x00user\x00password"
+	parts := bytes.Split(req.Body, []byte{0})
+	if len(parts) != 3 || !c.server.authenticate(string(parts[1]), string(parts[2])) {
+		return c.respond(req, statusAuthError, nil)
+	}
+
+	c.authenticated = true
+	return c.respond(req, mcd.SUCCESS, nil)
+}
+
+func (c *conn) handleGet(req *mcd.MCRequest) bool {
+	vb, ok := c.server.vbucket(req.VBucket)
+	if !ok {
+		return c.respond(req, mcd.NOT_MY_VBUCKET, nil)
+	}
+
+	item, ok := vb.get(string(req.Key))
+	if !ok {
+		return c.respond(req, mcd.KEY_ENOENT, nil)
+	}
+
+	res := &mcd.MCResponse{
+		Cas:    item.Cas,
+		Extras: make([]byte, 4),
+		Body:   item.Value,
+	}
+	binary.BigEndian.PutUint32(res.Extras, item.Flags)
+	if req.Opcode == mcd.GETK {
+		res.Key = req.Key
+	}
+	return c.respond(req, mcd.SUCCESS, res)
+}
+
+func (c *conn) handleStore(req *mcd.MCRequest) bool {
+	vb, ok := c.server.vbucket(req.VBucket)
+	if !ok {
+		return c.respond(req, mcd.NOT_MY_VBUCKET, nil)
+	}
+
+	if len(req.Extras) != 8 {
+		return c.respond(req, mcd.EINVAL, nil)
+	}
+
+	item := &Item{
+		Key:      string(req.Key),
+		Value:    req.Body,
+		Datatype: req.Datatype,
+		Flags:    binary.BigEndian.Uint32(req.Extras[0:4]),
+		Expiry:   binary.BigEndian.Uint32(req.Extras[4:8]),
+	}
+
+	if req.Datatype&DatatypeXattrs != 0 {
+		xattrs, value, err := decodeXattrs(req.Body)
+		if err != nil {
+			return c.respond(req, mcd.EINVAL, nil)
+		}
+		item.Xattrs, item.Value = xattrs, value
+	}
+
+	check := casMatches(req.Cas)
+	switch req.Opcode {
+	case mcd.ADD:
+		check = func(existing *Item, live bool) mcd.Status {
+			if live {
+				return mcd.KEY_EEXISTS
+			}
+			return mcd.SUCCESS
+		}
+
+	case mcd.REPLACE:
+		check = func(existing *Item, live bool) mcd.Status {
+			if !live {
+				return mcd.KEY_ENOENT
+			}
+			return casMatches(req.Cas)(existing, live)
+		}
+	}
+
+	stored, status := vb.apply(item, check)
+	if status != mcd.SUCCESS {
+		return c.respond(req, status, nil)
+	}
+	return c.respond(req, mcd.SUCCESS, &mcd.MCResponse{Cas: stored.Cas})
+}
+
+func (c *conn) handleDelete(req *mcd.MCRequest) bool {
+	vb, ok := c.server.vbucket(req.VBucket)
+	if !ok {
+		return c.respond(req, mcd.NOT_MY_VBUCKET, nil)
+	}
+
+	stored, status := vb.apply(&Item{Key: string(req.Key), Deleted: true}, casMatches(req.Cas))
+	if status != mcd.SUCCESS {
+		return c.respond(req, status, nil)
+	}
+	return c.respond(req, mcd.SUCCESS, &mcd.MCResponse{Cas: stored.Cas})
+}
+
+func (c *conn) handleDcpOpen(req *mcd.MCRequest) bool {
+	if len(req.Extras) != 8 {
+		return c.respond(req, mcd.EINVAL, nil)
+	}
+
+	flags := binary.BigEndian.Uint32(req.Extras[4:8])
+	if flags&dcpOpenProducer == 0 {
+		// Only producer connections, i.e. eventing acting as DCP consumer, are supported
+		return c.respond(req, mcd.EINVAL, nil)
+	}
+
+	c.dcpName, c.dcpFlags = string(req.Key), flags
+	return c.respond(req, mcd.SUCCESS, nil)
+}
+
+func (c *conn) handleDcpControl(req *mcd.MCRequest) bool {
+	key, value := string(req.Key), string(req.Body)
+
+	if key == "connection_buffer_size" {
+		size, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return c.respond(req, mcd.EINVAL, nil)
+		}
+
+		c.fcMu.Lock()
+		c.bufferSize = uint32(size)
+		c.fcCond.Broadcast()
+		c.fcMu.Unlock()
+	}
+
+	c.controls[key] = value
+	return c.respond(req, mcd.SUCCESS, nil)
+}
+
+// Buffer acks aren't responded to, same as KV
+func (c *conn) handleBufferAck(req *mcd.MCRequest) {
+	if len(req.Extras) != 4 {
+		return
+	}
+	acked := binary.BigEndian.Uint32(req.Extras)
+
+	c.fcMu.Lock()
+	if acked > c.unacked {
+		acked = c.unacked
+	}
+	c.unacked -= acked
+	c.fcCond.Broadcast()
+	c.fcMu.Unlock()
+}
+
+// acquire blocks till flow control window admits size bytes, returns false
+// if connection or stream was closed meanwhile
+func (c *conn) acquire(size uint32, st *dcpStream) bool {
+	c.fcMu.Lock()
+	defer c.fcMu.Unlock()
+
+	for c.bufferSize > 0 && c.unacked > 0 && c.unacked+size > c.bufferSize {
+		if c.closed || st.isStopped() {
+			return false
+		}
+		c.fcCond.Wait()
+	}
+
+	if c.closed || st.isStopped() {
+		return false
+	}
+
+	c.unacked += size
+	return true
+}
+
+func (c *conn) release(size uint32) {
+	c.fcMu.Lock()
+	defer c.fcMu.Unlock()
+
+	if size > c.unacked {
+		size = c.unacked
+	}
+	c.unacked -= size
+}
+
+func (c *conn) stream(vb uint16) *dcpStream {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	return c.streams[vb]
+}
+
+func (c *conn) handleStreamRequest(req *mcd.MCRequest) bool {
+	vb, ok := c.server.vbucket(req.VBucket)
+	if !ok {
+		return c.respond(req, mcd.NOT_MY_VBUCKET, nil)
+	}
+
+	if c.dcpName == "" || len(req.Extras) != 48 {
+		return c.respond(req, mcd.EINVAL, nil)
+	}
+
+	start := binary.BigEndian.Uint64(req.Extras[8:16])
+	end := binary.BigEndian.Uint64(req.Extras[16:24])
+	vbuuid := binary.BigEndian.Uint64(req.Extras[24:32])
+
+	if start > end {
+		return c.respond(req, mcd.ERANGE, nil)
+	}
+
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+
+	if _, exists := c.streams[req.VBucket]; exists {
+		return c.respond(req, mcd.KEY_EEXISTS, nil)
+	}
+
+	status, rollbackSeqNo := vb.streamRequestStatus(vbuuid, start)
+	if status == mcd.ROLLBACK {
+		body := make([]byte, 8)
+		binary.BigEndian.PutUint64(body, rollbackSeqNo)
+		return c.respond(req, mcd.ROLLBACK, &mcd.MCResponse{Body: body})
+	}
+
+	// Response must reach the client ahead of any stream message
+	if !c.respond(req, mcd.SUCCESS, &mcd.MCResponse{Body: vb.failoverLogBytes()}) {
+		return false
+	}
+
+	st := newDcpStream(c, vb, req.Opaque, start, end)
+	c.streams[req.VBucket] = st
+
+	c.streamsWg.Add(1)
+	go st.run()
+	return true
+}
+
+func (c *conn) handleCloseStream(req *mcd.MCRequest) bool {
+	st := c.stream(req.VBucket)
+	if st == nil {
+		return c.respond(req, mcd.KEY_ENOENT, nil)
+	}
+
+	if !c.respond(req, mcd.SUCCESS, nil) {
+		return false
+	}
+
+	if c.controls["send_stream_end_on_client_close_stream"] == "true" {
+		st.end(StreamEndClosed)
+	} else {
+		st.end(streamEndSilently)
+	}
+	return true
+}
+
+func (c *conn) removeStream(st *dcpStream) {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+
+	if c.streams[st.vb.id] == st {
+		delete(c.streams, st.vb.id)
+	}
+}
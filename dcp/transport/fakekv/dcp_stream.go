@@ -0,0 +1,211 @@
+package fakekv
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+)
+
+// Ends stream without notifying client, i.e. when client closes a stream
+// without asking for a STREAMEND
+const streamEndSilently = uint32(0xFFFFFFFF)
+
+const snapshotTypeMemory = uint32(0x01)
+
+type dcpStream struct {
+	c          *conn
+	vb         *vbucket
+	opaque     uint32
+	startSeqNo uint64
+	endSeqNo   uint64
+
+	endCh    chan uint32
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newDcpStream(c *conn, vb *vbucket, opaque uint32, start, end uint64) *dcpStream {
+	return &dcpStream{
+		c:          c,
+		vb:         vb,
+		opaque:     opaque,
+		startSeqNo: start,
+		endSeqNo:   end,
+		endCh:      make(chan uint32, 1),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// end asks the stream to send a STREAMEND with the flag and terminate
+func (st *dcpStream) end(flag uint32) {
+	select {
+	case st.endCh <- flag:
+	default:
+	}
+	st.stop()
+}
+
+// stop terminates the stream, unblocking it if it's waiting on flow control
+func (st *dcpStream) stop() {
+	st.stopOnce.Do(func() {
+		close(st.stopCh)
+
+		st.c.fcMu.Lock()
+		st.c.fcCond.Broadcast()
+		st.c.fcMu.Unlock()
+	})
+}
+
+func (st *dcpStream) isStopped() bool {
+	select {
+	case <-st.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (st *dcpStream) run() {
+	defer st.c.streamsWg.Done()
+	defer st.c.removeStream(st)
+
+	next := st.startSeqNo
+	for {
+		if next >= st.endSeqNo {
+			st.sendStreamEnd(StreamEndOK)
+			return
+		}
+
+		changes, changed := st.vb.changesSince(next, st.endSeqNo)
+		if len(changes) > 0 {
+			if !st.sendSnapshot(changes) {
+				st.finish()
+				return
+			}
+			next = changes[len(changes)-1].SeqNo
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-st.stopCh:
+			st.finish()
+			return
+		}
+	}
+}
+
+func (st *dcpStream) finish() {
+	select {
+	case flag := <-st.endCh:
+		if flag != streamEndSilently {
+			st.sendStreamEnd(flag)
+		}
+	default:
+	}
+}
+
+func (st *dcpStream) sendSnapshot(changes []*Item) bool {
+	marker := &mcd.MCRequest{
+		Opcode:  mcd.DCP_SNAPSHOT,
+		VBucket: st.vb.id,
+		Opaque:  st.opaque,
+		Extras:  make([]byte, 20),
+	}
+	binary.BigEndian.PutUint64(marker.Extras[0:8], changes[0].SeqNo)
+	binary.BigEndian.PutUint64(marker.Extras[8:16], changes[len(changes)-1].SeqNo)
+	binary.BigEndian.PutUint32(marker.Extras[16:20], snapshotTypeMemory)
+
+	if !st.send(marker) {
+		return false
+	}
+
+	for _, item := range changes {
+		if !st.send(st.changeRequest(item)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (st *dcpStream) changeRequest(item *Item) *mcd.MCRequest {
+	req := &mcd.MCRequest{
+		Opcode:  item.opcode(),
+		VBucket: st.vb.id,
+		Opaque:  st.opaque,
+		Cas:     item.Cas,
+		Key:     []byte(item.Key),
+	}
+
+	includeXattrs := st.c.dcpFlags&dcpOpenIncludeXattrs != 0
+
+	switch req.Opcode {
+	case mcd.DCP_MUTATION:
+		// by_seqno, rev_seqno, flags, expiration, lock_time, nmeta, nru
+		req.Extras = make([]byte, 31)
+		binary.BigEndian.PutUint64(req.Extras[0:8], item.SeqNo)
+		binary.BigEndian.PutUint64(req.Extras[8:16], item.RevSeqNo)
+		binary.BigEndian.PutUint32(req.Extras[16:20], item.Flags)
+		binary.BigEndian.PutUint32(req.Extras[20:24], item.Expiry)
+
+		req.Datatype = item.Datatype &^ DatatypeXattrs
+		req.Body = item.Value
+		if includeXattrs && len(item.Xattrs) > 0 {
+			req.Datatype |= DatatypeXattrs
+			req.Body = encodeXattrs(item.Xattrs, item.Value)
+		}
+
+	case mcd.DCP_DELETION, mcd.DCP_EXPIRATION:
+		if st.c.dcpFlags&dcpOpenIncludeDelTime != 0 {
+			// by_seqno, rev_seqno, delete_time, collection_len
+			req.Extras = make([]byte, 21)
+			binary.BigEndian.PutUint32(req.Extras[16:20], uint32(time.Now().Unix()))
+		} else {
+			// by_seqno, rev_seqno, nmeta
+			req.Extras = make([]byte, 18)
+		}
+		binary.BigEndian.PutUint64(req.Extras[0:8], item.SeqNo)
+		binary.BigEndian.PutUint64(req.Extras[8:16], item.RevSeqNo)
+	}
+
+	return req
+}
+
+func (st *dcpStream) sendStreamEnd(flag uint32) {
+	// Client may request the stream again as soon as it sees STREAMEND
+	st.c.removeStream(st)
+
+	req := &mcd.MCRequest{
+		Opcode:  mcd.DCP_STREAMEND,
+		VBucket: st.vb.id,
+		Opaque:  st.opaque,
+		Extras:  make([]byte, 4),
+	}
+	binary.BigEndian.PutUint32(req.Extras, flag)
+
+	// Control messages aren't held back by flow control
+	size := uint32(req.Size())
+	st.c.fcMu.Lock()
+	st.c.unacked += size
+	st.c.fcMu.Unlock()
+
+	if err := st.c.transmit(req); err != nil {
+		st.c.release(size)
+	}
+}
+
+func (st *dcpStream) send(req *mcd.MCRequest) bool {
+	size := uint32(req.Size())
+	if !st.c.acquire(size, st) {
+		return false
+	}
+
+	if err := st.c.transmit(req); err != nil {
+		st.c.release(size)
+		st.c.close()
+		return false
+	}
+	return true
+}
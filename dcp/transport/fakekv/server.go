@@ -0,0 +1,313 @@
+// Package fakekv is an in-process stand-in for a Couchbase data service node.
+// It speaks enough of the memcached binary protocol for the memcached client
+// and DCP feeds in dcp/transport/client to run against it: SASL PLAIN auth,
+// bucket selection, get/set/delete with xattrs, and DCP open, control, stream
+// requests, failover logs and seq nos along with buffer ack based flow control.
+//
+// Rollbacks, failovers and stream ends can be scripted, which allows DCP
+// consumers to be exercised without a cluster.
+package fakekv
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Flags carried by DCP_STREAMEND
+const (
+	StreamEndOK           = uint32(0x00)
+	StreamEndClosed       = uint32(0x01)
+	StreamEndStateChanged = uint32(0x02)
+	StreamEndDisconnected = uint32(0x03)
+	StreamEndTooSlow      = uint32(0x04)
+)
+
+const defaultNumVbuckets = 1024
+
+var casCounter uint64
+
+func newCas() uint64 {
+	return uint64(time.Now().UnixNano()) + atomic.AddUint64(&casCounter, 1)
+}
+
+// Config for fake KV server
+type Config struct {
+	// Address to listen on, defaults to an ephemeral port on loopback
+	Addr        string
+	Bucket      string
+	NumVbuckets int
+
+	// SASL PLAIN credentials, any credentials are accepted when empty
+	Users map[string]string
+}
+
+// Server is a fake KV node holding a single bucket
+type Server struct {
+	config   Config
+	listener net.Listener
+	vbuckets []*vbucket
+
+	conns   map[*conn]struct{} // Access controlled by connsMu
+	connsMu sync.Mutex
+
+	closed uint32
+	wg     sync.WaitGroup
+}
+
+// NewServer starts a fake KV server with empty vbuckets
+func NewServer(config Config) (*Server, error) {
+	logPrefix := "FakeKV::NewServer"
+
+	if config.Addr == "" {
+		config.Addr = "127.0.0.1:0"
+	}
+
+	if config.NumVbuckets <= 0 {
+		config.NumVbuckets = defaultNumVbuckets
+	}
+
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		config:   config,
+		listener: listener,
+		vbuckets: make([]*vbucket, config.NumVbuckets),
+		conns:    make(map[*conn]struct{}),
+	}
+
+	for i := range s.vbuckets {
+		s.vbuckets[i] = newVbucket(uint16(i), uint64(rand.Int63()))
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	logging.Infof("%s Listening on: %s bucket: %s vbuckets: %d",
+		logPrefix, listener.Addr(), config.Bucket, config.NumVbuckets)
+
+	return s, nil
+}
+
+// Addr returns the address server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the listener and drops all client connections
+func (s *Server) Close() error {
+	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		return fmt.Errorf("fake kv server already closed")
+	}
+
+	err := s.listener.Close()
+
+	s.connsMu.Lock()
+	for c := range s.conns {
+		c.close()
+	}
+	s.connsMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	logPrefix := "FakeKV::acceptLoop"
+	defer s.wg.Done()
+
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			if atomic.LoadUint32(&s.closed) == 0 {
+				logging.Errorf("%s Accept failed, err: %v", logPrefix, err)
+			}
+			return
+		}
+
+		c := newConn(s, netConn)
+
+		s.connsMu.Lock()
+		s.conns[c] = struct{}{}
+		s.connsMu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+
+			s.connsMu.Lock()
+			delete(s.conns, c)
+			s.connsMu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) vbucket(vb uint16) (*vbucket, bool) {
+	if int(vb) >= len(s.vbuckets) {
+		return nil, false
+	}
+	return s.vbuckets[vb], true
+}
+
+func (s *Server) authenticate(user, password string) bool {
+	if len(s.config.Users) == 0 {
+		return true
+	}
+	expected, ok := s.config.Users[user]
+	return ok && expected == password
+}
+
+func (s *Server) forEachStream(vb uint16, fn func(*dcpStream)) {
+	s.connsMu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsMu.Unlock()
+
+	for _, c := range conns {
+		if st := c.stream(vb); st != nil {
+			fn(st)
+		}
+	}
+}
+
+// Set stores a document, notifying DCP streams of the mutation
+func (s *Server) Set(vb uint16, key string, value []byte, xattrs map[string][]byte) (*Item, error) {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return nil, fmt.Errorf("invalid vbucket: %d", vb)
+	}
+
+	datatype := DatatypeJSON
+	if len(xattrs) > 0 {
+		datatype |= DatatypeXattrs
+	}
+
+	item, status := v.apply(&Item{
+		Key:      key,
+		Value:    value,
+		Xattrs:   xattrs,
+		Datatype: datatype,
+	}, nil)
+	if status != mcd.SUCCESS {
+		return nil, fmt.Errorf("set failed, status: %v", status)
+	}
+	return item, nil
+}
+
+// Delete removes a document, notifying DCP streams of the deletion
+func (s *Server) Delete(vb uint16, key string) (*Item, error) {
+	return s.remove(vb, key, false)
+}
+
+// Expire removes a document as if its TTL elapsed, notifying DCP streams
+// of the expiration
+func (s *Server) Expire(vb uint16, key string) (*Item, error) {
+	return s.remove(vb, key, true)
+}
+
+func (s *Server) remove(vb uint16, key string, expired bool) (*Item, error) {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return nil, fmt.Errorf("invalid vbucket: %d", vb)
+	}
+
+	item, status := v.apply(&Item{Key: key, Deleted: true, Expired: expired}, nil)
+	if status != mcd.SUCCESS {
+		return nil, fmt.Errorf("delete failed, status: %v", status)
+	}
+	return item, nil
+}
+
+// Get returns a live document along with its xattrs
+func (s *Server) Get(vb uint16, key string) (*Item, bool) {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return nil, false
+	}
+	return v.get(key)
+}
+
+// SeqNo returns high seq no of the vbucket
+func (s *Server) SeqNo(vb uint16) uint64 {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return 0
+	}
+	return v.highSeqNo()
+}
+
+// FailoverLog returns failover log of the vbucket, newest entry first
+func (s *Server) FailoverLog(vb uint16) [][2]uint64 {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return nil
+	}
+
+	v.RLock()
+	defer v.RUnlock()
+	return append([][2]uint64(nil), v.failoverLog...)
+}
+
+// ScriptRollback makes the next stream request for the vbucket fail with
+// a rollback to seqNo, irrespective of the requested position
+func (s *Server) ScriptRollback(vb uint16, seqNo uint64) {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return
+	}
+
+	v.Lock()
+	v.scriptedRollback = &seqNo
+	v.Unlock()
+}
+
+// EndStreams ends all open DCP streams of the vbucket with the supplied flag
+func (s *Server) EndStreams(vb uint16, flag uint32) {
+	s.forEachStream(vb, func(st *dcpStream) {
+		st.end(flag)
+	})
+}
+
+// Failover simulates a vbucket failover: a new branch is added to the failover
+// log after truncating history beyond rollbackTo, and open streams are ended
+// with StreamEndStateChanged
+func (s *Server) Failover(vb uint16, rollbackTo uint64) uint64 {
+	v, ok := s.vbucket(vb)
+	if !ok {
+		return 0
+	}
+
+	v.Lock()
+	if rollbackTo < v.seqNo {
+		kept := v.history[:0]
+		items := make(map[string]*Item)
+		for _, item := range v.history {
+			if item.SeqNo <= rollbackTo {
+				kept = append(kept, item)
+				items[item.Key] = item
+			}
+		}
+		v.history = kept
+		v.items = items
+		v.seqNo = rollbackTo
+	}
+
+	vbuuid := uint64(rand.Int63())
+	v.failoverLog = append([][2]uint64{{vbuuid, v.seqNo}}, v.failoverLog...)
+	v.Unlock()
+
+	s.EndStreams(vb, StreamEndStateChanged)
+	return vbuuid
+}
@@ -0,0 +1,268 @@
+package fakekv
+
+import (
+	"testing"
+	"time"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/dcp/transport/client"
+)
+
+const testBucket = "default"
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(Config{
+		Bucket:      testBucket,
+		NumVbuckets: 4,
+		Users:       map[string]string{"eventing": "asdasd"},
+	})
+	if err != nil {
+		t.Fatalf("failed to start fake KV, err: %v", err)
+	}
+	return s
+}
+
+func openFeed(t *testing.T, s *Server, flags uint32) (*memcached.DcpFeed, chan *memcached.DcpEvent) {
+	mc, err := memcached.Connect("tcp", s.Addr())
+	if err != nil {
+		t.Fatalf("failed to connect, err: %v", err)
+	}
+	if _, err = mc.Auth("eventing", "asdasd"); err != nil {
+		t.Fatalf("failed to auth, err: %v", err)
+	}
+	if _, err = mc.SelectBucket(testBucket); err != nil {
+		t.Fatalf("failed to select bucket, err: %v", err)
+	}
+
+	config := map[string]interface{}{
+		"genChanSize":  10,
+		"dataChanSize": 10,
+	}
+	outch := make(chan *memcached.DcpEvent, 100)
+	feed, err := memcached.NewDcpFeed(mc, "test_feed", outch, 0xABBA, config)
+	if err != nil {
+		t.Fatalf("failed to create feed, err: %v", err)
+	}
+	if err = feed.DcpOpen("test_feed", 0, flags, 1024*1024, 0xABBA); err != nil {
+		t.Fatalf("failed to open feed, err: %v", err)
+	}
+	return feed, outch
+}
+
+func nextEvent(t *testing.T, outch chan *memcached.DcpEvent) *memcached.DcpEvent {
+	select {
+	case event := <-outch:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for DCP event")
+	}
+	return nil
+}
+
+// nextOf skips snapshot markers
+func nextOf(t *testing.T, outch chan *memcached.DcpEvent, opcode mcd.CommandCode) *memcached.DcpEvent {
+	for {
+		event := nextEvent(t, outch)
+		if event.Opcode == mcd.DCP_SNAPSHOT && opcode != mcd.DCP_SNAPSHOT {
+			continue
+		}
+		if event.Opcode != opcode {
+			t.Fatalf("expected %v, got %v", opcode, event.Opcode)
+		}
+		return event
+	}
+}
+
+func TestDcpFeedStreamsChanges(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	if _, err := s.Set(1, "doc1", []byte(`{"a":1}`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set(1, "doc2", []byte(`{"b":2}`), map[string][]byte{"_eventing": []byte(`{"x":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, outch := openFeed(t, s, 0x04)
+	defer feed.Close()
+
+	vbuuid := s.FailoverLog(1)[0][0]
+	if err := feed.DcpRequestStream(1, 1, 0, vbuuid, 0, 0xFFFFFFFFFFFFFFFF, 0, 0); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+
+	event := nextOf(t, outch, mcd.DCP_STREAMREQ)
+	if event.Status != mcd.SUCCESS || event.FailoverLog == nil {
+		t.Fatalf("unexpected stream request response: %v", event)
+	}
+
+	event = nextOf(t, outch, mcd.DCP_MUTATION)
+	if string(event.Key) != "doc1" || string(event.Value) != `{"a":1}` || event.Seqno != 1 {
+		t.Errorf("unexpected first mutation: %v", event)
+	}
+	if event.Datatype != DatatypeJSON {
+		t.Errorf("expected JSON datatype, got %x", event.Datatype)
+	}
+
+	event = nextOf(t, outch, mcd.DCP_MUTATION)
+	if string(event.Key) != "doc2" || event.Datatype&DatatypeXattrs == 0 {
+		t.Errorf("expected doc2 with xattrs, got %v datatype: %x", event, event.Datatype)
+	}
+	xattrs, value, err := decodeXattrs(event.Value)
+	if err != nil || string(xattrs["_eventing"]) != `{"x":1}` || string(value) != `{"b":2}` {
+		t.Errorf("unexpected xattrs: %v value: %s err: %v", xattrs, value, err)
+	}
+
+	// Changes made after stream is open are delivered as they happen
+	if _, err = s.Delete(1, "doc1"); err != nil {
+		t.Fatal(err)
+	}
+	event = nextOf(t, outch, mcd.DCP_DELETION)
+	if string(event.Key) != "doc1" || event.Seqno != 3 {
+		t.Errorf("unexpected deletion: %v", event)
+	}
+
+	if err = feed.CloseStream(1, 1); err != nil {
+		t.Fatalf("close stream failed, err: %v", err)
+	}
+	nextOf(t, outch, mcd.DCP_STREAMEND)
+}
+
+func TestDcpFeedResumesStream(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	for _, key := range []string{"doc1", "doc2", "doc3"} {
+		if _, err := s.Set(2, key, []byte(`{}`), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed, outch := openFeed(t, s, 0)
+	defer feed.Close()
+
+	vbuuid := s.FailoverLog(2)[0][0]
+	if err := feed.DcpRequestStream(2, 2, 0, vbuuid, 2, 0xFFFFFFFFFFFFFFFF, 2, 2); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+
+	nextOf(t, outch, mcd.DCP_STREAMREQ)
+	event := nextOf(t, outch, mcd.DCP_MUTATION)
+	if string(event.Key) != "doc3" || event.Seqno != 3 {
+		t.Errorf("expected stream to resume at doc3, got %v", event)
+	}
+}
+
+func TestDcpFeedRollback(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	for _, key := range []string{"doc1", "doc2"} {
+		if _, err := s.Set(0, key, []byte(`{}`), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed, outch := openFeed(t, s, 0)
+	defer feed.Close()
+
+	// Unknown branch rolls back to 0
+	if err := feed.DcpRequestStream(0, 0, 0, 12345, 2, 0xFFFFFFFFFFFFFFFF, 2, 2); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+	event := nextOf(t, outch, mcd.DCP_STREAMREQ)
+	if event.Status != mcd.ROLLBACK || event.Seqno != 0 {
+		t.Errorf("expected rollback to 0, got status: %v seqno: %d", event.Status, event.Seqno)
+	}
+
+	// Scripted rollback overrides a valid request
+	s.ScriptRollback(0, 1)
+	vbuuid := s.FailoverLog(0)[0][0]
+	if err := feed.DcpRequestStream(0, 0, 0, vbuuid, 2, 0xFFFFFFFFFFFFFFFF, 2, 2); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+	event = nextOf(t, outch, mcd.DCP_STREAMREQ)
+	if event.Status != mcd.ROLLBACK || event.Seqno != 1 {
+		t.Errorf("expected rollback to 1, got status: %v seqno: %d", event.Status, event.Seqno)
+	}
+}
+
+func TestDcpFeedFailover(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	for _, key := range []string{"doc1", "doc2", "doc3"} {
+		if _, err := s.Set(3, key, []byte(`{}`), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed, outch := openFeed(t, s, 0)
+	defer feed.Close()
+
+	oldVbuuid := s.FailoverLog(3)[0][0]
+	if err := feed.DcpRequestStream(3, 3, 0, oldVbuuid, 0, 0xFFFFFFFFFFFFFFFF, 0, 0); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+	nextOf(t, outch, mcd.DCP_STREAMREQ)
+	for i := 0; i < 3; i++ {
+		nextOf(t, outch, mcd.DCP_MUTATION)
+	}
+
+	newVbuuid := s.Failover(3, 1)
+	event := nextOf(t, outch, mcd.DCP_STREAMEND)
+	if event.VBucket != 3 {
+		t.Errorf("unexpected stream end: %v", event)
+	}
+
+	// Resuming on old branch past the failover point must rollback
+	if err := feed.DcpRequestStream(3, 3, 0, oldVbuuid, 3, 0xFFFFFFFFFFFFFFFF, 3, 3); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+	event = nextOf(t, outch, mcd.DCP_STREAMREQ)
+	if event.Status != mcd.ROLLBACK || event.Seqno != 1 {
+		t.Errorf("expected rollback to 1, got status: %v seqno: %d", event.Status, event.Seqno)
+	}
+
+	if err := feed.DcpRequestStream(3, 3, 0, newVbuuid, 1, 0xFFFFFFFFFFFFFFFF, 1, 1); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+	event = nextOf(t, outch, mcd.DCP_STREAMREQ)
+	if event.Status != mcd.SUCCESS {
+		t.Errorf("expected stream on new branch, got status: %v", event.Status)
+	}
+}
+
+func TestDcpFeedFlowControl(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	// Far more than the connection buffer, so KV has to wait on buffer acks
+	value := make([]byte, 64*1024)
+	for i := range value {
+		value[i] = 'x'
+	}
+	const numDocs = 64
+	for i := 0; i < numDocs; i++ {
+		if _, err := s.Set(0, string(rune('a'+i%26))+string(rune('0'+i/26)), value, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed, outch := openFeed(t, s, 0)
+	defer feed.Close()
+
+	vbuuid := s.FailoverLog(0)[0][0]
+	if err := feed.DcpRequestStream(0, 0, 0, vbuuid, 0, 0xFFFFFFFFFFFFFFFF, 0, 0); err != nil {
+		t.Fatalf("stream request failed, err: %v", err)
+	}
+	nextOf(t, outch, mcd.DCP_STREAMREQ)
+
+	for i := 0; i < numDocs; i++ {
+		event := nextOf(t, outch, mcd.DCP_MUTATION)
+		if event.Seqno != uint64(i+1) {
+			t.Fatalf("expected seqno %d, got %d", i+1, event.Seqno)
+		}
+	}
+}
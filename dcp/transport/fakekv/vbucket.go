@@ -0,0 +1,289 @@
+package fakekv
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+)
+
+// Datatype bits as per binary protocol
+const (
+	DatatypeJSON   = uint8(0x01)
+	DatatypeXattrs = uint8(0x04)
+)
+
+var errInvalidXattrs = errors.New("invalid xattr encoding")
+
+// Item is a document as stored in a vbucket, along with its DCP metadata
+type Item struct {
+	Key      string
+	Value    []byte
+	Xattrs   map[string][]byte
+	Datatype uint8
+	Flags    uint32
+	Expiry   uint32
+	Cas      uint64
+	SeqNo    uint64
+	RevSeqNo uint64
+	Deleted  bool
+	Expired  bool
+}
+
+func (item *Item) clone() *Item {
+	c := *item
+	if item.Xattrs != nil {
+		c.Xattrs = make(map[string][]byte, len(item.Xattrs))
+		for k, v := range item.Xattrs {
+			c.Xattrs[k] = v
+		}
+	}
+	return &c
+}
+
+func (item *Item) opcode() mcd.CommandCode {
+	switch {
+	case item.Expired:
+		return mcd.DCP_EXPIRATION
+	case item.Deleted:
+		return mcd.DCP_DELETION
+	default:
+		return mcd.DCP_MUTATION
+	}
+}
+
+type vbucket struct {
+	id          uint16
+	seqNo       uint64
+	failoverLog [][2]uint64 // Newest entry first
+	items       map[string]*Item
+	history     []*Item // Every change in seq no order, replayed to DCP streams
+
+	// Closed and replaced whenever history grows, to wake up DCP streams
+	changed chan struct{}
+
+	// Seq no to which the next stream request is asked to rollback
+	scriptedRollback *uint64
+
+	sync.RWMutex
+}
+
+func newVbucket(id uint16, vbuuid uint64) *vbucket {
+	return &vbucket{
+		id:          id,
+		failoverLog: [][2]uint64{{vbuuid, 0}},
+		items:       make(map[string]*Item),
+		changed:     make(chan struct{}),
+	}
+}
+
+func (vb *vbucket) vbuuid() uint64 {
+	vb.RLock()
+	defer vb.RUnlock()
+	return vb.failoverLog[0][0]
+}
+
+// precondition vets a change against the existing document, if any
+type precondition func(existing *Item, live bool) mcd.Status
+
+func casMatches(cas uint64) precondition {
+	return func(existing *Item, live bool) mcd.Status {
+		switch {
+		case cas == 0:
+			return mcd.SUCCESS
+		case !live:
+			return mcd.KEY_ENOENT
+		case existing.Cas != cas:
+			return mcd.KEY_EEXISTS
+		}
+		return mcd.SUCCESS
+	}
+}
+
+// apply stamps the change with next seq no and cas, and records it
+func (vb *vbucket) apply(change *Item, check precondition) (*Item, mcd.Status) {
+	vb.Lock()
+	defer vb.Unlock()
+
+	existing, ok := vb.items[change.Key]
+	live := ok && !existing.Deleted
+
+	if check != nil {
+		if status := check(existing, live); status != mcd.SUCCESS {
+			return nil, status
+		}
+	}
+
+	if change.Deleted && !live {
+		return nil, mcd.KEY_ENOENT
+	}
+
+	vb.seqNo++
+	change.SeqNo = vb.seqNo
+	change.Cas = newCas()
+	if ok {
+		change.RevSeqNo = existing.RevSeqNo + 1
+	} else {
+		change.RevSeqNo = 1
+	}
+
+	stored := change.clone()
+	vb.items[change.Key] = stored
+	vb.history = append(vb.history, stored)
+
+	close(vb.changed)
+	vb.changed = make(chan struct{})
+
+	return stored.clone(), mcd.SUCCESS
+}
+
+func (vb *vbucket) get(key string) (*Item, bool) {
+	vb.RLock()
+	defer vb.RUnlock()
+
+	item, ok := vb.items[key]
+	if !ok || item.Deleted {
+		return nil, false
+	}
+	return item.clone(), true
+}
+
+// changesSince returns changes with seq no in (start, end] along with a
+// channel that's closed once further changes arrive
+func (vb *vbucket) changesSince(start, end uint64) ([]*Item, <-chan struct{}) {
+	vb.RLock()
+	defer vb.RUnlock()
+
+	idx := sort.Search(len(vb.history), func(i int) bool {
+		return vb.history[i].SeqNo > start
+	})
+
+	var changes []*Item
+	for _, item := range vb.history[idx:] {
+		if item.SeqNo > end {
+			break
+		}
+		changes = append(changes, item)
+	}
+	return changes, vb.changed
+}
+
+// streamRequestStatus decides whether a stream request may proceed, returning
+// the seq no to rollback to otherwise
+func (vb *vbucket) streamRequestStatus(vbuuid, start uint64) (mcd.Status, uint64) {
+	vb.Lock()
+	defer vb.Unlock()
+
+	if vb.scriptedRollback != nil {
+		rollbackSeqNo := *vb.scriptedRollback
+		vb.scriptedRollback = nil
+		return mcd.ROLLBACK, rollbackSeqNo
+	}
+
+	if start == 0 {
+		return mcd.SUCCESS, 0
+	}
+
+	if start > vb.seqNo {
+		return mcd.ROLLBACK, vb.seqNo
+	}
+
+	// Requested branch must be known, and the start must not be beyond
+	// the point at which the branch was superseded
+	upper := vb.seqNo
+	for _, entry := range vb.failoverLog {
+		if entry[0] == vbuuid {
+			if start > upper {
+				return mcd.ROLLBACK, upper
+			}
+			return mcd.SUCCESS, 0
+		}
+		upper = entry[1]
+	}
+	return mcd.ROLLBACK, 0
+}
+
+func (vb *vbucket) failoverLogBytes() []byte {
+	vb.RLock()
+	defer vb.RUnlock()
+
+	body := make([]byte, 16*len(vb.failoverLog))
+	for i, entry := range vb.failoverLog {
+		binary.BigEndian.PutUint64(body[i*16:], entry[0])
+		binary.BigEndian.PutUint64(body[i*16+8:], entry[1])
+	}
+	return body
+}
+
+func (vb *vbucket) highSeqNo() uint64 {
+	vb.RLock()
+	defer vb.RUnlock()
+	return vb.seqNo
+}
+
+// encodeXattrs prefixes the document with xattrs in the wire format of KV,
+// i.e. total length followed by length prefixed "key\x00value\x00" pairs
+func encodeXattrs(xattrs map[string][]byte, value []byte) []byte {
+	keys := make([]string, 0, len(xattrs))
+	for k := range xattrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	blob := make([]byte, 4)
+	for _, k := range keys {
+		pair := make([]byte, 4, 4+len(k)+len(xattrs[k])+2)
+		pair = append(pair, k...)
+		pair = append(pair, 0)
+		pair = append(pair, xattrs[k]...)
+		pair = append(pair, 0)
+		binary.BigEndian.PutUint32(pair, uint32(len(pair)-4))
+		blob = append(blob, pair...)
+	}
+	binary.BigEndian.PutUint32(blob, uint32(len(blob)-4))
+
+	return append(blob, value...)
+}
+
+func decodeXattrs(body []byte) (map[string][]byte, []byte, error) {
+	if len(body) < 4 {
+		return nil, nil, errInvalidXattrs
+	}
+
+	total := int(binary.BigEndian.Uint32(body))
+	if total > len(body)-4 {
+		return nil, nil, errInvalidXattrs
+	}
+
+	xattrs := make(map[string][]byte)
+	blob := body[4 : 4+total]
+	for len(blob) > 0 {
+		if len(blob) < 4 {
+			return nil, nil, errInvalidXattrs
+		}
+
+		size := int(binary.BigEndian.Uint32(blob))
+		if size > len(blob)-4 || size < 2 {
+			return nil, nil, errInvalidXattrs
+		}
+
+		pair := blob[4 : 4+size]
+		sep := -1
+		for i, b := range pair {
+			if b == 0 {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || pair[size-1] != 0 || sep == size-1 {
+			return nil, nil, errInvalidXattrs
+		}
+
+		xattrs[string(pair[:sep])] = append([]byte(nil), pair[sep+1:size-1]...)
+		blob = blob[4+size:]
+	}
+
+	return xattrs, body[4+total:], nil
+}
@@ -49,7 +49,8 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 	// 4
 	data[pos] = byte(len(req.Extras))
 	pos++
-	// data[pos] = 0
+	// Zero on requests sent by clients, set on DCP changes sent by fakekv
+	data[pos] = req.Datatype
 	pos++
 	binary.BigEndian.PutUint16(data[pos:pos+2], req.VBucket)
 	pos += 2
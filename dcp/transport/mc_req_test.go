@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	req := &MCRequest{
+		Opcode:   DCP_MUTATION,
+		Datatype: 0x05,
+		VBucket:  42,
+		Opaque:   0xABBA0042,
+		Cas:      938424885,
+		Extras:   []byte{1, 2, 3, 4},
+		Key:      []byte("doc"),
+		Body:     bytes.Repeat([]byte("x"), 200),
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := req.Transmit(buf); err != nil {
+		t.Fatalf("transmit failed, err: %v", err)
+	}
+
+	got := &MCRequest{}
+	if _, err := got.Receive(buf, nil); err != nil {
+		t.Fatalf("receive failed, err: %v", err)
+	}
+
+	if got.Opcode != req.Opcode || got.Datatype != req.Datatype || got.VBucket != req.VBucket ||
+		got.Opaque != req.Opaque || got.Cas != req.Cas {
+		t.Errorf("header mismatch, sent: %v received: %v datatype: %x", req, got, got.Datatype)
+	}
+	if !bytes.Equal(got.Extras, req.Extras) || !bytes.Equal(got.Key, req.Key) || !bytes.Equal(got.Body, req.Body) {
+		t.Errorf("payload mismatch, sent: %v received: %v", req, got)
+	}
+}
@@ -180,6 +180,10 @@ func (b *Bucket) StartDcpFeed(
 //      "genChanSize", buffer channel size for control path.
 //      "dataChanSize", buffer channel size for data path.
 //      "numConnections", number of connections with DCP for local vbuckets.
+//      "connectionBufferSize", DCP flow control buffer size per connection.
+//      "bufferAckThreshold", fraction of buffer consumed before acking.
+//      "ackThrottleFn", optional callback reporting consumer fill level.
+//      "ackThrottleWatermark", fill level beyond which acks are withheld.
 func (b *Bucket) StartDcpFeedOver(
 	name DcpFeedName,
 	sequence, flags uint32,
@@ -221,6 +225,7 @@ const (
 	ufCmdCloseStream
 	ufCmdGetSeqnos
 	ufCmdClose
+	ufCmdFlowControlStats
 )
 
 // DcpRequestStream starts a stream for a vb on a feed
@@ -271,6 +276,18 @@ func (feed *DcpFeed) DcpGetSeqnos() (map[uint16]uint64, error) {
 	return resp[0].(map[uint16]uint64), nil
 }
 
+// FlowControlStats returns flow control stats of all underlying
+// connections, keyed by connection name. Synchronous call.
+func (feed *DcpFeed) FlowControlStats() (map[string]map[string]uint64, error) {
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{ufCmdFlowControlStats, respch}
+	resp, err := failsafeOp(feed.reqch, respch, cmd, feed.finch)
+	if err = opError(err, resp, 1); err != nil {
+		return nil, err
+	}
+	return resp[0].(map[string]map[string]uint64), nil
+}
+
 // Close DcpFeed. Synchronous call.
 func (feed *DcpFeed) Close() error {
 	respch := make(chan []interface{}, 1)
@@ -329,6 +346,10 @@ loop:
 				seqnos, err := feed.dcpGetSeqnos()
 				respch <- []interface{}{seqnos, err}
 
+			case ufCmdFlowControlStats:
+				respch := msg[1].(chan []interface{})
+				respch <- []interface{}{feed.flowControlStats(), nil}
+
 			case ufCmdClose:
 				closeNodeFeeds()
 				respch := msg[1].(chan []interface{})
@@ -552,6 +573,19 @@ func (feed *DcpFeed) dcpGetSeqnos() (map[uint16]uint64, error) {
 	return seqnos, nil
 }
 
+func (feed *DcpFeed) flowControlStats() map[string]map[string]uint64 {
+	stats := make(map[string]map[string]uint64)
+	for _, nodeFeeds := range feed.nodeFeeds {
+		for _, singleFeed := range nodeFeeds {
+			if singleFeed == nil {
+				continue
+			}
+			stats[singleFeed.dcpFeed.Name()] = singleFeed.dcpFeed.FlowControlStats()
+		}
+	}
+	return stats
+}
+
 func addtofeed(nodeFeeds []*FeedInfo) (*FeedInfo, bool) {
 	if len(nodeFeeds) == 0 {
 		return nil, false
@@ -0,0 +1,57 @@
+package eventsource
+
+import (
+	"errors"
+
+	"github.com/couchbase/eventing/dcp/transport/client"
+)
+
+// Errors returned by event sources
+var (
+	ErrSourceClosed     = errors.New("event source closed")
+	ErrStreamNotFound   = errors.New("no stream for vbucket")
+	ErrStreamExists     = errors.New("stream already exists for vbucket")
+	ErrInvalidRecord    = errors.New("invalid event record")
+	ErrUnknownOperation = errors.New("unknown event operation")
+)
+
+// Position identifies a point within a vbucket's change stream, from which
+// a stream can be started or resumed
+type Position struct {
+	VBuuid    uint64 `json:"vb_uuid"`
+	SeqNo     uint64 `json:"seq_no"`
+	SnapStart uint64 `json:"snap_start"`
+	SnapEnd   uint64 `json:"snap_end"`
+}
+
+// EventSource abstracts a partitioned change stream that feeds the consumer.
+// Events are delivered as DCP events so that the consumer's worker, checkpoint
+// and stats machinery can be reused irrespective of where changes come from.
+//
+// Starting a stream must eventually deliver a DCP_STREAMREQ event for the
+// vbucket on Events(), carrying the status and failover log. Closing a stream
+// must eventually deliver a DCP_STREAMEND event.
+type EventSource interface {
+	// Name of the source, used for logging
+	Name() string
+
+	// Events is the channel on which events for all streams are delivered.
+	// It's closed once the source is closed.
+	Events() <-chan *memcached.DcpEvent
+
+	// StartStream requests events of a vbucket from the supplied position onwards
+	StartStream(vb uint16, pos Position) error
+
+	// CloseStream stops delivery of events for a vbucket
+	CloseStream(vb uint16) error
+
+	// Ack marks events of a vbucket till seqNo as processed
+	Ack(vb uint16, seqNo uint64)
+
+	// CheckpointToken returns the position from which the vbucket stream
+	// should be resumed, based on events acked so far
+	CheckpointToken(vb uint16) (Position, error)
+
+	// Close stops all streams and releases resources held by the source
+	Close() error
+}
@@ -0,0 +1,353 @@
+package eventsource
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/dcp/transport/client"
+	"github.com/couchbase/eventing/logging"
+)
+
+const (
+	dcpDatatypeJSON = uint8(1)
+
+	defaultFileSourceChanSize = 10000
+
+	// Maximum size of a single line within the replay file
+	maxFileRecordSize = 20 * 1024 * 1024
+)
+
+// FileRecord is a single line of a JSONL replay file
+type FileRecord struct {
+	Op       string          `json:"op"`
+	VB       uint16          `json:"vb"`
+	SeqNo    uint64          `json:"seq"`
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Cas      uint64          `json:"cas"`
+	Flags    uint32          `json:"flags"`
+	Expiry   uint32          `json:"expiry"`
+	Datatype *uint8          `json:"datatype,omitempty"`
+}
+
+type fileStream struct {
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// FileSource replays mutations recorded in a JSONL file, one FileRecord
+// per line. Records of a vbucket are replayed in seq no order and a stream
+// stays open once it catches up, mirroring a DCP stream with no new
+// mutations. Intended for testing handlers and reproducing issues offline.
+type FileSource struct {
+	path   string
+	vbuuid uint64
+
+	records map[uint16][]*FileRecord
+	eventCh chan *memcached.DcpEvent
+
+	closeCh chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+
+	positions map[uint16]Position    // Access controlled by RWMutex
+	streams   map[uint16]*fileStream // Access controlled by RWMutex
+	sync.RWMutex
+}
+
+// NewFileSource loads the replay file at path
+func NewFileSource(path string) (*FileSource, error) {
+	logPrefix := "FileSource::NewFileSource"
+
+	records, err := loadFileRecords(path)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for _, vbRecords := range records {
+		count += len(vbRecords)
+	}
+	logging.Infof("%s Loaded %d records across %d vbuckets from: %s",
+		logPrefix, count, len(records), path)
+
+	return &FileSource{
+		path:      path,
+		vbuuid:    uint64(crc32.ChecksumIEEE([]byte(path))),
+		records:   records,
+		eventCh:   make(chan *memcached.DcpEvent, defaultFileSourceChanSize),
+		closeCh:   make(chan struct{}),
+		positions: make(map[uint16]Position),
+		streams:   make(map[uint16]*fileStream),
+	}, nil
+}
+
+func loadFileRecords(path string) (map[uint16][]*FileRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make(map[uint16][]*FileRecord)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxFileRecordSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+
+		record := &FileRecord{}
+		err = json.Unmarshal(data, record)
+		if err != nil {
+			return nil, fmt.Errorf("%v, line: %d err: %v", ErrInvalidRecord, line, err)
+		}
+
+		if _, err = record.opcode(); err != nil {
+			return nil, fmt.Errorf("%v, line: %d op: %s", err, line, record.Op)
+		}
+
+		if record.Key == "" {
+			return nil, fmt.Errorf("%v, line: %d missing key", ErrInvalidRecord, line)
+		}
+
+		records[record.VB] = append(records[record.VB], record)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for vb, vbRecords := range records {
+		sort.SliceStable(vbRecords, func(i, j int) bool {
+			return vbRecords[i].SeqNo < vbRecords[j].SeqNo
+		})
+
+		for i := 1; i < len(vbRecords); i++ {
+			if vbRecords[i].SeqNo == vbRecords[i-1].SeqNo {
+				return nil, fmt.Errorf("%v, vb: %d duplicate seq: %d",
+					ErrInvalidRecord, vb, vbRecords[i].SeqNo)
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func (r *FileRecord) opcode() (mcd.CommandCode, error) {
+	switch r.Op {
+	case "mutation", "":
+		return mcd.DCP_MUTATION, nil
+	case "deletion":
+		return mcd.DCP_DELETION, nil
+	case "expiration":
+		return mcd.DCP_EXPIRATION, nil
+	default:
+		return 0, ErrUnknownOperation
+	}
+}
+
+func (r *FileRecord) event(vbuuid uint64) *memcached.DcpEvent {
+	opcode, _ := r.opcode()
+
+	datatype := dcpDatatypeJSON
+	if r.Datatype != nil {
+		datatype = *r.Datatype
+	}
+
+	e := &memcached.DcpEvent{
+		Opcode:       opcode,
+		Status:       mcd.SUCCESS,
+		Datatype:     datatype,
+		VBucket:      r.VB,
+		VBuuid:       vbuuid,
+		Key:          []byte(r.Key),
+		Cas:          r.Cas,
+		Seqno:        r.SeqNo,
+		Flags:        r.Flags,
+		Expiry:       r.Expiry,
+		SnapstartSeq: r.SeqNo,
+		SnapendSeq:   r.SeqNo,
+		Ctime:        time.Now().UnixNano(),
+	}
+
+	if opcode == mcd.DCP_MUTATION {
+		e.Value = []byte(r.Value)
+	}
+	return e
+}
+
+// Name returns path of the replay file
+func (s *FileSource) Name() string {
+	return fmt.Sprintf("file:%s", s.path)
+}
+
+// Events returns the channel on which replayed events are delivered
+func (s *FileSource) Events() <-chan *memcached.DcpEvent {
+	return s.eventCh
+}
+
+// StartStream replays records of the vbucket with seq no beyond pos.SeqNo
+func (s *FileSource) StartStream(vb uint16, pos Position) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return ErrSourceClosed
+	}
+
+	if _, ok := s.streams[vb]; ok {
+		return ErrStreamExists
+	}
+
+	stream := &fileStream{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	s.streams[vb] = stream
+
+	if pos.VBuuid == 0 {
+		pos.VBuuid = s.vbuuid
+	}
+	s.positions[vb] = pos
+
+	s.wg.Add(1)
+	go s.replay(vb, pos.SeqNo, stream)
+	return nil
+}
+
+func (s *FileSource) replay(vb uint16, startSeqNo uint64, stream *fileStream) {
+	defer s.wg.Done()
+	defer close(stream.doneCh)
+
+	flog := memcached.FailoverLog{{s.vbuuid, 0}}
+	streamReq := &memcached.DcpEvent{
+		Opcode:      mcd.DCP_STREAMREQ,
+		Status:      mcd.SUCCESS,
+		VBucket:     vb,
+		VBuuid:      s.vbuuid,
+		FailoverLog: &flog,
+		Ctime:       time.Now().UnixNano(),
+	}
+	if !s.send(streamReq, stream) {
+		return
+	}
+
+	for _, record := range s.records[vb] {
+		if record.SeqNo <= startSeqNo {
+			continue
+		}
+
+		if !s.send(record.event(s.vbuuid), stream) {
+			return
+		}
+	}
+
+	select {
+	case <-stream.stopCh:
+	case <-s.closeCh:
+	}
+}
+
+func (s *FileSource) send(e *memcached.DcpEvent, stream *fileStream) bool {
+	select {
+	case s.eventCh <- e:
+		return true
+	case <-stream.stopCh:
+		return false
+	case <-s.closeCh:
+		return false
+	}
+}
+
+// CloseStream stops replay of the vbucket and delivers a STREAMEND
+func (s *FileSource) CloseStream(vb uint16) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return ErrSourceClosed
+	}
+
+	stream, ok := s.streams[vb]
+	if !ok {
+		s.Unlock()
+		return ErrStreamNotFound
+	}
+	delete(s.streams, vb)
+
+	// Keeps Close from closing eventCh while STREAMEND is being delivered
+	s.wg.Add(1)
+	defer s.wg.Done()
+	s.Unlock()
+
+	close(stream.stopCh)
+	<-stream.doneCh
+
+	streamEnd := &memcached.DcpEvent{
+		Opcode:  mcd.DCP_STREAMEND,
+		Status:  mcd.SUCCESS,
+		VBucket: vb,
+		VBuuid:  s.vbuuid,
+		Ctime:   time.Now().UnixNano(),
+	}
+
+	select {
+	case s.eventCh <- streamEnd:
+		return nil
+	case <-s.closeCh:
+		return ErrSourceClosed
+	}
+}
+
+// Ack advances the checkpoint token of the vbucket
+func (s *FileSource) Ack(vb uint16, seqNo uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	pos := s.positions[vb]
+	if seqNo > pos.SeqNo {
+		pos.SeqNo, pos.SnapStart, pos.SnapEnd = seqNo, seqNo, seqNo
+		s.positions[vb] = pos
+	}
+}
+
+// CheckpointToken returns position of last acked event of the vbucket
+func (s *FileSource) CheckpointToken(vb uint16) (Position, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	pos, ok := s.positions[vb]
+	if !ok {
+		return pos, ErrStreamNotFound
+	}
+	return pos, nil
+}
+
+// Close stops replay of all vbuckets and closes the event channel
+func (s *FileSource) Close() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return ErrSourceClosed
+	}
+	s.closed = true
+	s.streams = make(map[uint16]*fileStream)
+	s.Unlock()
+
+	close(s.closeCh)
+	s.wg.Wait()
+	close(s.eventCh)
+	return nil
+}
@@ -0,0 +1,160 @@
+package eventsource
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/dcp/transport/client"
+)
+
+// writeReplayFile writes lines to a temporary file, which caller removes
+func writeReplayFile(t *testing.T, lines ...string) string {
+	f, err := ioutil.TempFile("", "file_source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString(strings.Join(lines, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func nextEvent(t *testing.T, s *FileSource) *memcached.DcpEvent {
+	select {
+	case e := <-s.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func TestFileSourceLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"valid", []string{`{"vb":1,"seq":2,"key":"a","value":{}}`, ``, `{"op":"deletion","vb":1,"seq":1,"key":"b"}`}, ""},
+		{"not json", []string{`{"vb":1`}, ErrInvalidRecord.Error()},
+		{"unknown op", []string{`{"op":"touch","vb":1,"seq":1,"key":"a"}`}, ErrUnknownOperation.Error()},
+		{"missing key", []string{`{"vb":1,"seq":1}`}, "missing key"},
+		{"duplicate seq", []string{`{"vb":1,"seq":1,"key":"a"}`, `{"vb":1,"seq":1,"key":"b"}`}, "duplicate seq"},
+	}
+
+	for _, test := range tests {
+		path := writeReplayFile(t, test.lines...)
+		_, err := NewFileSource(path)
+		os.Remove(path)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestFileSourceReplay(t *testing.T) {
+	path := writeReplayFile(t,
+		`{"vb":3,"seq":7,"key":"c","value":{"n":3}}`,
+		`{"vb":3,"seq":5,"key":"b","value":{"n":2}}`,
+		`{"vb":3,"seq":2,"key":"a","value":{"n":1}}`,
+		`{"op":"expiration","vb":3,"seq":9,"key":"b"}`,
+		`{"vb":4,"seq":1,"key":"other"}`)
+	defer os.Remove(path)
+
+	s, err := NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.StartStream(3, Position{SeqNo: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.StartStream(3, Position{}); err != ErrStreamExists {
+		t.Errorf("expected %v, got %v", ErrStreamExists, err)
+	}
+
+	e := nextEvent(t, s)
+	if e.Opcode != mcd.DCP_STREAMREQ || e.VBucket != 3 || e.FailoverLog == nil {
+		t.Fatalf("expected stream request of vb 3 first, got %+v", e)
+	}
+
+	expected := []struct {
+		opcode mcd.CommandCode
+		key    string
+		seqNo  uint64
+	}{
+		{mcd.DCP_MUTATION, "b", 5},
+		{mcd.DCP_MUTATION, "c", 7},
+		{mcd.DCP_EXPIRATION, "b", 9},
+	}
+	for _, exp := range expected {
+		e = nextEvent(t, s)
+		if e.Opcode != exp.opcode || string(e.Key) != exp.key || e.Seqno != exp.seqNo {
+			t.Errorf("expected %v of %s at %d, got %v of %s at %d",
+				exp.opcode, exp.key, exp.seqNo, e.Opcode, e.Key, e.Seqno)
+		}
+		if e.Opcode == mcd.DCP_MUTATION && e.Datatype != dcpDatatypeJSON {
+			t.Errorf("expected JSON datatype for %s, got %d", e.Key, e.Datatype)
+		}
+		if e.Opcode == mcd.DCP_EXPIRATION && e.Value != nil {
+			t.Errorf("expected no value for expiration of %s, got %s", e.Key, e.Value)
+		}
+	}
+
+	s.Ack(3, 7)
+	s.Ack(3, 5)
+	pos, err := s.CheckpointToken(3)
+	if err != nil || pos.SeqNo != 7 || pos.VBuuid != s.vbuuid {
+		t.Errorf("expected checkpoint at 7 with vbuuid %d, got %+v, err: %v", s.vbuuid, pos, err)
+	}
+	if _, err = s.CheckpointToken(4); err != ErrStreamNotFound {
+		t.Errorf("expected %v for vb without stream, got %v", ErrStreamNotFound, err)
+	}
+
+	if err = s.CloseStream(3); err != nil {
+		t.Fatal(err)
+	}
+	if e = nextEvent(t, s); e.Opcode != mcd.DCP_STREAMEND || e.VBucket != 3 {
+		t.Errorf("expected stream end of vb 3, got %+v", e)
+	}
+	if err = s.CloseStream(3); err != ErrStreamNotFound {
+		t.Errorf("expected %v, got %v", ErrStreamNotFound, err)
+	}
+}
+
+func TestFileSourceClose(t *testing.T) {
+	path := writeReplayFile(t, `{"vb":0,"seq":1,"key":"a"}`)
+	defer os.Remove(path)
+
+	s, err := NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.StartStream(0, Position{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events sent ahead of close may still be buffered
+	for range s.Events() {
+	}
+	if err = s.Close(); err != ErrSourceClosed {
+		t.Errorf("expected %v, got %v", ErrSourceClosed, err)
+	}
+	if err = s.StartStream(1, Position{}); err != ErrSourceClosed {
+		t.Errorf("expected %v, got %v", ErrSourceClosed, err)
+	}
+}
@@ -12,6 +12,7 @@
 #ifndef COUCHBASE_BLOCKING_DEQUE_H
 #define COUCHBASE_BLOCKING_DEQUE_H
 
+#include <algorithm>
 #include <condition_variable>
 #include <deque>
 #include <mutex>
@@ -30,6 +31,10 @@ public:
 
   bool PopBack(T &elem);
 
+  // Element goes to priority lane, which PopFront drains ahead of rest of the
+  // deque, unless it matches an element already queued outside of priority lane
+  template <typename Pred> void PushBackPriority(T elem, Pred matches);
+
   size_t GetMemory();
 
   size_t GetSize();
@@ -40,6 +45,7 @@ public:
 
 private:
   std::deque<T> elems_;
+  std::deque<T> priority_elems_;
   std::mutex lock_;
   std::condition_variable cond_;
   bool closed_{false};
@@ -56,12 +62,15 @@ template <typename T> void BlockingDeque<T>::PushFront(T elem) {
 
 template <typename T> bool BlockingDeque<T>::PopFront(T &elem) {
   std::unique_lock<std::mutex> lck(lock_);
-  cond_.wait(lck, [this] { return !elems_.empty() || closed_; });
-  if (elems_.empty())
+  cond_.wait(lck, [this] {
+    return !elems_.empty() || !priority_elems_.empty() || closed_;
+  });
+  auto &elems = priority_elems_.empty() ? elems_ : priority_elems_;
+  if (elems.empty())
     return false;
-  elem = std::move(elems_.front());
+  elem = std::move(elems.front());
   mem_size_ -= elem->GetSize();
-  elems_.pop_front();
+  elems.pop_front();
   return true;
 }
 
@@ -75,15 +84,32 @@ template <typename T> void BlockingDeque<T>::PushBack(T elem) {
 
 template <typename T> bool BlockingDeque<T>::PopBack(T &elem) {
   std::unique_lock<std::mutex> lck(lock_);
-  cond_.wait(lck, [this] { return !elems_.empty() || closed_; });
-  if (elems_.empty())
+  cond_.wait(lck, [this] {
+    return !elems_.empty() || !priority_elems_.empty() || closed_;
+  });
+  auto &elems = elems_.empty() ? priority_elems_ : elems_;
+  if (elems.empty())
     return false;
-  elem = std::move(elems_.back());
+  elem = std::move(elems.back());
   mem_size_ -= elem->GetSize();
-  elems_.pop_back();
+  elems.pop_back();
   return true;
 }
 
+template <typename T>
+template <typename Pred>
+void BlockingDeque<T>::PushBackPriority(T elem, Pred matches) {
+  std::unique_lock<std::mutex> lck(lock_);
+  mem_size_ += elem->GetSize();
+  if (std::any_of(elems_.begin(), elems_.end(), matches)) {
+    elems_.push_back(std::move(elem));
+  } else {
+    priority_elems_.push_back(std::move(elem));
+  }
+  lck.unlock();
+  cond_.notify_one();
+}
+
 template <typename T> size_t BlockingDeque<T>::GetMemory() {
   std::lock_guard<std::mutex> lck(lock_);
   return mem_size_;
@@ -91,12 +117,13 @@ template <typename T> size_t BlockingDeque<T>::GetMemory() {
 
 template <typename T> size_t BlockingDeque<T>::GetSize() {
   std::lock_guard<std::mutex> lck(lock_);
-  return elems_.size();
+  return elems_.size() + priority_elems_.size();
 }
 
 template <typename T> void BlockingDeque<T>::Clear() {
   std::lock_guard<std::mutex> lck(lock_);
   elems_.clear();
+  priority_elems_.clear();
 }
 
 template <typename T> void BlockingDeque<T>::Close() {
@@ -75,6 +75,9 @@ public:
 private:
   Error FormatErrorAndDestroyConn(const std::string &message,
                                   const lcb_error_t &error) const;
+  std::string QuotedIdempotencyKey();
+  static void SetIdempotencyKeySpec(lcb_SDSPEC &spec,
+                                    const std::string &quoted_key);
 
   v8::Isolate *isolate_{nullptr};
   std::string bucket_name_;
@@ -186,5 +189,6 @@ private:
 // TODO : Must be implemented by the component that wants to use Bucket
 void AddLcbException(const IsolateData *isolate_data, lcb_error_t error);
 std::string GetFunctionInstanceID(v8::Isolate *isolate);
+std::string GetIdempotencyKey(v8::Isolate *isolate);
 
 #endif
\ No newline at end of file
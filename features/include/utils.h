@@ -214,6 +214,7 @@ std::string EventingVer();
 bool IsTerminatingRetriable(bool retry);
 bool IsExecutionTerminating(v8::Isolate *isolate);
 std::string base64Encode(const std::string &data);
+std::string base64Decode(const std::string &data);
 
 void UrlEncodeFunction(const v8::FunctionCallbackInfo<v8::Value> &args);
 void UrlDecodeFunction(const v8::FunctionCallbackInfo<v8::Value> &args);
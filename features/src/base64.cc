@@ -76,3 +76,43 @@ std::string base64Encode(const std::string &blob) {
   }
   return result;
 }
+
+static uint32_t decode_char(char ch) {
+  auto pos = std::find(code, code + 64, static_cast<uint8_t>(ch));
+  if (pos == code + 64) {
+    throw std::invalid_argument("invalid character in base64 encoded data");
+  }
+  return static_cast<uint32_t>(pos - code);
+}
+
+std::string base64Decode(const std::string &data) {
+  if (data.size() % 4 != 0) {
+    throw std::invalid_argument("base64 encoded data isn't a multiple of 4");
+  }
+
+  std::string result;
+  result.reserve(data.size() / 4 * 3);
+
+  for (size_t ii = 0; ii < data.size(); ii += 4) {
+    auto last = ii + 4 == data.size();
+    auto padding = last ? std::count(data.end() - 2, data.end(), '=') : 0;
+
+    uint32_t val = (decode_char(data[ii]) << 18) |
+                   (decode_char(data[ii + 1]) << 12);
+    if (padding < 2) {
+      val |= decode_char(data[ii + 2]) << 6;
+    }
+    if (padding < 1) {
+      val |= decode_char(data[ii + 3]);
+    }
+
+    result.push_back(static_cast<char>((val >> 16) & 0xff));
+    if (padding < 2) {
+      result.push_back(static_cast<char>((val >> 8) & 0xff));
+    }
+    if (padding < 1) {
+      result.push_back(static_cast<char>(val & 0xff));
+    }
+  }
+  return result;
+}
@@ -12,6 +12,7 @@
 #include <algorithm>
 #include <memory>
 #include <mutex>
+#include <nlohmann/json.hpp>
 #include <ostream>
 #include <sstream>
 #include <string>
@@ -251,7 +252,15 @@ Bucket::SetWithXattr(const std::string &key, const std::string &value) {
   LCB_SDSPEC_SET_VALUE(&doc_spec, value.c_str(), value.length());
 
   std::vector<lcb_SDSPEC> specs = {function_id_spec, dcp_seqno_spec,
-                                   value_crc32_spec, doc_spec};
+                                   value_crc32_spec};
+  lcb_SDSPEC idempotency_key_spec = {0};
+  const auto idempotency_key = QuotedIdempotencyKey();
+  if (!idempotency_key.empty()) {
+    SetIdempotencyKeySpec(idempotency_key_spec, idempotency_key);
+    specs.push_back(idempotency_key_spec);
+  }
+  specs.push_back(doc_spec);
+
   lcb_CMDSUBDOC cmd = {0};
   LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
   cmd.specs = specs.data();
@@ -269,6 +278,26 @@ Bucket::SetWithXattr(const std::string &key, const std::string &value) {
           std::make_unique<Result>(std::move(result))};
 }
 
+// Idempotency key of the event being handled, as a JSON string so that it can
+// go into an xattr. Empty if idempotency keys aren't enabled
+std::string Bucket::QuotedIdempotencyKey() {
+  auto idempotency_key = GetIdempotencyKey(isolate_);
+  if (idempotency_key.empty()) {
+    return idempotency_key;
+  }
+  return nlohmann::json(idempotency_key).dump();
+}
+
+void Bucket::SetIdempotencyKeySpec(lcb_SDSPEC &spec,
+                                   const std::string &quoted_key) {
+  static const std::string idempotency_key_path("_eventing.idempotency_key");
+  spec.sdcmd = LCB_SDCMD_DICT_UPSERT;
+  spec.options = LCB_SDSPEC_F_MKINTERMEDIATES | LCB_SDSPEC_F_XATTRPATH;
+  LCB_SDSPEC_SET_PATH(&spec, idempotency_key_path.c_str(),
+                      idempotency_key_path.size());
+  LCB_SDSPEC_SET_VALUE(&spec, quoted_key.c_str(), quoted_key.size());
+}
+
 std::tuple<Error, std::unique_ptr<lcb_error_t>, std::unique_ptr<Result>>
 Bucket::SetWithoutXattr(const std::string &key, const std::string &value) {
   if (!is_connected_) {
@@ -276,6 +305,36 @@ Bucket::SetWithoutXattr(const std::string &key, const std::string &value) {
             nullptr, nullptr};
   }
 
+  // Writes made by handler carry key of the event they were made for, so
+  // that replays of the event can be told apart from fresh writes
+  const auto idempotency_key = QuotedIdempotencyKey();
+  if (!idempotency_key.empty()) {
+    lcb_SDSPEC idempotency_key_spec = {0};
+    SetIdempotencyKeySpec(idempotency_key_spec, idempotency_key);
+
+    lcb_SDSPEC doc_spec = {0};
+    doc_spec.sdcmd = LCB_SDCMD_SET_FULLDOC;
+    LCB_SDSPEC_SET_PATH(&doc_spec, "", 0);
+    LCB_SDSPEC_SET_VALUE(&doc_spec, value.c_str(), value.length());
+
+    std::vector<lcb_SDSPEC> specs = {idempotency_key_spec, doc_spec};
+    lcb_CMDSUBDOC cmd = {0};
+    LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
+    cmd.specs = specs.data();
+    cmd.nspecs = specs.size();
+    cmd.cmdflags = LCB_CMDSUBDOC_F_UPSERT_DOC;
+
+    const auto max_retry = UnwrapData(isolate_)->lcb_retry_count;
+    auto [err_code, result] =
+        RetryLcbCommand(connection_, cmd, max_retry, LcbSubdocSet);
+    if (err_code != LCB_SUCCESS) {
+      ++lcb_retry_failure;
+      return {nullptr, std::make_unique<lcb_error_t>(err_code), nullptr};
+    }
+    return {nullptr, std::make_unique<lcb_error_t>(err_code),
+            std::make_unique<Result>(std::move(result))};
+  }
+
   lcb_CMDSTORE cmd = {0};
   LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
   LCB_CMD_SET_VALUE(&cmd, value.c_str(), value.length());
@@ -32,7 +32,7 @@ var esc_gt = regexp.MustCompile(
 	`([^\\])\\x3E`)
 
 var timer_use = regexp.MustCompile(
-	`createTimer([[:space:]]*)\(`)
+	`create(Recurring)?Timer([[:space:]]*)\(`)
 
 var printable_stmt = regexp.MustCompile(
 	`^[[:print:]]*$`)
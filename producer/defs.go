@@ -7,6 +7,7 @@ import (
 
 	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/suptree"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 	"gopkg.in/couchbase/gocb.v1"
 )
@@ -28,6 +29,18 @@ const (
 
 	supervisorTimeout = 60 * time.Second
 
+	// Time given to a worker to drain its queues, and to its replacement to
+	// bootstrap, when workers are restarted on request
+	workerDrainTimeout            = 5 * time.Minute
+	workerRestartBootstrapTimeout = 5 * time.Minute
+
+	// Worker autoscaler samples load every autoscaleCheckInterval and acts only
+	// after load stays beyond thresholds for autoscaleStableChecks samples
+	autoscaleCheckInterval = 10 * time.Second
+	autoscaleStableChecks  = 3
+	autoscaleCooldown      = 2 * time.Minute
+	workerScaleTimeout     = 10 * time.Minute
+
 	// KV blob suffixes to assist in choose right consumer instance
 	// for instantiating V8 Debugger instance
 	startDebuggerFlag    = "startDebugger"
@@ -53,7 +66,7 @@ type Producer struct {
 	cleanupTimers          bool
 	handleV8ConsumerMutex  *sync.Mutex // controls access to Producer.handleV8Consumer
 	isBootstrapping        bool
-	isPlannerRunning       bool
+	isPlannerRunning       bool // Access controlled by plannerRWMutex
 	isTerminateRunning     bool
 	isRebalanceOngoing     int32
 	firstRebalanceDone     bool
@@ -67,6 +80,8 @@ type Producer struct {
 	numVbuckets            int
 	isPausing              bool
 	pauseProducerCh        chan struct{}
+	restartingWorkers      uint32 // Set while workers are being drained and restarted one at a time
+	scalingWorkers         uint32 // Set while autoscaler is adding or removing a worker
 	pollBucketInterval     time.Duration
 	pollBucketStopCh       chan struct{}
 	pollBucketTicker       *time.Ticker
@@ -75,6 +90,9 @@ type Producer struct {
 	stopChClosed           bool
 	stopProducerCh         chan struct{}
 	superSup               common.EventingSuperSup
+	timerCancel            *common.TimerCancelReport // Last or ongoing cancellation of timers in a range
+	timerCancelMutex       sync.Mutex
+	timerCheck             *timers.CheckTask
 	trapEvent              bool
 	debuggerToken          string
 	uuid                   string
@@ -83,7 +101,7 @@ type Producer struct {
 	latencyStats     *util.Stats
 	curlLatencyStats *util.Stats
 
-	handlerConfig   *common.HandlerConfig
+	handlerConfig   *common.HandlerConfig // WorkerCount and CPPWorkerThrCount access controlled by workerCountRWMutex
 	processConfig   *common.ProcessConfig
 	rebalanceConfig *common.RebalanceConfig
 
@@ -166,6 +184,12 @@ type Producer struct {
 	workerVbucketMap   map[string][]uint16 // Access controlled by workerVbMapRWMutex
 	workerVbMapRWMutex *sync.RWMutex
 
+	// Worker and thread counts change at runtime when workers are autoscaled
+	workerCountRWMutex *sync.RWMutex
+
+	// Autoscaler backs off while planner reassigns vbuckets
+	plannerRWMutex *sync.RWMutex
+
 	// Supervisor of workers responsible for
 	// pipelining messages to V8
 	workerSupervisor *suptree.Supervisor
@@ -10,6 +10,7 @@ import (
 	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/gen/flatbuf/cfg"
 	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -109,6 +110,12 @@ func (p *Producer) parseDepcfg() error {
 		p.handlerConfig.LanguageCompatibility = common.LanguageCompatibility[0]
 	}
 
+	if val, ok := settings["adopt_timers"]; ok {
+		p.handlerConfig.AdoptTimers = val.(bool)
+	} else {
+		p.handlerConfig.AdoptTimers = false
+	}
+
 	if val, ok := settings["checkpoint_interval"]; ok {
 		p.handlerConfig.CheckpointInterval = int(val.(float64))
 	} else {
@@ -133,6 +140,24 @@ func (p *Producer) parseDepcfg() error {
 		p.handlerConfig.StreamBoundary = common.DcpStreamBoundary("everything")
 	}
 
+	if val, ok := settings["dcp_rollback_policy"]; ok {
+		p.handlerConfig.RollbackPolicy = val.(string)
+	} else {
+		p.handlerConfig.RollbackPolicy = "replay"
+	}
+
+	if val, ok := settings["event_source"]; ok {
+		p.handlerConfig.EventSource = val.(string)
+	} else {
+		p.handlerConfig.EventSource = "dcp"
+	}
+
+	if val, ok := settings["event_source_file"]; ok {
+		p.handlerConfig.EventSourceFile = val.(string)
+	} else {
+		p.handlerConfig.EventSourceFile = ""
+	}
+
 	if val, ok := settings["deadline_timeout"]; ok {
 		p.handlerConfig.SocketTimeout = int(val.(float64))
 	} else {
@@ -191,6 +216,60 @@ func (p *Producer) parseDepcfg() error {
 		p.handlerConfig.SocketWriteBatchSize = 100
 	}
 
+	if val, ok := settings["dcp_event_batch_size"]; ok {
+		p.handlerConfig.DcpEventBatchSize = int(val.(float64))
+	} else {
+		p.handlerConfig.DcpEventBatchSize = 16
+	}
+
+	if val, ok := settings["enriched_meta"]; ok {
+		p.handlerConfig.EnrichedMeta = val.(bool)
+	} else {
+		p.handlerConfig.EnrichedMeta = false
+	}
+
+	if val, ok := settings["idempotency_keys"]; ok {
+		p.handlerConfig.IdempotencyKeys = val.(bool)
+	} else {
+		p.handlerConfig.IdempotencyKeys = false
+	}
+
+	if val, ok := settings["ipc_capture"]; ok {
+		p.handlerConfig.IPCCapture = val.(bool)
+	} else {
+		p.handlerConfig.IPCCapture = false
+	}
+
+	if val, ok := settings["ipc_capture_max_size"]; ok {
+		p.handlerConfig.IPCCaptureMaxSize = int64(val.(float64))
+	} else {
+		p.handlerConfig.IPCCaptureMaxSize = 1024 * 1024 * 64
+	}
+
+	if val, ok := settings["ipc_capture_max_files"]; ok {
+		p.handlerConfig.IPCCaptureMaxFiles = int(val.(float64))
+	} else {
+		p.handlerConfig.IPCCaptureMaxFiles = 4
+	}
+
+	if val, ok := settings["priority_key_prefixes"]; ok {
+		p.handlerConfig.PriorityKeyPrefixes = util.ToStringArray(val)
+	} else {
+		p.handlerConfig.PriorityKeyPrefixes = nil
+	}
+
+	if val, ok := settings["priority_field"]; ok {
+		p.handlerConfig.PriorityField = val.(string)
+	} else {
+		p.handlerConfig.PriorityField = ""
+	}
+
+	if val, ok := settings["priority_field_value"]; ok {
+		p.handlerConfig.PriorityFieldValue = val.(string)
+	} else {
+		p.handlerConfig.PriorityFieldValue = ""
+	}
+
 	if val, ok := settings["tick_duration"]; ok {
 		p.handlerConfig.StatsLogInterval = int(val.(float64))
 	} else {
@@ -210,9 +289,56 @@ func (p *Producer) parseDepcfg() error {
 	}
 
 	if val, ok := settings["worker_count"]; ok {
-		p.handlerConfig.WorkerCount = int(val.(float64))
+		p.setWorkerCount(int(val.(float64)))
+	} else {
+		p.setWorkerCount(3)
+	}
+
+	if val, ok := settings["worker_autoscale"]; ok {
+		p.handlerConfig.WorkerAutoscale = val.(bool)
+	} else {
+		p.handlerConfig.WorkerAutoscale = false
+	}
+
+	if val, ok := settings["min_worker_count"]; ok {
+		p.handlerConfig.MinWorkerCount = int(val.(float64))
+	} else {
+		p.handlerConfig.MinWorkerCount = p.getWorkerCount()
+	}
+
+	if val, ok := settings["max_worker_count"]; ok {
+		p.handlerConfig.MaxWorkerCount = int(val.(float64))
 	} else {
-		p.handlerConfig.WorkerCount = 3
+		p.handlerConfig.MaxWorkerCount = p.getWorkerCount()
+	}
+
+	p.handlerConfig.MinCPPWorkerThrCount = p.handlerConfig.CPPWorkerThrCount
+	if val, ok := settings["max_cpp_worker_thread_count"]; ok {
+		p.handlerConfig.MaxCPPWorkerThrCount = int(val.(float64))
+	} else {
+		p.handlerConfig.MaxCPPWorkerThrCount = p.handlerConfig.CPPWorkerThrCount
+	}
+
+	if val, ok := settings["autoscale_event_backlog"]; ok {
+		p.handlerConfig.AutoscaleEventBacklog = uint64(val.(float64))
+	} else {
+		p.handlerConfig.AutoscaleEventBacklog = 10000
+	}
+
+	if val, ok := settings["autoscale_cpu_threshold"]; ok {
+		p.handlerConfig.AutoscaleCPUThreshold = int(val.(float64))
+	} else {
+		p.handlerConfig.AutoscaleCPUThreshold = 80
+	}
+
+	// Autoscaled functions start off with worker_count, kept within bounds
+	if p.handlerConfig.WorkerAutoscale {
+		if p.getWorkerCount() < p.handlerConfig.MinWorkerCount {
+			p.setWorkerCount(p.handlerConfig.MinWorkerCount)
+		}
+		if p.getWorkerCount() > p.handlerConfig.MaxWorkerCount {
+			p.setWorkerCount(p.handlerConfig.MaxWorkerCount)
+		}
 	}
 
 	if val, ok := settings["worker_feedback_queue_cap"]; ok {
@@ -258,6 +384,42 @@ func (p *Producer) parseDepcfg() error {
 		p.handlerConfig.TimerContextSize = 1024
 	}
 
+	if val, ok := settings["timer_context_overflow"]; ok {
+		p.handlerConfig.TimerContextOverflow = val.(string)
+	} else {
+		p.handlerConfig.TimerContextOverflow = string(timers.OverflowReject)
+	}
+
+	if val, ok := settings["timer_resolution"]; ok {
+		p.handlerConfig.TimerResolution = int64(val.(float64))
+	} else {
+		p.handlerConfig.TimerResolution = timers.Resolution
+	}
+
+	if val, ok := settings["timer_in_past_policy"]; ok {
+		p.handlerConfig.TimerInPastPolicy = val.(string)
+	} else {
+		p.handlerConfig.TimerInPastPolicy = string(timers.InPastFire)
+	}
+
+	if val, ok := settings["missed_timer_policy"]; ok {
+		p.handlerConfig.MissedTimerPolicy = val.(string)
+	} else {
+		p.handlerConfig.MissedTimerPolicy = string(timers.MissedFireAll)
+	}
+
+	if val, ok := settings["missed_timer_threshold"]; ok {
+		p.handlerConfig.MissedTimerThreshold = int64(val.(float64))
+	} else {
+		p.handlerConfig.MissedTimerThreshold = timers.MissedThreshold
+	}
+
+	if val, ok := settings["missed_timer_rate"]; ok {
+		p.handlerConfig.MissedTimerRate = int64(val.(float64))
+	} else {
+		p.handlerConfig.MissedTimerRate = timers.MissedRate
+	}
+
 	if val, ok := settings["timer_storage_routine_count"]; ok {
 		p.handlerConfig.TimerStorageRoutineCount = int(val.(float64))
 	} else {
@@ -366,6 +528,30 @@ func (p *Producer) parseDepcfg() error {
 		p.dcpConfig["numConnections"] = 1
 	}
 
+	if val, ok := settings["dcp_connection_buffer_size"]; ok {
+		p.dcpConfig["connectionBufferSize"] = int(val.(float64))
+	} else {
+		p.dcpConfig["connectionBufferSize"] = 20 * 1024 * 1024
+	}
+
+	if val, ok := settings["dcp_buffer_ack_threshold"]; ok {
+		p.dcpConfig["bufferAckThreshold"] = val.(float64)
+	} else {
+		p.dcpConfig["bufferAckThreshold"] = 0.1
+	}
+
+	if val, ok := settings["dcp_adaptive_ack"]; ok {
+		p.dcpConfig["adaptiveAck"] = val.(bool)
+	} else {
+		p.dcpConfig["adaptiveAck"] = false
+	}
+
+	if val, ok := settings["dcp_ack_throttle_watermark"]; ok {
+		p.dcpConfig["ackThrottleWatermark"] = val.(float64)
+	} else {
+		p.dcpConfig["ackThrottleWatermark"] = 0.8
+	}
+
 	p.dcpConfig["activeVbOnly"] = true
 	p.app.Settings = settings
 
@@ -379,12 +565,25 @@ func (p *Producer) parseDepcfg() error {
 	logging.SetLogLevel(util.GetLogLevel(logLevel))
 
 	logging.Infof("%s [%s] Loaded function => wc: %v bucket: %v statsTickD: %v",
-		logPrefix, p.appName, p.handlerConfig.WorkerCount, p.handlerConfig.SourceBucket, p.handlerConfig.StatsLogInterval)
+		logPrefix, p.appName, p.getWorkerCount(), p.handlerConfig.SourceBucket, p.handlerConfig.StatsLogInterval)
 
-	if p.handlerConfig.WorkerCount <= 0 {
+	if p.getWorkerCount() <= 0 {
 		return fmt.Errorf("%v", errorUnexpectedWorkerCount)
 	}
 
+	if p.handlerConfig.WorkerAutoscale {
+		if p.handlerConfig.MinWorkerCount <= 0 || p.handlerConfig.MinWorkerCount > p.handlerConfig.MaxWorkerCount {
+			return fmt.Errorf("%v", errorUnexpectedWorkerCount)
+		}
+		if p.handlerConfig.MaxCPPWorkerThrCount < p.handlerConfig.MinCPPWorkerThrCount {
+			p.handlerConfig.MaxCPPWorkerThrCount = p.handlerConfig.MinCPPWorkerThrCount
+		}
+
+		logging.Infof("%s [%s] Worker autoscale enabled => min wc: %d max wc: %d starting wc: %d thr count: %d max thr count: %d",
+			logPrefix, p.appName, p.handlerConfig.MinWorkerCount, p.handlerConfig.MaxWorkerCount, p.getWorkerCount(),
+			p.handlerConfig.MinCPPWorkerThrCount, p.handlerConfig.MaxCPPWorkerThrCount)
+	}
+
 	p.nsServerHostPort = net.JoinHostPort(util.Localhost(), p.nsServerPort)
 
 	p.kvHostPorts, err = util.KVNodesAddresses(p.auth, p.nsServerHostPort, p.handlerConfig.SourceBucket)
@@ -399,7 +598,7 @@ func (p *Producer) parseDepcfg() error {
 }
 
 func (p *Producer) consumerMemQuota() int64 {
-	wc := int64(p.handlerConfig.WorkerCount)
+	wc := int64(p.getWorkerCount())
 	if wc > 0 {
 		// Accounting for memory usage by following queues:
 		// (a) dcp feed queue
@@ -20,6 +20,7 @@ import (
 	mcd "github.com/couchbase/eventing/dcp/transport"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/suptree"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -90,6 +91,11 @@ func (p *Producer) GetExecutionStats() map[string]interface{} {
 				continue
 			}
 
+			// Reported by partition through GetTimerFiringStats
+			if k == "timer_firing" {
+				continue
+			}
+
 			if _, ok := executionStats[k]; !ok {
 				executionStats[k] = float64(0)
 			}
@@ -261,6 +267,23 @@ func (p *Producer) PauseProducer() {
 	p.pauseProducerCh <- struct{}{}
 }
 
+// RestartWorkers drains and respawns eventing-consumer instances one at a time,
+// without any event being lost or handed to handler twice
+func (p *Producer) RestartWorkers() {
+	logPrefix := "Producer::RestartWorkers"
+
+	if !atomic.CompareAndSwapUint32(&p.restartingWorkers, 0, 1) {
+		logging.Infof("%s [%s:%d] Workers restart already in progress",
+			logPrefix, p.appName, p.LenRunningConsumers())
+		return
+	}
+
+	go func() {
+		defer atomic.StoreUint32(&p.restartingWorkers, 0)
+		p.restartWorkers()
+	}()
+}
+
 // StopProducer cleans up resource handles
 func (p *Producer) StopProducer() {
 	logPrefix := "Producer::StopProducer"
@@ -587,18 +610,24 @@ func (p *Producer) CleanupMetadataBucket(skipCheckpointBlobs bool) error {
 
 	vbsDistribution := util.VbucketNodeAssignment(vbsToCleanup, p.handlerConfig.UndeployRoutineCount)
 
+	skipTimers := p.retainTimers()
+	if skipTimers {
+		logging.Infof("%s [%s:%d] Keeping timers for next deployment to adopt",
+			logPrefix, p.appName, p.LenRunningConsumers())
+	}
+
 	var undeployWG sync.WaitGroup
 	undeployWG.Add(p.handlerConfig.UndeployRoutineCount)
 
 	for i := 0; i < p.handlerConfig.UndeployRoutineCount; i++ {
-		go p.cleanupMetadataImpl(i, vbsDistribution[i], &undeployWG, skipCheckpointBlobs)
+		go p.cleanupMetadataImpl(i, vbsDistribution[i], &undeployWG, skipCheckpointBlobs, skipTimers)
 	}
 
 	undeployWG.Wait()
 	return nil
 }
 
-func (p *Producer) cleanupMetadataImpl(id int, vbsToCleanup []uint16, undeployWG *sync.WaitGroup, skipCheckpointBlobs bool) error {
+func (p *Producer) cleanupMetadataImpl(id int, vbsToCleanup []uint16, undeployWG *sync.WaitGroup, skipCheckpointBlobs, skipTimers bool) error {
 	logPrefix := "Producer::cleanupMetadataImpl"
 	defer undeployWG.Done()
 
@@ -662,6 +691,7 @@ func (p *Producer) cleanupMetadataImpl(id int, vbsToCleanup []uint16, undeployWG
 		defer wg.Done()
 
 		prefix := p.GetMetadataPrefix()
+		timerPrefix := timers.KeyPrefix(prefix)
 		for {
 			select {
 			case e, ok := <-dcpFeed.C:
@@ -683,6 +713,9 @@ func (p *Producer) cleanupMetadataImpl(id int, vbsToCleanup []uint16, undeployWG
 						if skipCheckpointBlobs && strings.Contains(docID, "::vb::") {
 							continue
 						}
+						if skipTimers && strings.HasPrefix(docID, timerPrefix) {
+							continue
+						}
 
 						err = util.Retry(util.NewFixedBackoff(bucketOpRetryInterval), &p.retryCount, deleteOpCallback, p, docID)
 						if err == common.ErrRetryTimeout {
@@ -817,7 +850,7 @@ func (p *Producer) UpdateMemoryQuota(quota int64) {
 	p.MemoryQuota = quota // in MB
 
 	for _, c := range p.getConsumers() {
-		wc := int64(p.handlerConfig.WorkerCount)
+		wc := int64(p.getWorkerCount())
 		if wc > 0 {
 			c.UpdateWorkerQueueMemCap(p.MemoryQuota / wc)
 		} else {
@@ -996,9 +1029,17 @@ func (p *Producer) getSupervisorToken(c common.EventingConsumer) suptree.Service
 
 // IsPlannerRunning returns planner execution status
 func (p *Producer) IsPlannerRunning() bool {
+	p.plannerRWMutex.RLock()
+	defer p.plannerRWMutex.RUnlock()
 	return p.isPlannerRunning
 }
 
+func (p *Producer) setPlannerRunning(running bool) {
+	p.plannerRWMutex.Lock()
+	defer p.plannerRWMutex.Unlock()
+	p.isPlannerRunning = running
+}
+
 // CheckpointBlobDump returns state of metadata blobs stored in Couchbase bucket
 func (p *Producer) CheckpointBlobDump() map[string]interface{} {
 	logPrefix := "Producer::CheckpointBlobDump"
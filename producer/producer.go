@@ -18,7 +18,9 @@ import (
 	"github.com/couchbase/eventing/consumer"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/parser"
+	"github.com/couchbase/eventing/shmipc"
 	"github.com/couchbase/eventing/suptree"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -56,6 +58,7 @@ func NewProducer(appName, debuggerPort, eventingPort, eventingSSLPort, eventingD
 		statsRWMutex:                 &sync.RWMutex{},
 		stopCh:                       make(chan struct{}, 1),
 		superSup:                     superSup,
+		timerCheck:                   timers.NewCheckTask(fmt.Sprintf("Producer::checkTimers [%s]", appName)),
 		topologyChangeCh:             make(chan *common.TopologyChangeMsg, 10),
 		uuid:                         uuid,
 		vbEventingNodeAssignRWMutex:  &sync.RWMutex{},
@@ -65,6 +68,8 @@ func NewProducer(appName, debuggerPort, eventingPort, eventingSSLPort, eventingD
 		workerNameConsumerMap:        make(map[string]common.EventingConsumer),
 		workerNameConsumerMapRWMutex: &sync.RWMutex{},
 		workerVbMapRWMutex:           &sync.RWMutex{},
+		workerCountRWMutex:           &sync.RWMutex{},
+		plannerRWMutex:               &sync.RWMutex{},
 		handlerConfig:                &common.HandlerConfig{},
 		processConfig:                &common.ProcessConfig{},
 		rebalanceConfig:              &common.RebalanceConfig{},
@@ -131,22 +136,22 @@ func (p *Producer) Serve() {
 		return
 	}
 
-	p.isPlannerRunning = true
-	logging.Infof("%s [%s:%d] Planner status: %t, before vbucket to node assignment", logPrefix, p.appName, p.LenRunningConsumers(), p.isPlannerRunning)
+	p.setPlannerRunning(true)
+	logging.Infof("%s [%s:%d] Planner status: %t, before vbucket to node assignment", logPrefix, p.appName, p.LenRunningConsumers(), p.IsPlannerRunning())
 
 	err = p.vbEventingNodeAssign(p.handlerConfig.SourceBucket)
 	if err == common.ErrRetryTimeout {
 		logging.Errorf("%s [%s:%d] Exiting due to timeout", logPrefix, p.appName, p.LenRunningConsumers())
-		p.isPlannerRunning = false
-		logging.Infof("%s [%s:%d] Planner status: %t, after vbucket to node assignment", logPrefix, p.appName, p.LenRunningConsumers(), p.isPlannerRunning)
+		p.setPlannerRunning(false)
+		logging.Infof("%s [%s:%d] Planner status: %t, after vbucket to node assignment", logPrefix, p.appName, p.LenRunningConsumers(), p.IsPlannerRunning())
 		return
 	}
 
 	p.vbNodeWorkerMap()
 
 	p.initWorkerVbMap()
-	p.isPlannerRunning = false
-	logging.Infof("%s [%s:%d] Planner status: %t, after vbucket to worker assignment", logPrefix, p.appName, p.LenRunningConsumers(), p.isPlannerRunning)
+	p.setPlannerRunning(false)
+	logging.Infof("%s [%s:%d] Planner status: %t, after vbucket to worker assignment", logPrefix, p.appName, p.LenRunningConsumers(), p.IsPlannerRunning())
 
 	if err != nil {
 		logging.Fatalf("%s [%s:%d] Failure while assigning vbuckets to workers, err: %v", logPrefix, p.appName, p.LenRunningConsumers(), err)
@@ -186,6 +191,10 @@ func (p *Producer) Serve() {
 
 	p.startBucket()
 
+	if p.handlerConfig.WorkerAutoscale {
+		go p.autoscaleWorkers()
+	}
+
 	p.bootstrapFinishCh <- struct{}{}
 
 	p.isBootstrapping = false
@@ -206,23 +215,23 @@ func (p *Producer) Serve() {
 
 			switch msg.CType {
 			case common.StartRebalanceCType:
-				p.isPlannerRunning = true
+				p.setPlannerRunning(true)
 				logging.Infof("%s [%s:%d] Planner status: %t, before vbucket to node assignment as part of rebalance",
-					logPrefix, p.appName, p.LenRunningConsumers(), p.isPlannerRunning)
+					logPrefix, p.appName, p.LenRunningConsumers(), p.IsPlannerRunning())
 
 				err = p.vbEventingNodeAssign(p.handlerConfig.SourceBucket)
 				if err == common.ErrRetryTimeout {
 					logging.Errorf("%s [%s:%d] Exiting due to timeout", logPrefix, p.appName, p.LenRunningConsumers())
-					p.isPlannerRunning = false
+					p.setPlannerRunning(false)
 					logging.Infof("%s [%s:%d] Planner status: %t, after vbucket to node assignment post rebalance request",
-						logPrefix, p.appName, p.LenRunningConsumers(), p.isPlannerRunning)
+						logPrefix, p.appName, p.LenRunningConsumers(), p.IsPlannerRunning())
 					return
 				}
 				p.vbNodeWorkerMap()
 				oldworkerVbucketMap := p.initWorkerVbMap()
-				p.isPlannerRunning = false
+				p.setPlannerRunning(false)
 				logging.Infof("%s [%s:%d] Planner status: %t, post vbucket to worker assignment during rebalance",
-					logPrefix, p.appName, p.LenRunningConsumers(), p.isPlannerRunning)
+					logPrefix, p.appName, p.LenRunningConsumers(), p.IsPlannerRunning())
 
 				for _, c := range p.getConsumers() {
 					consumerName := c.ConsumerName()
@@ -469,7 +478,7 @@ func (p *Producer) startBucket() {
 
 	logging.Infof("%s [%s:%d] Connecting with bucket: %q", logPrefix, p.appName, p.LenRunningConsumers(), p.handlerConfig.SourceBucket)
 
-	for i := 0; i < p.handlerConfig.WorkerCount; i++ {
+	for i := 0; i < p.getWorkerCount(); i++ {
 		workerName := fmt.Sprintf("worker_%s_%d", p.appName, i)
 
 		p.workerVbMapRWMutex.RLock()
@@ -504,7 +513,36 @@ func (p *Producer) handleV8Consumer(workerName string, vbnos []uint16, index int
 	logging.Infof("%s [%s:%d] udsSockPath len: %d dump: %s feedbackSockPath len: %d dump: %s",
 		logPrefix, p.appName, p.LenRunningConsumers(), len(udsSockPath), udsSockPath, len(feedbackSockPath), feedbackSockPath)
 
-	if runtime.GOOS == "windows" || len(feedbackSockPath) > udsSockPathLimit {
+	// Shared memory segments aren't subject to uds path limit, fall back to sockets
+	// only when segments can't be created at all
+	ipcType := util.GetIPCType()
+	if ipcType == "shm" {
+		shmPath := fmt.Sprintf("%s/%s.shm", shmipc.Dir(), strings.TrimSuffix(pathNameSuffix, ".sock"))
+		feedbackShmPath := fmt.Sprintf("%s/f_%s.shm", shmipc.Dir(), strings.TrimSuffix(pathNameSuffix, ".sock"))
+
+		feedbackListener, listener, err = listenShm(feedbackShmPath, shmPath)
+		if err != nil {
+			logging.Errorf("%s [%s:%d] Failed to create shared memory segments, falling back to sockets, err: %v",
+				logPrefix, p.appName, p.LenRunningConsumers(), err)
+			ipcType = ""
+		} else {
+			p.processConfig.FeedbackSockIdentifier = feedbackShmPath
+			p.processConfig.SockIdentifier = shmPath
+		}
+	}
+
+	if ipcType == "" || ipcType == "af_unix" {
+		ipcType = "af_unix"
+		if runtime.GOOS == "windows" || len(feedbackSockPath) > udsSockPathLimit {
+			ipcType = "af_inet"
+		}
+	}
+
+	switch ipcType {
+	case "shm":
+		p.processConfig.IPCType = "shm"
+
+	case "af_inet":
 		feedbackListener, err = net.Listen("tcp", net.JoinHostPort(util.Localhost(), "0"))
 		if err != nil {
 			logging.Errorf("%s [%s:%d] Failed to listen on feedback tcp port, err: %v", logPrefix, p.appName, p.LenRunningConsumers(), err)
@@ -527,7 +565,7 @@ func (p *Producer) handleV8Consumer(workerName string, vbnos []uint16, index int
 
 		p.processConfig.IPCType = "af_inet"
 
-	} else {
+	default:
 		os.Remove(udsSockPath)
 		os.Remove(feedbackSockPath)
 
@@ -624,6 +662,14 @@ func (p *Producer) handleV8Consumer(workerName string, vbnos []uint16, index int
 					logging.Errorf("%s [%s:%d] Exiting due to timeout", logPrefix, p.appName, p.LenRunningConsumers())
 					return
 				}
+				if err != nil {
+					// Worker that failed handshake can't be talked to, so it's replaced
+					// rather than left running without a session
+					logging.Errorf("%s [%s:%d] Failed to set up cpp worker: %s, respawning it, err: %v",
+						logPrefix, p.appName, p.LenRunningConsumers(), c.ConsumerName(), err)
+					p.KillAndRespawnEventingConsumer(c)
+					return
+				}
 			case <-p.stopCh:
 				logging.Infof("%s [%s:%d] Got message on stop chan, exiting", logPrefix, p.appName, p.LenRunningConsumers())
 				return
@@ -667,6 +713,29 @@ func (p *Producer) KillAndRespawnEventingConsumer(c common.EventingConsumer) {
 
 	p.workerSpawnCounter++
 
+	consumerIndex := c.Index()
+	p.removeConsumer(c)
+
+	if p.isPausing {
+		logging.Infof("%s [%s:%d] Not respawning consumer as the Function is pausing",
+			logPrefix, p.appName, p.LenRunningConsumers())
+		return
+	}
+
+	logging.Infof("%s [%s:%d] ConsumerIndex: %d respawning the Eventing.Consumer instance",
+		logPrefix, p.appName, p.LenRunningConsumers(), consumerIndex)
+	workerName := fmt.Sprintf("worker_%s_%d", p.appName, consumerIndex)
+	p.workerVbMapRWMutex.RLock()
+	vbsAssigned := p.workerVbucketMap[workerName]
+	p.workerVbMapRWMutex.RUnlock()
+
+	p.handleV8Consumer(workerName, vbsAssigned, consumerIndex, true)
+}
+
+// removeConsumer drops consumer from list of active running consumers and shuts it down
+func (p *Producer) removeConsumer(c common.EventingConsumer) {
+	logPrefix := "Producer::removeConsumer"
+
 	consumerIndex := c.Index()
 
 	p.runningConsumersRWMutex.Lock()
@@ -714,21 +783,60 @@ func (p *Producer) KillAndRespawnEventingConsumer(c common.EventingConsumer) {
 		delete(p.feedbackListeners, c)
 	}
 	p.listenerRWMutex.Unlock()
+}
 
-	if p.isPausing {
-		logging.Infof("%s [%s:%d] Not respawning consumer as the Function is pausing",
-			logPrefix, p.appName, p.LenRunningConsumers())
-		return
+func (p *Producer) restartWorkers() {
+	logPrefix := "Producer::restartWorkers"
+
+	consumers := p.getConsumers()
+	logging.Infof("%s [%s:%d] Restarting %d workers", logPrefix, p.appName, p.LenRunningConsumers(), len(consumers))
+
+	for _, c := range consumers {
+		if p.isPausing || atomic.LoadInt32(&p.isRebalanceOngoing) == 1 || atomic.LoadUint32(&p.scalingWorkers) == 1 {
+			logging.Infof("%s [%s:%d] Function is pausing, rebalance is ongoing or workers are being scaled, abandoning workers restart",
+				logPrefix, p.appName, p.LenRunningConsumers())
+			return
+		}
+
+		workerName := c.ConsumerName()
+		if err := c.DrainForRestart(workerDrainTimeout); err != nil {
+			logging.Errorf("%s [%s:%d] Worker: %s failed to drain, skipping its restart, err: %v",
+				logPrefix, p.appName, p.LenRunningConsumers(), workerName, err)
+			continue
+		}
+
+		p.KillAndRespawnEventingConsumer(c)
+
+		// Next worker is restarted only once this one is back, so that function
+		// keeps processing mutations throughout
+		if err := p.waitForWorkerBootstrap(workerName, c); err != nil {
+			logging.Errorf("%s [%s:%d] Worker: %s didn't come back after restart, abandoning workers restart, err: %v",
+				logPrefix, p.appName, p.LenRunningConsumers(), workerName, err)
+			return
+		}
+
+		logging.Infof("%s [%s:%d] Worker: %s restarted", logPrefix, p.appName, p.LenRunningConsumers(), workerName)
 	}
+}
 
-	logging.Infof("%s [%s:%d] ConsumerIndex: %d respawning the Eventing.Consumer instance",
-		logPrefix, p.appName, p.LenRunningConsumers(), consumerIndex)
-	workerName := fmt.Sprintf("worker_%s_%d", p.appName, consumerIndex)
-	p.workerVbMapRWMutex.RLock()
-	vbsAssigned := p.workerVbucketMap[workerName]
-	p.workerVbMapRWMutex.RUnlock()
+func (p *Producer) waitForWorkerBootstrap(workerName string, prev common.EventingConsumer) error {
+	deadline := time.Now().Add(workerRestartBootstrapTimeout)
 
-	p.handleV8Consumer(workerName, vbsAssigned, consumerIndex, true)
+	for {
+		p.workerNameConsumerMapRWMutex.RLock()
+		c, ok := p.workerNameConsumerMap[workerName]
+		p.workerNameConsumerMapRWMutex.RUnlock()
+
+		// Respawned consumer is marked bootstrapping before it's put in the map
+		if ok && c != prev && !c.BootstrapStatus() {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for worker: %s to bootstrap", workerName)
+		}
+		time.Sleep(time.Second)
+	}
 }
 
 func (p *Producer) getEventingNodeAddrs() []string {
@@ -940,3 +1048,20 @@ func (p *Producer) getConsumers() []common.EventingConsumer {
 
 	return workers
 }
+
+// listenShm creates shared memory segments for data and feedback channels to
+// eventing-consumer, either both are created or neither
+func listenShm(feedbackShmPath, shmPath string) (net.Listener, net.Listener, error) {
+	feedbackListener, err := shmipc.Listen(feedbackShmPath, shmipc.DefaultRingSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener, err := shmipc.Listen(shmPath, shmipc.DefaultRingSize)
+	if err != nil {
+		feedbackListener.Close()
+		return nil, nil, err
+	}
+
+	return feedbackListener, listener, nil
+}
@@ -0,0 +1,446 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/timers"
+	"github.com/couchbase/eventing/util"
+)
+
+const defaultTimerPageLimit = 100
+
+// ListTimers returns a page of pending timers of the function, in due order
+// within a partition and partitions in turn. Recurring timers asked for with
+// series are listed all at once.
+func (p *Producer) ListTimers(query *common.TimerQuery) (*common.TimerPage, error) {
+	logPrefix := "Producer::ListTimers"
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultTimerPageLimit
+	}
+
+	page := &common.TimerPage{Timers: make([]common.TimerInfo, 0)}
+
+	if query.Series {
+		for _, partn := range p.timerPartitions(query.Partition, 0) {
+			store, found, err := p.inspectTimers(partn)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+
+			series, err := store.ListSeries()
+			if err != nil {
+				return nil, err
+			}
+			page.Timers = append(page.Timers, series...)
+		}
+		return page, nil
+	}
+
+	if query.Reference != "" {
+		for _, partn := range p.timerPartitions(query.Partition, 0) {
+			store, found, err := p.inspectTimers(partn)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+
+			entry, err := store.Lookup(query.Reference)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				continue
+			}
+
+			info := store.Info(entry)
+			info.Reference = query.Reference
+			page.Timers = append(page.Timers, info)
+		}
+		return page, nil
+	}
+
+	cursorPartn, cursorDue, cursorSeq, err := parseTimerCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partn := range p.timerPartitions(query.Partition, cursorPartn) {
+		store, found, err := p.inspectTimers(partn)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		from, afterDue, afterSeq := query.From, int64(0), int64(0)
+		if partn == cursorPartn && query.Cursor != "" {
+			afterDue, afterSeq = cursorDue, cursorSeq
+			if from < cursorDue {
+				from = cursorDue
+			}
+		}
+
+		iter := store.ScanRange(from, timerQueryTo(query), afterDue, afterSeq)
+		for {
+			entry, err := iter.ScanNext()
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				break
+			}
+
+			info := store.Info(entry)
+			page.Timers = append(page.Timers, info)
+			if len(page.Timers) == limit {
+				page.Next = fmt.Sprintf("%d:%d:%d", partn, info.Due, info.Seq)
+				return page, nil
+			}
+		}
+	}
+
+	logging.Debugf("%s [%s:%d] Listed %d timers for query: %+v",
+		logPrefix, p.appName, p.LenRunningConsumers(), len(page.Timers), *query)
+	return page, nil
+}
+
+// CancelTimers cancels timer with given reference, returning number of timers
+// cancelled. Range of query is cancelled by CancelTimersInRange.
+func (p *Producer) CancelTimers(query *common.TimerQuery) (int, error) {
+	logPrefix := "Producer::CancelTimers"
+
+	cancelled := 0
+
+	for _, partn := range p.timerPartitions(query.Partition, 0) {
+		store, found, err := p.inspectTimers(partn)
+		if err != nil {
+			return cancelled, err
+		}
+		if !found {
+			continue
+		}
+
+		entry, err := store.Lookup(query.Reference)
+		if err != nil {
+			return cancelled, err
+		}
+		if entry == nil {
+			continue
+		}
+		if err = store.Cancel(query.Reference); err != nil {
+			return cancelled, err
+		}
+		cancelled++
+	}
+
+	logging.Infof("%s [%s:%d] Cancelled %d timers for query: %+v",
+		logPrefix, p.appName, p.LenRunningConsumers(), cancelled, *query)
+	return cancelled, nil
+}
+
+// CancelTimersInRange starts cancelling timers due within range of query in
+// background, unless a cancellation is running
+func (p *Producer) CancelTimersInRange(query *common.TimerQuery) error {
+	logPrefix := "Producer::CancelTimersInRange"
+
+	p.timerCancelMutex.Lock()
+	defer p.timerCancelMutex.Unlock()
+
+	if p.timerCancel != nil && p.timerCancel.Running {
+		return fmt.Errorf("timer cancellation started at %s is still running", p.timerCancel.Started)
+	}
+
+	report := &common.TimerCancelReport{
+		From:      query.From,
+		To:        query.To,
+		Partition: query.Partition,
+		Running:   true,
+		Started:   time.Now().Format(time.RFC3339),
+	}
+	p.timerCancel = report
+
+	logging.Infof("%s [%s:%d] Starting to cancel timers for query: %+v",
+		logPrefix, p.appName, p.LenRunningConsumers(), *query)
+
+	go p.cancelTimersInRange(query, *report)
+	return nil
+}
+
+// TimerCancelStatus returns report of last or ongoing cancellation of timers
+// due within a range
+func (p *Producer) TimerCancelStatus() *common.TimerCancelReport {
+	p.timerCancelMutex.Lock()
+	defer p.timerCancelMutex.Unlock()
+
+	if p.timerCancel == nil {
+		return nil
+	}
+	report := *p.timerCancel
+	return &report
+}
+
+func (p *Producer) cancelTimersInRange(query *common.TimerQuery, progress common.TimerCancelReport) {
+	logPrefix := "Producer::cancelTimersInRange"
+
+	err := func() error {
+		for _, partn := range p.timerPartitions(query.Partition, 0) {
+			if p.isTerminateRunning {
+				return fmt.Errorf("function is being undeployed")
+			}
+
+			store, found, err := p.inspectTimers(partn)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+
+			iter := store.ScanRange(query.From, timerQueryTo(query), 0, 0)
+			for {
+				entry, err := iter.ScanNext()
+				if err != nil {
+					return err
+				}
+				if entry == nil {
+					break
+				}
+
+				ok, err := store.CancelEntry(entry)
+				if err != nil {
+					return err
+				}
+				if ok {
+					progress.Cancelled++
+				}
+			}
+			p.publishTimerCancel(progress)
+		}
+		return nil
+	}()
+
+	progress.Running = false
+	progress.Finished = time.Now().Format(time.RFC3339)
+	if err != nil {
+		progress.Error = err.Error()
+		logging.Errorf("%s [%s:%d] Cancelling timers failed after cancelling %d, err: %v",
+			logPrefix, p.appName, p.LenRunningConsumers(), progress.Cancelled, err)
+	}
+	p.publishTimerCancel(progress)
+
+	logging.Infof("%s [%s:%d] Cancelled %d timers for query: %+v",
+		logPrefix, p.appName, p.LenRunningConsumers(), progress.Cancelled, *query)
+}
+
+func (p *Producer) publishTimerCancel(progress common.TimerCancelReport) {
+	p.timerCancelMutex.Lock()
+	defer p.timerCancelMutex.Unlock()
+	p.timerCancel = &progress
+}
+
+// CreateTimer sets a timer from outside handler, in partition handler would
+// have set it in, for worker owning the partition to fire
+func (p *Producer) CreateTimer(request *common.TimerRequest) (*common.TimerInfo, error) {
+	logPrefix := "Producer::CreateTimer"
+
+	partn := timers.Partition(request.Callback, request.Reference, p.numVbuckets)
+	store, err := timers.Open(p.GetMetadataPrefix(), partn, timers.Pool(p.timerConnStr()), p.metadatabucket, p.timerConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	context := timers.CallbackContext{Callback: request.Callback, Context: request.Context}
+	if request.Schedule != nil {
+		schedule, err := timerSchedule(request.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		err = store.ScheduleRecurring(request.Callback, request.Reference, schedule, request.Context)
+		if err != nil {
+			return nil, err
+		}
+	} else if err = store.Schedule(request.Due, request.Reference, context); err != nil {
+		return nil, err
+	}
+
+	info := common.TimerInfo{Partition: partn, Due: request.Due, Reference: request.Reference, Context: context}
+	entry, err := store.Lookup(request.Reference)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		info = store.Info(entry)
+		info.Reference = request.Reference
+	}
+
+	logging.Infof("%s [%s:%d] Created timer in partition: %d request: %+v",
+		logPrefix, p.appName, p.LenRunningConsumers(), partn, *request)
+	return &info, nil
+}
+
+// CheckTimers starts timer store checker in background, unless it's running
+func (p *Producer) CheckTimers(request *common.TimerCheckRequest) error {
+	return p.timerCheck.Start(request, timers.CheckSource{
+		Partitions: p.timerPartitions(request.Partition, 0),
+		Open:       p.inspectTimers,
+		Stop: func() error {
+			if p.isTerminateRunning {
+				return fmt.Errorf("function is being undeployed")
+			}
+			return nil
+		},
+		Lister: timers.NewDcpLister(net.JoinHostPort(util.Localhost(), p.nsServerPort)),
+	})
+}
+
+// TimerCheckStatus returns report of last or ongoing run of timer store checker
+func (p *Producer) TimerCheckStatus() *common.TimerCheckReport {
+	return p.timerCheck.Status()
+}
+
+// GetTimerFiringStats returns lateness and scan cost of timers fired on this
+// node, by partition. Stats of timers fired by workers are added to those of
+// stores opened here. Pending counts alarms of a partition as a whole, so
+// it isn't added up.
+func (p *Producer) GetTimerFiringStats() map[int]*common.TimerFiringStats {
+	firingStats := timers.FiringStats(p.GetMetadataPrefix())
+
+	for _, c := range p.getConsumers() {
+		for partn, stats := range c.GetTimerFiringStats() {
+			merged, ok := firingStats[partn]
+			if !ok {
+				firingStats[partn] = stats
+				continue
+			}
+
+			if stats.Pending > merged.Pending {
+				merged.Pending = stats.Pending
+			}
+			for bin, count := range stats.FireLag {
+				merged.FireLag[bin] += count
+			}
+			for bin, count := range stats.ScanTime {
+				merged.ScanTime[bin] += count
+			}
+		}
+	}
+	return firingStats
+}
+
+// retainTimers tells if timers are to be left in metadata bucket on undeploy,
+// for next deployment of function to adopt. Settings are read afresh, as
+// adopt_timers may be passed along with undeploy request.
+func (p *Producer) retainTimers() bool {
+	logPrefix := "Producer::retainTimers"
+
+	sData, err := util.MetakvGet(metakvAppSettingsPath + p.appName)
+	if err != nil || sData == nil {
+		logging.Errorf("%s [%s:%d] Failed to fetch settings from metakv, err: %v",
+			logPrefix, p.appName, p.LenRunningConsumers(), err)
+		return p.handlerConfig.AdoptTimers
+	}
+
+	settings := make(map[string]interface{})
+	if err = json.Unmarshal(sData, &settings); err != nil {
+		logging.Errorf("%s [%s:%d] Failed to unmarshal settings received from metakv, err: %v",
+			logPrefix, p.appName, p.LenRunningConsumers(), err)
+		return p.handlerConfig.AdoptTimers
+	}
+
+	if adopt, ok := settings["adopt_timers"].(bool); ok {
+		return adopt
+	}
+	return p.handlerConfig.AdoptTimers
+}
+
+// inspectTimers opens timer store of a partition in metadata bucket, found is
+// false if function never had timers in it
+func (p *Producer) inspectTimers(partn int) (*timers.TimerStore, bool, error) {
+	return timers.Inspect(p.GetMetadataPrefix(), partn, timers.Pool(p.timerConnStr()), p.metadatabucket,
+		p.handlerConfig.TimerResolution)
+}
+
+func (p *Producer) timerConfig() timers.Config {
+	return timers.Config{
+		Resolution:      p.handlerConfig.TimerResolution,
+		InPastPolicy:    timers.InPastPolicy(p.handlerConfig.TimerInPastPolicy),
+		MissedPolicy:    timers.MissedPolicy(p.handlerConfig.MissedTimerPolicy),
+		MissedThreshold: p.handlerConfig.MissedTimerThreshold,
+		MissedRate:      p.handlerConfig.MissedTimerRate,
+		ContextSize:     p.handlerConfig.TimerContextSize,
+		ContextOverflow: timers.ContextOverflow(p.handlerConfig.TimerContextOverflow),
+	}
+}
+
+func (p *Producer) timerConnStr() string {
+	connStr := "couchbase://" + strings.Join(p.KvHostPorts(), ",")
+	if util.IsIPv6() {
+		connStr += "?ipv6=allow"
+	}
+	return connStr
+}
+
+// timerPartitions lists partitions a query covers, from given partition on
+func (p *Producer) timerPartitions(partn, from int) []int {
+	if partn >= 0 {
+		if partn < from || partn >= p.numVbuckets {
+			return nil
+		}
+		return []int{partn}
+	}
+
+	partns := make([]int, 0, p.numVbuckets)
+	for partn = from; partn < p.numVbuckets; partn++ {
+		partns = append(partns, partn)
+	}
+	return partns
+}
+
+// timerSchedule decodes schedule of a recurring timer in a request
+func timerSchedule(spec interface{}) (timers.Schedule, error) {
+	schedule := timers.Schedule{}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return schedule, err
+	}
+	if err = json.Unmarshal(data, &schedule); err != nil {
+		return schedule, err
+	}
+	return schedule, schedule.Validate()
+}
+
+func timerQueryTo(query *common.TimerQuery) int64 {
+	if query.To <= 0 {
+		return math.MaxInt64
+	}
+	return query.To
+}
+
+// Cursor is partition, due and seq of last timer returned
+func parseTimerCursor(cursor string) (partn int, due, seq int64, err error) {
+	if cursor == "" {
+		return 0, 0, 0, nil
+	}
+
+	_, err = fmt.Sscanf(cursor, "%d:%d:%d", &partn, &due, &seq)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return
+}
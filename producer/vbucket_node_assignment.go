@@ -173,16 +173,17 @@ func (p *Producer) vbNodeWorkerMap() {
 
 	p.vbMapping = make(map[uint16]*vbNodeWorkerMapping)
 
+	workerCount := p.getWorkerCount()
 	for node, vbucketsToHandle := range nodeVbsToHandle {
 
 		logging.Infof("%s [%s:%d] eventingAddr: %rs vbs to handle len: %d dump: %s",
 			logPrefix, p.appName, p.LenRunningConsumers(), node, len(vbucketsToHandle), util.Condense(vbucketsToHandle))
 
-		vbucketPerWorker := len(vbucketsToHandle) / p.handlerConfig.WorkerCount
+		vbucketPerWorker := len(vbucketsToHandle) / workerCount
 		var startVbIndex int
 
-		vbCountPerWorker := make([]int, p.handlerConfig.WorkerCount)
-		for i := 0; i < p.handlerConfig.WorkerCount; i++ {
+		vbCountPerWorker := make([]int, workerCount)
+		for i := 0; i < workerCount; i++ {
 			vbCountPerWorker[i] = vbucketPerWorker
 			startVbIndex += vbucketPerWorker
 		}
@@ -196,7 +197,7 @@ func (p *Producer) vbNodeWorkerMap() {
 
 		startVbIndex = 0
 
-		for i := 0; i < p.handlerConfig.WorkerCount; i++ {
+		for i := 0; i < workerCount; i++ {
 			workerName := fmt.Sprintf("worker_%s_%d", p.appName, i)
 
 			for j := 0; j < vbCountPerWorker[i]; j++ {
@@ -250,11 +251,12 @@ func (p *Producer) initWorkerVbMap() map[string][]uint16 {
 	logging.Infof("%s [%s:%d] eventingAddr: %rs vbucketsToHandle, len: %d dump: %v",
 		logPrefix, p.appName, p.LenRunningConsumers(), eventingNodeAddr, len(vbucketsToHandle), util.Condense(vbucketsToHandle))
 
-	vbucketPerWorker := len(vbucketsToHandle) / p.handlerConfig.WorkerCount
+	workerCount := p.getWorkerCount()
+	vbucketPerWorker := len(vbucketsToHandle) / workerCount
 	var startVbIndex int
 
-	vbCountPerWorker := make([]int, p.handlerConfig.WorkerCount)
-	for i := 0; i < p.handlerConfig.WorkerCount; i++ {
+	vbCountPerWorker := make([]int, workerCount)
+	for i := 0; i < workerCount; i++ {
 		vbCountPerWorker[i] = vbucketPerWorker
 		startVbIndex += vbucketPerWorker
 	}
@@ -276,7 +278,7 @@ func (p *Producer) initWorkerVbMap() map[string][]uint16 {
 
 	startVbIndex = 0
 
-	for i := 0; i < p.handlerConfig.WorkerCount; i++ {
+	for i := 0; i < workerCount; i++ {
 		workerName = fmt.Sprintf("worker_%s_%d", p.appName, i)
 
 		for j := 0; j < vbCountPerWorker[i]; j++ {
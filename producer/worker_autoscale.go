@@ -0,0 +1,460 @@
+package producer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+)
+
+// autoscaleWorkers samples DCP backlog and cpu usage of eventing-consumers and
+// adds or removes one worker at a time, within configured bounds. Only workers
+// whose vbuckets move are notified, others keep processing undisturbed.
+//
+// Once at max worker count, V8 thread count within workers is raised instead.
+// Worker partitions its vbuckets across its threads at bootstrap, so a change
+// of thread count is applied by draining and restarting every worker one at a
+// time, which is why it's the last resort, and the first to be undone.
+func (p *Producer) autoscaleWorkers() {
+	logPrefix := "Producer::autoscaleWorkers"
+
+	ticker := time.NewTicker(autoscaleCheckInterval)
+	defer ticker.Stop()
+
+	logging.Infof("%s [%s:%d] Starting worker autoscaler, min wc: %d max wc: %d min thr count: %d max thr count: %d",
+		logPrefix, p.appName, p.LenRunningConsumers(), p.handlerConfig.MinWorkerCount, p.handlerConfig.MaxWorkerCount,
+		p.handlerConfig.MinCPPWorkerThrCount, p.handlerConfig.MaxCPPWorkerThrCount)
+
+	cpuTimes := make(map[int]time.Duration)
+	lastSampled := time.Now()
+	lastScaled := time.Now()
+	lastStep := autoscaleNone
+	var stableChecks int
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			logging.Infof("%s [%s:%d] Got message on stop chan, exiting", logPrefix, p.appName, p.LenRunningConsumers())
+			return
+		}
+
+		cpuUsage, cpuKnown := p.workerCPUUsage(cpuTimes, time.Since(lastSampled))
+		lastSampled = time.Now()
+
+		if !p.canScaleWorkers() || time.Since(lastScaled) < autoscaleCooldown {
+			lastStep, stableChecks = autoscaleNone, 0
+			continue
+		}
+
+		load := workerLoad{
+			workerCount: p.getWorkerCount(),
+			threadCount: p.getThreadCount(),
+			backlog:     p.GetDcpEventsRemainingToProcess(),
+			cpuUsage:    cpuUsage,
+			cpuKnown:    cpuKnown,
+		}
+
+		step := autoscaleStepFor(load, p.handlerConfig)
+		if step != lastStep {
+			stableChecks = 0
+		}
+		if step != autoscaleNone {
+			stableChecks++
+		}
+		lastStep = step
+
+		logging.Debugf("%s [%s:%d] wc: %d thr count: %d backlog: %d cpu usage: %.2f cpu usage known: %t step: %s stable checks: %d",
+			logPrefix, p.appName, p.LenRunningConsumers(), load.workerCount, load.threadCount, load.backlog,
+			cpuUsage, cpuKnown, step, stableChecks)
+
+		if step == autoscaleNone || stableChecks < autoscaleStableChecks {
+			continue
+		}
+
+		logging.Infof("%s [%s:%d] Autoscale step: %s, wc: %d thr count: %d backlog: %d cpu usage: %.2f",
+			logPrefix, p.appName, p.LenRunningConsumers(), step, load.workerCount, load.threadCount, load.backlog, cpuUsage)
+
+		var err error
+		switch step {
+		case autoscaleAddWorker, autoscaleRemoveWorker:
+			if !atomic.CompareAndSwapUint32(&p.scalingWorkers, 0, 1) {
+				continue
+			}
+			if step == autoscaleAddWorker {
+				err = p.scaleUpWorkers()
+			} else {
+				err = p.scaleDownWorkers()
+			}
+			atomic.StoreUint32(&p.scalingWorkers, 0)
+
+		case autoscaleAddThread, autoscaleRemoveThread:
+			if !atomic.CompareAndSwapUint32(&p.restartingWorkers, 0, 1) {
+				continue
+			}
+			if step == autoscaleAddThread {
+				p.setThreadCount(load.threadCount + 1)
+			} else {
+				p.setThreadCount(load.threadCount - 1)
+			}
+			// Workers left over by an abandoned restart pick up new thread count
+			// whenever they're respawned next
+			p.restartWorkers()
+			atomic.StoreUint32(&p.restartingWorkers, 0)
+		}
+
+		if err != nil {
+			logging.Errorf("%s [%s:%d] Failed to scale workers, wc: %d thr count: %d err: %v",
+				logPrefix, p.appName, p.LenRunningConsumers(), p.getWorkerCount(), p.getThreadCount(), err)
+		} else {
+			logging.Infof("%s [%s:%d] Scaled workers, wc: %d thr count: %d",
+				logPrefix, p.appName, p.LenRunningConsumers(), p.getWorkerCount(), p.getThreadCount())
+		}
+
+		lastStep, stableChecks = autoscaleNone, 0
+		lastScaled = time.Now()
+	}
+}
+
+// autoscaleStep is a change autoscaler makes to workers of function
+type autoscaleStep int
+
+const (
+	autoscaleNone autoscaleStep = iota
+	autoscaleAddWorker
+	autoscaleRemoveWorker
+	autoscaleAddThread
+	autoscaleRemoveThread
+)
+
+func (s autoscaleStep) String() string {
+	switch s {
+	case autoscaleAddWorker:
+		return "add_worker"
+	case autoscaleRemoveWorker:
+		return "remove_worker"
+	case autoscaleAddThread:
+		return "add_thread"
+	case autoscaleRemoveThread:
+		return "remove_thread"
+	default:
+		return "none"
+	}
+}
+
+// workerLoad is a sample of load on workers of function
+type workerLoad struct {
+	workerCount int
+	threadCount int
+	backlog     uint64
+	cpuUsage    float64 // Average over workers, in percent of their thread count cores
+	cpuKnown    bool
+}
+
+// autoscaleStepFor picks the change that brings load within thresholds. Workers
+// are added before threads and removed after them, and capacity is taken away
+// only if what remains would stay well within thresholds, so that function
+// doesn't flap between two sizes.
+func autoscaleStepFor(load workerLoad, config *common.HandlerConfig) autoscaleStep {
+	backlogThreshold := config.AutoscaleEventBacklog
+	cpuThreshold := float64(config.AutoscaleCPUThreshold)
+
+	overloaded := load.backlog/uint64(load.workerCount) > backlogThreshold ||
+		(load.cpuKnown && load.cpuUsage > cpuThreshold)
+
+	switch {
+	case overloaded && load.workerCount < config.MaxWorkerCount:
+		return autoscaleAddWorker
+	case overloaded && load.threadCount < config.MaxCPPWorkerThrCount:
+		return autoscaleAddThread
+	case overloaded:
+		return autoscaleNone
+	}
+
+	if load.threadCount > config.MinCPPWorkerThrCount && load.backlog/uint64(load.workerCount) < backlogThreshold/4 &&
+		(!load.cpuKnown || load.cpuUsage*float64(load.threadCount)/float64(load.threadCount-1) < cpuThreshold/2) {
+		return autoscaleRemoveThread
+	}
+
+	if load.workerCount > config.MinWorkerCount && load.backlog/uint64(load.workerCount-1) < backlogThreshold/4 &&
+		(!load.cpuKnown || load.cpuUsage*float64(load.workerCount)/float64(load.workerCount-1) < cpuThreshold/2) {
+		return autoscaleRemoveWorker
+	}
+	return autoscaleNone
+}
+
+// workerCPUUsage returns average cpu usage of eventing-consumers since previous
+// sample, in percent of cores their V8 sandboxes could have used
+func (p *Producer) workerCPUUsage(cpuTimes map[int]time.Duration, elapsed time.Duration) (float64, bool) {
+	var usage float64
+	var sampled int
+
+	pids := make(map[int]struct{})
+	for _, c := range p.getConsumers() {
+		pid := c.Pid()
+		if pid == 0 {
+			continue
+		}
+		pids[pid] = struct{}{}
+
+		cpuTime, err := util.ProcessCPUTime(pid)
+		if err != nil {
+			continue
+		}
+
+		if prevCPUTime, ok := cpuTimes[pid]; ok && elapsed > 0 {
+			usage += float64(cpuTime-prevCPUTime) / float64(elapsed*time.Duration(p.getThreadCount())) * 100
+			sampled++
+		}
+		cpuTimes[pid] = cpuTime
+	}
+
+	// Forget workers that have exited or were respawned
+	for pid := range cpuTimes {
+		if _, ok := pids[pid]; !ok {
+			delete(cpuTimes, pid)
+		}
+	}
+
+	if sampled == 0 {
+		return 0, false
+	}
+	return usage / float64(sampled), true
+}
+
+func (p *Producer) canScaleWorkers() bool {
+	if p.isPausing || p.IsPlannerRunning() || atomic.LoadInt32(&p.isRebalanceOngoing) == 1 ||
+		atomic.LoadUint32(&p.restartingWorkers) == 1 {
+		return false
+	}
+
+	consumers := p.getConsumers()
+	if len(consumers) != p.getWorkerCount() {
+		return false
+	}
+
+	for _, c := range consumers {
+		if c.BootstrapStatus() || c.RebalanceStatus() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Producer) scaleUpWorkers() error {
+	workerIndex := p.getWorkerCount()
+	workerName := fmt.Sprintf("worker_%s_%d", p.appName, workerIndex)
+
+	p.workerVbMapRWMutex.Lock()
+	prevWorkerVbucketMap := p.workerVbucketMap
+	p.workerVbucketMap = p.addWorkerToVbMap(prevWorkerVbucketMap, workerName)
+	p.setWorkerCount(workerIndex + 1)
+	p.workerVbMapRWMutex.Unlock()
+
+	p.notifyWorkerVbMapChange(prevWorkerVbucketMap)
+	p.UpdateMemoryQuota(p.MemoryQuota)
+
+	// New worker takes over vbuckets as the workers they're moving from give them up
+	p.handleV8Consumer(workerName, p.getAssignedVbs(workerName), workerIndex, true)
+	return p.waitForWorkerBootstrap(workerName, nil)
+}
+
+func (p *Producer) scaleDownWorkers() error {
+	logPrefix := "Producer::scaleDownWorkers"
+
+	workerIndex := p.getWorkerCount() - 1
+	workerName := fmt.Sprintf("worker_%s_%d", p.appName, workerIndex)
+
+	// Retiring worker stays in the map with no vbuckets until it has given them
+	// all up, so that other workers wait for it instead of taking them over
+	p.workerVbMapRWMutex.Lock()
+	prevWorkerVbucketMap := p.workerVbucketMap
+	p.workerVbucketMap = p.removeWorkerFromVbMap(prevWorkerVbucketMap, workerName)
+	p.workerVbMapRWMutex.Unlock()
+
+	p.notifyWorkerVbMapChange(prevWorkerVbucketMap)
+
+	if err := p.waitForVbHandover(workerName); err != nil {
+		return err
+	}
+
+	if len(p.getAssignedVbs(workerName)) != 0 || atomic.LoadInt32(&p.isRebalanceOngoing) == 1 {
+		return fmt.Errorf("vbuckets were reassigned to worker: %s while it was being removed", workerName)
+	}
+
+	p.workerVbMapRWMutex.Lock()
+	delete(p.workerVbucketMap, workerName)
+	p.setWorkerCount(workerIndex)
+	workerVbucketMap := make(map[string][]uint16)
+	for name, assignedVbs := range p.workerVbucketMap {
+		workerVbucketMap[name] = assignedVbs
+	}
+	p.workerVbMapRWMutex.Unlock()
+
+	p.workerNameConsumerMapRWMutex.RLock()
+	c, ok := p.workerNameConsumerMap[workerName]
+	p.workerNameConsumerMapRWMutex.RUnlock()
+
+	for _, consumer := range p.getConsumers() {
+		if consumer != c {
+			consumer.WorkerVbMapUpdate(workerVbucketMap)
+		}
+	}
+
+	if ok {
+		logging.Infof("%s [%s:%d] Worker: %s has given up all its vbs, stopping it",
+			logPrefix, p.appName, p.LenRunningConsumers(), workerName)
+		p.removeConsumer(c)
+	}
+
+	p.UpdateMemoryQuota(p.MemoryQuota)
+	return nil
+}
+
+func (p *Producer) getWorkerCount() int {
+	p.workerCountRWMutex.RLock()
+	defer p.workerCountRWMutex.RUnlock()
+	return p.handlerConfig.WorkerCount
+}
+
+func (p *Producer) setWorkerCount(workerCount int) {
+	p.workerCountRWMutex.Lock()
+	defer p.workerCountRWMutex.Unlock()
+	p.handlerConfig.WorkerCount = workerCount
+}
+
+func (p *Producer) getThreadCount() int {
+	p.workerCountRWMutex.RLock()
+	defer p.workerCountRWMutex.RUnlock()
+	return p.handlerConfig.CPPWorkerThrCount
+}
+
+// setThreadCount changes V8 thread count of workers spawned from now on. It's
+// also held back while a worker is being spawned, as consumer reads the count
+// straight off handler config.
+func (p *Producer) setThreadCount(threadCount int) {
+	p.handleV8ConsumerMutex.Lock()
+	defer p.handleV8ConsumerMutex.Unlock()
+
+	p.workerCountRWMutex.Lock()
+	defer p.workerCountRWMutex.Unlock()
+	p.handlerConfig.CPPWorkerThrCount = threadCount
+}
+
+// addWorkerToVbMap hands new worker an even share of vbuckets, made up only of
+// vbuckets existing workers hold beyond their share
+func (p *Producer) addWorkerToVbMap(workerVbucketMap map[string][]uint16, workerName string) map[string][]uint16 {
+	workerCount := len(workerVbucketMap) + 1
+
+	var vbCount int
+	for _, vbs := range workerVbucketMap {
+		vbCount += len(vbs)
+	}
+
+	newWorkerVbucketMap := make(map[string][]uint16)
+	var movedVbs []uint16
+
+	for i := 0; i < workerCount-1; i++ {
+		name := fmt.Sprintf("worker_%s_%d", p.appName, i)
+		vbs := workerVbucketMap[name]
+
+		share := vbCount / workerCount
+		if i < vbCount%workerCount {
+			share++
+		}
+
+		if len(vbs) > share {
+			movedVbs = append(movedVbs, vbs[share:]...)
+			vbs = vbs[:share]
+		}
+		newWorkerVbucketMap[name] = append([]uint16{}, vbs...)
+	}
+
+	newWorkerVbucketMap[workerName] = movedVbs
+	return newWorkerVbucketMap
+}
+
+// removeWorkerFromVbMap spreads vbuckets of given worker across the others
+func (p *Producer) removeWorkerFromVbMap(workerVbucketMap map[string][]uint16, workerName string) map[string][]uint16 {
+	workerCount := len(workerVbucketMap) - 1
+	vbsDistribution := util.VbucketDistribution(workerVbucketMap[workerName], workerCount)
+
+	newWorkerVbucketMap := make(map[string][]uint16)
+	for i := 0; i < workerCount; i++ {
+		name := fmt.Sprintf("worker_%s_%d", p.appName, i)
+		newWorkerVbucketMap[name] = append(append([]uint16{}, workerVbucketMap[name]...), vbsDistribution[i]...)
+	}
+
+	newWorkerVbucketMap[workerName] = []uint16{}
+	return newWorkerVbucketMap
+}
+
+// notifyWorkerVbMapChange sends updated vbucket map to all consumers, and asks
+// only the ones whose vbuckets changed to give up or take over vbuckets
+func (p *Producer) notifyWorkerVbMapChange(prevWorkerVbucketMap map[string][]uint16) {
+	logPrefix := "Producer::notifyWorkerVbMapChange"
+
+	workerVbucketMap := make(map[string][]uint16)
+	func() {
+		p.workerVbMapRWMutex.RLock()
+		defer p.workerVbMapRWMutex.RUnlock()
+
+		for workerName, assignedVbs := range p.workerVbucketMap {
+			workerVbucketMap[workerName] = assignedVbs
+		}
+	}()
+
+	for _, c := range p.getConsumers() {
+		c.WorkerVbMapUpdate(workerVbucketMap)
+		c.SendAssignedVbs()
+
+		consumerName := c.ConsumerName()
+		if util.CompareSlices(prevWorkerVbucketMap[consumerName], workerVbucketMap[consumerName]) {
+			continue
+		}
+
+		logging.Infof("%s [%s:%d] Consumer: %s vbs len: %d dump: %s, notifying it about vbs reassignment",
+			logPrefix, p.appName, p.LenRunningConsumers(), consumerName,
+			len(workerVbucketMap[consumerName]), util.Condense(workerVbucketMap[consumerName]))
+		c.NotifyClusterChange()
+	}
+}
+
+// waitForVbHandover waits till given worker has stopped streaming all vbuckets
+// and the workers they moved to have taken them over
+func (p *Producer) waitForVbHandover(workerName string) error {
+	deadline := time.Now().Add(workerScaleTimeout)
+
+	for {
+		p.workerNameConsumerMapRWMutex.RLock()
+		c, ok := p.workerNameConsumerMap[workerName]
+		p.workerNameConsumerMapRWMutex.RUnlock()
+
+		if ok && len(c.InternalVbDistributionStats()) == 0 && p.vbsReassignmentDone() {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for worker: %s to give up its vbs", workerName)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (p *Producer) vbsReassignmentDone() bool {
+	for _, c := range p.getConsumers() {
+		if c.RebalanceStatus() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Producer) getAssignedVbs(workerName string) []uint16 {
+	p.workerVbMapRWMutex.RLock()
+	defer p.workerVbMapRWMutex.RUnlock()
+	return p.workerVbucketMap[workerName]
+}
@@ -0,0 +1,228 @@
+package producer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/util"
+)
+
+func testWorkerVbMap(appName string, workerCount, numVbuckets int) map[string][]uint16 {
+	vbs := make([]uint16, numVbuckets)
+	for i := range vbs {
+		vbs[i] = uint16(i)
+	}
+
+	workerVbucketMap := make(map[string][]uint16)
+	for i, assigned := range util.VbucketDistribution(vbs, workerCount) {
+		workerVbucketMap[fmt.Sprintf("worker_%s_%d", appName, i)] = assigned
+	}
+	return workerVbucketMap
+}
+
+// checkVbMap verifies that every vbucket is assigned exactly once and returns
+// number of vbuckets held by each worker
+func checkVbMap(t *testing.T, workerVbucketMap map[string][]uint16, numVbuckets int) map[string]int {
+	seen := make(map[uint16]string)
+	counts := make(map[string]int)
+
+	for name, vbs := range workerVbucketMap {
+		for _, vb := range vbs {
+			if owner, ok := seen[vb]; ok {
+				t.Fatalf("vb: %d assigned to both %s and %s", vb, owner, name)
+			}
+			seen[vb] = name
+		}
+		counts[name] = len(vbs)
+	}
+
+	if len(seen) != numVbuckets {
+		t.Fatalf("expected %d vbs to be assigned, got %d", numVbuckets, len(seen))
+	}
+	return counts
+}
+
+// moved returns vbuckets whose owner differs between the two maps
+func moved(prev, next map[string][]uint16) []uint16 {
+	owners := make(map[uint16]string)
+	for name, vbs := range prev {
+		for _, vb := range vbs {
+			owners[vb] = name
+		}
+	}
+
+	var vbs []uint16
+	for name, assigned := range next {
+		for _, vb := range assigned {
+			if owners[vb] != name {
+				vbs = append(vbs, vb)
+			}
+		}
+	}
+	sort.Sort(util.Uint16Slice(vbs))
+	return vbs
+}
+
+func TestAddWorkerToVbMap(t *testing.T) {
+	p := &Producer{appName: "fn"}
+
+	tests := []struct {
+		workerCount int
+		numVbuckets int
+	}{
+		{1, 1024},
+		{3, 1024},
+		{4, 1024},
+		{7, 64},
+		{3, 2},
+		{1, 0},
+	}
+
+	for _, test := range tests {
+		prev := testWorkerVbMap(p.appName, test.workerCount, test.numVbuckets)
+		newWorker := fmt.Sprintf("worker_%s_%d", p.appName, test.workerCount)
+		next := p.addWorkerToVbMap(prev, newWorker)
+
+		counts := checkVbMap(t, next, test.numVbuckets)
+		if len(counts) != test.workerCount+1 {
+			t.Errorf("wc: %d vbs: %d expected %d workers, got %d",
+				test.workerCount, test.numVbuckets, test.workerCount+1, len(counts))
+		}
+
+		// Shares differ by at most one vbucket
+		min, max := test.numVbuckets, 0
+		for _, count := range counts {
+			if count < min {
+				min = count
+			}
+			if count > max {
+				max = count
+			}
+		}
+		if max-min > 1 {
+			t.Errorf("wc: %d vbs: %d uneven shares: %v", test.workerCount, test.numVbuckets, counts)
+		}
+
+		// Only vbuckets handed to new worker move, existing workers keep the rest
+		movedVbs := moved(prev, next)
+		if len(movedVbs) != counts[newWorker] {
+			t.Errorf("wc: %d vbs: %d %d vbs moved while new worker got %d",
+				test.workerCount, test.numVbuckets, len(movedVbs), counts[newWorker])
+		}
+
+		// Input map is left as is, as it's used to tell which workers changed
+		if !util.CompareSlices(prev[newWorker], nil) || len(prev) != test.workerCount {
+			t.Errorf("wc: %d vbs: %d previous map modified", test.workerCount, test.numVbuckets)
+		}
+	}
+}
+
+func TestRemoveWorkerFromVbMap(t *testing.T) {
+	p := &Producer{appName: "fn"}
+
+	tests := []struct {
+		workerCount int
+		numVbuckets int
+	}{
+		{2, 1024},
+		{4, 1024},
+		{5, 1024},
+		{8, 64},
+		{3, 2},
+	}
+
+	for _, test := range tests {
+		prev := testWorkerVbMap(p.appName, test.workerCount, test.numVbuckets)
+		retiring := fmt.Sprintf("worker_%s_%d", p.appName, test.workerCount-1)
+		retiringVbs := len(prev[retiring])
+
+		next := p.removeWorkerFromVbMap(prev, retiring)
+
+		counts := checkVbMap(t, next, test.numVbuckets)
+
+		// Retiring worker stays in the map with nothing assigned
+		if vbs, ok := next[retiring]; !ok || len(vbs) != 0 {
+			t.Errorf("wc: %d vbs: %d retiring worker left with: %v", test.workerCount, test.numVbuckets, vbs)
+		}
+
+		// Only vbuckets of retiring worker move
+		if movedVbs := moved(prev, next); len(movedVbs) != retiringVbs {
+			t.Errorf("wc: %d vbs: %d %d vbs moved while retiring worker had %d",
+				test.workerCount, test.numVbuckets, len(movedVbs), retiringVbs)
+		}
+
+		// Vbuckets of retiring worker are spread evenly
+		for name, count := range counts {
+			if name == retiring {
+				continue
+			}
+			gained := count - len(prev[name])
+			if gained < retiringVbs/(test.workerCount-1) || gained > retiringVbs/(test.workerCount-1)+1 {
+				t.Errorf("wc: %d vbs: %d worker: %s gained %d of %d vbs",
+					test.workerCount, test.numVbuckets, name, gained, retiringVbs)
+			}
+		}
+	}
+}
+
+func TestAddThenRemoveWorker(t *testing.T) {
+	p := &Producer{appName: "fn"}
+
+	prev := testWorkerVbMap(p.appName, 3, 1024)
+	added := p.addWorkerToVbMap(prev, "worker_fn_3")
+	removed := p.removeWorkerFromVbMap(added, "worker_fn_3")
+	delete(removed, "worker_fn_3")
+
+	counts := checkVbMap(t, removed, 1024)
+	for name, count := range counts {
+		if count < 341 || count > 342 {
+			t.Errorf("worker: %s left with %d vbs", name, count)
+		}
+	}
+}
+
+func TestAutoscaleStepFor(t *testing.T) {
+	config := &common.HandlerConfig{
+		AutoscaleEventBacklog: 1000,
+		AutoscaleCPUThreshold: 80,
+		MinWorkerCount:        2,
+		MaxWorkerCount:        4,
+		MinCPPWorkerThrCount:  2,
+		MaxCPPWorkerThrCount:  3,
+	}
+
+	tests := []struct {
+		name string
+		load workerLoad
+		step autoscaleStep
+	}{
+		{"backlog", workerLoad{workerCount: 2, threadCount: 2, backlog: 5000}, autoscaleAddWorker},
+		{"cpu", workerLoad{workerCount: 3, threadCount: 2, cpuUsage: 90, cpuKnown: true}, autoscaleAddWorker},
+		{"threads at max workers", workerLoad{workerCount: 4, threadCount: 2, backlog: 8000}, autoscaleAddThread},
+		{"at max", workerLoad{workerCount: 4, threadCount: 3, backlog: 8000}, autoscaleNone},
+		{"within thresholds", workerLoad{workerCount: 3, threadCount: 2, backlog: 600, cpuUsage: 50, cpuKnown: true}, autoscaleNone},
+		{"threads removed first", workerLoad{workerCount: 4, threadCount: 3, backlog: 100}, autoscaleRemoveThread},
+		{"threads busy", workerLoad{workerCount: 4, threadCount: 3, cpuUsage: 30, cpuKnown: true}, autoscaleNone},
+		{"workers removed next", workerLoad{workerCount: 4, threadCount: 2, backlog: 100}, autoscaleRemoveWorker},
+		{"remaining workers would be busy", workerLoad{workerCount: 3, threadCount: 2, cpuUsage: 30, cpuKnown: true}, autoscaleNone},
+		{"at min", workerLoad{workerCount: 2, threadCount: 2}, autoscaleNone},
+	}
+
+	for _, test := range tests {
+		if step := autoscaleStepFor(test.load, config); step != test.step {
+			t.Errorf("%s: expected %s, got %s", test.name, test.step, step)
+		}
+	}
+}
+
+func TestCanScaleWorkersWhilePlannerRuns(t *testing.T) {
+	p := &Producer{plannerRWMutex: &sync.RWMutex{}}
+
+	p.setPlannerRunning(true)
+	if p.canScaleWorkers() {
+		t.Error("expected workers not to be scaled while planner is running")
+	}
+}
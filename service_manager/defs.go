@@ -7,6 +7,7 @@ import (
 
 	"github.com/couchbase/cbauth/service"
 	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -19,6 +20,7 @@ const (
 	metakvRebalanceTokenPath = metakvEventingPath + "rebalanceToken/"
 	metakvRebalanceProgress  = metakvEventingPath + "rebalanceProgress/"
 	metakvAppsRetryPath      = metakvEventingPath + "retry/"
+	metakvAppsRestartPath    = metakvEventingPath + "restartWorkers/"
 	metakvTempAppsPath       = metakvEventingPath + "tempApps/"
 	metakvChecksumPath       = metakvEventingPath + "checksum/"
 	metakvTempChecksumPath   = metakvEventingPath + "tempchecksum/"
@@ -42,10 +44,32 @@ const (
 	maxApplicationNameLength = 100
 	maxAliasLength           = 20 // Technically, there isn't any limit on a JavaScript variable length.
 	maxPrefixLength          = 16
+	maxEventSourceFileLength = 4096
 
 	rebalanceStalenessCounter = 200
 )
 
+var eventSourceValues = []string{common.EventSourceDcp, common.EventSourceFile}
+
+const (
+	rollbackPolicyReplay = "replay"
+	rollbackPolicySkip   = "skip"
+	rollbackPolicyPause  = "pause"
+)
+
+var rollbackPolicyValues = []string{rollbackPolicyReplay, rollbackPolicySkip, rollbackPolicyPause}
+
+// Seconds either side of span of a partition timer checker looks at by default
+const defaultTimerCheckMargin = 3600
+
+var timerInPastPolicyValues = []string{string(timers.InPastFire), string(timers.InPastReject)}
+
+var timerContextOverflowValues = []string{string(timers.OverflowReject), string(timers.OverflowCompress),
+	string(timers.OverflowChunk)}
+
+var missedTimerPolicyValues = []string{string(timers.MissedFireAll), string(timers.MissedLatestOnly),
+	string(timers.MissedRateLimit), string(timers.MissedDrop)}
+
 var (
 	errInvalidVersion = errors.New("invalid eventing version")
 
@@ -213,6 +237,9 @@ type stats struct {
 	RebalanceStats                  interface{} `json:"rebalance_stats,omitempty"`
 	SeqsProcessed                   interface{} `json:"seqs_processed,omitempty"`
 	SpanBlobDump                    interface{} `json:"span_blob_dump,omitempty"`
+	TimerFiringStats                interface{} `json:"timer_firing_stats,omitempty"`
+	TimerFiringStatsPerPartition    interface{} `json:"timer_firing_stats_per_partition,omitempty"`
+	TimerLagPercentileStats         interface{} `json:"timer_lag_percentile_stats,omitempty"`
 	VbDcpEventsRemaining            interface{} `json:"dcp_event_backlog_per_vb,omitempty"`
 	VbDistributionStatsFromMetadata interface{} `json:"vb_distribution_stats_from_metadata,omitempty"`
 	VbSeqnoStats                    interface{} `json:"vb_seq_no_stats,omitempty"`
@@ -29,6 +29,7 @@ import (
 	"github.com/couchbase/eventing/gen/flatbuf/cfg"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/parser"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 	"github.com/google/flatbuffers/go"
 )
@@ -131,6 +132,12 @@ func (m *ServiceMgr) deletePrimaryStore(appName string) (info *runtimeInfo) {
 		return
 	}
 
+	// Timers kept for next deployment are located off definition and settings
+	// of function, so they're purged before those are deleted
+	if err := m.superSup.PurgeTimers(appName); err != nil {
+		logging.Errorf("%s Function: %s failed to purge timers, err: %v", logPrefix, appName, err)
+	}
+
 	settingPath := metakvAppSettingsPath + appName
 	err := util.MetaKvDelete(settingPath, nil)
 	if err != nil {
@@ -2540,6 +2547,10 @@ func (m *ServiceMgr) functionsHandler(w http.ResponseWriter, r *http.Request) {
 	functionsUndeploy := regexp.MustCompile("^/api/v1/functions/(.*[^/])/undeploy/?$")
 	functionsPause := regexp.MustCompile("^/api/v1/functions/(.*[^/])/pause/?$")
 	functionsResume := regexp.MustCompile("^/api/v1/functions/(.*[^/])/resume/?$")
+	functionsRestartWorkers := regexp.MustCompile("^/api/v1/functions/(.*[^/])/restart-workers/?$")
+	functionsTimers := regexp.MustCompile("^/api/v1/functions/(.*[^/])/timers/?$")
+	functionsTimersCheck := regexp.MustCompile("^/api/v1/functions/(.*[^/])/timers/check/?$")
+	functionsTimersCancel := regexp.MustCompile("^/api/v1/functions/(.*[^/])/timers/cancel/?$")
 
 	if match := functionsNameRetry.FindStringSubmatch(r.URL.Path); len(match) != 0 {
 		appName := match[1]
@@ -2689,6 +2700,170 @@ func (m *ServiceMgr) functionsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+	} else if match := functionsRestartWorkers.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		info := &runtimeInfo{}
+		if r.Method != "POST" {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("Only POST call allowed to this endpoint")
+			m.sendErrorInfo(w, info)
+			return
+		}
+		appName := match[1]
+
+		audit.Log(auditevent.RestartWorkers, r, appName)
+
+		if info = m.notifyRestartWorkersToAllProducers(appName); info.Code != m.statusCodes.ok.Code {
+			m.sendErrorInfo(w, info)
+			return
+		}
+
+	} else if match := functionsTimersCheck.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+
+		var response interface{}
+		info := &runtimeInfo{}
+		switch r.Method {
+		case "GET":
+			audit.Log(auditevent.ListTimers, r, appName)
+
+			if response, info = m.timerCheckStatus(appName); info.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, info)
+				return
+			}
+
+		case "POST":
+			audit.Log(auditevent.CheckTimers, r, appName)
+
+			request, info := m.parseTimerCheckRequest(r)
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, info)
+				return
+			}
+
+			if info = m.checkTimers(appName, request); info.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, info)
+				return
+			}
+			response = map[string]bool{"started": true, "dry_run": request.DryRun}
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := json.MarshalIndent(response, "", " ")
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("failed to marshal timer check report, err : %v", err)
+			logging.Errorf("%s %s", logPrefix, info.Info)
+			m.sendErrorInfo(w, info)
+			return
+		}
+
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+		fmt.Fprintf(w, "%s", string(data))
+
+	} else if match := functionsTimersCancel.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		audit.Log(auditevent.ListTimers, r, appName)
+
+		report, info := m.timerCancelStatus(appName)
+		if info.Code != m.statusCodes.ok.Code {
+			m.sendErrorInfo(w, info)
+			return
+		}
+
+		data, err := json.MarshalIndent(report, "", " ")
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("failed to marshal timer cancellation report, err : %v", err)
+			logging.Errorf("%s %s", logPrefix, info.Info)
+			m.sendErrorInfo(w, info)
+			return
+		}
+
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+		fmt.Fprintf(w, "%s", string(data))
+
+	} else if match := functionsTimers.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+
+		query, info := m.parseTimerQuery(r)
+		if info.Code != m.statusCodes.ok.Code {
+			m.sendErrorInfo(w, info)
+			return
+		}
+
+		var response interface{}
+		switch r.Method {
+		case "GET":
+			audit.Log(auditevent.ListTimers, r, appName)
+
+			if response, info = m.listTimers(appName, query); info.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, info)
+				return
+			}
+
+		case "DELETE":
+			audit.Log(auditevent.CancelTimers, r, appName)
+
+			if query.Reference == "" && query.From == 0 && query.To == 0 {
+				info.Code = m.statusCodes.errInvalidConfig.Code
+				info.Info = fmt.Sprintf("Function: %s either reference or from/to is needed to cancel timers", appName)
+				m.sendErrorInfo(w, info)
+				return
+			}
+
+			if query.Reference != "" {
+				if response, info = m.cancelTimers(appName, query); info.Code != m.statusCodes.ok.Code {
+					m.sendErrorInfo(w, info)
+					return
+				}
+			} else {
+				// Range may hold any number of timers, so it's cancelled in background
+				if info = m.cancelTimersInRange(appName, query); info.Code != m.statusCodes.ok.Code {
+					m.sendErrorInfo(w, info)
+					return
+				}
+				response = map[string]bool{"started": true}
+			}
+
+		case "POST":
+			audit.Log(auditevent.CreateTimer, r, appName)
+
+			request, info := m.parseTimerRequest(r)
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, info)
+				return
+			}
+
+			if response, info = m.createTimer(appName, request); info.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, info)
+				return
+			}
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := json.MarshalIndent(response, "", " ")
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("failed to marshal timers, err : %v", err)
+			logging.Errorf("%s %s", logPrefix, info.Info)
+			m.sendErrorInfo(w, info)
+			return
+		}
+
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+		fmt.Fprintf(w, "%s", string(data))
+
 	} else if match := functionsDeploy.FindStringSubmatch(r.URL.Path); len(match) != 0 {
 		info := &runtimeInfo{}
 		if r.Method != "POST" {
@@ -2962,6 +3137,380 @@ func (m *ServiceMgr) notifyRetryToAllProducers(appName string, r *retry) (info *
 	return
 }
 
+// parseTimerQuery reads timer selection from query parameters: from, to,
+// partition, reference, limit, cursor and series
+func (m *ServiceMgr) parseTimerQuery(r *http.Request) (*common.TimerQuery, *runtimeInfo) {
+	info := &runtimeInfo{}
+	values := r.URL.Query()
+
+	query := &common.TimerQuery{Partition: -1}
+	ints := []struct {
+		name string
+		val  *int64
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	}
+	for _, param := range ints {
+		if val := values.Get(param.name); val != "" {
+			parsed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || parsed < 0 {
+				info.Code = m.statusCodes.errInvalidConfig.Code
+				info.Info = fmt.Sprintf("%s should be a unix timestamp in seconds, got: %s", param.name, val)
+				return nil, info
+			}
+			*param.val = parsed
+		}
+	}
+
+	if val := values.Get("partition"); val != "" {
+		partn, err := strconv.Atoi(val)
+		if err != nil || partn < 0 {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("partition should be a non-negative integer, got: %s", val)
+			return nil, info
+		}
+		query.Partition = partn
+	}
+
+	if val := values.Get("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit <= 0 {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("limit should be a positive integer, got: %s", val)
+			return nil, info
+		}
+		query.Limit = limit
+	}
+
+	if query.To != 0 && query.To < query.From {
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		info.Info = fmt.Sprintf("to: %d is before from: %d", query.To, query.From)
+		return nil, info
+	}
+
+	query.Reference = values.Get("reference")
+	query.Cursor = values.Get("cursor")
+
+	if val := values.Get("series"); val != "" {
+		series, err := strconv.ParseBool(val)
+		if err != nil {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("series should be true or false, got: %s", val)
+			return nil, info
+		}
+		query.Series = series
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return query, info
+}
+
+func (m *ServiceMgr) listTimers(appName string, query *common.TimerQuery) (*common.TimerPage, *runtimeInfo) {
+	logPrefix := "ServiceMgr::listTimers"
+
+	info := m.checkTimersAccessible(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return nil, info
+	}
+
+	page, err := m.superSup.ListTimers(appName, query)
+	if err != nil {
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to list timers, err: %v", appName, err)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return nil, info
+	}
+
+	return page, info
+}
+
+func (m *ServiceMgr) cancelTimers(appName string, query *common.TimerQuery) (map[string]int, *runtimeInfo) {
+	logPrefix := "ServiceMgr::cancelTimers"
+
+	info := m.checkTimersAccessible(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return nil, info
+	}
+
+	cancelled, err := m.superSup.CancelTimers(appName, query)
+	if err != nil {
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to cancel timers after cancelling %d, err: %v", appName, cancelled, err)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return nil, info
+	}
+
+	logging.Infof("%s Function: %s cancelled %d timers", logPrefix, appName, cancelled)
+	return map[string]int{"cancelled": cancelled}, info
+}
+
+func (m *ServiceMgr) cancelTimersInRange(appName string, query *common.TimerQuery) *runtimeInfo {
+	logPrefix := "ServiceMgr::cancelTimersInRange"
+
+	info := m.checkTimersAccessible(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return info
+	}
+
+	if err := m.superSup.CancelTimersInRange(appName, query); err != nil {
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to start cancelling timers, err: %v", appName, err)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return info
+	}
+
+	logging.Infof("%s Function: %s started cancelling timers, query: %+v", logPrefix, appName, *query)
+	return info
+}
+
+func (m *ServiceMgr) timerCancelStatus(appName string) (*common.TimerCancelReport, *runtimeInfo) {
+	info := m.checkTimersAccessible(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return nil, info
+	}
+
+	report, err := m.superSup.TimerCancelStatus(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to read timer cancellation report, err: %v", appName, err)
+		return nil, info
+	}
+	// No range has been cancelled since function was deployed
+	if report == nil {
+		report = &common.TimerCancelReport{}
+	}
+
+	return report, info
+}
+
+// parseTimerRequest reads timer to create from request body. Callback,
+// reference and due time are needed.
+func (m *ServiceMgr) parseTimerRequest(r *http.Request) (*common.TimerRequest, *runtimeInfo) {
+	info := &runtimeInfo{}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		info.Code = m.statusCodes.errReadReq.Code
+		info.Info = fmt.Sprintf("Failed to read request, err : %v", err)
+		return nil, info
+	}
+
+	request := &common.TimerRequest{}
+	if err = json.Unmarshal(body, request); err != nil {
+		info.Code = m.statusCodes.errUnmarshalPld.Code
+		info.Info = fmt.Sprintf("Failed to unmarshal request, err : %v", err)
+		return nil, info
+	}
+
+	if request.Callback == "" || request.Reference == "" || (request.Due <= 0 && request.Schedule == nil) {
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		info.Info = fmt.Sprintf("callback, reference and either due or schedule are needed to create a timer, got: %+v", *request)
+		return nil, info
+	}
+
+	if request.Schedule != nil {
+		data, _ := json.Marshal(request.Schedule)
+		schedule := timers.Schedule{}
+		if err = json.Unmarshal(data, &schedule); err == nil {
+			err = schedule.Validate()
+		}
+		if err != nil {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("invalid schedule: %s, err: %v", data, err)
+			return nil, info
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return request, info
+}
+
+func (m *ServiceMgr) createTimer(appName string, request *common.TimerRequest) (*common.TimerInfo, *runtimeInfo) {
+	logPrefix := "ServiceMgr::createTimer"
+
+	info := m.checkTimersAccessible(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return nil, info
+	}
+
+	timer, err := m.superSup.CreateTimer(appName, request)
+	switch err {
+	case nil:
+	case timers.ErrTimerInPast, timers.ErrContextTooLarge:
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		info.Info = fmt.Sprintf("Function: %s timer rejected, err: %v", appName, err)
+		return nil, info
+	default:
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to create timer, err: %v", appName, err)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return nil, info
+	}
+
+	logging.Infof("%s Function: %s created timer in partition: %d due: %d", logPrefix, appName, timer.Partition, timer.Due)
+	return timer, info
+}
+
+// parseTimerCheckRequest reads checker options from query parameters: dry_run,
+// partition and margin
+func (m *ServiceMgr) parseTimerCheckRequest(r *http.Request) (*common.TimerCheckRequest, *runtimeInfo) {
+	info := &runtimeInfo{}
+	values := r.URL.Query()
+
+	request := &common.TimerCheckRequest{Partition: -1, Margin: defaultTimerCheckMargin}
+
+	if val := values.Get("dry_run"); val != "" {
+		dryRun, err := strconv.ParseBool(val)
+		if err != nil {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("dry_run should be true or false, got: %s", val)
+			return nil, info
+		}
+		request.DryRun = dryRun
+	}
+
+	if val := values.Get("partition"); val != "" {
+		partn, err := strconv.Atoi(val)
+		if err != nil || partn < 0 {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("partition should be a non-negative integer, got: %s", val)
+			return nil, info
+		}
+		request.Partition = partn
+	}
+
+	if val := values.Get("margin"); val != "" {
+		margin, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || margin < 0 {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("margin should be a non-negative number of seconds, got: %s", val)
+			return nil, info
+		}
+		request.Margin = margin
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return request, info
+}
+
+func (m *ServiceMgr) checkTimers(appName string, request *common.TimerCheckRequest) *runtimeInfo {
+	logPrefix := "ServiceMgr::checkTimers"
+
+	info := m.checkTimersCheckable(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return info
+	}
+
+	if err := m.superSup.CheckTimers(appName, request); err != nil {
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to start timer check, err: %v", appName, err)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return info
+	}
+
+	logging.Infof("%s Function: %s started timer check, request: %+v", logPrefix, appName, *request)
+	return info
+}
+
+func (m *ServiceMgr) timerCheckStatus(appName string) (*common.TimerCheckReport, *runtimeInfo) {
+	info := m.checkTimersCheckable(appName)
+	if info.Code != m.statusCodes.ok.Code {
+		return nil, info
+	}
+
+	report, err := m.superSup.TimerCheckStatus(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errTimerStore.Code
+		info.Info = fmt.Sprintf("Function: %s failed to read timer check report, err: %v", appName, err)
+		return nil, info
+	}
+	// Checker hasn't been run since function was deployed or undeployed
+	if report == nil {
+		report = &common.TimerCheckReport{Issues: make([]common.TimerCheckIssue, 0)}
+	}
+
+	return report, info
+}
+
+// Timers are read through producer of function, so it has to be running here
+func (m *ServiceMgr) checkTimersAccessible(appName string) *runtimeInfo {
+	info := &runtimeInfo{}
+
+	if !m.checkAppExists(appName) {
+		info.Code = m.statusCodes.errAppNotFound.Code
+		info.Info = fmt.Sprintf("Function: %s not found", appName)
+		return info
+	}
+
+	if !m.checkIfDeployedAndRunning(appName) {
+		info.Code = m.statusCodes.errAppNotDeployed.Code
+		info.Info = fmt.Sprintf("Function: %s is not in deployed state, timers can't be accessed", appName)
+		return info
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return info
+}
+
+// Timers of an undeployed function, which have no producer to go through, are
+// checked by supervisor, so that documents left behind by undeploy are reached
+func (m *ServiceMgr) checkTimersCheckable(appName string) *runtimeInfo {
+	info := &runtimeInfo{}
+
+	if !m.checkAppExists(appName) {
+		info.Code = m.statusCodes.errAppNotFound.Code
+		info.Info = fmt.Sprintf("Function: %s not found", appName)
+		return info
+	}
+
+	if m.superSup.GetAppState(appName) != common.AppStateUndeployed && !m.checkIfDeployedAndRunning(appName) {
+		info.Code = m.statusCodes.errAppNotDeployed.Code
+		info.Info = fmt.Sprintf("Function: %s is being deployed, undeployed or is paused, timers can't be checked", appName)
+		return info
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return info
+}
+
+// notifyRestartWorkersToAllProducers asks every eventing node to drain and
+// restart workers of the function, one worker at a time
+func (m *ServiceMgr) notifyRestartWorkersToAllProducers(appName string) (info *runtimeInfo) {
+	logPrefix := "ServiceMgr::notifyRestartWorkersToAllProducers"
+
+	info = &runtimeInfo{}
+
+	if m.superSup.GetAppState(appName) != common.AppStateEnabled {
+		info.Code = m.statusCodes.errAppNotDeployed.Code
+		info.Info = fmt.Sprintf("Function: %s isn't deployed or is paused, workers can't be restarted", appName)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return
+	}
+
+	if info = m.checkRebalanceStatus(); info.Code != m.statusCodes.ok.Code {
+		info.Info = fmt.Sprintf("Function: %s workers can't be restarted, %s", appName, info.Info)
+		return
+	}
+
+	// Every request carries a new value, so that it's noticed even if an
+	// earlier one for the same function is still around
+	requestID := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	err := util.MetakvSet(metakvAppsRestartPath+appName, requestID, nil)
+	if err != nil {
+		info.Code = m.statusCodes.errMetakvWriteFailed.Code
+		info.Info = fmt.Sprintf("unable to set metakv path for restart of workers, err : %v", err)
+		logging.Errorf("%s %s", logPrefix, info.Info)
+		return
+	}
+
+	logging.Infof("%s Function: %s requested restart of workers", logPrefix, appName)
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
 func (m *ServiceMgr) statusHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if !m.validateAuth(w, r, EventingPermissionManage) {
@@ -3141,6 +3690,40 @@ func percentileN(latencyStats map[string]uint64, p int) int {
 	return 0
 }
 
+func percentiles(latencyStats map[string]uint64) map[string]int {
+	ls := make(map[string]int)
+	ls["50"] = percentileN(latencyStats, 50)
+	ls["80"] = percentileN(latencyStats, 80)
+	ls["90"] = percentileN(latencyStats, 90)
+	ls["95"] = percentileN(latencyStats, 95)
+	ls["99"] = percentileN(latencyStats, 99)
+	ls["100"] = percentileN(latencyStats, 100)
+	return ls
+}
+
+// mergeTimerFiringStats sums up timer firing stats of all partitions
+func mergeTimerFiringStats(firingStats map[int]*common.TimerFiringStats) *common.TimerFiringStats {
+	merged := &common.TimerFiringStats{
+		FireLag:     make(common.StatsData),
+		ScanTime:    make(common.StatsData),
+		ScanLookups: make(common.StatsData),
+	}
+
+	for _, partnStats := range firingStats {
+		merged.Pending += partnStats.Pending
+		for bin, count := range partnStats.FireLag {
+			merged.FireLag[bin] += count
+		}
+		for bin, count := range partnStats.ScanTime {
+			merged.ScanTime[bin] += count
+		}
+		for bin, count := range partnStats.ScanLookups {
+			merged.ScanLookups[bin] += count
+		}
+	}
+	return merged
+}
+
 func (m *ServiceMgr) populateStats(fullStats bool) []stats {
 	statsList := make([]stats, 0)
 	for _, app := range m.getTempStoreAll() {
@@ -3165,15 +3748,14 @@ func (m *ServiceMgr) populateStats(fullStats bool) []stats {
 			stats.PlannerStats = m.superSup.PlannerStats(app.Name)
 			stats.VbDistributionStatsFromMetadata = m.superSup.VbDistributionStatsFromMetadata(app.Name)
 
-			latencyStats := m.superSup.GetLatencyStats(app.Name)
-			ls := make(map[string]int)
-			ls["50"] = percentileN(latencyStats, 50)
-			ls["80"] = percentileN(latencyStats, 80)
-			ls["90"] = percentileN(latencyStats, 90)
-			ls["95"] = percentileN(latencyStats, 95)
-			ls["99"] = percentileN(latencyStats, 99)
-			ls["100"] = percentileN(latencyStats, 100)
-			stats.LatencyPercentileStats = ls
+			stats.LatencyPercentileStats = percentiles(m.superSup.GetLatencyStats(app.Name))
+
+			firingStats := m.superSup.GetTimerFiringStats(app.Name)
+			if len(firingStats) > 0 {
+				timerStats := mergeTimerFiringStats(firingStats)
+				stats.TimerFiringStats = timerStats
+				stats.TimerLagPercentileStats = percentiles(timerStats.FireLag)
+			}
 
 			if m.rebalancer != nil {
 				rebalanceStats := make(map[string]interface{})
@@ -3196,6 +3778,7 @@ func (m *ServiceMgr) populateStats(fullStats bool) []stats {
 
 				stats.LatencyStats = m.superSup.GetLatencyStats(app.Name)
 				stats.CurlLatencyStats = m.superSup.GetCurlLatencyStats(app.Name)
+				stats.TimerFiringStatsPerPartition = firingStats
 				stats.SeqsProcessed = m.superSup.GetSeqsProcessed(app.Name)
 
 				spanBlobDump, err := m.superSup.SpanBlobDump(app.Name)
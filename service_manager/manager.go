@@ -193,6 +193,7 @@ func (m *ServiceMgr) initService() {
 		sslAddr := net.JoinHostPort("", m.adminSSLPort)
 
 		refresh := func() error {
+			util.RefreshKVTLSConfig()
 			if sslsrv != nil {
 				reload = true
 				sslsrv.Shutdown(context.Background())
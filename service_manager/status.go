@@ -91,6 +91,7 @@ type statusCodes struct {
 	errSyncGatewayEnabled     statusBase
 	errAppNotFound            statusBase
 	errMetakvWriteFailed      statusBase
+	errTimerStore             statusBase
 }
 
 func (m *ServiceMgr) getDisposition(code int) int {
@@ -191,6 +192,8 @@ func (m *ServiceMgr) getDisposition(code int) int {
 		return http.StatusNotFound
 	case m.statusCodes.errMetakvWriteFailed.Code:
 		return http.StatusInternalServerError
+	case m.statusCodes.errTimerStore.Code:
+		return http.StatusInternalServerError
 	default:
 		logging.Warnf("Unknown status code: %v", code)
 		return http.StatusInternalServerError
@@ -248,6 +251,7 @@ func (m *ServiceMgr) initErrCodes() {
 		errSyncGatewayEnabled:     statusBase{"ERR_SYNC_GATEWAY_ENABLED", 52},
 		errAppNotFound:            statusBase{"ERR_APP_NOT_FOUND", 53},
 		errMetakvWriteFailed:      statusBase{"ERR_METAKV_WRITE_FAILED", 54},
+		errTimerStore:             statusBase{"ERR_TIMER_STORE", 55},
 	}
 
 	errors := []errorPayload{
@@ -514,6 +518,11 @@ func (m *ServiceMgr) initErrCodes() {
 			Code:        m.statusCodes.errMetakvWriteFailed.Code,
 			Description: "Metakv write failed",
 		},
+		{
+			Name:        m.statusCodes.errTimerStore.Name,
+			Code:        m.statusCodes.errTimerStore.Code,
+			Description: "Timer store operation failed",
+		},
 	}
 
 	m.errorCodes = make(map[int]errorPayload)
@@ -18,6 +18,7 @@ import (
 	"github.com/couchbase/eventing/gen/flatbuf/cfg"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/parser"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -94,6 +95,7 @@ func (m *ServiceMgr) fillMissingWithDefaults(appName string, settings map[string
 
 	// Handler related configurations
 	fillMissingDefault(app, settings, "n1ql_prepare_all", false)
+	fillMissingDefault(app, settings, "adopt_timers", false)
 	fillMissingDefault(app, settings, "checkpoint_interval", float64(60000))
 	fillMissingDefault(app, settings, "cleanup_timers", false)
 	fillMissingDefault(app, settings, "cpp_worker_thread_count", float64(2))
@@ -106,10 +108,31 @@ func (m *ServiceMgr) fillMissingWithDefaults(appName string, settings map[string
 	fillMissingDefault(app, settings, "log_level", "INFO")
 	fillMissingDefault(app, settings, "poll_bucket_interval", float64(10))
 	fillMissingDefault(app, settings, "sock_batch_size", float64(100))
+	fillMissingDefault(app, settings, "dcp_event_batch_size", float64(16))
+	fillMissingDefault(app, settings, "enriched_meta", false)
+	fillMissingDefault(app, settings, "idempotency_keys", false)
+	fillMissingDefault(app, settings, "ipc_capture", false)
+	fillMissingDefault(app, settings, "ipc_capture_max_size", float64(1024*1024*64))
+	fillMissingDefault(app, settings, "ipc_capture_max_files", float64(4))
+	fillMissingDefault(app, settings, "priority_key_prefixes", []interface{}{})
+	fillMissingDefault(app, settings, "priority_field", "")
+	fillMissingDefault(app, settings, "priority_field_value", "")
 	fillMissingDefault(app, settings, "tick_duration", float64(60000))
 	fillMissingDefault(app, settings, "timer_context_size", float64(1024))
+	fillMissingDefault(app, settings, "timer_context_overflow", string(timers.OverflowReject))
+	fillMissingDefault(app, settings, "timer_in_past_policy", string(timers.InPastFire))
+	fillMissingDefault(app, settings, "timer_resolution", float64(timers.Resolution))
+	fillMissingDefault(app, settings, "missed_timer_policy", string(timers.MissedFireAll))
+	fillMissingDefault(app, settings, "missed_timer_threshold", float64(timers.MissedThreshold))
+	fillMissingDefault(app, settings, "missed_timer_rate", float64(timers.MissedRate))
 	fillMissingDefault(app, settings, "undeploy_routine_count", float64(6))
 	fillMissingDefault(app, settings, "worker_count", float64(3))
+	fillMissingDefault(app, settings, "worker_autoscale", false)
+	fillMissingDefault(app, settings, "min_worker_count", settings["worker_count"])
+	fillMissingDefault(app, settings, "max_worker_count", settings["worker_count"])
+	fillMissingDefault(app, settings, "max_cpp_worker_thread_count", settings["cpp_worker_thread_count"])
+	fillMissingDefault(app, settings, "autoscale_event_backlog", float64(10000))
+	fillMissingDefault(app, settings, "autoscale_cpu_threshold", float64(80))
 	fillMissingDefault(app, settings, "worker_feedback_queue_cap", float64(500))
 	fillMissingDefault(app, settings, "worker_queue_cap", float64(100*1000))
 	fillMissingDefault(app, settings, "worker_queue_mem_cap", float64(1024))
@@ -139,6 +162,12 @@ func (m *ServiceMgr) fillMissingWithDefaults(appName string, settings map[string
 	fillMissingDefault(app, settings, "data_chan_size", float64(50))
 	fillMissingDefault(app, settings, "dcp_gen_chan_size", float64(10000))
 	fillMissingDefault(app, settings, "dcp_num_connections", float64(1))
+	fillMissingDefault(app, settings, "dcp_connection_buffer_size", float64(20*1024*1024))
+	fillMissingDefault(app, settings, "dcp_buffer_ack_threshold", float64(0.1))
+	fillMissingDefault(app, settings, "dcp_adaptive_ack", false)
+	fillMissingDefault(app, settings, "dcp_ack_throttle_watermark", float64(0.8))
+	fillMissingDefault(app, settings, "dcp_rollback_policy", rollbackPolicyReplay)
+	fillMissingDefault(app, settings, "event_source", common.EventSourceDcp)
 
 	// N1QL related configuration
 	fillMissingDefault(app, settings, "n1ql_consistency", "none")
@@ -1,6 +1,7 @@
 package servicemanager
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"net"
@@ -14,6 +15,7 @@ import (
 	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/parser"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -325,6 +327,10 @@ func (m *ServiceMgr) validateConfig(c map[string]interface{}) (info *runtimeInfo
 		return
 	}
 
+	if info = m.validateBoolean("enable_kv_tls", true, c); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
 	if info = m.validatePositiveInteger("ram_quota", c); info.Code != m.statusCodes.ok.Code {
 		return
 	}
@@ -682,6 +688,21 @@ func (m *ServiceMgr) validateStringMustExist(field string, maxLength int, settin
 	return
 }
 
+func (m *ServiceMgr) validateString(field string, settings map[string]interface{}) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	info.Code = m.statusCodes.errInvalidConfig.Code
+
+	if val, ok := settings[field]; ok {
+		if _, ok = val.(string); !ok {
+			info.Info = fmt.Sprintf("%s must be a string", field)
+			return
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
 func (m *ServiceMgr) validatePositiveInteger(field string, settings map[string]interface{}) (info *runtimeInfo) {
 	info = &runtimeInfo{}
 	info.Code = m.statusCodes.errInvalidConfig.Code
@@ -732,6 +753,26 @@ func (m *ServiceMgr) validateNonNegativeInteger(field string, settings map[strin
 	return
 }
 
+func (m *ServiceMgr) validateFraction(field string, settings map[string]interface{}) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	info.Code = m.statusCodes.errInvalidConfig.Code
+
+	if val, ok := settings[field]; ok {
+		if info = m.validateNumber(field, settings); info.Code != m.statusCodes.ok.Code {
+			return
+		}
+
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		if val.(float64) <= 0 || val.(float64) > 1 {
+			info.Info = fmt.Sprintf("%s must be greater than 0 and at most 1", field)
+			return
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
 func (m *ServiceMgr) validateTimerContextSize(field string, settings map[string]interface{}) (info *runtimeInfo) {
 	info = &runtimeInfo{}
 	info.Code = m.statusCodes.errInvalidConfig.Code
@@ -752,6 +793,56 @@ func (m *ServiceMgr) validateTimerContextSize(field string, settings map[string]
 	return
 }
 
+func (m *ServiceMgr) validateTimerResolution(field string, settings map[string]interface{}) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	info.Code = m.statusCodes.errInvalidConfig.Code
+
+	if val, ok := settings[field]; ok {
+		if int64(val.(float64)) < timers.MinResolution || int64(val.(float64)) > timers.MaxResolution {
+			info.Info = fmt.Sprintf("%s value must be between %d and %d seconds", field, timers.MinResolution, timers.MaxResolution)
+			return
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// validateKeptTimerResolution rejects a change of timer_resolution while an
+// undeployed function keeps timers for its next deployment, as workers lay rows
+// out at configured resolution and would never reach rows of kept timers
+func (m *ServiceMgr) validateKeptTimerResolution(appName string, settings map[string]interface{}) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	info.Code = m.statusCodes.ok.Code
+
+	val, ok := settings["timer_resolution"]
+	if !ok || m.superSup.GetAppState(appName) != common.AppStateUndeployed {
+		return
+	}
+
+	sData, err := util.MetakvGet(metakvAppSettingsPath + appName)
+	if err != nil || len(sData) == 0 {
+		return
+	}
+	stored := make(map[string]interface{})
+	if err = json.Unmarshal(sData, &stored); err != nil {
+		return
+	}
+	if adopt, _ := stored["adopt_timers"].(bool); !adopt {
+		return
+	}
+
+	kept, ok := stored["timer_resolution"].(float64)
+	if !ok {
+		kept = float64(timers.Resolution)
+	}
+	if val.(float64) != kept {
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		info.Info = fmt.Sprintf("timer_resolution can't be changed from %d seconds while function keeps timers for next deployment", int64(kept))
+	}
+	return
+}
+
 func (m *ServiceMgr) validatePossibleValues(field string, settings map[string]interface{}, possibleValues []string) (info *runtimeInfo) {
 	info = &runtimeInfo{}
 	info.Code = m.statusCodes.errInvalidConfig.Code
@@ -791,6 +882,10 @@ func (m *ServiceMgr) validateSettings(appName string, settings map[string]interf
 		return
 	}
 
+	if info = m.validateBoolean("adopt_timers", true, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
 	if info = m.validatePositiveInteger("checkpoint_interval", settings); info.Code != m.statusCodes.ok.Code {
 		return
 	}
@@ -808,6 +903,26 @@ func (m *ServiceMgr) validateSettings(appName string, settings map[string]interf
 		return
 	}
 
+	if info = m.validatePossibleValues("dcp_rollback_policy", settings, rollbackPolicyValues); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePossibleValues("event_source", settings, eventSourceValues); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if settings["event_source"] == common.EventSourceFile {
+		if info = m.validateStringMustExist("event_source_file", maxEventSourceFileLength, settings); info.Code != m.statusCodes.ok.Code {
+			return
+		}
+
+		if path, ok := settings["event_source_file"].(string); !ok || path == "" {
+			info.Code = m.statusCodes.errInvalidConfig.Code
+			info.Info = fmt.Sprintf("event_source_file must be specified when event_source is %s", common.EventSourceFile)
+			return
+		}
+	}
+
 	if info = m.validatePositiveInteger("deadline_timeout", settings); info.Code != m.statusCodes.ok.Code {
 		return
 	}
@@ -853,6 +968,42 @@ func (m *ServiceMgr) validateSettings(appName string, settings map[string]interf
 		return
 	}
 
+	if info = m.validatePositiveInteger("dcp_event_batch_size", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateBoolean("enriched_meta", true, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateBoolean("idempotency_keys", true, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateBoolean("ipc_capture", true, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("ipc_capture_max_size", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("ipc_capture_max_files", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateStringArray("priority_key_prefixes", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateString("priority_field", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateString("priority_field_value", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
 	if info = m.validatePositiveInteger("timer_context_size", settings); info.Code != m.statusCodes.ok.Code {
 		return
 	}
@@ -861,6 +1012,38 @@ func (m *ServiceMgr) validateSettings(appName string, settings map[string]interf
 		return
 	}
 
+	if info = m.validatePossibleValues("timer_context_overflow", settings, timerContextOverflowValues); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("timer_resolution", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateTimerResolution("timer_resolution", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateKeptTimerResolution(appName, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePossibleValues("timer_in_past_policy", settings, timerInPastPolicyValues); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePossibleValues("missed_timer_policy", settings, missedTimerPolicyValues); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("missed_timer_threshold", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("missed_timer_rate", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
 	if info = m.validatePositiveInteger("tick_duration", settings); info.Code != m.statusCodes.ok.Code {
 		return
 	}
@@ -869,6 +1052,42 @@ func (m *ServiceMgr) validateSettings(appName string, settings map[string]interf
 		return
 	}
 
+	if info = m.validateBoolean("worker_autoscale", true, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("min_worker_count", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("max_worker_count", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("max_cpp_worker_thread_count", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("autoscale_event_backlog", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validatePositiveInteger("autoscale_cpu_threshold", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if settings["worker_autoscale"] == true && settings["min_worker_count"].(float64) > settings["max_worker_count"].(float64) {
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		info.Info = "min_worker_count can not be greater than max_worker_count"
+		return
+	}
+
+	if settings["worker_autoscale"] == true && settings["cpp_worker_thread_count"].(float64) > settings["max_cpp_worker_thread_count"].(float64) {
+		info.Code = m.statusCodes.errInvalidConfig.Code
+		info.Info = "cpp_worker_thread_count can not be greater than max_cpp_worker_thread_count"
+		return
+	}
+
 	if info = m.validatePositiveInteger("worker_feedback_queue_cap", settings); info.Code != m.statusCodes.ok.Code {
 		return
 	}
@@ -958,6 +1177,22 @@ func (m *ServiceMgr) validateSettings(appName string, settings map[string]interf
 		return
 	}
 
+	if info = m.validatePositiveInteger("dcp_connection_buffer_size", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateFraction("dcp_buffer_ack_threshold", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateBoolean("dcp_adaptive_ack", true, settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateFraction("dcp_ack_throttle_watermark", settings); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
 	// N1QL related configuration
 	if info = m.validatePossibleValues("n1ql_consistency", settings, m.consistencyValues); info.Code != m.statusCodes.ok.Code {
 		return
@@ -0,0 +1,102 @@
+package shmipc
+
+import (
+	"errors"
+	"time"
+)
+
+// Layout of a shared memory segment, eventing-consumer maps the same segment
+// and must agree on every offset below (v8_consumer/include/shm_transport.h).
+//
+// Segment is a control page followed by data area of two rings:
+//
+//	0:    control block
+//	64:   ring descriptor for messages sent to worker
+//	256:  ring descriptor for messages received from worker
+//	4096: data area of ring to worker
+//	4096 + ringSize: data area of ring from worker
+//
+// Each ring descriptor carries its read and write positions on separate cache
+// lines, followed by futex words used to park reader and writer:
+//
+//	+0:   head, bytes consumed by reader
+//	+64:  tail, bytes produced by writer
+//	+128: dataSeq, bumped by writer after every write
+//	+132: spaceSeq, bumped by reader after every read
+//	+136: readerWaiting, set while reader is parked on dataSeq
+//	+140: writerWaiting, set while writer is parked on spaceSeq
+const (
+	segmentMagic   uint32 = 0x45564950 // "EVIP"
+	segmentVersion uint32 = 1
+
+	controlSize = 4096
+
+	magicOffset      = 0
+	versionOffset    = 4
+	ringSizeOffset   = 8
+	stateOffset      = 12
+	ownerPidOffset   = 16
+	attachPidOffset  = 20
+	toWorkerOffset   = 64
+	fromWorkerOffset = 256
+
+	headOffset          = 0
+	tailOffset          = 64
+	dataSeqOffset       = 128
+	spaceSeqOffset      = 132
+	readerWaitingOffset = 136
+	writerWaitingOffset = 140
+)
+
+// Values of state word in control block, eventing-consumer parks on it while
+// waiting for producer to start accepting
+const (
+	stateIdle uint32 = iota
+	stateListening
+	stateAttached
+	stateClosed
+)
+
+const (
+	// DefaultRingSize is size of each of the two rings in a segment. Like a
+	// socket buffer, a full ring blocks writer until reader drains it.
+	DefaultRingSize = 4 * 1024 * 1024
+
+	// Parked readers and writers wake up at least this often to notice that
+	// either end has gone away
+	pollInterval = 100 * time.Millisecond
+)
+
+var (
+	errNotSupported         = errors.New("shared memory transport isn't supported on this platform")
+	errInvalidRingSize      = errors.New("ring size must be a power of two and multiple of page size")
+	errListenerClosed       = errors.New("shared memory listener closed")
+	errConnClosed           = errors.New("shared memory connection closed")
+	errDeadlineNotSupported = errors.New("deadlines aren't supported on shared memory connections")
+	errInvalidSegment       = errors.New("shared memory segment has unexpected layout")
+	errAttachTimeout        = errors.New("timed out attaching to shared memory segment")
+)
+
+// Addr is the net.Addr of a shared memory segment
+type Addr struct {
+	Path string
+}
+
+// Network returns the network name of shared memory transport
+func (a *Addr) Network() string {
+	return "shm"
+}
+
+func (a *Addr) String() string {
+	return a.Path
+}
+
+// Dir returns the directory shared memory segments are created in
+func Dir() string {
+	return segmentDir()
+}
+
+// Supported reports whether shared memory transport is available on this platform
+func Supported() bool {
+	return supported
+}
@@ -0,0 +1,666 @@
+package shmipc
+
+import (
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const supported = true
+
+const (
+	futexWaitOp = 0
+	futexWakeOp = 1
+)
+
+func segmentDir() string {
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
+
+// ring is a single producer single consumer byte queue living in shared memory.
+// Positions only ever grow, their difference is the number of unread bytes.
+type ring struct {
+	head          *uint64
+	tail          *uint64
+	dataSeq       *uint32
+	spaceSeq      *uint32
+	readerWaiting *uint32
+	writerWaiting *uint32
+	data          []byte
+	mask          uint64
+}
+
+func newRing(mem []byte, descOffset, dataOffset, size int) *ring {
+	return &ring{
+		head:          (*uint64)(unsafe.Pointer(&mem[descOffset+headOffset])),
+		tail:          (*uint64)(unsafe.Pointer(&mem[descOffset+tailOffset])),
+		dataSeq:       (*uint32)(unsafe.Pointer(&mem[descOffset+dataSeqOffset])),
+		spaceSeq:      (*uint32)(unsafe.Pointer(&mem[descOffset+spaceSeqOffset])),
+		readerWaiting: (*uint32)(unsafe.Pointer(&mem[descOffset+readerWaitingOffset])),
+		writerWaiting: (*uint32)(unsafe.Pointer(&mem[descOffset+writerWaitingOffset])),
+		data:          mem[dataOffset : dataOffset+size],
+		mask:          uint64(size - 1),
+	}
+}
+
+func (r *ring) reset() {
+	atomic.StoreUint64(r.head, 0)
+	atomic.StoreUint64(r.tail, 0)
+	atomic.StoreUint32(r.readerWaiting, 0)
+	atomic.StoreUint32(r.writerWaiting, 0)
+}
+
+func (r *ring) readable() uint64 {
+	return atomic.LoadUint64(r.tail) - atomic.LoadUint64(r.head)
+}
+
+func (r *ring) writable() uint64 {
+	return uint64(len(r.data)) - r.readable()
+}
+
+// write copies as much of p as fits and returns the number of bytes copied
+func (r *ring) write(p []byte) int {
+	free := r.writable()
+	if free == 0 {
+		return 0
+	}
+	if uint64(len(p)) > free {
+		p = p[:free]
+	}
+
+	tail := atomic.LoadUint64(r.tail)
+	n := copy(r.data[tail&r.mask:], p)
+	copy(r.data, p[n:])
+	atomic.StoreUint64(r.tail, tail+uint64(len(p)))
+
+	// Futex wake is a syscall, skip it unless reader is actually parked
+	atomic.AddUint32(r.dataSeq, 1)
+	if atomic.LoadUint32(r.readerWaiting) != 0 {
+		futexWake(r.dataSeq)
+	}
+	return len(p)
+}
+
+// read copies unread bytes into p and returns the number of bytes copied
+func (r *ring) read(p []byte) int {
+	avail := r.readable()
+	if avail == 0 {
+		return 0
+	}
+	if uint64(len(p)) > avail {
+		p = p[:avail]
+	}
+
+	head := atomic.LoadUint64(r.head)
+	n := copy(p, r.data[head&r.mask:])
+	copy(p[n:], r.data)
+	atomic.StoreUint64(r.head, head+uint64(len(p)))
+
+	atomic.AddUint32(r.spaceSeq, 1)
+	if atomic.LoadUint32(r.writerWaiting) != 0 {
+		futexWake(r.spaceSeq)
+	}
+	return len(p)
+}
+
+// park blocks until seq moves on from its current value or pollInterval elapses.
+// Waiting flag is raised before ready is rechecked, so that the other end either
+// sees the flag and wakes us up or we see its update and don't sleep at all.
+func park(seq, waiting *uint32, ready func() bool) {
+	atomic.StoreUint32(waiting, 1)
+	defer atomic.StoreUint32(waiting, 0)
+
+	val := atomic.LoadUint32(seq)
+	if ready() {
+		return
+	}
+	futexWait(seq, val, pollInterval)
+}
+
+func futexWait(addr *uint32, val uint32, timeout time.Duration) {
+	ts := syscall.NsecToTimespec(int64(timeout))
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitOp,
+		uintptr(val), uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+func futexWake(addr *uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakeOp,
+		math.MaxInt32, 0, 0, 0)
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return true
+	}
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
+
+// Listener owns a shared memory segment and hands out one connection at a time
+// over it. A new connection is accepted only once the previous one is closed or
+// its worker has exited, which is when a respawned worker attaches again.
+// Closing the listener also closes the connection accepted on it, as both share
+// the same mapping.
+type Listener struct {
+	addr *Addr
+	mem  []byte
+
+	magic      *uint32
+	state      *uint32
+	ownerPid   *uint32
+	attachPid  *uint32
+	generation uint32
+
+	toWorker   *ring
+	fromWorker *ring
+
+	// Held shared by every operation touching the mapping and exclusively to unmap it
+	mu        sync.RWMutex
+	closed    int32
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	connMu sync.Mutex
+	conn   *Conn
+}
+
+// Listen creates shared memory segment at path with two rings of ringSize
+// bytes each, replacing any stale segment left behind at the same path
+func Listen(path string, ringSize int) (net.Listener, error) {
+	if ringSize <= 0 || ringSize&(ringSize-1) != 0 || ringSize%os.Getpagesize() != 0 {
+		return nil, errInvalidRingSize
+	}
+
+	os.Remove(path)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size := controlSize + 2*ringSize
+	if err = f.Truncate(int64(size)); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	l := &Listener{
+		addr:       &Addr{Path: path},
+		mem:        mem,
+		magic:      (*uint32)(unsafe.Pointer(&mem[magicOffset])),
+		state:      (*uint32)(unsafe.Pointer(&mem[stateOffset])),
+		ownerPid:   (*uint32)(unsafe.Pointer(&mem[ownerPidOffset])),
+		attachPid:  (*uint32)(unsafe.Pointer(&mem[attachPidOffset])),
+		toWorker:   newRing(mem, toWorkerOffset, controlSize, ringSize),
+		fromWorker: newRing(mem, fromWorkerOffset, controlSize+ringSize, ringSize),
+		closeCh:    make(chan struct{}),
+	}
+
+	*(*uint32)(unsafe.Pointer(&mem[versionOffset])) = segmentVersion
+	*(*uint32)(unsafe.Pointer(&mem[ringSizeOffset])) = uint32(ringSize)
+	atomic.StoreUint32(l.ownerPid, uint32(os.Getpid()))
+	atomic.StoreUint32(l.state, stateIdle)
+	atomic.StoreUint32(l.magic, segmentMagic)
+
+	return l, nil
+}
+
+// Accept waits for eventing-consumer to attach to the segment
+func (l *Listener) Accept() (net.Conn, error) {
+	if err := l.waitForPreviousConn(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.isClosed() {
+		return nil, errListenerClosed
+	}
+
+	// Rings are reset only while nobody is attached, a connection still holding
+	// on to previous generation sees the bump and bails out
+	l.toWorker.reset()
+	l.fromWorker.reset()
+	atomic.StoreUint32(l.attachPid, 0)
+	generation := atomic.AddUint32(&l.generation, 1)
+	atomic.StoreUint32(l.state, stateListening)
+	futexWake(l.state)
+
+	for {
+		state := atomic.LoadUint32(l.state)
+		if state == stateAttached {
+			break
+		}
+		if l.isClosed() {
+			return nil, errListenerClosed
+		}
+		futexWait(l.state, state, pollInterval)
+	}
+
+	conn := &Conn{
+		l:          l,
+		generation: generation,
+		peerPid:    int(atomic.LoadUint32(l.attachPid)),
+		tx:         l.toWorker,
+		rx:         l.fromWorker,
+		closeCh:    make(chan struct{}),
+	}
+
+	l.connMu.Lock()
+	l.conn = conn
+	l.connMu.Unlock()
+
+	return conn, nil
+}
+
+func (l *Listener) waitForPreviousConn() error {
+	l.connMu.Lock()
+	prev := l.conn
+	l.connMu.Unlock()
+
+	if prev == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closeCh:
+			return errListenerClosed
+		case <-prev.closeCh:
+			return nil
+		case <-ticker.C:
+			if !processAlive(prev.peerPid) {
+				return nil
+			}
+		}
+	}
+}
+
+// Close unmaps and removes the segment
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		atomic.StoreInt32(&l.closed, 1)
+		close(l.closeCh)
+
+		l.connMu.Lock()
+		conn := l.conn
+		l.connMu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+
+		atomic.StoreUint32(l.state, stateClosed)
+		l.wakeAll()
+
+		l.mu.Lock()
+		syscall.Munmap(l.mem)
+		l.mem = nil
+		l.mu.Unlock()
+
+		os.Remove(l.addr.Path)
+	})
+	return nil
+}
+
+// Addr returns the segment path
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *Listener) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) == 1
+}
+
+func (l *Listener) wakeAll() {
+	futexWake(l.state)
+	for _, r := range []*ring{l.toWorker, l.fromWorker} {
+		futexWake(r.dataSeq)
+		futexWake(r.spaceSeq)
+	}
+}
+
+// Conn is the producer end of a shared memory segment attached to by eventing-consumer
+type Conn struct {
+	l          *Listener
+	generation uint32
+	peerPid    int
+
+	tx *ring
+	rx *ring
+
+	// Each ring has exactly one reader and one writer
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	closed    int32
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// check reports whether the connection is still usable. Connection is stale once
+// the listener has moved on to a newer worker, and detached once either end has
+// closed the segment.
+func (c *Conn) check() (closed, stale, detached bool) {
+	if atomic.LoadInt32(&c.closed) == 1 || c.l.isClosed() {
+		return true, false, false
+	}
+	if c.generation != atomic.LoadUint32(&c.l.generation) {
+		return false, true, false
+	}
+	return false, false, atomic.LoadUint32(c.l.state) != stateAttached
+}
+
+// Read blocks until worker writes something, data already written by worker is
+// handed out before end of stream is reported
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	c.l.mu.RLock()
+	defer c.l.mu.RUnlock()
+
+	for {
+		closed, stale, detached := c.check()
+		if closed {
+			return 0, errConnClosed
+		}
+		if stale {
+			return 0, io.EOF
+		}
+
+		if n := c.rx.read(b); n > 0 {
+			return n, nil
+		}
+
+		if detached || !processAlive(c.peerPid) {
+			return 0, io.EOF
+		}
+
+		park(c.rx.dataSeq, c.rx.readerWaiting, func() bool { return c.rx.readable() > 0 })
+	}
+}
+
+// Write blocks until all of b is in the ring, a full ring applies the same
+// backpressure to producer as a full socket buffer would
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.l.mu.RLock()
+	defer c.l.mu.RUnlock()
+
+	written := 0
+	for written < len(b) {
+		closed, stale, detached := c.check()
+		if closed {
+			return written, errConnClosed
+		}
+		if stale || detached {
+			return written, io.ErrClosedPipe
+		}
+
+		if n := c.tx.write(b[written:]); n > 0 {
+			written += n
+			continue
+		}
+
+		if !processAlive(c.peerPid) {
+			return written, io.ErrClosedPipe
+		}
+
+		park(c.tx.spaceSeq, c.tx.writerWaiting, func() bool { return c.tx.writable() > 0 })
+	}
+	return written, nil
+}
+
+// Close marks the segment closed so that worker stops using it, segment itself
+// stays mapped for the listener to accept next worker on
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.StoreInt32(&c.closed, 1)
+		close(c.closeCh)
+
+		c.l.mu.RLock()
+		defer c.l.mu.RUnlock()
+
+		if c.l.mem == nil {
+			return
+		}
+		if c.generation == atomic.LoadUint32(&c.l.generation) {
+			atomic.CompareAndSwapUint32(c.l.state, stateAttached, stateClosed)
+		}
+		c.l.wakeAll()
+	})
+	return nil
+}
+
+// LocalAddr returns the segment path
+func (c *Conn) LocalAddr() net.Addr {
+	return c.l.addr
+}
+
+// RemoteAddr returns the segment path
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.l.addr
+}
+
+// SetDeadline isn't supported on shared memory connections
+func (c *Conn) SetDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+// SetReadDeadline isn't supported on shared memory connections
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+// SetWriteDeadline isn't supported on shared memory connections
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+// WorkerConn is the eventing-consumer end of a shared memory segment. It's what
+// eventing-consumer does in C++, and lets Go tools and tests stand in for it.
+type WorkerConn struct {
+	addr     *Addr
+	mem      []byte
+	state    *uint32
+	ownerPid int
+
+	tx *ring
+	rx *ring
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	// Held shared by every operation touching the mapping and exclusively to unmap it
+	mu        sync.RWMutex
+	closed    int32
+	closeOnce sync.Once
+}
+
+// Dial attaches to the segment at path once producer is accepting on it,
+// giving up after timeout
+func Dial(path string, timeout time.Duration) (net.Conn, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	ringSize := int(atomic.LoadUint32((*uint32)(unsafe.Pointer(&mem[ringSizeOffset]))))
+	if atomic.LoadUint32((*uint32)(unsafe.Pointer(&mem[magicOffset]))) != segmentMagic ||
+		atomic.LoadUint32((*uint32)(unsafe.Pointer(&mem[versionOffset]))) != segmentVersion ||
+		controlSize+2*ringSize != len(mem) {
+		syscall.Munmap(mem)
+		return nil, errInvalidSegment
+	}
+
+	c := &WorkerConn{
+		addr:     &Addr{Path: path},
+		mem:      mem,
+		state:    (*uint32)(unsafe.Pointer(&mem[stateOffset])),
+		ownerPid: int(atomic.LoadUint32((*uint32)(unsafe.Pointer(&mem[ownerPidOffset])))),
+		rx:       newRing(mem, toWorkerOffset, controlSize, ringSize),
+		tx:       newRing(mem, fromWorkerOffset, controlSize+ringSize, ringSize),
+	}
+
+	attachPid := (*uint32)(unsafe.Pointer(&mem[attachPidOffset]))
+	deadline := time.Now().Add(timeout)
+	for {
+		atomic.StoreUint32(attachPid, uint32(os.Getpid()))
+		if atomic.CompareAndSwapUint32(c.state, stateListening, stateAttached) {
+			futexWake(c.state)
+			return c, nil
+		}
+
+		if !processAlive(c.ownerPid) || time.Now().After(deadline) {
+			syscall.Munmap(mem)
+			return nil, errAttachTimeout
+		}
+		futexWait(c.state, atomic.LoadUint32(c.state), pollInterval)
+	}
+}
+
+func (c *WorkerConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// Read blocks until producer writes something, data already written by
+// producer is handed out before end of stream is reported
+func (c *WorkerConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for {
+		if c.isClosed() {
+			return 0, errConnClosed
+		}
+
+		if n := c.rx.read(b); n > 0 {
+			return n, nil
+		}
+
+		if atomic.LoadUint32(c.state) != stateAttached || !processAlive(c.ownerPid) {
+			return 0, io.EOF
+		}
+
+		park(c.rx.dataSeq, c.rx.readerWaiting, func() bool { return c.rx.readable() > 0 })
+	}
+}
+
+// Write blocks until all of b is in the ring
+func (c *WorkerConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	written := 0
+	for written < len(b) {
+		if c.isClosed() {
+			return written, errConnClosed
+		}
+		if atomic.LoadUint32(c.state) != stateAttached {
+			return written, io.ErrClosedPipe
+		}
+
+		if n := c.tx.write(b[written:]); n > 0 {
+			written += n
+			continue
+		}
+
+		if !processAlive(c.ownerPid) {
+			return written, io.ErrClosedPipe
+		}
+
+		park(c.tx.spaceSeq, c.tx.writerWaiting, func() bool { return c.tx.writable() > 0 })
+	}
+	return written, nil
+}
+
+// Close detaches from the segment and unmaps it
+func (c *WorkerConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.StoreInt32(&c.closed, 1)
+
+		atomic.CompareAndSwapUint32(c.state, stateAttached, stateClosed)
+		futexWake(c.state)
+		for _, r := range []*ring{c.rx, c.tx} {
+			futexWake(r.dataSeq)
+			futexWake(r.spaceSeq)
+		}
+
+		c.mu.Lock()
+		syscall.Munmap(c.mem)
+		c.mem = nil
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr returns the segment path
+func (c *WorkerConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// RemoteAddr returns the segment path
+func (c *WorkerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline isn't supported on shared memory connections
+func (c *WorkerConn) SetDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+// SetReadDeadline isn't supported on shared memory connections
+func (c *WorkerConn) SetReadDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+// SetWriteDeadline isn't supported on shared memory connections
+func (c *WorkerConn) SetWriteDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
@@ -0,0 +1,209 @@
+package shmipc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loopback returns both ends of a segment with rings of a single page
+func loopback(t *testing.T) (net.Listener, net.Conn, net.Conn, func()) {
+	dir, err := ioutil.TempDir("", "shmipc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Listen(filepath.Join(dir, "test.shm"), os.Getpagesize())
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("listen failed, err: %v", err)
+	}
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept failed, err: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	worker, err := Dial(l.Addr().String(), 5*time.Second)
+	if err != nil {
+		l.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("dial failed, err: %v", err)
+	}
+	producer := <-accepted
+
+	return l, producer, worker, func() {
+		worker.Close()
+		producer.Close()
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func pattern(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestLoopbackWraparound(t *testing.T) {
+	_, producer, worker, cleanup := loopback(t)
+	defer cleanup()
+
+	// Odd sized messages adding up to several times the ring size, so that
+	// they straddle the end of the ring every now and then
+	msg := pattern(1000)
+	const count = 50
+
+	for _, ends := range [][2]net.Conn{{producer, worker}, {worker, producer}} {
+		w, r := ends[0], ends[1]
+
+		for i := 0; i < count; i++ {
+			if _, err := w.Write(msg); err != nil {
+				t.Fatalf("write failed, err: %v", err)
+			}
+
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatalf("read failed, err: %v", err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("message %d corrupted", i)
+			}
+		}
+	}
+}
+
+func TestLoopbackFullRing(t *testing.T) {
+	_, producer, worker, cleanup := loopback(t)
+	defer cleanup()
+
+	data := pattern(4*os.Getpagesize() + 123)
+	done := make(chan error, 1)
+	go func() {
+		_, err := producer.Write(data)
+		done <- err
+	}()
+
+	// Writer must block once ring is full until worker drains it
+	select {
+	case err := <-done:
+		t.Fatalf("write of more than ring size returned without reader, err: %v", err)
+	case <-time.After(3 * pollInterval):
+	}
+
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(worker, got); err != nil {
+		t.Fatalf("read failed, err: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("data corrupted across full ring")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("write failed, err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writer not woken up after ring was drained")
+	}
+}
+
+func TestLoopbackWakeUp(t *testing.T) {
+	_, producer, worker, cleanup := loopback(t)
+	defer cleanup()
+
+	read := make(chan time.Time, 1)
+	go func() {
+		b := make([]byte, 16)
+		if _, err := worker.Read(b); err != nil {
+			t.Errorf("read failed, err: %v", err)
+		}
+		read <- time.Now()
+	}()
+
+	// Let reader park on the empty ring
+	time.Sleep(3 * pollInterval)
+
+	written := time.Now()
+	if _, err := producer.Write([]byte("wake up")); err != nil {
+		t.Fatalf("write failed, err: %v", err)
+	}
+
+	// Reader must be woken up by writer rather than notice data on next poll
+	select {
+	case at := <-read:
+		if lag := at.Sub(written); lag >= pollInterval/2 {
+			t.Errorf("reader took %v to wake up", lag)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reader not woken up")
+	}
+}
+
+func TestLoopbackClose(t *testing.T) {
+	_, producer, worker, cleanup := loopback(t)
+	defer cleanup()
+
+	if _, err := worker.Write([]byte("bye")); err != nil {
+		t.Fatalf("write failed, err: %v", err)
+	}
+	worker.Close()
+
+	// Data written before close is handed out ahead of end of stream
+	b := make([]byte, 16)
+	n, err := producer.Read(b)
+	if err != nil || string(b[:n]) != "bye" {
+		t.Fatalf("expected pending data, got: %q err: %v", b[:n], err)
+	}
+	if _, err = producer.Read(b); err != io.EOF {
+		t.Fatalf("expected EOF after worker closed, got: %v", err)
+	}
+}
+
+func TestLoopbackReattach(t *testing.T) {
+	l, producer, worker, cleanup := loopback(t)
+	defer cleanup()
+
+	// Producer closes its end on seeing worker go away
+	worker.Close()
+	producer.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("accept failed, err: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	// A respawned worker attaches to the same segment
+	next, err := Dial(l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial failed, err: %v", err)
+	}
+	defer next.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write failed, err: %v", err)
+	}
+	b := make([]byte, 5)
+	if _, err = io.ReadFull(next, b); err != nil || string(b) != "hello" {
+		t.Fatalf("expected hello, got: %q err: %v", b, err)
+	}
+}
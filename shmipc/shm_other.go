@@ -0,0 +1,26 @@
+// +build !linux
+
+package shmipc
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+const supported = false
+
+func segmentDir() string {
+	return os.TempDir()
+}
+
+// Listen always fails, shared memory transport relies on futexes which are
+// only available on linux
+func Listen(path string, ringSize int) (net.Listener, error) {
+	return nil, errNotSupported
+}
+
+// Dial always fails, see Listen
+func Dial(path string, timeout time.Duration) (net.Conn, error) {
+	return nil, errNotSupported
+}
@@ -67,6 +67,21 @@ var metakvAppCallback = func(args ...interface{}) error {
 	return nil
 }
 
+var metakvSetCallback = func(args ...interface{}) error {
+	logPrefix := "SuperSupervisor::metakvSetCallback"
+
+	s := args[0].(*SuperSupervisor)
+	path := args[1].(string)
+	value := args[2].([]byte)
+
+	err := util.MetakvSet(path, value, nil)
+	if err != nil {
+		logging.Errorf("%s [%d] Unable to set %s, err: %v",
+			logPrefix, s.runningFnsCount(), path, err)
+	}
+	return err
+}
+
 var metakvDeleteCallback = func(args ...interface{}) error {
 	logPrefix := "SuperSupervisor::metakvDeleteCallback"
 
@@ -81,9 +96,19 @@ var metakvDeleteCallback = func(args ...interface{}) error {
 	return err
 }
 
-var undeployFunctionCallback = func(args ...interface{}) error {
-	logPrefix := "SuperSupervisor::undeployFunctionCallback"
+var pauseFunctionCallback = func(args ...interface{}) error {
+	s := args[0].(*SuperSupervisor)
+	appName := args[1].(string)
+
+	settings := make(map[string]interface{})
+	settings["deployment_status"] = true
+	settings["processing_status"] = false
+	settings["dcp_stream_boundary"] = "everything"
 
+	return s.postSettings("SuperSupervisor::pauseFunctionCallback", appName, settings)
+}
+
+var undeployFunctionCallback = func(args ...interface{}) error {
 	s := args[0].(*SuperSupervisor)
 	appName := args[1].(string)
 
@@ -91,6 +116,11 @@ var undeployFunctionCallback = func(args ...interface{}) error {
 	settings["deployment_status"] = false
 	settings["processing_status"] = false
 
+	return s.postSettings("SuperSupervisor::undeployFunctionCallback", appName, settings)
+}
+
+// postSettings changes settings of a function through local eventing REST endpoint
+func (s *SuperSupervisor) postSettings(logPrefix, appName string, settings map[string]interface{}) error {
 	data, err := json.Marshal(&settings)
 	if err != nil {
 		logging.Errorf("%s [%d] Function: %s failed to marshal settings", logPrefix, s.runningFnsCount(), appName)
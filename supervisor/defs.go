@@ -6,6 +6,7 @@ import (
 	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/dcp"
 	"github.com/couchbase/eventing/suptree"
+	"github.com/couchbase/eventing/timers"
 )
 
 const (
@@ -18,6 +19,14 @@ const (
 	// from operations that are retried upon failure
 	MetakvAppsRetryPath = metakvEventingPath + "retry/"
 
+	// MetakvAppsRestartWorkersPath refers to path where requests to restart
+	// workers of a function are posted
+	MetakvAppsRestartWorkersPath = metakvEventingPath + "restartWorkers/"
+
+	// Last restart workers request handled by a node for a function, kept under
+	// <uuid>/<appName> so that requests aren't handled again once node restarts
+	metakvRestartsHandledPath = metakvEventingPath + "restartsHandled/"
+
 	// MetakvAppSettingsPath refers to path under metakv where app settings are stored
 	MetakvAppSettingsPath       = metakvEventingPath + "appsettings/"
 	metakvProducerHostPortsPath = metakvEventingPath + "hostports/"
@@ -102,6 +111,9 @@ type SuperSupervisor struct {
 	// to signify app has been undeployed. Access controlled by appListRWMutex
 	locallyDeployedApps map[string]string
 
+	// Last restart workers request seen per app. Access controlled by appListRWMutex
+	restartWorkersRequests map[string]string
+
 	// Global config
 	memoryQuota int64 // In MB
 
@@ -113,6 +125,11 @@ type SuperSupervisor struct {
 	runningProducersRWMutex    *sync.RWMutex
 	vbucketsToOwn              []uint16
 
+	// Timer store checks of functions that aren't deployed, access controlled by
+	// timerChecksMutex
+	timerChecks      map[string]*timers.CheckTask
+	timerChecksMutex *sync.Mutex
+
 	serviceMgr common.EventingServiceMgr
 	sync.RWMutex
 }
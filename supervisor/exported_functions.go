@@ -90,6 +90,14 @@ func (s *SuperSupervisor) GetCurlLatencyStats(appName string) common.StatsData {
 	return nil
 }
 
+// GetTimerFiringStats returns lateness and scan cost of timers, by partition
+func (s *SuperSupervisor) GetTimerFiringStats(appName string) map[int]*common.TimerFiringStats {
+	if p, ok := s.runningFns()[appName]; ok {
+		return p.GetTimerFiringStats()
+	}
+	return nil
+}
+
 func (s *SuperSupervisor) GetInsight(appName string) *common.Insight {
 	logPrefix := "SuperSupervisor::GetInsight"
 	if p, ok := s.runningFns()[appName]; ok {
@@ -147,6 +155,18 @@ func (s *SuperSupervisor) GetSeqsProcessed(appName string) map[int]int64 {
 	return nil
 }
 
+// PauseFunction requests pausing of a deployed function via the local eventing REST endpoint
+func (s *SuperSupervisor) PauseFunction(appName string) error {
+	logPrefix := "SuperSupervisor::PauseFunction"
+
+	if _, ok := s.runningFns()[appName]; !ok {
+		return fmt.Errorf("function: %s not running", appName)
+	}
+
+	logging.Infof("%s [%d] Function: %s requesting pause", logPrefix, s.runningFnsCount(), appName)
+	return util.Retry(util.NewExponentialBackoff(), &s.retryCount, pauseFunctionCallback, s, appName)
+}
+
 // RestPort returns ns_server port(typically 8091/9000)
 func (s *SuperSupervisor) RestPort() string {
 	return s.restPort
@@ -305,6 +325,157 @@ func (s *SuperSupervisor) TimerDebugStats(appName string) (map[int]map[string]in
 	return nil, fmt.Errorf("Eventing.Producer isn't alive")
 }
 
+// ListTimers returns a page of pending timers of a function
+func (s *SuperSupervisor) ListTimers(appName string, query *common.TimerQuery) (*common.TimerPage, error) {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.ListTimers(query)
+	}
+
+	return nil, fmt.Errorf("Eventing.Producer isn't alive")
+}
+
+// CancelTimers cancels pending timers of a function matching query
+func (s *SuperSupervisor) CancelTimers(appName string, query *common.TimerQuery) (int, error) {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.CancelTimers(query)
+	}
+
+	return 0, fmt.Errorf("Eventing.Producer isn't alive")
+}
+
+// CancelTimersInRange starts cancelling timers of a function due within range
+// of query in background
+func (s *SuperSupervisor) CancelTimersInRange(appName string, query *common.TimerQuery) error {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.CancelTimersInRange(query)
+	}
+
+	return fmt.Errorf("Eventing.Producer isn't alive")
+}
+
+// TimerCancelStatus returns report of last or ongoing cancellation of timers
+// due within a range
+func (s *SuperSupervisor) TimerCancelStatus(appName string) (*common.TimerCancelReport, error) {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.TimerCancelStatus(), nil
+	}
+
+	return nil, fmt.Errorf("Eventing.Producer isn't alive")
+}
+
+// CreateTimer sets a timer of a function from outside its handler
+func (s *SuperSupervisor) CreateTimer(appName string, request *common.TimerRequest) (*common.TimerInfo, error) {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.CreateTimer(request)
+	}
+
+	return nil, fmt.Errorf("Eventing.Producer isn't alive")
+}
+
+// CheckTimers starts timer store checker of a function in background. Timers of
+// a function that isn't deployed, such as those left behind by an undeploy,
+// are checked from here.
+func (s *SuperSupervisor) CheckTimers(appName string, request *common.TimerCheckRequest) error {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.CheckTimers(request)
+	}
+
+	if s.GetAppState(appName) != common.AppStateUndeployed {
+		return fmt.Errorf("Eventing.Producer isn't alive")
+	}
+
+	prefix, metadataBucket, err := s.timerLocation(appName)
+	if err != nil {
+		return err
+	}
+	backend, err := s.timerBackend(metadataBucket)
+	if err != nil {
+		return err
+	}
+
+	partns := make([]int, 0, s.numVbuckets)
+	for partn := 0; partn < s.numVbuckets; partn++ {
+		if request.Partition < 0 || request.Partition == partn {
+			partns = append(partns, partn)
+		}
+	}
+
+	return s.timerCheck(appName).Start(request, timers.CheckSource{
+		Partitions: partns,
+		Open: func(partn int) (*timers.TimerStore, bool, error) {
+			return timers.Inspect(prefix, partn, backend, metadataBucket, 0)
+		},
+		Stop: func() error {
+			if s.GetAppState(appName) != common.AppStateUndeployed {
+				return fmt.Errorf("function is being deployed")
+			}
+			return nil
+		},
+		Lister: timers.NewDcpLister(net.JoinHostPort(util.Localhost(), s.restPort)),
+	})
+}
+
+// TimerCheckStatus returns report of last or ongoing timer store check of a function
+func (s *SuperSupervisor) TimerCheckStatus(appName string) (*common.TimerCheckReport, error) {
+	p, ok := s.runningFns()[appName]
+	if ok {
+		return p.TimerCheckStatus(), nil
+	}
+
+	if s.GetAppState(appName) != common.AppStateUndeployed {
+		return nil, fmt.Errorf("Eventing.Producer isn't alive")
+	}
+	return s.timerCheck(appName).Status(), nil
+}
+
+// PurgeTimers removes timers a function being deleted kept for its next
+// deployment, in the background. They're located right away, as definition
+// and settings of function go along with it.
+func (s *SuperSupervisor) PurgeTimers(appName string) error {
+	logPrefix := "SuperSupervisor::PurgeTimers"
+
+	prefix, metadataBucket, err := s.timerLocation(appName)
+	if err != nil {
+		return err
+	}
+	backend, err := s.timerBackend(metadataBucket)
+	if err != nil {
+		return err
+	}
+	lister := timers.NewDcpLister(net.JoinHostPort(util.Localhost(), s.restPort))
+
+	go func() {
+		removed, err := timers.Purge(prefix, backend, lister, metadataBucket)
+		if err != nil {
+			logging.Errorf("%s [%d] Function: %s failed to purge timers, removed: %d err: %v",
+				logPrefix, s.runningFnsCount(), appName, removed, err)
+			return
+		}
+		logging.Infof("%s [%d] Function: %s purged %d timer documents",
+			logPrefix, s.runningFnsCount(), appName, removed)
+	}()
+	return nil
+}
+
+// timerCheck returns check task for timers of a function that isn't deployed
+func (s *SuperSupervisor) timerCheck(appName string) *timers.CheckTask {
+	s.timerChecksMutex.Lock()
+	defer s.timerChecksMutex.Unlock()
+
+	task, ok := s.timerChecks[appName]
+	if !ok {
+		task = timers.NewCheckTask(fmt.Sprintf("SuperSupervisor::checkTimers [%s]", appName))
+		s.timerChecks[appName] = task
+	}
+	return task
+}
+
 // BootstrapAppStatus reports back status of bootstrap for a particular app on current node
 func (s *SuperSupervisor) BootstrapAppStatus(appName string) bool {
 	logPrefix := "SuperSupervisor::BootstrapAppStatus"
@@ -17,6 +17,7 @@ import (
 	"github.com/couchbase/eventing/producer"
 	"github.com/couchbase/eventing/service_manager"
 	"github.com/couchbase/eventing/suptree"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 	"github.com/pkg/errors"
 )
@@ -41,11 +42,14 @@ func NewSuperSupervisor(adminPort AdminPortConfig, eventingDir, kvPort, restPort
 		numVbuckets:                numVbuckets,
 		producerSupervisorTokenMap: make(map[common.EventingProducer]suptree.ServiceToken),
 		restPort:                   restPort,
+		restartWorkersRequests:     make(map[string]string),
 		retryCount:                 -1,
 		runningProducers:           make(map[string]common.EventingProducer),
 		runningProducersRWMutex:    &sync.RWMutex{},
 		supCmdCh:                   make(chan supCmdMsg, 10),
 		superSup:                   suptree.NewSimple("super_supervisor"),
+		timerChecks:                make(map[string]*timers.CheckTask),
+		timerChecksMutex:           &sync.Mutex{},
 		tokenMapRWMutex:            &sync.RWMutex{},
 		uuid:                       uuid,
 	}
@@ -57,6 +61,8 @@ func NewSuperSupervisor(adminPort AdminPortConfig, eventingDir, kvPort, restPort
 	s.bucketsRWMutex = &sync.RWMutex{}
 	s.superSup.ServeBackground("SuperSupervisor")
 
+	util.SetKVTLSCertificates(s.adminPort.CertFile, s.adminPort.KeyFile)
+
 	config, _ := util.NewConfig(nil)
 	config.Set("uuid", s.uuid)
 	config.Set("eventing_admin_http_port", s.adminPort.HTTPPort)
@@ -269,6 +275,12 @@ func (s *SuperSupervisor) SettingsChangeCallback(path string, value []byte, rev
 						return err
 					}
 
+					if err := util.MetaKvDelete(MetakvAppsRestartWorkersPath+appName, nil); err != nil {
+						logging.Errorf("%s [%d] Function: %s failed to delete from metakv restart workers path, err : %v",
+							logPrefix, s.runningFnsCount(), appName, err)
+						return err
+					}
+
 					if state == common.AppStatePaused {
 						if p, ok := s.runningFns()[appName]; ok {
 							logging.Infof("%s [%d] Function: %s stopping running producer instance", logPrefix, s.runningFnsCount(), appName)
@@ -553,6 +565,11 @@ func (s *SuperSupervisor) HandleGlobalConfigChange(config common.Config) error {
 				s.updateQuotaForRunningFns()
 			}
 
+		case "enable_kv_tls":
+			if enabled, ok := value.(bool); ok {
+				util.SetKVTLSEnabled(enabled)
+			}
+
 		case "function_size":
 			if size, ok := value.(float64); ok {
 				util.SetMaxFunctionSize(int(size))
@@ -619,6 +636,52 @@ func (s *SuperSupervisor) AppsRetryCallback(path string, value []byte, rev inter
 	return nil
 }
 
+// AppsRestartWorkersCallback asks running function to drain and restart its workers
+func (s *SuperSupervisor) AppsRestartWorkersCallback(path string, value []byte, rev interface{}) error {
+	logPrefix := "SuperSupervisor::AppsRestartWorkersCallback"
+	if value == nil {
+		return nil
+	}
+
+	appName := util.GetAppNameFromPath(path)
+	requestID := string(value)
+
+	// Observer replays existing requests when it's restarted, and so does a
+	// restarted node, they must not restart workers a second time
+	s.appListRWMutex.Lock()
+	if s.restartWorkersRequests[appName] == requestID {
+		s.appListRWMutex.Unlock()
+		return nil
+	}
+	s.restartWorkersRequests[appName] = requestID
+	s.appListRWMutex.Unlock()
+
+	handledPath := s.restartsHandledPath(appName)
+	var handled []byte
+	util.Retry(util.NewFixedBackoff(time.Second), nil, metakvGetCallback, s, handledPath, &handled)
+	if string(handled) == requestID {
+		logging.Infof("%s [%d] Function: %s restart of workers, request: %s already handled",
+			logPrefix, s.runningFnsCount(), appName, requestID)
+		return nil
+	}
+
+	if p, exists := s.runningFns()[appName]; exists {
+		logging.Infof("%s [%d] Function: %s restarting workers, request: %s",
+			logPrefix, s.runningFnsCount(), appName, requestID)
+		p.RestartWorkers()
+	} else {
+		logging.Infof("%s [%d] Function: %s not running on this node, ignoring restart of workers",
+			logPrefix, s.runningFnsCount(), appName)
+	}
+
+	util.Retry(util.NewFixedBackoff(time.Second), nil, metakvSetCallback, s, handledPath, value)
+	return nil
+}
+
+func (s *SuperSupervisor) restartsHandledPath(appName string) string {
+	return metakvRestartsHandledPath + s.uuid + "/" + appName
+}
+
 func (s *SuperSupervisor) spawnApp(appName string, cleanupTimers bool) {
 	logPrefix := "SuperSupervisor::spawnApp"
 
@@ -656,6 +719,8 @@ func (s *SuperSupervisor) HandleSupCmdMsg() {
 			case cmdAppDelete:
 				logging.Infof("%s [%d] Function: %s deleting", logPrefix, s.runningFnsCount(), appName)
 
+				util.Retry(util.NewFixedBackoff(time.Second), nil, metakvDeleteCallback, s, s.restartsHandledPath(appName))
+
 				d, err := os.Open(s.eventingDir)
 				if err != nil {
 					logging.Errorf("%s [%d] Function: %s failed to open eventingDir: %s while trying to purge app logs, err: %v",
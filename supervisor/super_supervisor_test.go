@@ -0,0 +1,96 @@
+package supervisor
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/couchbase/eventing/common"
+)
+
+type restartProducer struct {
+	common.EventingProducer
+	restarts int
+}
+
+func (p *restartProducer) RestartWorkers() { p.restarts++ }
+
+// withMetakv serves metakv gets and sets from store instead of cluster
+func withMetakv(store map[string][]byte) func() {
+	prevGet, prevSet := metakvGetCallback, metakvSetCallback
+	metakvGetCallback = func(args ...interface{}) error {
+		*args[2].(*[]byte) = store[args[1].(string)]
+		return nil
+	}
+	metakvSetCallback = func(args ...interface{}) error {
+		store[args[1].(string)] = args[2].([]byte)
+		return nil
+	}
+	return func() {
+		metakvGetCallback, metakvSetCallback = prevGet, prevSet
+	}
+}
+
+// newRestartSupervisor stands in for eventing-producer process of a node
+// running fn
+func newRestartSupervisor(p common.EventingProducer) *SuperSupervisor {
+	return &SuperSupervisor{
+		uuid:                    "node1",
+		appListRWMutex:          &sync.RWMutex{},
+		restartWorkersRequests:  make(map[string]string),
+		runningProducersRWMutex: &sync.RWMutex{},
+		runningProducers:        map[string]common.EventingProducer{"fn": p},
+	}
+}
+
+func TestAppsRestartWorkersCallback(t *testing.T) {
+	store := make(map[string][]byte)
+	defer withMetakv(store)()
+
+	p := &restartProducer{}
+	s := newRestartSupervisor(p)
+	path := MetakvAppsRestartWorkersPath + "fn"
+
+	s.AppsRestartWorkersCallback(path, []byte("100"), nil)
+	if p.restarts != 1 {
+		t.Fatalf("expected workers to be restarted once, got %d", p.restarts)
+	}
+	if string(store[metakvRestartsHandledPath+"node1/fn"]) != "100" {
+		t.Errorf("expected request to be recorded as handled, got %q", store[metakvRestartsHandledPath+"node1/fn"])
+	}
+
+	// Replayed by restarted observer
+	s.AppsRestartWorkersCallback(path, []byte("100"), nil)
+	if p.restarts != 1 {
+		t.Errorf("expected replayed request to be ignored, got %d restarts", p.restarts)
+	}
+
+	s.AppsRestartWorkersCallback(path, nil, nil)
+	s.AppsRestartWorkersCallback(path, []byte("200"), nil)
+	if p.restarts != 2 {
+		t.Errorf("expected new request to restart workers again, got %d restarts", p.restarts)
+	}
+}
+
+func TestAppsRestartWorkersCallbackAfterNodeRestart(t *testing.T) {
+	store := make(map[string][]byte)
+	defer withMetakv(store)()
+
+	path := MetakvAppsRestartWorkersPath + "fn"
+	newRestartSupervisor(&restartProducer{}).AppsRestartWorkersCallback(path, []byte("100"), nil)
+
+	// Restarted node has nothing in memory, and sees the request still in metakv
+	p := &restartProducer{}
+	newRestartSupervisor(p).AppsRestartWorkersCallback(path, []byte("100"), nil)
+	if p.restarts != 0 {
+		t.Errorf("expected request handled before node restart to be ignored, got %d restarts", p.restarts)
+	}
+
+	// Handled requests are tracked per node
+	p = &restartProducer{}
+	other := newRestartSupervisor(p)
+	other.uuid = "node2"
+	other.AppsRestartWorkersCallback(path, []byte("100"), nil)
+	if p.restarts != 1 {
+		t.Errorf("expected request to restart workers on another node, got %d restarts", p.restarts)
+	}
+}
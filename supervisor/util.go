@@ -8,11 +8,13 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/gen/flatbuf/cfg"
 	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/timers"
 	"github.com/couchbase/eventing/util"
 )
 
@@ -139,6 +141,53 @@ func (s *SuperSupervisor) getSourceAndMetaBucketNodeCount(appName string) (sourc
 	return
 }
 
+// timerLocation reads metadata prefix and bucket timers of a function are kept
+// under off its definition, so that they can be reached while it isn't deployed
+func (s *SuperSupervisor) timerLocation(appName string) (prefix, metadataBucket string, err error) {
+	appData, err := util.ReadAppContent(MetakvAppsPath, MetakvChecksumPath, appName)
+	if err != nil {
+		return "", "", err
+	}
+	if len(appData) == 0 {
+		return "", "", fmt.Errorf("function: %s not found", appName)
+	}
+	config := cfg.GetRootAsConfig(appData, 0)
+	depcfg := config.DepCfg(new(cfg.DepCfg))
+
+	userPrefix := "eventing"
+	sData, err := util.MetakvGet(MetakvAppSettingsPath + appName)
+	if err != nil {
+		return "", "", err
+	}
+	settings := make(map[string]interface{})
+	if len(sData) > 0 {
+		if err = json.Unmarshal(sData, &settings); err != nil {
+			return "", "", err
+		}
+	}
+	if val, ok := settings["user_prefix"].(string); ok {
+		userPrefix = val
+	}
+
+	prefix = common.NewKey(userPrefix, strconv.Itoa(int(config.HandlerUUID())), "").GetPrefix()
+	return prefix, string(depcfg.MetadataBucket()), nil
+}
+
+// timerBackend connects to KV nodes of metadata bucket, to reach timers of a
+// function that isn't deployed
+func (s *SuperSupervisor) timerBackend(metadataBucket string) (timers.Backend, error) {
+	hostAddress := net.JoinHostPort(util.Localhost(), s.restPort)
+	kvNodes, err := util.KVNodesAddresses(s.auth, hostAddress, metadataBucket)
+	if err != nil {
+		return nil, err
+	}
+	connStr := "couchbase://" + strings.Join(kvNodes, ",")
+	if util.IsIPv6() {
+		connStr += "?ipv6=allow"
+	}
+	return timers.Pool(connStr), nil
+}
+
 func printMemoryStats() {
 	stats := memoryStats()
 	buf, err := json.Marshal(&stats)
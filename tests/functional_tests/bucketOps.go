@@ -266,3 +266,98 @@ func pumpBucketOpsSrc(ops opsType, srcBucket string, rate *rateLimit) {
 		}
 	}
 }
+
+type versionedDoc struct {
+	ID      int `json:"uid"`
+	Version int `json:"version"`
+}
+
+// pumpVersionedBucketOps keeps mutating the same set of keys, bumping up version
+// on every pass, so that handler can tell if it saw a key's mutations out of order
+func pumpVersionedBucketOps(keyCount int, rate *rateLimit) {
+	log.Println("Starting versioned bucket ops to source bucket")
+
+	cluster, _ := gocb.Connect("couchbase://127.0.0.1:12000")
+	cluster.Authenticate(gocb.PasswordAuthenticator{
+		Username: rbacuser,
+		Password: rbacpass,
+	})
+	bucket, err := cluster.OpenBucket(srcBucket, "")
+	if err != nil {
+		fmt.Println("Bucket open, err:", err)
+		return
+	}
+	defer bucket.Close()
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate.opsPSec))
+	defer ticker.Stop()
+
+	for i := 0; ; i++ {
+		select {
+		case <-ticker.C:
+			doc := versionedDoc{ID: i % keyCount, Version: i / keyCount}
+
+		retryOp:
+			_, err := bucket.Upsert(fmt.Sprintf("doc_id_%d", doc.ID), doc, 0)
+			if err != nil {
+				time.Sleep(time.Second)
+				goto retryOp
+			}
+
+		case <-rate.stopCh:
+			return
+		}
+	}
+}
+
+// countCheckDocs returns number of keys for which handler recorded a check
+// failure under prefix. Such docs are kept in metadata bucket, so that they
+// don't count towards items handler is expected to write to destination bucket.
+func countCheckDocs(prefix string, keyCount int) int {
+	cluster, _ := gocb.Connect("couchbase://127.0.0.1:12000")
+	cluster.Authenticate(gocb.PasswordAuthenticator{
+		Username: rbacuser,
+		Password: rbacpass,
+	})
+	bucket, err := cluster.OpenBucket(metaBucket, "")
+	if err != nil {
+		fmt.Println("Bucket open, err:", err)
+		return -1
+	}
+	defer bucket.Close()
+
+	count := 0
+	for i := 0; i < keyCount; i++ {
+		var entry map[string]interface{}
+		docID := fmt.Sprintf("%s::doc_id_%d", prefix, i)
+		if _, err := bucket.Get(docID, &entry); err == nil {
+			log.Printf("DocID: %s failed check: %v\n", docID, entry)
+			count++
+		}
+	}
+	return count
+}
+
+// lookupIdempotencyKey returns idempotency key stamped on a document written by
+// handler to destination bucket
+func lookupIdempotencyKey(docID string) (string, error) {
+	cluster, _ := gocb.Connect("couchbase://127.0.0.1:12000")
+	cluster.Authenticate(gocb.PasswordAuthenticator{
+		Username: rbacuser,
+		Password: rbacpass,
+	})
+	bucket, err := cluster.OpenBucket(dstBucket, "")
+	if err != nil {
+		return "", err
+	}
+	defer bucket.Close()
+
+	frag, err := bucket.LookupIn(docID).GetEx("_eventing.idempotency_key", gocb.SubdocFlagXattr).Execute()
+	if err != nil {
+		return "", err
+	}
+
+	var key string
+	err = frag.Content("_eventing.idempotency_key", &key)
+	return key, err
+}
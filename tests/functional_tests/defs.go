@@ -10,6 +10,7 @@ const (
 
 const (
 	handlerCodeDir       = "hcode/"
+	eventingURL          = "http://127.0.0.1:9300"
 	aggBootstrappingApps = "http://127.0.0.1:9300/getAggBootstrappingApps"
 	deployedAppsURL      = "http://127.0.0.1:9300/getDeployedApps"
 	exportFunctionsURL   = "http://127.0.0.1:9300/api/v1/export"
@@ -120,6 +121,7 @@ type bucket struct {
 }
 
 type commonSettings struct {
+	adoptTimers              bool
 	aliasHandles             []string
 	aliasSources             []string
 	curlBindings             []common.Curl
@@ -128,13 +130,19 @@ type commonSettings struct {
 	deadlineTimeout          int
 	executeTimerRoutineCount int
 	executionTimeout         int
+	idempotencyKeys          bool
 	lcbInstCap               int
 	logLevel                 string
 	metaBucket               string
+	missedTimerPolicy        string
+	missedTimerRate          int
+	missedTimerThreshold     int
 	n1qlConsistency          string
 	sourceBucket             string
 	streamBoundary           string
 	thrCount                 int
+	timerContextOverflow     string
+	timerContextSize         int
 	timerStorageRoutineCount int
 	undeployedState          bool
 	workerCount              int
@@ -262,7 +262,35 @@ func createFunction(deploymentStatus, processingStatus bool, id int, s *commonSe
 		settings["execution_timeout"] = s.executionTimeout
 	}
 
-	settings["timer_context_size"] = 15 * 1024 * 1024
+	if s.timerContextSize == 0 {
+		settings["timer_context_size"] = 15 * 1024 * 1024
+	} else {
+		settings["timer_context_size"] = s.timerContextSize
+	}
+
+	if s.timerContextOverflow != "" {
+		settings["timer_context_overflow"] = s.timerContextOverflow
+	}
+
+	if s.idempotencyKeys {
+		settings["idempotency_keys"] = true
+	}
+
+	if s.adoptTimers {
+		settings["adopt_timers"] = true
+	}
+
+	if s.missedTimerPolicy != "" {
+		settings["missed_timer_policy"] = s.missedTimerPolicy
+	}
+
+	if s.missedTimerRate != 0 {
+		settings["missed_timer_rate"] = s.missedTimerRate
+	}
+
+	if s.missedTimerThreshold != 0 {
+		settings["missed_timer_threshold"] = s.missedTimerThreshold
+	}
 
 	if s.n1qlConsistency == "" {
 		settings["n1ql_consistency"] = n1qlConsistency
@@ -340,6 +368,10 @@ func setSettings(fnName string, deploymentStatus, processingStatus bool, s *comm
 
 	settings["cleanup_timers"] = s.cleanupTimers
 
+	if s.adoptTimers {
+		settings["adopt_timers"] = true
+	}
+
 	if s.n1qlConsistency == "" {
 		settings["n1ql_consistency"] = n1qlConsistency
 	} else {
@@ -690,6 +722,15 @@ func waitForStatusChange(appName, expectedStatus string, retryCounter int) {
 }
 
 func getFailureStatCounter(statName, fnName string) int {
+	return getStatCounter("failure_stats", statName, fnName)
+}
+
+func getExecutionStatCounter(statName, fnName string) int {
+	return getStatCounter("execution_stats", statName, fnName)
+}
+
+// getStatCounter sums a stat of function in given category across nodes
+func getStatCounter(category, statName, fnName string) int {
 	responses := make([]interface{}, 0)
 
 	res0, err := makeStatsRequest("", statsEndpointURL0, false)
@@ -732,11 +773,11 @@ func getFailureStatCounter(statName, fnName string) int {
 			}
 
 			if sFName == fnName {
-				failureStats, ok := s["failure_stats"].(map[string]interface{})
+				categoryStats, ok := s[category].(map[string]interface{})
 				if !ok {
 					continue
 				}
-				if val, ok := failureStats[statName].(float64); !ok {
+				if val, ok := categoryStats[statName].(float64); !ok {
 					continue
 				} else {
 					result += int(val)
@@ -0,0 +1,99 @@
+// +build all rebalance eventing_reb_ordering
+
+package eventing
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	oKeyCount = 500
+	oOpsPSec  = 1000
+)
+
+// Mutations on a key must reach handler once, in the order they were made, even
+// as its vbucket moves between eventing nodes
+func testPerKeyOrderingWithReb(t *testing.T, rebalance func()) {
+	functionName := t.Name()
+	time.Sleep(5 * time.Second)
+	handler := "bucket_op_on_update_ordering"
+
+	flushFunctionAndBucket(functionName)
+	// Check docs left behind by previous runs
+	bucketFlush(metaBucket)
+	time.Sleep(5 * time.Second)
+	createAndDeployFunction(functionName, handler, &commonSettings{
+		aliasHandles: []string{"dst_bucket", "check_bucket"},
+		aliasSources: []string{dstBucket, metaBucket},
+	})
+	waitForDeployToFinish(functionName)
+
+	rl := &rateLimit{
+		limit:   true,
+		opsPSec: oOpsPSec,
+		stopCh:  make(chan struct{}, 1),
+	}
+
+	go pumpVersionedBucketOps(oKeyCount, rl)
+
+	rebalance()
+	metaStateDump()
+
+	rl.stopCh <- struct{}{}
+
+	eventCount := verifyBucketOps(oKeyCount, statsLookupRetryCounter)
+	if eventCount != oKeyCount {
+		t.Error("For", functionName,
+			"expected", oKeyCount,
+			"got", eventCount)
+	}
+
+	if outOfOrder := countCheckDocs("out_of_order", oKeyCount); outOfOrder != 0 {
+		t.Error("For", functionName,
+			"keys with mutations processed out of order", outOfOrder)
+	}
+
+	if processedTwice := countCheckDocs("processed_twice", oKeyCount); processedTwice != 0 {
+		t.Error("For", functionName,
+			"keys with a mutation processed twice", processedTwice)
+	}
+
+	flushFunctionAndBucket(functionName)
+}
+
+func TestPerKeyOrderingWithEventingRebInOut(t *testing.T) {
+	testPerKeyOrderingWithReb(t, func() {
+		addNodeFromRest("http://127.0.0.1:9001", "eventing")
+		rebalanceFromRest([]string{""})
+		waitForRebalanceFinish()
+		metaStateDump()
+
+		rebalanceFromRest([]string{"127.0.0.1:9001"})
+		waitForRebalanceFinish()
+	})
+}
+
+func TestPerKeyOrderingWithEventingRebOneByOne(t *testing.T) {
+	testPerKeyOrderingWithReb(t, func() {
+		addAllNodesOneByOne("eventing")
+		removeAllNodesOneByOne()
+	})
+}
+
+func TestPerKeyOrderingWithEventingSwapReb(t *testing.T) {
+	testPerKeyOrderingWithReb(t, func() {
+		addNodeFromRest("http://127.0.0.1:9001", "eventing")
+		rebalanceFromRest([]string{""})
+		waitForRebalanceFinish()
+		metaStateDump()
+
+		addNodeFromRest("http://127.0.0.1:9002", "eventing")
+		rebalanceFromRest([]string{"127.0.0.1:9001"})
+		waitForRebalanceFinish()
+		metaStateDump()
+
+		rebalanceFromRest([]string{"127.0.0.1:9002"})
+		waitForRebalanceFinish()
+	})
+}
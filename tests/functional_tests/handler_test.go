@@ -6,9 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/timers"
 )
 
 func testEnoent(itemCount int, handler string, settings *commonSettings, t *testing.T) {
@@ -386,6 +391,55 @@ func TestOnDeleteBucketOp5K(t *testing.T) {
 	flushFunctionAndBucket(functionName)
 }
 
+func TestIdempotencyKeysWithWorkersKilled(t *testing.T) {
+	functionName := t.Name()
+
+	time.Sleep(5 * time.Second)
+	handler := "bucket_op_on_update"
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, &commonSettings{idempotencyKeys: true})
+	waitForDeployToFinish(functionName)
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+
+	// Seq nos are acked only once handler has written out its doc, so workers
+	// killed midway must not leave any mutation unprocessed
+	for i := 0; i < 2; i++ {
+		pids, err := eventingConsumerPids(9300, functionName)
+		if err != nil {
+			t.Error("For", "IdempotencyKeysWithWorkersKilled", "failed to look up worker pids, err:", err)
+		}
+		for _, pid := range pids {
+			log.Println("Killing pid:", pid)
+			killPid(pid)
+		}
+		time.Sleep(10 * time.Second)
+	}
+
+	eventCount := verifyBucketOps(itemCount, statsLookupRetryCounter)
+	if itemCount != eventCount {
+		t.Error("For", "IdempotencyKeysWithWorkersKilled",
+			"expected", itemCount,
+			"got", eventCount,
+		)
+	}
+
+	// Key is <function id>-<function instance id>::<vb>::<seq no>
+	keyFormat := regexp.MustCompile(`^\d+-[^:]+::\d+::\d+$`)
+	for _, docID := range []string{"doc_id_0", fmt.Sprintf("doc_id_%d", itemCount-1)} {
+		key, err := lookupIdempotencyKey(docID)
+		if err != nil || !keyFormat.MatchString(key) {
+			t.Error("For", "IdempotencyKeysWithWorkersKilled",
+				"expected idempotency key on", docID,
+				"got", key, "err:", err,
+			)
+		}
+	}
+
+	dumpStats()
+	flushFunctionAndBucket(functionName)
+}
+
 func TestTimerBucketOp(t *testing.T) {
 	functionName := t.Name()
 
@@ -407,6 +461,56 @@ func TestTimerBucketOp(t *testing.T) {
 	flushFunctionAndBucket(functionName)
 }
 
+func TestTimerContextCompressed(t *testing.T) {
+	functionName := t.Name()
+
+	time.Sleep(5 * time.Second)
+	handler := "bucket_op_with_timer_with_large_context"
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, &commonSettings{timerContextSize: 1024, timerContextOverflow: "compress"})
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+	eventCount := verifyBucketOps(itemCount, statsLookupRetryCounter)
+	if itemCount != eventCount {
+		t.Error("For", "TimerContextCompressed",
+			"expected", itemCount,
+			"got", eventCount,
+		)
+	}
+
+	if count := getExecutionStatCounter("timer_context_compressed_counter", functionName); count == 0 {
+		t.Error("For", "TimerContextCompressed", "expected contexts to be compressed, got", count)
+	}
+
+	dumpStats()
+	flushFunctionAndBucket(functionName)
+}
+
+func TestTimerContextChunked(t *testing.T) {
+	functionName := t.Name()
+
+	time.Sleep(5 * time.Second)
+	handler := "bucket_op_with_timer_with_large_context"
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, &commonSettings{timerContextSize: 1024, timerContextOverflow: "chunk"})
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+	eventCount := verifyBucketOps(itemCount, statsLookupRetryCounter)
+	if itemCount != eventCount {
+		t.Error("For", "TimerContextChunked",
+			"expected", itemCount,
+			"got", eventCount,
+		)
+	}
+
+	if count := getFailureStatCounter("timer_context_chunk_missing_counter", functionName); count != 0 {
+		t.Error("For", "TimerContextChunked", "expected no missing chunks, got", count)
+	}
+
+	dumpStats()
+	flushFunctionAndBucket(functionName)
+}
+
 func TestTimerInPastBucketOp(t *testing.T) {
 	functionName := t.Name()
 
@@ -726,6 +830,204 @@ func TestChangeFnCodeBetweenPauseResume(t *testing.T) {
 	flushFunctionAndBucket(functionName)
 }
 
+func TestMissedTimersDropped(t *testing.T) {
+	time.Sleep(5 * time.Second)
+	functionName := t.Name()
+	handler := "bucket_op_with_timer_100s"
+	settings := &commonSettings{missedTimerPolicy: "drop", missedTimerThreshold: 60}
+
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, settings)
+	waitForDeployToFinish(functionName)
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+	time.Sleep(30 * time.Second) // Allow timers to get created
+
+	log.Println("Pausing function:", functionName)
+	setSettings(functionName, true, false, &commonSettings{})
+	waitForStatusChange(functionName, "paused", statsLookupRetryCounter)
+
+	// Timers are due 100s after creation, resume once they're missed by more than threshold
+	time.Sleep(3 * time.Minute)
+
+	log.Printf("Resuming function: %s with from_prior feed boundary\n", functionName)
+	settings.streamBoundary = "from_prior"
+	createAndDeployFunction(functionName, handler, settings)
+
+	waitForFailureStatCounterSync(functionName, "timer_missed_dropped_counter", itemCount)
+
+	eventCount := verifyBucketOps(0, statsLookupRetryCounter)
+	if eventCount != 0 {
+		t.Error("For", "TestMissedTimersDropped",
+			"expected", 0,
+			"got", eventCount,
+		)
+	}
+
+	log.Println("Undeploying function:", functionName)
+	setSettings(functionName, false, false, &commonSettings{})
+
+	time.Sleep(5 * time.Second)
+	flushFunctionAndBucket(functionName)
+}
+
+func TestMissedTimersRateLimited(t *testing.T) {
+	time.Sleep(5 * time.Second)
+	functionName := t.Name()
+	handler := "bucket_op_with_timer_100s"
+	settings := &commonSettings{missedTimerPolicy: "rate_limit", missedTimerRate: 1, missedTimerThreshold: 60}
+
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, settings)
+	waitForDeployToFinish(functionName)
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+	time.Sleep(30 * time.Second) // Allow timers to get created
+
+	log.Println("Pausing function:", functionName)
+	setSettings(functionName, true, false, &commonSettings{})
+	waitForStatusChange(functionName, "paused", statsLookupRetryCounter)
+
+	time.Sleep(3 * time.Minute)
+
+	log.Printf("Resuming function: %s with from_prior feed boundary\n", functionName)
+	settings.streamBoundary = "from_prior"
+	createAndDeployFunction(functionName, handler, settings)
+
+	// Every missed timer fires, a few per partition each second
+	eventCount := verifyBucketOps(itemCount, statsLookupRetryCounter)
+	if itemCount != eventCount {
+		t.Error("For", "TestMissedTimersRateLimited",
+			"expected", itemCount,
+			"got", eventCount,
+		)
+	}
+
+	if deferred := getFailureStatCounter("timer_missed_deferred_counter", functionName); deferred == 0 {
+		t.Error("For", "TestMissedTimersRateLimited",
+			"expected missed timers to be deferred, got none",
+		)
+	}
+
+	log.Println("Undeploying function:", functionName)
+	setSettings(functionName, false, false, &commonSettings{})
+
+	time.Sleep(5 * time.Second)
+	flushFunctionAndBucket(functionName)
+}
+
+func TestAdoptTimersOnRedeploy(t *testing.T) {
+	time.Sleep(5 * time.Second)
+	functionName := t.Name()
+	handler := "bucket_op_with_timer_100s"
+
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, &commonSettings{})
+	waitForDeployToFinish(functionName)
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+	time.Sleep(30 * time.Second) // Allow timers to get created
+
+	log.Println("Undeploying function keeping its timers:", functionName)
+	setSettings(functionName, false, false, &commonSettings{adoptTimers: true})
+	waitForUndeployToFinish(functionName)
+
+	// Timers are due 100s after creation, none of them fired before undeploy
+	if eventCount := verifyBucketOps(0, statsLookupRetryCounter); eventCount != 0 {
+		t.Error("For", "TestAdoptTimersOnRedeploy",
+			"expected no timers to fire before redeploy, got", eventCount,
+		)
+	}
+
+	// Mutations aren't seen again from now, only timers kept can write to destination
+	log.Println("Redeploying function from now:", functionName)
+	createAndDeployFunction(functionName, handler, &commonSettings{streamBoundary: "from_now"})
+	waitForDeployToFinish(functionName)
+
+	eventCount := verifyBucketOps(itemCount, statsLookupRetryCounter)
+	if itemCount != eventCount {
+		t.Error("For", "TestAdoptTimersOnRedeploy",
+			"expected", itemCount,
+			"got", eventCount,
+		)
+	}
+
+	log.Println("Undeploying function:", functionName)
+	setSettings(functionName, false, false, &commonSettings{})
+
+	time.Sleep(5 * time.Second)
+	flushFunctionAndBucket(functionName)
+}
+
+func TestAdoptedTimersPurgedOnDelete(t *testing.T) {
+	time.Sleep(5 * time.Second)
+	functionName := t.Name()
+	handler := "bucket_op_with_timer_100s"
+
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, handler, &commonSettings{})
+	waitForDeployToFinish(functionName)
+
+	pumpBucketOps(opsType{}, &rateLimit{})
+	time.Sleep(30 * time.Second) // Allow timers to get created
+
+	setSettings(functionName, false, false, &commonSettings{adoptTimers: true})
+	waitForUndeployToFinish(functionName)
+
+	count, err := getBucketItemCount(metaBucket)
+	if count == 0 && err == nil {
+		t.Error("Expected timers to be kept in metadata bucket after undeploy")
+	}
+
+	deleteFunction(functionName)
+	time.Sleep(60 * time.Second) // Allow timers to get purged
+
+	count, err = getBucketItemCount(metaBucket)
+	if count != 0 && err == nil {
+		t.Error("Item count in metadata bucket after delete", count)
+	}
+
+	flushFunctionAndBucket(functionName)
+}
+
+func TestTimersCreatedOverREST(t *testing.T) {
+	time.Sleep(5 * time.Second)
+	functionName := t.Name()
+
+	// Mutations aren't seen from now, only timers set over REST can write to destination
+	flushFunctionAndBucket(functionName)
+	createAndDeployFunction(functionName, "bucket_op_with_timer_100s", &commonSettings{streamBoundary: "from_now"})
+	waitForDeployToFinish(functionName)
+
+	due := time.Now().Unix() + 10
+	for i := 0; i < itemCount; i++ {
+		docID := fmt.Sprintf("doc_id_%d", i)
+		request := &common.TimerRequest{
+			Callback:  "Callback",
+			Due:       due,
+			Reference: docID,
+			Context:   map[string]interface{}{"docID": docID},
+		}
+		if _, err := timers.ScheduleTimer(http.DefaultClient, eventingURL, username, password, functionName, request); err != nil {
+			t.Fatal("For", "TestTimersCreatedOverREST", "failed to schedule timer", docID, "err:", err)
+		}
+	}
+
+	eventCount := verifyBucketOps(itemCount, statsLookupRetryCounter)
+	if itemCount != eventCount {
+		t.Error("For", "TestTimersCreatedOverREST",
+			"expected", itemCount,
+			"got", eventCount,
+		)
+	}
+
+	log.Println("Undeploying function:", functionName)
+	setSettings(functionName, false, false, &commonSettings{})
+
+	time.Sleep(5 * time.Second)
+	flushFunctionAndBucket(functionName)
+}
+
 func TestDiffFeedBoundariesWithResume(t *testing.T) {
 	functionName := t.Name()
 
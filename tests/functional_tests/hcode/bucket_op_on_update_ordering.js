@@ -0,0 +1,10 @@
+function OnUpdate(doc, meta) {
+    var prev = dst_bucket[meta.id];
+    if (prev !== undefined && prev.version > doc.version) {
+        check_bucket['out_of_order::' + meta.id] = {'seen': prev.version, 'got': doc.version};
+    }
+    if (prev !== undefined && prev.version === doc.version) {
+        check_bucket['processed_twice::' + meta.id] = {'version': doc.version};
+    }
+    dst_bucket[meta.id] = {'version': doc.version};
+}
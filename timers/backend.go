@@ -0,0 +1,75 @@
+package timers
+
+// Cas is the version of a document as returned by a backend, to be passed back
+// for a write to go through only if the document hasn't changed since. Zero
+// matches any version.
+type Cas uint64
+
+// Backend is the document store holding alarm, context and span records of
+// timer stores. Values are JSON encoded. Couchbase KV, through kvPool, is the
+// default. Memory and file backends serve single node dev setups and tests.
+//
+// Like gocb, calls report a missing key as absent and a CAS or existence
+// conflict as mismatch rather than as an error. Errors are left for failures
+// that may go away on retry.
+type Backend interface {
+	Get(bucket, key string, valuePtr interface{}) (cas Cas, absent bool, err error)
+	Insert(bucket, key string, value interface{}, expiry uint32) (cas Cas, mismatch bool, err error)
+	Upsert(bucket, key string, value interface{}, expiry uint32) (cas Cas, err error)
+	Replace(bucket, key string, value interface{}, cas Cas, expiry uint32) (rcas Cas, absent bool, mismatch bool, err error)
+	Remove(bucket, key string, cas Cas) (rcas Cas, absent bool, mismatch bool, err error)
+	Counter(bucket, key string, delta, initial int64, expiry uint32) (count int64, cas Cas, err error)
+}
+
+// mustBackend retries backend calls until they succeed or MustRun gives up
+type mustBackend struct {
+	Backend
+}
+
+func (r mustBackend) MustUpsert(bucket, key string, value interface{}, expiry uint32) (cas Cas, err error) {
+	err = MustRun(func() (e error) {
+		cas, e = r.Upsert(bucket, key, value, expiry)
+		return
+	})
+	return
+}
+
+func (r mustBackend) MustCounter(bucket, key string, delta, initial int64, expiry uint32) (val int64, cas Cas, err error) {
+	err = MustRun(func() (e error) {
+		val, cas, e = r.Counter(bucket, key, delta, initial, expiry)
+		return
+	})
+	return
+}
+
+func (r mustBackend) MustGet(bucket, key string, valuePtr interface{}) (cas Cas, absent bool, err error) {
+	err = MustRun(func() (e error) {
+		cas, absent, e = r.Get(bucket, key, valuePtr)
+		return
+	})
+	return
+}
+
+func (r mustBackend) MustReplace(bucket, key string, value interface{}, cas Cas, expiry uint32) (rcas Cas, absent, mismatch bool, err error) {
+	err = MustRun(func() (e error) {
+		rcas, absent, mismatch, e = r.Replace(bucket, key, value, cas, expiry)
+		return
+	})
+	return
+}
+
+func (r mustBackend) MustInsert(bucket, key string, value interface{}, expiry uint32) (rcas Cas, mismatch bool, err error) {
+	err = MustRun(func() (e error) {
+		rcas, mismatch, e = r.Insert(bucket, key, value, expiry)
+		return
+	})
+	return
+}
+
+func (r mustBackend) MustRemove(bucket, key string, cas Cas) (rcas Cas, absent bool, mismatch bool, err error) {
+	err = MustRun(func() (e error) {
+		rcas, absent, mismatch, e = r.Remove(bucket, key, cas)
+		return
+	})
+	return
+}
@@ -110,6 +110,7 @@ func (r *kvPool) getConn(bucket string) (*gocb.Bucket, error) {
 }
 
 func getCluster(connstr string) (*gocb.Cluster, error) {
+	connstr = util.SecureConnStr(connstr)
 	logging.Infof("Connecting to cluster %rs", connstr)
 	conn, err := gocb.Connect(connstr)
 	if err != nil {
@@ -125,7 +126,7 @@ func getCluster(connstr string) (*gocb.Cluster, error) {
 	return conn, nil
 }
 
-func (r *kvPool) Upsert(bucket, key string, value interface{}, expiry uint32) (cas gocb.Cas, err error) {
+func (r *kvPool) Upsert(bucket, key string, value interface{}, expiry uint32) (cas Cas, err error) {
 	if r.status != nil {
 		return 0, r.status
 	}
@@ -135,11 +136,11 @@ func (r *kvPool) Upsert(bucket, key string, value interface{}, expiry uint32) (c
 		return
 	}
 	atomic.AddUint64(&r.stats.UpsertCounter, 1)
-	cas, err = conn.Upsert(key, value, expiry)
-	return
+	gcas, err := conn.Upsert(key, value, expiry)
+	return Cas(gcas), err
 }
 
-func (r *kvPool) Counter(bucket, key string, delta, initial int64, expiry uint32) (count int64, cas gocb.Cas, err error) {
+func (r *kvPool) Counter(bucket, key string, delta, initial int64, expiry uint32) (count int64, cas Cas, err error) {
 	if r.status != nil {
 		return initial, 0, r.status
 	}
@@ -149,12 +150,11 @@ func (r *kvPool) Counter(bucket, key string, delta, initial int64, expiry uint32
 		return
 	}
 	atomic.AddUint64(&r.stats.IncrCounter, 1)
-	ucount, cas, err := conn.Counter(key, delta, initial, expiry)
-	count = int64(ucount)
-	return
+	ucount, gcas, err := conn.Counter(key, delta, initial, expiry)
+	return int64(ucount), Cas(gcas), err
 }
 
-func (r *kvPool) Get(bucket, key string, valuePtr interface{}) (cas gocb.Cas, absent bool, err error) {
+func (r *kvPool) Get(bucket, key string, valuePtr interface{}) (cas Cas, absent bool, err error) {
 	if r.status != nil {
 		return 0, false, r.status
 	}
@@ -164,7 +164,8 @@ func (r *kvPool) Get(bucket, key string, valuePtr interface{}) (cas gocb.Cas, ab
 		return
 	}
 	atomic.AddUint64(&r.stats.LookupCounter, 1)
-	cas, err = conn.Get(key, valuePtr)
+	gcas, err := conn.Get(key, valuePtr)
+	cas = Cas(gcas)
 	if err != nil && gocb.IsKeyNotFoundError(err) {
 		absent = true
 		err = nil
@@ -172,7 +173,7 @@ func (r *kvPool) Get(bucket, key string, valuePtr interface{}) (cas gocb.Cas, ab
 	return
 }
 
-func (r *kvPool) Insert(bucket, key string, value interface{}, expiry uint32) (rcas gocb.Cas, mismatch bool, err error) {
+func (r *kvPool) Insert(bucket, key string, value interface{}, expiry uint32) (rcas Cas, mismatch bool, err error) {
 	if r.status != nil {
 		return 0, false, r.status
 	}
@@ -182,7 +183,8 @@ func (r *kvPool) Insert(bucket, key string, value interface{}, expiry uint32) (r
 		return
 	}
 	atomic.AddUint64(&r.stats.InsertCounter, 1)
-	rcas, err = conn.Insert(key, value, expiry)
+	gcas, err := conn.Insert(key, value, expiry)
+	rcas = Cas(gcas)
 	if err != nil && gocb.IsKeyNotFoundError(err) {
 		mismatch = true
 		err = nil
@@ -190,7 +192,7 @@ func (r *kvPool) Insert(bucket, key string, value interface{}, expiry uint32) (r
 	return
 }
 
-func (r *kvPool) Replace(bucket, key string, value interface{}, cas gocb.Cas, expiry uint32) (rcas gocb.Cas, absent bool, mismatch bool, err error) {
+func (r *kvPool) Replace(bucket, key string, value interface{}, cas Cas, expiry uint32) (rcas Cas, absent bool, mismatch bool, err error) {
 	if r.status != nil {
 		return 0, false, false, r.status
 	}
@@ -200,7 +202,8 @@ func (r *kvPool) Replace(bucket, key string, value interface{}, cas gocb.Cas, ex
 		return
 	}
 	atomic.AddUint64(&r.stats.ReplaceCounter, 1)
-	rcas, err = conn.Replace(key, value, cas, expiry)
+	gcas, err := conn.Replace(key, value, gocb.Cas(cas), expiry)
+	rcas = Cas(gcas)
 	if err != nil && gocb.IsKeyExistsError(err) {
 		mismatch = true
 		err = nil
@@ -212,7 +215,7 @@ func (r *kvPool) Replace(bucket, key string, value interface{}, cas gocb.Cas, ex
 	return
 }
 
-func (r *kvPool) Remove(bucket, key string, cas gocb.Cas) (rcas gocb.Cas, absent bool, mismatch bool, err error) {
+func (r *kvPool) Remove(bucket, key string, cas Cas) (rcas Cas, absent bool, mismatch bool, err error) {
 	if r.status != nil {
 		return 0, false, false, r.status
 	}
@@ -222,7 +225,8 @@ func (r *kvPool) Remove(bucket, key string, cas gocb.Cas) (rcas gocb.Cas, absent
 		return
 	}
 	atomic.AddUint64(&r.stats.RemoveCounter, 1)
-	rcas, err = conn.Remove(key, cas)
+	gcas, err := conn.Remove(key, gocb.Cas(cas))
+	rcas = Cas(gcas)
 	if err != nil && gocb.IsKeyExistsError(err) {
 		mismatch = true
 		err = nil
@@ -234,52 +238,6 @@ func (r *kvPool) Remove(bucket, key string, cas gocb.Cas) (rcas gocb.Cas, absent
 	return
 }
 
-func (r *kvPool) MustUpsert(bucket, key string, value interface{}, expiry uint32) (cas gocb.Cas, err error) {
-	err = MustRun(func() (e error) {
-		cas, e = r.Upsert(bucket, key, value, expiry)
-		return
-	})
-	return
-}
-
-func (r *kvPool) MustCounter(bucket, key string, delta, initial int64, expiry uint32) (val int64, cas gocb.Cas, err error) {
-	err = MustRun(func() (e error) {
-		val, cas, e = r.Counter(bucket, key, delta, initial, expiry)
-		return
-	})
-	return
-}
-
-func (r *kvPool) MustGet(bucket, key string, valuePtr interface{}) (cas gocb.Cas, absent bool, err error) {
-	err = MustRun(func() (e error) {
-		cas, absent, e = r.Get(bucket, key, valuePtr)
-		return
-	})
-	return
-}
-
-func (r *kvPool) MustReplace(bucket, key string, value interface{}, cas gocb.Cas, expiry uint32) (rcas gocb.Cas, absent, mismatch bool, err error) {
-	err = MustRun(func() (e error) {
-		rcas, absent, mismatch, e = r.Replace(bucket, key, value, cas, expiry)
-		return
-	})
-	return
-}
-func (r *kvPool) MustInsert(bucket, key string, value interface{}, expiry uint32) (rcas gocb.Cas, absent bool, err error) {
-	err = MustRun(func() (e error) {
-		rcas, absent, e = r.Insert(bucket, key, value, expiry)
-		return
-	})
-	return
-}
-func (r *kvPool) MustRemove(bucket, key string, cas gocb.Cas) (rcas gocb.Cas, absent bool, mismatch bool, err error) {
-	err = MustRun(func() (e error) {
-		rcas, absent, mismatch, e = r.Remove(bucket, key, cas)
-		return
-	})
-	return
-}
-
 func SetTimeout(tmout time.Duration) {
 	atomic.StoreInt64(&maxRetryTime, tmout.Nanoseconds())
 }
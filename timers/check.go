@@ -0,0 +1,501 @@
+package timers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Kinds of problems found by checker
+const (
+	IssueOrphanAlarm   = "orphan_alarm"   // alarm without context, as left by a crash or a cancel after fire
+	IssueStaleAlarm    = "stale_alarm"    // alarm superseded by a later timer with the same reference
+	IssueOrphanContext = "orphan_context" // context whose alarm is gone, so it never fires
+	IssueMissingChunks = "missing_chunks" // context whose overflow chunks are gone, so it never fires
+	IssueEmptyRow      = "empty_row"      // row counter of a past row without alarms
+	IssueSpanGap       = "span_gap"       // timer outside span, so it never fires
+)
+
+// MaxCheckIssues caps issues listed in a check report, counts go on beyond it
+const MaxCheckIssues = 1000
+
+// Lister is implemented by backends that can enumerate their keys. Checker uses
+// it to find contexts no alarm refers to, which can't be reached otherwise.
+type Lister interface {
+	Keys(bucket, prefix string) ([]string, error)
+}
+
+type CheckOptions struct {
+	DryRun bool
+	Margin int64         // seconds either side of span to look for timers left out of it, where keys can't be listed
+	Grace  time.Duration // between finding an orphan and confirming it
+	Lister Lister        // lists timer documents, backend of store is used instead if it's a Lister
+}
+
+// Checker walks rows of timer stores for orphaned documents and span gaps.
+// Walk expands spans to cover timers left out of them right away. Orphans
+// found are only repaired by Confirm if they are unchanged after grace period,
+// so that timers being set, fired or cancelled meanwhile aren't taken for them.
+type Checker struct {
+	options  CheckOptions
+	report   *common.TimerCheckReport
+	suspects []suspect
+
+	// Timer documents by partition, listed once for all stores walked. Nil if
+	// keys can't be listed, then rows are looked up around span.
+	listed map[int]*listedKeys
+}
+
+// listedKeys are timer documents of a partition found by listing
+type listedKeys struct {
+	rows     map[int64]struct{}
+	alarms   map[int64][]int64 // seqs of alarms by row
+	contexts []string
+}
+
+type suspect struct {
+	store *TimerStore
+	issue string
+	key   string
+	cas   Cas
+}
+
+func NewChecker(options CheckOptions, report *common.TimerCheckReport) *Checker {
+	report.DryRun = options.DryRun
+	if report.Issues == nil {
+		report.Issues = make([]common.TimerCheckIssue, 0)
+	}
+	return &Checker{options: options, report: report}
+}
+
+// Walk looks for problems in rows of store. Where timer documents are listed,
+// only rows found are walked, wherever they are. Otherwise rows within span
+// and margin around it are looked up.
+func (c *Checker) Walk(r *TimerStore) error {
+	listed, err := c.list(r)
+	if err != nil {
+		return err
+	}
+
+	span := r.span.Span
+	now := r.roundDown(time.Now().Unix())
+	seen := make(map[string]struct{})
+	gaps := make([]string, 0)
+	gapStart, gapStop := span.Start, span.Stop
+
+	c.report.Partitions++
+	for _, row := range c.rows(r, listed) {
+		var seqs []int64
+		if listed != nil {
+			seqs = listed.alarms[row]
+		}
+		rowFound, rcas, live, err := c.walkRow(r, row, seqs, listed == nil, seen)
+		if err != nil {
+			return err
+		}
+		if rowFound || len(live) > 0 {
+			c.report.Rows++
+		}
+		if rowFound && len(live) == 0 && row < now {
+			c.suspect(r, IssueEmptyRow, r.kvLocatorRoot(row), rcas)
+		}
+		if len(live) > 0 && (row < span.Start || row > span.Stop) {
+			gaps = append(gaps, live...)
+			if row < gapStart {
+				gapStart = row
+			}
+			if row > gapStop {
+				gapStop = row
+			}
+		}
+	}
+
+	if err := c.checkUnreachable(r, listed, seen); err != nil {
+		return err
+	}
+
+	// Gaps are only repaired once span is widened over them
+	widened := false
+	if !c.options.DryRun && len(gaps) > 0 {
+		if widened, err = c.closeGap(r, gapStart, gapStop); err != nil {
+			return err
+		}
+	}
+	for _, akey := range gaps {
+		c.issue(r, IssueSpanGap, akey, widened)
+	}
+	return nil
+}
+
+// rows lists rows of store to walk, in order
+func (c *Checker) rows(r *TimerStore, listed *listedKeys) []int64 {
+	rows := make([]int64, 0)
+	if listed != nil {
+		for row := range listed.rows {
+			rows = append(rows, row)
+		}
+		for row := range listed.alarms {
+			if _, found := listed.rows[row]; !found {
+				rows = append(rows, row)
+			}
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+		return rows
+	}
+
+	span := r.span.Span
+	for row := r.roundDown(span.Start - c.options.Margin); row <= r.roundUp(span.Stop+c.options.Margin); row += r.resolution {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// walkRow checks alarms of a row, which are the listed seqs or else those up
+// to its counter, and returns whether its counter was found along with alarms
+// of live timers in it
+func (c *Checker) walkRow(r *TimerStore, row int64, seqs []int64, probe bool, seen map[string]struct{}) (rowFound bool, rcas Cas, live []string, err error) {
+	seqEnd := int64(0)
+	rcas, absent, err := r.kv().MustGet(r.bucket, r.kvLocatorRoot(row), &seqEnd)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if probe {
+		if absent {
+			return false, 0, nil, nil
+		}
+		for seq := init_seq; seq <= seqEnd; seq++ {
+			seqs = append(seqs, seq)
+		}
+	} else {
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	}
+
+	for _, seq := range seqs {
+		akey := r.kvLocatorAlarm(row, seq)
+		found, err := c.walkAlarm(r, akey, seen)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		if found {
+			live = append(live, akey)
+		}
+	}
+	return !absent, rcas, live, nil
+}
+
+// walkAlarm checks an alarm and the context it refers to, and returns whether
+// it belongs to a live timer
+func (c *Checker) walkAlarm(r *TimerStore, akey string, seen map[string]struct{}) (bool, error) {
+	kv := r.kv()
+	alarm := AlarmRecord{}
+	acas, absent, err := kv.MustGet(r.bucket, akey, &alarm)
+	if err != nil || absent {
+		return false, err
+	}
+
+	context := ContextRecord{}
+	ccas, absent, err := kv.MustGet(r.bucket, alarm.ContextRef, &context)
+	if err != nil {
+		return false, err
+	}
+	if absent {
+		c.suspect(r, IssueOrphanAlarm, akey, acas)
+		return false, nil
+	}
+
+	if _, found := seen[alarm.ContextRef]; !found {
+		seen[alarm.ContextRef] = struct{}{}
+		if err = c.checkContext(r, alarm.ContextRef, &context, ccas); err != nil {
+			return false, err
+		}
+	}
+	if context.AlarmRef != akey {
+		c.suspect(r, IssueStaleAlarm, akey, acas)
+		return false, nil
+	}
+	c.report.Timers++
+	return true, nil
+}
+
+// checkContext looks for problems with a context reached from an alarm
+func (c *Checker) checkContext(r *TimerStore, ckey string, context *ContextRecord, ccas Cas) error {
+	_, absent, err := r.kv().MustGet(r.bucket, context.AlarmRef, &AlarmRecord{})
+	if err != nil {
+		return err
+	}
+	if absent {
+		c.suspect(r, IssueOrphanContext, ckey, ccas)
+		return nil
+	}
+
+	found, err := r.unpackContext(context)
+	if err != nil {
+		return err
+	}
+	if !found {
+		c.suspect(r, IssueMissingChunks, ckey, ccas)
+	}
+	return nil
+}
+
+// checkUnreachable looks at listed contexts no alarm walked refers to
+func (c *Checker) checkUnreachable(r *TimerStore, listed *listedKeys, seen map[string]struct{}) error {
+	if listed == nil {
+		return nil
+	}
+
+	for _, ckey := range listed.contexts {
+		if _, found := seen[ckey]; found {
+			continue
+		}
+		context := ContextRecord{}
+		ccas, absent, err := r.kv().MustGet(r.bucket, ckey, &context)
+		if err != nil {
+			return err
+		}
+		if absent {
+			continue
+		}
+		if err = c.checkContext(r, ckey, &context, ccas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns timer documents of partition of store, listing those of all
+// partitions the first time round. Nil is returned if keys can't be listed.
+func (c *Checker) list(r *TimerStore) (*listedKeys, error) {
+	if c.listed == nil {
+		lister := c.options.Lister
+		if lister == nil {
+			lister, _ = r.backend.(Lister)
+		}
+		if lister == nil {
+			return nil, nil
+		}
+
+		prefix := KeyPrefix(r.uid)
+		keys, err := lister.Keys(r.bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		c.listed = make(map[int]*listedKeys)
+		for _, key := range keys {
+			c.addListed(strings.TrimPrefix(key, prefix), key)
+		}
+	}
+
+	if listed, found := c.listed[r.partn]; found {
+		return listed, nil
+	}
+	return &listedKeys{}, nil
+}
+
+// addListed files key under its partition. Keys look like <partn>:rt:<due>,
+// <partn>:al:<due>:<seq> or <partn>:cx:<hash> past prefix, and the rest, such
+// as span, pending count and chunks, are left out.
+func (c *Checker) addListed(suffix, key string) {
+	parts := strings.Split(suffix, ":")
+	if len(parts) < 3 {
+		return
+	}
+	partn, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return
+	}
+
+	listed, found := c.listed[partn]
+	if !found {
+		listed = &listedKeys{rows: make(map[int64]struct{}), alarms: make(map[int64][]int64)}
+		c.listed[partn] = listed
+	}
+
+	switch {
+	case parts[1] == "rt" && len(parts) == 3:
+		if row, err := strconv.ParseInt(parts[2], encode_base, 64); err == nil {
+			listed.rows[row] = struct{}{}
+		}
+
+	case parts[1] == "al" && len(parts) == 4:
+		row, err := strconv.ParseInt(parts[2], encode_base, 64)
+		if err != nil {
+			return
+		}
+		if seq, err := strconv.ParseInt(parts[3], 10, 64); err == nil {
+			listed.alarms[row] = append(listed.alarms[row], seq)
+		}
+
+	case parts[1] == "cx" && len(parts) == 3:
+		listed.contexts = append(listed.contexts, key)
+	}
+}
+
+// closeGap widens persisted span to cover timers found outside it, and
+// returns whether it did. Workers owning the partition merge it into their
+// span on next sync.
+func (c *Checker) closeGap(r *TimerStore, start, stop int64) (bool, error) {
+	kv := r.kv()
+	pos := r.kvLocatorSpan()
+
+	for {
+		span := Span{}
+		cas, absent, err := kv.MustGet(r.bucket, pos, &span)
+		if err != nil || absent {
+			return false, err
+		}
+		if span.Start > start {
+			span.Start = start
+		}
+		if span.Stop < stop {
+			span.Stop = stop
+		}
+
+		_, absent, mismatch, err := kv.MustReplace(r.bucket, pos, span, cas, 0)
+		if err != nil || absent {
+			return false, err
+		}
+		if !mismatch {
+			logging.Infof("%v Checker widened span to %+v", r.log, span)
+			return true, nil
+		}
+	}
+}
+
+// Confirm takes a second look at orphans found after grace period, and repairs
+// the ones that are unchanged unless it's a dry run
+func (c *Checker) Confirm() error {
+	if len(c.suspects) > 0 {
+		time.Sleep(c.options.Grace)
+	}
+
+	for _, s := range c.suspects {
+		confirmed, err := c.confirm(s)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			continue
+		}
+
+		repaired := false
+		if !c.options.DryRun {
+			if repaired, err = c.repair(s); err != nil {
+				return err
+			}
+		}
+		c.issue(s.store, s.issue, s.key, repaired)
+	}
+
+	c.suspects = nil
+	return nil
+}
+
+func (c *Checker) confirm(s suspect) (bool, error) {
+	r := s.store
+	kv := r.kv()
+
+	switch s.issue {
+	case IssueOrphanAlarm, IssueStaleAlarm:
+		alarm := AlarmRecord{}
+		cas, absent, err := kv.MustGet(r.bucket, s.key, &alarm)
+		if err != nil || absent || cas != s.cas {
+			return false, err
+		}
+		context := ContextRecord{}
+		_, absent, err = kv.MustGet(r.bucket, alarm.ContextRef, &context)
+		return err == nil && (absent || context.AlarmRef != s.key), err
+
+	case IssueEmptyRow:
+		seqEnd := int64(0)
+		cas, absent, err := kv.MustGet(r.bucket, s.key, &seqEnd)
+		return err == nil && !absent && cas == s.cas, err
+
+	case IssueOrphanContext, IssueMissingChunks:
+		context := ContextRecord{}
+		cas, absent, err := kv.MustGet(r.bucket, s.key, &context)
+		if err != nil || absent || cas != s.cas {
+			return false, err
+		}
+		if s.issue == IssueMissingChunks {
+			found, err := r.unpackContext(&context)
+			return err == nil && !found, err
+		}
+		_, absent, err = kv.MustGet(r.bucket, context.AlarmRef, &AlarmRecord{})
+		return err == nil && absent, err
+	}
+	return false, nil
+}
+
+func (c *Checker) repair(s suspect) (bool, error) {
+	r := s.store
+	kv := r.kv()
+
+	switch s.issue {
+	case IssueOrphanAlarm, IssueStaleAlarm, IssueEmptyRow:
+		_, absent, mismatch, err := kv.MustRemove(r.bucket, s.key, s.cas)
+		if err != nil || absent || mismatch {
+			return false, err
+		}
+		if s.issue != IssueEmptyRow {
+			r.countPending(-1)
+		}
+		return true, nil
+
+	case IssueOrphanContext, IssueMissingChunks:
+		context := ContextRecord{}
+		_, _, err := kv.MustGet(r.bucket, s.key, &context)
+		if err != nil {
+			return false, err
+		}
+		liftOverflow(&context)
+		_, absent, mismatch, err := kv.MustRemove(r.bucket, s.key, s.cas)
+		if err != nil || absent || mismatch {
+			return false, err
+		}
+		if err = r.removeChunks(context.Overflow); err != nil {
+			return true, err
+		}
+		// Alarm of a timer with missing chunks is stale now
+		_, absent, _, err = kv.MustRemove(r.bucket, context.AlarmRef, 0)
+		if err == nil && !absent {
+			r.countPending(-1)
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+func (c *Checker) suspect(r *TimerStore, issue, key string, cas Cas) {
+	logging.Tracef("%v Checker suspects %v: %v", r.log, issue, key)
+	c.suspects = append(c.suspects, suspect{store: r, issue: issue, key: key, cas: cas})
+}
+
+func (c *Checker) issue(r *TimerStore, issue, key string, repaired bool) {
+	switch issue {
+	case IssueOrphanAlarm:
+		c.report.OrphanAlarms++
+	case IssueStaleAlarm:
+		c.report.StaleAlarms++
+	case IssueOrphanContext:
+		c.report.OrphanContexts++
+	case IssueMissingChunks:
+		c.report.MissingChunks++
+	case IssueEmptyRow:
+		c.report.EmptyRows++
+	case IssueSpanGap:
+		c.report.SpanGaps++
+	}
+	if repaired {
+		c.report.Repaired++
+	}
+
+	logging.Debugf("%v Checker found %v: %v repaired: %v", r.log, issue, key, repaired)
+	if len(c.report.Issues) < MaxCheckIssues {
+		c.report.Issues = append(c.report.Issues, common.TimerCheckIssue{Partition: r.partn, Kind: issue, Key: key, Repaired: repaired})
+	}
+}
@@ -0,0 +1,120 @@
+package timers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Time checker waits between finding an orphan and confirming it
+const CheckGrace = 15 * time.Second
+
+// CheckSource tells check task which stores to walk
+type CheckSource struct {
+	Partitions []int
+	Open       func(partn int) (store *TimerStore, found bool, err error)
+	Stop       func() error // error to give up with, looked at between partitions
+	Lister     Lister
+}
+
+// CheckTask runs timer store checker in background, one run at a time, and
+// keeps report of the last run
+type CheckTask struct {
+	log    string
+	lock   sync.Mutex
+	report *common.TimerCheckReport // Access controlled by lock
+}
+
+func NewCheckTask(log string) *CheckTask {
+	return &CheckTask{log: log}
+}
+
+// Start starts checker over stores of source, unless it's running
+func (t *CheckTask) Start(request *common.TimerCheckRequest, source CheckSource) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.report != nil && t.report.Running {
+		return fmt.Errorf("timer check started at %s is still running", t.report.Started)
+	}
+
+	report := &common.TimerCheckReport{
+		DryRun:  request.DryRun,
+		Running: true,
+		Started: time.Now().Format(time.RFC3339),
+		Issues:  make([]common.TimerCheckIssue, 0),
+	}
+	t.report = report
+
+	logging.Infof("%v Starting timer check: %+v", t.log, *request)
+	go t.run(request, source, copyCheckReport(report))
+	return nil
+}
+
+// Status returns report of last or ongoing run, nil if there wasn't any
+func (t *CheckTask) Status() *common.TimerCheckReport {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.report == nil {
+		return nil
+	}
+	return copyCheckReport(t.report)
+}
+
+// run works on a copy of report, which is published as partitions are done
+func (t *CheckTask) run(request *common.TimerCheckRequest, source CheckSource, progress *common.TimerCheckReport) {
+	checker := NewChecker(CheckOptions{
+		DryRun: request.DryRun,
+		Margin: request.Margin,
+		Grace:  CheckGrace,
+		Lister: source.Lister,
+	}, progress)
+
+	err := func() error {
+		for _, partn := range source.Partitions {
+			if err := source.Stop(); err != nil {
+				return err
+			}
+
+			store, found, err := source.Open(partn)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			if err = checker.Walk(store); err != nil {
+				return err
+			}
+			t.publish(progress)
+		}
+		return checker.Confirm()
+	}()
+
+	progress.Running = false
+	progress.Finished = time.Now().Format(time.RFC3339)
+	if err != nil {
+		progress.Error = err.Error()
+		logging.Errorf("%v Timer check failed, err: %v", t.log, err)
+	}
+	t.publish(progress)
+
+	logging.Infof("%v Timer check finished, partitions: %d timers: %d repaired: %d dry run: %t",
+		t.log, progress.Partitions, progress.Timers, progress.Repaired, progress.DryRun)
+}
+
+func (t *CheckTask) publish(progress *common.TimerCheckReport) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.report = copyCheckReport(progress)
+}
+
+func copyCheckReport(report *common.TimerCheckReport) *common.TimerCheckReport {
+	snapshot := *report
+	snapshot.Issues = append(make([]common.TimerCheckIssue, 0, len(report.Issues)), report.Issues...)
+	return &snapshot
+}
@@ -0,0 +1,186 @@
+package timers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+)
+
+// unlistedBackend hides Keys of backend, so that checker looks rows up
+type unlistedBackend struct {
+	Backend
+}
+
+// checkTestStore sets timers a and b, 100 and 200s out, and returns store
+// that set them along with its backend
+func checkTestStore(t *testing.T) (*TimerStore, *MemBackend, int64) {
+	backend := NewMemBackend()
+	store := newTestStore(t, backend)
+
+	now := time.Now().Unix()
+	if err := store.Set(now+100, "a", "context a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(now+200, "b", "context b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.syncSpan(); err != nil {
+		t.Fatal(err)
+	}
+	return store, backend, now
+}
+
+func runCheck(t *testing.T, backend Backend, options CheckOptions) *common.TimerCheckReport {
+	store, found, err := Inspect("test", 0, backend, "bucket", 0)
+	if err != nil || !found {
+		t.Fatalf("expected partition to be found, found: %v err: %v", found, err)
+	}
+
+	report := &common.TimerCheckReport{}
+	checker := NewChecker(options, report)
+	if err = checker.Walk(store); err != nil {
+		t.Fatal(err)
+	}
+	if err = checker.Confirm(); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestCheckHealthyStore(t *testing.T) {
+	_, backend, _ := checkTestStore(t)
+
+	report := runCheck(t, backend, CheckOptions{})
+	if report.Partitions != 1 || report.Rows != 2 || report.Timers != 2 || len(report.Issues) != 0 {
+		t.Errorf("expected 2 timers in 2 rows and no issues, got %+v", report)
+	}
+}
+
+func TestCheckOrphans(t *testing.T) {
+	store, backend, _ := checkTestStore(t)
+
+	// Context of a is gone, alarm of b is gone
+	a, _ := store.Lookup("a")
+	b, _ := store.Lookup("b")
+	if _, _, _, err := backend.Remove("bucket", a.ContextRef, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := backend.Remove("bucket", b.AlarmRef, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	report := runCheck(t, backend, CheckOptions{DryRun: true})
+	if report.OrphanAlarms != 1 || report.OrphanContexts != 1 || report.Repaired != 0 {
+		t.Fatalf("expected orphans to be found and left alone, got %+v", report)
+	}
+	if _, absent, _ := backend.Get("bucket", a.AlarmRef, &AlarmRecord{}); absent {
+		t.Errorf("expected dry run to leave orphan alarm in place")
+	}
+
+	report = runCheck(t, backend, CheckOptions{})
+	if report.OrphanAlarms != 1 || report.OrphanContexts != 1 || report.Repaired != 2 {
+		t.Fatalf("expected orphans to be repaired, got %+v", report)
+	}
+	if _, absent, _ := backend.Get("bucket", a.AlarmRef, &AlarmRecord{}); !absent {
+		t.Errorf("expected orphan alarm to be removed")
+	}
+	if _, absent, _ := backend.Get("bucket", b.ContextRef, &ContextRecord{}); !absent {
+		t.Errorf("expected context no alarm refers to to be removed")
+	}
+}
+
+func TestCheckOrphanContextNeedsListing(t *testing.T) {
+	store, backend, _ := checkTestStore(t)
+
+	b, _ := store.Lookup("b")
+	if _, _, _, err := backend.Remove("bucket", b.AlarmRef, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	report := runCheck(t, unlistedBackend{backend}, CheckOptions{Margin: 1000})
+	if report.OrphanContexts != 0 {
+		t.Errorf("expected context no alarm refers to be out of reach without listing, got %+v", report)
+	}
+
+	report = runCheck(t, unlistedBackend{backend}, CheckOptions{Lister: backend})
+	if report.OrphanContexts != 1 {
+		t.Errorf("expected context to be reached through lister of options, got %+v", report)
+	}
+}
+
+func TestCheckEmptyRow(t *testing.T) {
+	store, backend, now := checkTestStore(t)
+
+	row := store.kvLocatorRoot(now - 50)
+	if _, err := backend.Upsert("bucket", row, init_seq, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	report := runCheck(t, backend, CheckOptions{})
+	if report.EmptyRows != 1 || report.Repaired != 1 {
+		t.Errorf("expected empty past row to be repaired, got %+v", report)
+	}
+	if _, absent, _ := backend.Get("bucket", row, new(int64)); !absent {
+		t.Errorf("expected empty row counter to be removed")
+	}
+}
+
+func TestCheckSpanGap(t *testing.T) {
+	store, backend, now := checkTestStore(t)
+
+	// Span left timer a out
+	span := store.readSpan()
+	span.Start = now + 150
+	if _, err := backend.Upsert("bucket", store.kvLocatorSpan(), span, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows are only looked up within margin where keys can't be listed
+	report := runCheck(t, unlistedBackend{backend}, CheckOptions{DryRun: true})
+	if report.SpanGaps != 0 {
+		t.Errorf("expected timer out of margin not to be found, got %+v", report)
+	}
+	report = runCheck(t, unlistedBackend{backend}, CheckOptions{DryRun: true, Margin: 100})
+	if report.SpanGaps != 1 || report.Repaired != 0 {
+		t.Errorf("expected timer within margin to be found, got %+v", report)
+	}
+
+	report = runCheck(t, backend, CheckOptions{})
+	if report.SpanGaps != 1 || report.Repaired != 1 {
+		t.Fatalf("expected listed timer out of span to be repaired, got %+v", report)
+	}
+	persisted := Span{}
+	if _, _, err := backend.Get("bucket", store.kvLocatorSpan(), &persisted); err != nil {
+		t.Fatal(err)
+	}
+	if persisted.Start > now+100 {
+		t.Errorf("expected span to be widened over timer a, got %+v", persisted)
+	}
+}
+
+func TestCheckSpanGapNotRepairedWithoutSpan(t *testing.T) {
+	store, backend, now := checkTestStore(t)
+
+	span := store.readSpan()
+	span.Start = now + 150
+	if _, err := backend.Upsert("bucket", store.kvLocatorSpan(), span, 0); err != nil {
+		t.Fatal(err)
+	}
+	inspected, _, err := Inspect("test", 0, backend, "bucket", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Span is gone by the time gap is closed
+	if _, _, _, err = backend.Remove("bucket", store.kvLocatorSpan(), 0); err != nil {
+		t.Fatal(err)
+	}
+	report := &common.TimerCheckReport{}
+	if err = NewChecker(CheckOptions{}, report).Walk(inspected); err != nil {
+		t.Fatal(err)
+	}
+	if report.SpanGaps != 1 || report.Repaired != 0 {
+		t.Errorf("expected gap not to be counted as repaired, got %+v", report)
+	}
+}
@@ -0,0 +1,51 @@
+package timers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/couchbase/eventing/common"
+)
+
+// ScheduleTimer sets a timer of a deployed function from outside this project,
+// through eventing REST API at restURL, e.g. http://host:8096. Timer fires on
+// worker owning its partition as if handler had set it.
+func ScheduleTimer(client *http.Client, restURL, user, password, appName string, request *common.TimerRequest) (*common.TimerInfo, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(restURL, "/") + "/api/v1/functions/" + url.PathEscape(appName) + "/timers"
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(user, password)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("failed to schedule timer, status: %v response: %s", res.Status, data)
+	}
+
+	info := &common.TimerInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,62 @@
+package timers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/eventing/common"
+)
+
+func TestScheduleTimer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.EscapedPath() != "/api/v1/functions/my%20app/timers" {
+			http.NotFound(w, r)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		request := common.TimerRequest{}
+		data, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &request); err != nil || request.Callback != "fire" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"name":"ERR_INVALID_CONFIG"}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(common.TimerInfo{
+			Partition: Partition(request.Callback, request.Reference, 1024),
+			Due:       request.Due,
+			Reference: request.Reference,
+			Context:   request.Context,
+		})
+	}))
+	defer server.Close()
+
+	request := &common.TimerRequest{Callback: "fire", Due: 1000, Reference: "a", Context: "context"}
+	info, err := ScheduleTimer(http.DefaultClient, server.URL+"/", "admin", "secret", "my app", request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Due != 1000 || info.Reference != "a" || info.Context != "context" ||
+		info.Partition != Partition("fire", "a", 1024) {
+		t.Errorf("expected timer to be echoed back, got %+v", info)
+	}
+
+	_, err = ScheduleTimer(http.DefaultClient, server.URL, "admin", "wrong", "my app", request)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected failed status to be returned, got %v", err)
+	}
+
+	request.Callback = ""
+	_, err = ScheduleTimer(http.DefaultClient, server.URL, "admin", "secret", "my app", request)
+	if err == nil || !strings.Contains(err.Error(), "ERR_INVALID_CONFIG") {
+		t.Errorf("expected response to be returned along with error, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package timers
+
+import (
+	"hash/crc32"
+	"time"
+
+	"github.com/couchbase/eventing/logging"
+)
+
+// CallbackContext is how context of a timer set by a handler is stored, along
+// with name of the function it calls when it fires
+type CallbackContext struct {
+	Callback string      `json:"callback"`
+	Context  interface{} `json:"context"`
+}
+
+// Partition returns partition a timer with given callback and reference lives
+// in. It must agree with placement of timers set by handlers.
+func Partition(callback, ref string, numPartitions int) int {
+	return int(crc32.ChecksumIEEE([]byte(callback+":"+ref)) % uint32(numPartitions))
+}
+
+// Open opens timers of a partition for setting, while they may be owned and
+// fired by a worker elsewhere. Store returned isn't registered, and is only
+// good for Schedule.
+func Open(uid string, partn int, backend Backend, bucket string, config Config) (*TimerStore, error) {
+	if err := config.fillDefaults(); err != nil {
+		return nil, err
+	}
+	return newTimerStore(uid, partn, backend, bucket, config)
+}
+
+// Schedule sets a timer and persists span right away, so that worker owning
+// the partition merges it into its span on next sync
+func (r *TimerStore) Schedule(due int64, ref string, context interface{}) error {
+	if err := r.Set(due, ref, context); err != nil {
+		return err
+	}
+	if err := r.persistSpan(); err != nil {
+		return err
+	}
+
+	logging.Tracef("%v Scheduled timer %v due at %v", r.log, ref, due)
+	return nil
+}
+
+// ScheduleRecurring sets a recurring timer calling handler function callback,
+// and persists span like Schedule does. Schedule goes along in the context, as
+// worker owning the partition rearms the series from it each time it fires.
+func (r *TimerStore) ScheduleRecurring(callback, ref string, schedule Schedule, context interface{}) error {
+	if schedule.Interval != 0 && schedule.Start == 0 {
+		schedule.Start = time.Now().Unix()
+	}
+	schedule.Ref = ref
+
+	series := CallbackContext{
+		Callback: callback,
+		Context:  SeriesContext{Schedule: &schedule, Context: context},
+	}
+	if err := r.SetRecurring(ref, schedule, series); err != nil {
+		return err
+	}
+	if err := r.persistSpan(); err != nil {
+		return err
+	}
+
+	logging.Tracef("%v Scheduled recurring timer %v on %+v", r.log, ref, schedule)
+	return nil
+}
+
+func (r *TimerStore) persistSpan() error {
+	for {
+		mismatch, err := r.syncSpan()
+		if err != nil || !mismatch {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
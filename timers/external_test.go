@@ -0,0 +1,27 @@
+package timers
+
+import "testing"
+
+// Partitions are pinned to crc32 of callback and reference, as worker computes
+// them for timers set by handlers
+func TestPartition(t *testing.T) {
+	tests := []struct {
+		callback, ref string
+		partitions    int
+		crc           uint32
+	}{
+		{"timerCallback", "doc::1", 1024, 2908998438},
+		{"fire", "", 1024, 529348375},
+		{"cleanup", "order::42", 1024, 3531411867},
+		{"cleanup", "order::42", 128, 3531411867},
+		{"cleanup", "order::42", 1, 3531411867},
+	}
+
+	for _, test := range tests {
+		expected := int(test.crc % uint32(test.partitions))
+		if partn := Partition(test.callback, test.ref, test.partitions); partn != expected {
+			t.Errorf("%v:%v over %v partitions: expected %v, got %v",
+				test.callback, test.ref, test.partitions, expected, partn)
+		}
+	}
+}
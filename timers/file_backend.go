@@ -0,0 +1,151 @@
+package timers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/couchbase/eventing/logging"
+)
+
+// FileBackend keeps timer documents in memory and logs every change to a local
+// file, which is replayed when it's opened again. The log is compacted down to
+// live documents on open.
+type FileBackend struct {
+	*MemBackend
+	path string
+	file *os.File
+}
+
+// One line of the log, Doc is nil when key was removed
+type fileRecord struct {
+	Key string  `json:"k"`
+	Doc *memDoc `json:"d,omitempty"`
+}
+
+func OpenFileBackend(path string) (*FileBackend, error) {
+	logPrefix := "FileBackend::Open"
+
+	r := &FileBackend{
+		MemBackend: NewMemBackend(),
+		path:       path,
+	}
+
+	replayed, err := r.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.compact(); err != nil {
+		return nil, err
+	}
+
+	r.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r.persist = r.append
+
+	logging.Infof("%s Opened %v, replayed: %v live docs: %v", logPrefix, path, replayed, len(r.docs))
+	return r, nil
+}
+
+func (r *FileBackend) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.persist = nil
+	return r.file.Close()
+}
+
+func (r *FileBackend) replay() (int, error) {
+	logPrefix := "FileBackend::replay"
+
+	file, err := os.Open(r.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	replayed := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logging.Warnf("%s Dropping partially written last record of %v", logPrefix, r.path)
+			}
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		var record fileRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			logging.Warnf("%s Dropping unreadable record %v of %v: %v", logPrefix, replayed, r.path, err)
+			continue
+		}
+
+		if record.Doc == nil {
+			delete(r.docs, record.Key)
+		} else {
+			r.docs[record.Key] = record.Doc
+			if uint64(record.Doc.Cas) > r.cas {
+				r.cas = uint64(record.Doc.Cas)
+			}
+		}
+		replayed++
+	}
+}
+
+// compact rewrites log with only the live documents, swapping it in atomically
+func (r *FileBackend) compact() error {
+	tmpPath := r.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for key, doc := range r.docs {
+		if err = writeFileRecord(writer, &fileRecord{Key: key, Doc: doc}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err = writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.path)
+}
+
+// append logs a change, synced to disk before the write it belongs to returns
+func (r *FileBackend) append(key string, doc *memDoc) error {
+	if err := writeFileRecord(r.file, &fileRecord{Key: key, Doc: doc}); err != nil {
+		return err
+	}
+	return r.file.Sync()
+}
+
+func writeFileRecord(w io.Writer, record *fileRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
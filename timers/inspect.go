@@ -0,0 +1,172 @@
+package timers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Inspect opens timers of a partition for lookup, listing and cancellation,
+// while they may be owned and fired by a worker elsewhere. Store returned isn't
+// registered and never writes its span. Found is false when partition has
+// never had any timers. Resolution is that of function, for spans written by
+// worker, which leaves it out.
+func Inspect(uid string, partn int, backend Backend, bucket string, resolution int64) (store *TimerStore, found bool, err error) {
+	if resolution == 0 {
+		resolution = Resolution
+	}
+
+	store = &TimerStore{
+		backend:      backend,
+		bucket:       bucket,
+		uid:          uid,
+		partn:        partn,
+		log:          fmt.Sprintf("timerinspect:%v:%v", uid, partn),
+		resolution:   resolution,
+		inPastPolicy: InPastFire,
+		span:         storeSpan{empty: true, dirty: false},
+		metrics:      newTimerMetrics(),
+	}
+
+	span := Span{}
+	rcas, absent, err := store.kv().MustGet(bucket, store.kvLocatorSpan(), &span)
+	if err != nil || absent {
+		return nil, false, err
+	}
+
+	store.span.Span = span
+	store.span.spanCas = rcas
+	store.span.empty = false
+	if span.Resolution != 0 {
+		store.resolution = span.Resolution
+	}
+	return store, true, nil
+}
+
+// ScanRange returns iterator over timers due between from and to, inclusive.
+// Timers of row afterRow are only returned from column afterSeq on, so that a
+// listing can pick up where it left off. Unlike ScanDue, it leaves behind any
+// fired or cancelled timers it finds, and doesn't shrink span.
+func (r *TimerStore) ScanRange(from, to, afterRow, afterSeq int64) *TimerIter {
+	span := r.readSpan()
+
+	start := r.roundUp(from) - r.resolution
+	if start < span.Start {
+		start = span.Start
+	}
+
+	stop := r.roundDown(to)
+	if stop > span.Stop {
+		stop = span.Stop
+	}
+
+	if start >= stop {
+		return nil
+	}
+
+	iter := TimerIter{
+		store:    r,
+		row:      rowIter{start: start, current: start, stop: stop},
+		readOnly: true,
+		afterRow: afterRow,
+		afterSeq: afterSeq,
+	}
+
+	logging.Tracef("%v Created range iterator: %+v", r.log, iter)
+	return &iter
+}
+
+// Lookup returns pending timer with given reference, nil if there's none
+func (r *TimerStore) Lookup(ref string) (*TimerEntry, error) {
+	kv := r.kv()
+
+	context := ContextRecord{}
+	ccas, absent, err := kv.MustGet(r.bucket, r.kvLocatorContext(ref), &context)
+	if err != nil || absent {
+		return nil, err
+	}
+
+	// Alarm is gone if timer has just fired, or is being cancelled
+	alarm := AlarmRecord{}
+	acas, absent, err := kv.MustGet(r.bucket, context.AlarmRef, &alarm)
+	if err != nil || absent {
+		return nil, err
+	}
+
+	found, err := r.unpackContext(&context)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	seq, _ := strconv.ParseInt(context.AlarmRef[strings.LastIndex(context.AlarmRef, ":")+1:], 10, 64)
+	return &TimerEntry{AlarmRecord: alarm, ContextRecord: context, alarmSeq: seq, ctxCas: ccas, alrCas: acas}, nil
+}
+
+// CancelEntry removes a timer returned by ScanRange or Lookup, unless it has
+// since fired, moved on to next occurrence or been overwritten
+func (r *TimerStore) CancelEntry(entry *TimerEntry) (bool, error) {
+	kv := r.kv()
+
+	_, absent, mismatch, err := kv.MustRemove(r.bucket, entry.ContextRef, entry.ctxCas)
+	if err != nil || absent || mismatch {
+		return false, err
+	}
+
+	if err = r.removeChunks(entry.Overflow); err != nil {
+		return true, err
+	}
+
+	// Left over alarm is dropped by scan, as it has no context
+	_, absent, mismatch, err = kv.MustRemove(r.bucket, entry.AlarmRef, entry.alrCas)
+	if err != nil {
+		return true, err
+	}
+	if absent || mismatch {
+		logging.Debugf("%v Timer %v seq %v alarm changed while cancelling: %ru", r.log, entry.AlarmDue, entry.alarmSeq, *entry)
+	} else {
+		r.countPending(-1)
+	}
+	return true, nil
+}
+
+// Info describes timer for listing outside eventing
+func (r *TimerStore) Info(entry *TimerEntry) common.TimerInfo {
+	info := common.TimerInfo{
+		Partition:  r.partn,
+		Due:        entry.AlarmDue,
+		Seq:        entry.alarmSeq,
+		Context:    entry.Context,
+		AlarmKey:   entry.AlarmRef,
+		ContextKey: entry.ContextRef,
+	}
+	if entry.Schedule != nil {
+		info.Schedule = entry.Schedule
+	}
+	return info
+}
+
+// Purge removes every timer document of uid, for timers a function left behind
+// when it's deleted, and returns how many it removed. Documents are found by
+// listing, as the function's spans may no longer cover all of them.
+func Purge(uid string, backend Backend, lister Lister, bucket string) (int, error) {
+	keys, err := lister.Keys(bucket, KeyPrefix(uid))
+	if err != nil {
+		return 0, err
+	}
+
+	kv := mustBackend{backend}
+	removed := 0
+	for _, key := range keys {
+		_, absent, _, err := kv.MustRemove(bucket, key, 0)
+		if err != nil {
+			return removed, err
+		}
+		if !absent {
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,196 @@
+package timers
+
+import (
+	"testing"
+	"time"
+)
+
+// inspectTestStore sets timers a to d, with b and c due together, and opens
+// partition for inspection
+func inspectTestStore(t *testing.T) (*TimerStore, int64) {
+	backend := NewMemBackend()
+	store := newTestStore(t, backend)
+
+	now := time.Now().Unix()
+	timers := []struct {
+		ref string
+		due int64
+	}{{"a", now + 100}, {"b", now + 200}, {"c", now + 200}, {"d", now + 300}}
+	for _, timer := range timers {
+		if err := store.Set(timer.due, timer.ref, "context "+timer.ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.syncSpan(); err != nil {
+		t.Fatal(err)
+	}
+
+	inspected, found, err := Inspect("test", 0, backend, "bucket", 0)
+	if err != nil || !found {
+		t.Fatalf("expected partition to be found, found: %v err: %v", found, err)
+	}
+	if inspected.Resolution() != 1 {
+		t.Errorf("expected resolution of span to be used, got %v", inspected.Resolution())
+	}
+	return inspected, now
+}
+
+func scanRange(t *testing.T, store *TimerStore, from, to, afterRow, afterSeq int64) []*TimerEntry {
+	var entries []*TimerEntry
+	iter := store.ScanRange(from, to, afterRow, afterSeq)
+	for {
+		entry, err := iter.ScanNext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			return entries
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func contexts(entries []*TimerEntry) []interface{} {
+	contexts := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		contexts = append(contexts, entry.Context)
+	}
+	return contexts
+}
+
+func TestInspectMissingPartition(t *testing.T) {
+	store, found, err := Inspect("test", 1, NewMemBackend(), "bucket", 0)
+	if store != nil || found || err != nil {
+		t.Errorf("expected partition without timers not to be found, got found: %v err: %v", found, err)
+	}
+}
+
+func TestScanRange(t *testing.T) {
+	store, now := inspectTestStore(t)
+
+	tests := []struct {
+		name     string
+		from, to int64
+		expected []string
+	}{
+		{"all", now, now + 1000, []string{"context a", "context b", "context c", "context d"}},
+		{"inclusive", now + 200, now + 300, []string{"context b", "context c", "context d"}},
+		{"single row", now + 200, now + 200, []string{"context b", "context c"}},
+		{"past span", now + 400, now + 1000, nil},
+		{"before timers", now, now + 50, nil},
+	}
+
+	for _, test := range tests {
+		entries := contexts(scanRange(t, store, test.from, test.to, 0, 0))
+		if len(entries) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, entries)
+			continue
+		}
+		for i := range entries {
+			if entries[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, entries)
+				break
+			}
+		}
+	}
+
+	// Listing picks up after timer it left off at, from row of that timer on
+	entries := scanRange(t, store, now, now+1000, 0, 0)
+	resumed := scanRange(t, store, entries[1].AlarmDue, now+1000, entries[1].AlarmDue, entries[1].alarmSeq)
+	if got := contexts(resumed); len(got) != 2 || got[0] != "context c" || got[1] != "context d" {
+		t.Errorf("expected listing to resume after timer b, got %v", got)
+	}
+
+	// Timers listed are left in place
+	if got := scanRange(t, store, now, now+1000, 0, 0); len(got) != 4 {
+		t.Errorf("expected scan over range to leave timers behind, got %v", contexts(got))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	store, now := inspectTestStore(t)
+
+	entry, err := store.Lookup("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Context != "context b" || entry.AlarmDue != now+200 {
+		t.Fatalf("expected timer b to be found, got %+v", entry)
+	}
+	if info := store.Info(entry); info.Partition != 0 || info.Due != now+200 || info.ContextKey != store.kvLocatorContext("b") {
+		t.Errorf("expected info to describe timer b, got %+v", info)
+	}
+
+	if entry, err = store.Lookup("missing"); entry != nil || err != nil {
+		t.Errorf("expected missing timer not to be found, got %+v err: %v", entry, err)
+	}
+
+	// Timer whose alarm is gone has fired or is being cancelled
+	entry, _ = store.Lookup("b")
+	if _, _, _, err = store.backend.Remove("bucket", entry.AlarmRef, 0); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err = store.Lookup("b"); entry != nil || err != nil {
+		t.Errorf("expected timer without alarm not to be found, got %+v err: %v", entry, err)
+	}
+}
+
+func TestCancelEntry(t *testing.T) {
+	store, now := inspectTestStore(t)
+
+	entries := scanRange(t, store, now, now+1000, 0, 0)
+	cancelled, err := store.CancelEntry(entries[0])
+	if err != nil || !cancelled {
+		t.Fatalf("expected timer a to be cancelled, got %v err: %v", cancelled, err)
+	}
+	if entry, _ := store.Lookup("a"); entry != nil {
+		t.Errorf("expected cancelled timer not to be found, got %+v", entry)
+	}
+	if cancelled, err = store.CancelEntry(entries[0]); cancelled || err != nil {
+		t.Errorf("expected timer cancelled twice to be left alone, got %v err: %v", cancelled, err)
+	}
+
+	// Timer overwritten since it was listed isn't cancelled
+	if _, err = store.backend.Upsert("bucket", entries[1].ContextRef, entries[1].ContextRecord, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cancelled, err = store.CancelEntry(entries[1]); cancelled || err != nil {
+		t.Errorf("expected overwritten timer to be left alone, got %v err: %v", cancelled, err)
+	}
+
+	if got := contexts(scanRange(t, store, now, now+1000, 0, 0)); len(got) != 3 || got[0] != "context b" {
+		t.Errorf("expected timers b to d to be left, got %v", got)
+	}
+	if pending := store.Metrics().Pending; pending != 3 {
+		t.Errorf("expected 3 timers pending, got %v", pending)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	backend := NewMemBackend()
+	store := newTestStore(t, backend)
+	if err := store.Set(time.Now().Unix()+100, "a", "context a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.syncSpan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Upsert("bucket", "other:tm:0:sp", Span{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Purge("test", backend, backend, "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Root counter, alarm, context, pending count and span
+	if removed != 5 {
+		t.Errorf("expected 5 documents to be removed, got %v", removed)
+	}
+	if keys, _ := backend.Keys("bucket", KeyPrefix("test")); len(keys) != 0 {
+		t.Errorf("expected no timer documents to be left, got %v", keys)
+	}
+	if keys, _ := backend.Keys("bucket", KeyPrefix("other")); len(keys) != 1 {
+		t.Errorf("expected documents of other functions to be left alone, got %v", keys)
+	}
+}
@@ -0,0 +1,128 @@
+package timers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	couchbase "github.com/couchbase/eventing/dcp"
+	mcd "github.com/couchbase/eventing/dcp/transport"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+)
+
+// Time allowed for a listing to stream the whole bucket
+const listTimeout = 30 * time.Minute
+
+// DcpLister lists keys of a bucket by streaming every vbucket of it over DCP,
+// up to seqnos it had when listing started. Checker lists timer documents of
+// a function once this way, instead of looking up keys it can't reach.
+type DcpLister struct {
+	cluster string
+	config  map[string]interface{}
+}
+
+// NewDcpLister lists keys through cluster, given as host:port of ns_server
+func NewDcpLister(cluster string) *DcpLister {
+	return &DcpLister{
+		cluster: cluster,
+		config: map[string]interface{}{
+			"genChanSize":          10000,
+			"dataChanSize":         50,
+			"numConnections":       1,
+			"activeVbOnly":         true,
+			"connectionBufferSize": 20 * 1024 * 1024,
+			"bufferAckThreshold":   0.1,
+		},
+	}
+}
+
+// Keys lists keys of bucket starting with prefix
+func (l *DcpLister) Keys(bucket, prefix string) ([]string, error) {
+	b, err := util.ConnectBucket(l.cluster, "default", bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	name := couchbase.NewDcpFeedName(fmt.Sprintf("timerlist_%s_%d", bucket, time.Now().UnixNano()))
+	feed, err := b.StartDcpFeedOver(name, 0, 0, nil, 0xABCD, l.config)
+	if err != nil {
+		return nil, err
+	}
+	defer feed.Close()
+
+	seqnos, err := feed.DcpGetSeqnos()
+	if err != nil {
+		return nil, err
+	}
+	vbs := make([]uint16, 0, len(seqnos))
+	for vb, seqno := range seqnos {
+		if seqno > 0 {
+			vbs = append(vbs, vb)
+		}
+	}
+	if len(vbs) == 0 {
+		return []string{}, nil
+	}
+
+	flogs, err := b.GetFailoverLogs(0xABCD, vbs, l.config)
+	if err != nil {
+		return nil, err
+	}
+	for _, vb := range vbs {
+		flog := flogs[vb]
+		vbuuid, _, err := flog.Latest()
+		if err != nil {
+			return nil, err
+		}
+		err = feed.DcpRequestStream(vb, vb, 0, vbuuid, 0, seqnos[vb], 0, seqnos[vb])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	found := make(map[string]struct{})
+	timeout := time.NewTimer(listTimeout)
+	defer timeout.Stop()
+
+	for streaming := len(vbs); streaming > 0; {
+		select {
+		case e, ok := <-feed.C:
+			if !ok {
+				return nil, fmt.Errorf("feed of bucket %v closed while listing", bucket)
+			}
+
+			switch e.Opcode {
+			case mcd.DCP_MUTATION:
+				if key := string(e.Key); strings.HasPrefix(key, prefix) {
+					found[key] = struct{}{}
+				}
+
+			case mcd.DCP_DELETION, mcd.DCP_EXPIRATION:
+				delete(found, string(e.Key))
+
+			case mcd.DCP_STREAMREQ:
+				if e.Status != mcd.SUCCESS {
+					return nil, fmt.Errorf("stream of vb %v of bucket %v failed, status: %v", e.VBucket, bucket, e.Status)
+				}
+
+			case mcd.DCP_STREAMEND:
+				streaming--
+			}
+
+		case <-timeout.C:
+			return nil, fmt.Errorf("listing bucket %v took longer than %v", bucket, listTimeout)
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	logging.Infof("Listed %v keys of bucket %v with prefix %ru over %v vbs", len(keys), bucket, prefix, len(vbs))
+	return keys, nil
+}
@@ -0,0 +1,149 @@
+package timers
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MemBackend keeps timer documents in process memory. Expiry isn't supported,
+// as timer stores never set one.
+type MemBackend struct {
+	lock sync.Mutex
+	docs map[string]*memDoc
+	cas  uint64
+
+	// Called with lock held before a change is applied, doc is nil on removal
+	persist func(key string, doc *memDoc) error
+}
+
+type memDoc struct {
+	Value json.RawMessage `json:"val"`
+	Cas   Cas             `json:"cas"`
+}
+
+func NewMemBackend() *MemBackend {
+	return &MemBackend{
+		docs: make(map[string]*memDoc),
+	}
+}
+
+func (r *MemBackend) Get(bucket, key string, valuePtr interface{}) (cas Cas, absent bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	doc, found := r.docs[memLocator(bucket, key)]
+	if !found {
+		return 0, true, nil
+	}
+	return doc.Cas, false, json.Unmarshal(doc.Value, valuePtr)
+}
+
+func (r *MemBackend) Insert(bucket, key string, value interface{}, expiry uint32) (cas Cas, mismatch bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, found := r.docs[memLocator(bucket, key)]; found {
+		return 0, true, nil
+	}
+	cas, err = r.write(memLocator(bucket, key), value)
+	return
+}
+
+func (r *MemBackend) Upsert(bucket, key string, value interface{}, expiry uint32) (cas Cas, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.write(memLocator(bucket, key), value)
+}
+
+func (r *MemBackend) Replace(bucket, key string, value interface{}, cas Cas, expiry uint32) (rcas Cas, absent bool, mismatch bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	doc, found := r.docs[memLocator(bucket, key)]
+	if !found {
+		return 0, true, false, nil
+	}
+	if cas != 0 && cas != doc.Cas {
+		return 0, false, true, nil
+	}
+	rcas, err = r.write(memLocator(bucket, key), value)
+	return
+}
+
+func (r *MemBackend) Remove(bucket, key string, cas Cas) (rcas Cas, absent bool, mismatch bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	locator := memLocator(bucket, key)
+	doc, found := r.docs[locator]
+	if !found {
+		return 0, true, false, nil
+	}
+	if cas != 0 && cas != doc.Cas {
+		return 0, false, true, nil
+	}
+
+	if r.persist != nil {
+		if err = r.persist(locator, nil); err != nil {
+			return 0, false, false, err
+		}
+	}
+	delete(r.docs, locator)
+	return doc.Cas, false, false, nil
+}
+
+func (r *MemBackend) Counter(bucket, key string, delta, initial int64, expiry uint32) (count int64, cas Cas, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	locator := memLocator(bucket, key)
+	count = initial
+	if doc, found := r.docs[locator]; found {
+		if count, err = strconv.ParseInt(string(doc.Value), 10, 64); err != nil {
+			return 0, 0, err
+		}
+		count += delta
+	}
+
+	cas, err = r.write(locator, count)
+	return
+}
+
+// Keys lists keys of bucket starting with prefix
+func (r *MemBackend) Keys(bucket, prefix string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	keys := make([]string, 0)
+	for locator := range r.docs {
+		if strings.HasPrefix(locator, memLocator(bucket, prefix)) {
+			keys = append(keys, strings.TrimPrefix(locator, memLocator(bucket, "")))
+		}
+	}
+	return keys, nil
+}
+
+func (r *MemBackend) write(locator string, value interface{}) (Cas, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+
+	doc := &memDoc{Value: data, Cas: Cas(r.cas + 1)}
+	if r.persist != nil {
+		if err = r.persist(locator, doc); err != nil {
+			return 0, err
+		}
+	}
+
+	r.cas++
+	r.docs[locator] = doc
+	return doc.Cas, nil
+}
+
+func memLocator(bucket, key string) string {
+	return bucket + "/" + key
+}
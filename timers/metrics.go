@@ -0,0 +1,186 @@
+package timers
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Histograms are binned the same way as handler latency histograms, so they
+// can be read with the same tools. Fire lag is in milliseconds, scan time in
+// microseconds.
+const (
+	fireLagFrom  = 100
+	fireLagTill  = 1000 * 60 * 60
+	fireLagWidth = 100
+
+	scanTimeFrom  = 100
+	scanTimeTill  = 1000 * 1000 * 10
+	scanTimeWidth = 100
+
+	scanLookupsFrom  = 1
+	scanLookupsTill  = 10 * 1000
+	scanLookupsWidth = 1
+)
+
+// histogram counts samples into bins of fixed width between from and till.
+// Samples outside fall in first or last bin. Only bins seen are kept, as most
+// of them stay empty.
+type histogram struct {
+	from  int64
+	till  int64
+	width int64
+	lock  sync.Mutex
+	data  map[int64]uint64
+}
+
+// timerMetrics tell apart time timers wait to be scanned from time it takes
+// scan to reach them
+type timerMetrics struct {
+	pending     int64 // alarms written to partition and not yet removed, as last counted
+	unsynced    int64 // alarms written less those removed since pending was counted
+	batched     bool  // whether unsynced is left for sync routine to add to count
+	fireLag     *histogram
+	scanTime    *histogram
+	scanLookups *histogram
+}
+
+func newHistogram(from, till, width int64) *histogram {
+	return &histogram{from: from, till: till, width: width, data: make(map[int64]uint64)}
+}
+
+func newTimerMetrics() timerMetrics {
+	return timerMetrics{
+		fireLag:     newHistogram(fireLagFrom, fireLagTill, fireLagWidth),
+		scanTime:    newHistogram(scanTimeFrom, scanTimeTill, scanTimeWidth),
+		scanLookups: newHistogram(scanLookupsFrom, scanLookupsTill, scanLookupsWidth),
+	}
+}
+
+func (h *histogram) add(sample int64) {
+	bin := h.from
+	switch {
+	case sample <= h.from:
+	case sample >= h.till:
+		bin = ((h.till - h.from) / h.width) * h.width
+	default:
+		bin = ((sample-h.from)/h.width + 1) * h.width
+	}
+
+	h.lock.Lock()
+	h.data[bin]++
+	h.lock.Unlock()
+}
+
+func (h *histogram) get() common.StatsData {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stats := make(common.StatsData, len(h.data))
+	for bin, count := range h.data {
+		stats[strconv.FormatInt(bin, 10)] = count
+	}
+	return stats
+}
+
+// scanCost is what a scan spent to get to a timer
+type scanCost struct {
+	start   time.Time
+	lookups uint64
+}
+
+func (r *TimerIter) startCost() scanCost {
+	return scanCost{start: time.Now(), lookups: r.store.scanLookups()}
+}
+
+// recordFire notes how late a timer is handed out to fire, and what scan
+// spent to get to it since the previous one
+func (r *TimerIter) recordFire(entry *TimerEntry, cost scanCost) {
+	metrics := &r.store.metrics
+	now := time.Now()
+
+	metrics.fireLag.add(now.UnixNano()/int64(time.Millisecond) - entry.AlarmDue*1000)
+	metrics.scanTime.add(int64(now.Sub(cost.start) / time.Microsecond))
+	metrics.scanLookups.add(int64(r.store.scanLookups() - cost.lookups))
+}
+
+func (r *TimerStore) scanLookups() uint64 {
+	return atomic.LoadUint64(&r.stats.ScanRowLookupCounter) + atomic.LoadUint64(&r.stats.ScanColumnLookupCounter)
+}
+
+// countPending keeps count of alarms in partition, which is shared by all
+// stores opened on it. Stores fired from leave changes to sync routine, so
+// that timers aren't held up by a counter update each.
+func (r *TimerStore) countPending(delta int64) {
+	atomic.AddInt64(&r.metrics.unsynced, delta)
+	if !r.metrics.batched {
+		r.syncPending()
+	}
+}
+
+// syncPending adds changes since last sync to pending count. Count is only
+// informational, so failing to update it doesn't fail the operation, and the
+// changes are left for next sync.
+func (r *TimerStore) syncPending() {
+	delta := atomic.SwapInt64(&r.metrics.unsynced, 0)
+	if delta == 0 {
+		return
+	}
+
+	initial := delta
+	if initial < 0 {
+		initial = 0
+	}
+
+	count, _, err := r.kv().MustCounter(r.bucket, r.kvLocatorPending(), delta, initial, 0)
+	if err != nil {
+		logging.Warnf("%v Failed to update pending count by %v, err: %v", r.log, delta, err)
+		atomic.AddInt64(&r.metrics.unsynced, delta)
+		return
+	}
+	atomic.StoreInt64(&r.metrics.pending, count)
+}
+
+func (r *TimerStore) readPending() error {
+	count := int64(0)
+	_, _, err := r.kv().MustGet(r.bucket, r.kvLocatorPending(), &count)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&r.metrics.pending, count)
+	return nil
+}
+
+// Metrics returns firing metrics of store
+func (r *TimerStore) Metrics() *common.TimerFiringStats {
+	pending := atomic.LoadInt64(&r.metrics.pending) + atomic.LoadInt64(&r.metrics.unsynced)
+	if pending < 0 {
+		pending = 0
+	}
+
+	return &common.TimerFiringStats{
+		Pending:     pending,
+		FireLag:     r.metrics.fireLag.get(),
+		ScanTime:    r.metrics.scanTime.get(),
+		ScanLookups: r.metrics.scanLookups.get(),
+	}
+}
+
+// FiringStats returns firing metrics of stores of a function on this node,
+// by partition
+func FiringStats(uid string) map[int]*common.TimerFiringStats {
+	stores.lock.RLock()
+	defer stores.lock.RUnlock()
+
+	stats := make(map[int]*common.TimerFiringStats)
+	for _, store := range stores.entries {
+		if store.uid == uid {
+			stats[store.partn] = store.Metrics()
+		}
+	}
+	return stats
+}
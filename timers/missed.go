@@ -0,0 +1,130 @@
+package timers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/logging"
+)
+
+// MissedPolicy decides what becomes of timers found overdue by more than the
+// missed threshold of store, as happens after a pause or long downtime
+type MissedPolicy string
+
+const (
+	MissedFireAll    MissedPolicy = "fire_all"    // fired as they are found
+	MissedLatestOnly MissedPolicy = "latest_only" // only latest per reference is fired, at end of scan
+	MissedRateLimit  MissedPolicy = "rate_limit"  // fired at no more than missed rate, rest left for later scans
+	MissedDrop       MissedPolicy = "drop"        // dropped without firing
+)
+
+const (
+	MissedThreshold = int64(300) // seconds, default of stores
+	MissedRate      = int64(100) // timers per second, default of stores
+)
+
+// missedLimiter hands out missed rate worth of tokens every second
+type missedLimiter struct {
+	lock   sync.Mutex
+	tokens int64
+	refill time.Time
+}
+
+func (l *missedLimiter) take(rate int64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now := time.Now(); now.Sub(l.refill) >= time.Second {
+		l.tokens = rate
+		l.refill = now
+	}
+
+	if l.tokens <= 0 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// admit applies missed policy of store to timer just found by scan. A timer
+// that isn't to be fired is dropped or held here, unless scan has to stop to
+// respect the rate, in which case it stays for a later scan. Iterator covers
+// a single partition, so only that partition is left for later.
+func (r *TimerIter) admit(entry *TimerEntry) (fire bool, stop bool, err error) {
+	store := r.store
+
+	overdue := time.Now().Unix() - entry.AlarmDue
+	if overdue <= store.missedThreshold {
+		atomic.AddUint64(&store.stats.OnTimeCounter, 1)
+		return true, false, nil
+	}
+
+	switch store.missedPolicy {
+	case MissedLatestOnly:
+		return false, false, r.hold(entry)
+
+	case MissedRateLimit:
+		if !store.limiter.take(store.missedRate) {
+			atomic.AddUint64(&store.stats.MissedDeferredCounter, 1)
+			logging.Tracef("%v Missed timer rate reached, leaving timer %v seq %v for a later scan", store.log, entry.AlarmDue, entry.alarmSeq)
+			return false, true, nil
+		}
+
+	case MissedDrop:
+		atomic.AddUint64(&store.stats.MissedDroppedCounter, 1)
+		logging.Debugf("%v Dropping timer %v seq %v missed by %vs: %ru", store.log, entry.AlarmDue, entry.alarmSeq, overdue, *entry)
+		return false, false, store.Delete(entry)
+	}
+
+	atomic.AddUint64(&store.stats.MissedFiredCounter, 1)
+	return true, false, nil
+}
+
+// hold keeps missed timer till end of scan, so that only the latest timer of
+// its reference is fired. Whichever of it and any timer held for the same
+// reference is due earlier is dropped.
+func (r *TimerIter) hold(entry *TimerEntry) error {
+	store := r.store
+	if r.held == nil {
+		r.held = make(map[string]*TimerEntry)
+	}
+
+	dropped := entry
+	if held, found := r.held[entry.ContextRef]; !found || held.AlarmDue < entry.AlarmDue {
+		r.held[entry.ContextRef] = entry
+		dropped = held
+	}
+	if dropped == nil {
+		return nil
+	}
+
+	atomic.AddUint64(&store.stats.MissedCoalescedCounter, 1)
+	logging.Debugf("%v Dropping missed timer %v seq %v as a later one of its reference is due: %ru", store.log, dropped.AlarmDue, dropped.alarmSeq, *dropped)
+	return store.Delete(dropped)
+}
+
+// release hands out timers held by latest only policy once scan is done,
+// earliest due first
+func (r *TimerIter) release() *TimerEntry {
+	var next *TimerEntry
+	for _, entry := range r.held {
+		if next == nil || entry.AlarmDue < next.AlarmDue ||
+			(entry.AlarmDue == next.AlarmDue && entry.alarmSeq < next.alarmSeq) {
+			next = entry
+		}
+	}
+	if next != nil {
+		delete(r.held, next.ContextRef)
+		atomic.AddUint64(&r.store.stats.MissedFiredCounter, 1)
+	}
+	return next
+}
+
+// shrinkSpan moves span of store past rows scanned, unless missed timers are
+// held, which would be left out of span if scan isn't run to its end
+func (r *TimerIter) shrinkSpan(start int64) {
+	if len(r.held) == 0 {
+		r.store.shrinkSpan(start)
+	}
+}
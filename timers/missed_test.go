@@ -0,0 +1,99 @@
+package timers
+
+import (
+	"testing"
+	"time"
+)
+
+// newMissedTestStore sets up a store that takes every timer for missed
+func newMissedTestStore(t *testing.T, policy MissedPolicy) *TimerStore {
+	store := newTestStore(t, NewMemBackend())
+	store.missedPolicy = policy
+	store.missedThreshold = -60
+	return store
+}
+
+func TestLatestOnlyHoldsLatestPerReference(t *testing.T) {
+	store := newMissedTestStore(t, MissedLatestOnly)
+	iter := &TimerIter{store: store}
+
+	entry := func(ref string, due int64) *TimerEntry {
+		return &TimerEntry{
+			AlarmRecord:   AlarmRecord{AlarmDue: due, ContextRef: store.kvLocatorContext(ref)},
+			ContextRecord: ContextRecord{AlarmRef: store.kvLocatorAlarm(due, init_seq)},
+		}
+	}
+
+	for _, e := range []*TimerEntry{entry("a", 100), entry("b", 150), entry("a", 200), entry("a", 50)} {
+		fire, stop, err := iter.admit(e)
+		if fire || stop || err != nil {
+			t.Fatalf("expected missed timer %v to be held, got fire: %v stop: %v err: %v", e.AlarmDue, fire, stop, err)
+		}
+	}
+	if stats := store.Stats(); stats["meta_missed_timer_coalesced"] != 2 {
+		t.Errorf("expected 2 earlier timers of a to be dropped, got %v", stats)
+	}
+
+	var released []int64
+	for e := iter.release(); e != nil; e = iter.release() {
+		released = append(released, e.AlarmDue)
+	}
+	if len(released) != 2 || released[0] != 150 || released[1] != 200 {
+		t.Errorf("expected latest timer of each reference to be released earliest first, got %v", released)
+	}
+	if stats := store.Stats(); stats["meta_missed_timer_fired"] != 2 {
+		t.Errorf("expected released timers to be counted as fired, got %v", stats)
+	}
+}
+
+func TestLatestOnlyFiresAtEndOfScan(t *testing.T) {
+	store := newMissedTestStore(t, MissedLatestOnly)
+	due := time.Now().Unix() + 1
+	for _, ref := range []string{"a", "b"} {
+		if err := store.Set(due, ref, ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	span := store.readSpan()
+
+	time.Sleep(time.Duration(due-time.Now().Unix()+1) * time.Second)
+	iter := store.ScanDue()
+	first, err := iter.ScanNext()
+	if err != nil || first == nil || first.Context != "a" {
+		t.Fatalf("expected held timer a to be fired at end of scan, got %+v err: %v", first, err)
+	}
+	if len(iter.held) != 1 || store.readSpan().Start != span.Start {
+		t.Errorf("expected span to be left alone while timers are held, got %+v", store.readSpan())
+	}
+	if err = store.Delete(first); err != nil {
+		t.Fatal(err)
+	}
+
+	if contexts := scanAll(t, store); len(contexts) != 0 {
+		t.Errorf("expected timer b to be fired by previous scan, got %v", contexts)
+	}
+}
+
+func TestRateLimitStopsPartition(t *testing.T) {
+	store := newMissedTestStore(t, MissedRateLimit)
+	store.missedRate = 1
+	due := time.Now().Unix() + 1
+	for _, ref := range []string{"a", "b"} {
+		if err := store.Set(due, ref, ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(time.Duration(due-time.Now().Unix()+1) * time.Second)
+	if contexts := scanAll(t, store); len(contexts) != 1 {
+		t.Fatalf("expected only 1 timer to be fired within rate, got %v", contexts)
+	}
+	if stats := store.Stats(); stats["meta_missed_timer_deferred"] != 1 {
+		t.Errorf("expected timer over rate to be deferred, got %v", stats)
+	}
+
+	time.Sleep(time.Second)
+	if contexts := scanAll(t, store); len(contexts) != 1 {
+		t.Errorf("expected deferred timer to be fired by later scan, got %v", contexts)
+	}
+}
@@ -0,0 +1,239 @@
+package timers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+)
+
+// ContextOverflow decides what becomes of a timer context larger than context
+// size of store
+type ContextOverflow string
+
+const (
+	OverflowReject   ContextOverflow = "reject"   // Set fails with ErrContextTooLarge
+	OverflowCompress ContextOverflow = "compress" // compressed, and chunked if that isn't enough
+	OverflowChunk    ContextOverflow = "chunk"    // split across chunk documents
+)
+
+var ErrContextTooLarge = errors.New("timer context is larger than configured size")
+
+// Size of each chunk document a context is split across, short of the 20MiB
+// KV item limit to leave room for key and metadata. It doesn't follow context
+// size of store, which would make for many small documents. Same as
+// chunk_size of worker.
+const chunkSize = 19 * 1024 * 1024
+
+// Key of overflow standing in for a context too large to be kept in context
+// record as is, shared with worker
+const overflowKey = "_ovf"
+
+// Overflow holds a context too large to be kept in its record as is. Packed
+// context is inline, or split across Chunks documents starting at ChunkRef, and
+// is compressed if it starts with a 0 byte.
+type Overflow struct {
+	Packed   []byte `json:"pck,omitempty"`
+	ChunkRef string `json:"ref,omitempty"`
+	Chunks   int    `json:"chk,omitempty"`
+	Size     int    `json:"sz"`
+}
+
+// overflowContext stands in for context held in overflow. Only context of a
+// timer set for a handler is replaced, as worker firing it needs callback.
+type overflowContext struct {
+	Overflow *Overflow `json:"_ovf"`
+}
+
+// splitContext returns the part of context subject to overflow, along with a
+// function putting a replacement back in its place
+func splitContext(context interface{}) (interface{}, func(interface{}) interface{}) {
+	switch value := context.(type) {
+	case CallbackContext:
+		return value.Context, func(inner interface{}) interface{} {
+			return CallbackContext{Callback: value.Callback, Context: inner}
+		}
+
+	case map[string]interface{}:
+		if callback, ok := value["callback"].(string); ok && len(value) <= 2 {
+			return value["context"], func(inner interface{}) interface{} {
+				return map[string]interface{}{"callback": callback, "context": inner}
+			}
+		}
+	}
+	return context, func(inner interface{}) interface{} { return inner }
+}
+
+// overflowed returns context with overflow standing in for the part it holds
+func overflowed(context interface{}, overflow *Overflow) interface{} {
+	_, put := splitContext(context)
+	return put(overflowContext{Overflow: overflow})
+}
+
+// liftOverflow picks up overflow of a record, whether it was packed here or
+// by worker
+func liftOverflow(crecord *ContextRecord) {
+	if crecord.Overflow != nil {
+		return
+	}
+
+	inner, _ := splitContext(crecord.Context)
+	context, ok := inner.(map[string]interface{})
+	if !ok || len(context) != 1 {
+		return
+	}
+	spec, ok := context[overflowKey]
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return
+	}
+	overflow := &Overflow{}
+	if err = json.Unmarshal(data, overflow); err != nil || (overflow.Chunks == 0 && len(overflow.Packed) == 0) {
+		return
+	}
+	crecord.Overflow = overflow
+}
+
+// encodeContext returns context as stored in overflow if it's too large for
+// context size of store, nil if it fits in the record as is
+func (r *TimerStore) encodeContext(context interface{}) ([]byte, error) {
+	if r.contextSize == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(context)
+	if err != nil || int64(len(data)) <= r.contextSize {
+		return nil, err
+	}
+
+	if r.contextOverflow == OverflowReject {
+		atomic.AddUint64(&r.stats.ContextTooLargeCounter, 1)
+		return nil, ErrContextTooLarge
+	}
+	return data, nil
+}
+
+// packContext returns overflow for a context that doesn't fit context size of
+// store. Chunks are named after alarm of timer, so that a timer replacing
+// another with the same reference doesn't overwrite chunks being fired.
+func (r *TimerStore) packContext(akey string, data []byte) (*Overflow, error) {
+	overflow := &Overflow{Size: len(data)}
+	if r.contextOverflow == OverflowCompress {
+		packed, err := util.MaybeCompress(data, true)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&r.stats.ContextCompressedCounter, 1)
+		if int64(len(packed)) <= r.contextSize {
+			overflow.Packed = packed
+			return overflow, nil
+		}
+		data = packed
+	}
+
+	kv := r.kv()
+	overflow.ChunkRef = akey + ":ck"
+	for start := 0; start < len(data); start += chunkSize {
+		stop := start + chunkSize
+		if stop > len(data) {
+			stop = len(data)
+		}
+		_, err := kv.MustUpsert(r.bucket, kvLocatorChunk(overflow.ChunkRef, overflow.Chunks), data[start:stop], 0)
+		if err != nil {
+			return nil, err
+		}
+		overflow.Chunks++
+	}
+
+	atomic.AddUint64(&r.stats.ContextChunkedCounter, 1)
+	atomic.AddUint64(&r.stats.ContextChunkDocsCounter, uint64(overflow.Chunks))
+	return overflow, nil
+}
+
+// unpackContext reassembles context of a record holding overflow, and picks up
+// schedule of a recurring timer set by a handler. Found is false if a chunk is
+// missing, which happens when timer is being cancelled or replaced.
+func (r *TimerStore) unpackContext(crecord *ContextRecord) (found bool, err error) {
+	liftOverflow(crecord)
+	if crecord.Overflow == nil {
+		liftSchedule(crecord)
+		return true, nil
+	}
+
+	data := crecord.Overflow.Packed
+	if crecord.Overflow.Chunks > 0 {
+		kv := r.kv()
+		data = make([]byte, 0, crecord.Overflow.Size)
+		for i := 0; i < crecord.Overflow.Chunks; i++ {
+			var chunk []byte
+			_, absent, err := kv.MustGet(r.bucket, kvLocatorChunk(crecord.Overflow.ChunkRef, i), &chunk)
+			if err != nil {
+				return false, err
+			}
+			if absent {
+				atomic.AddUint64(&r.stats.ContextChunkMissingCounter, 1)
+				logging.Debugf("%v Chunk %v of %v missing for context %v", r.log, i, crecord.Overflow.ChunkRef, crecord.AlarmRef)
+				return false, nil
+			}
+			data = append(data, chunk...)
+		}
+	}
+
+	data, err = util.MaybeDecompress(data)
+	if err != nil {
+		return false, err
+	}
+	var context interface{}
+	if err = json.Unmarshal(data, &context); err != nil {
+		return false, fmt.Errorf("unable to decode overflow context %v: %v", crecord.AlarmRef, err)
+	}
+	_, put := splitContext(crecord.Context)
+	crecord.Context = put(context)
+	liftSchedule(crecord)
+
+	atomic.AddUint64(&r.stats.ContextUnpackCounter, 1)
+	return true, nil
+}
+
+// removeChunks deletes chunk documents of an overflow, once no record refers to them
+func (r *TimerStore) removeChunks(overflow *Overflow) error {
+	if overflow == nil {
+		return nil
+	}
+
+	kv := r.kv()
+	for i := 0; i < overflow.Chunks; i++ {
+		_, absent, _, err := kv.MustRemove(r.bucket, kvLocatorChunk(overflow.ChunkRef, i), 0)
+		if err != nil {
+			return err
+		}
+		if absent {
+			logging.Debugf("%v Chunk %v of %v already removed", r.log, i, overflow.ChunkRef)
+		}
+	}
+	return nil
+}
+
+// ChunkKeys lists chunk documents holding context of timer, if any
+func (e *TimerEntry) ChunkKeys() []string {
+	if e.Overflow == nil || e.Overflow.Chunks == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, e.Overflow.Chunks)
+	for i := 0; i < e.Overflow.Chunks; i++ {
+		keys = append(keys, kvLocatorChunk(e.Overflow.ChunkRef, i))
+	}
+	return keys
+}
+
+func kvLocatorChunk(ref string, index int) string {
+	return fmt.Sprintf("%v:%v", ref, index)
+}
@@ -0,0 +1,63 @@
+package timers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLiftOverflow(t *testing.T) {
+	overflow := &Overflow{ChunkRef: "uid:tm:5:cx:ab:ck:7", Chunks: 3, Size: 4000}
+
+	tests := []struct {
+		name     string
+		context  interface{}
+		expected *Overflow
+	}{
+		{"handler timer", CallbackContext{Callback: "fn", Context: overflowContext{overflow}}, overflow},
+		{"other timer", overflowContext{overflow}, overflow},
+		{"inline", CallbackContext{Callback: "fn", Context: overflowContext{&Overflow{Packed: []byte{0, 0, 1}, Size: 90}}},
+			&Overflow{Packed: []byte{0, 0, 1}, Size: 90}},
+		{"plain context", CallbackContext{Callback: "fn", Context: map[string]interface{}{"a": 1}}, nil},
+		{"key among others", CallbackContext{Callback: "fn", Context: map[string]interface{}{overflowKey: overflow, "a": 1}}, nil},
+		{"empty overflow", CallbackContext{Callback: "fn", Context: map[string]interface{}{overflowKey: map[string]interface{}{"sz": 10}}}, nil},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(ContextRecord{Context: test.context})
+		if err != nil {
+			t.Fatal(err)
+		}
+		crecord := ContextRecord{}
+		if err = json.Unmarshal(data, &crecord); err != nil {
+			t.Fatal(err)
+		}
+
+		liftOverflow(&crecord)
+		if !reflect.DeepEqual(crecord.Overflow, test.expected) {
+			t.Errorf("%s: expected overflow %+v, got %+v", test.name, test.expected, crecord.Overflow)
+		}
+	}
+}
+
+func TestOverflowedKeepsCallback(t *testing.T) {
+	overflow := &Overflow{ChunkRef: "ref", Chunks: 1, Size: 10}
+
+	var stored map[string]interface{}
+	data, _ := json.Marshal(overflowed(CallbackContext{Callback: "fn", Context: "large"}, overflow))
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored["callback"] != "fn" {
+		t.Errorf("expected callback to be kept, got %v", stored)
+	}
+
+	inner, put := splitContext(stored)
+	if _, ok := inner.(map[string]interface{})[overflowKey]; !ok {
+		t.Errorf("expected overflow in place of context, got %v", inner)
+	}
+	restored := put("large").(map[string]interface{})
+	if restored["callback"] != "fn" || restored["context"] != "large" {
+		t.Errorf("expected context put back along with callback, got %v", restored)
+	}
+}
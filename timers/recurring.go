@@ -0,0 +1,152 @@
+package timers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/eventing/common"
+	"github.com/couchbase/eventing/logging"
+)
+
+// Key of schedule in context of a recurring timer set for a handler
+const seriesKey = "_series"
+
+// SeriesContext is how context of a recurring timer set for a handler is kept,
+// so that worker firing it finds schedule to rearm the series with
+type SeriesContext struct {
+	Schedule *Schedule   `json:"_series"`
+	Context  interface{} `json:"context,omitempty"`
+}
+
+// SetRecurring creates a timer that fires on schedule until it's cancelled. It
+// replaces any timer, one-shot or recurring, with the same reference.
+func (r *TimerStore) SetRecurring(ref string, schedule Schedule, context interface{}) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	if schedule.Interval != 0 && schedule.Interval < r.resolution {
+		return fmt.Errorf("interval %vs is shorter than timer resolution %vs", schedule.Interval, r.resolution)
+	}
+
+	now := time.Now().Unix()
+	if schedule.Interval != 0 && schedule.Start == 0 {
+		schedule.Start = now
+	}
+
+	due, err := schedule.Next(now)
+	if err != nil {
+		return err
+	}
+
+	schedule.Ref = ref
+	atomic.AddUint64(&r.stats.SeriesSetCounter, 1)
+	return r.set(due, ref, context, &schedule)
+}
+
+// rearm moves a fired recurring timer on to its next occurrence. Context record
+// is replaced with CAS it was fired with, so the series is rearmed exactly once
+// and a concurrent cancel or overwrite wins over it. Alarm left behind by a
+// lost race is dropped when scan finds it superseded.
+func (r *TimerStore) rearm(entry *TimerEntry) error {
+	kv := r.kv()
+
+	due, err := entry.Schedule.Next(time.Now().Unix())
+	if err != nil {
+		atomic.AddUint64(&r.stats.SeriesRearmFailedCounter, 1)
+		logging.Errorf("%v Timer %v seq %v has no next occurrence, ending series: %v", r.log, entry.AlarmDue, entry.alarmSeq, err)
+
+		_, absent, mismatch, err := kv.MustRemove(r.bucket, entry.ContextRef, entry.ctxCas)
+		if err != nil || absent || mismatch {
+			return err
+		}
+		return r.removeChunks(entry.Overflow)
+	}
+
+	if due, err = r.adjustDue(due, entry.Context); err != nil {
+		return err
+	}
+	akey, err := r.writeAlarm(due, entry.ContextRef)
+	if err != nil {
+		return err
+	}
+	r.expandSpan(due)
+
+	crecord := entry.ContextRecord
+	crecord.AlarmRef = akey
+	// Next occurrence reuses chunks, rather than context unpacked for firing
+	if crecord.Overflow != nil {
+		crecord.Context = overflowed(crecord.Context, crecord.Overflow)
+	}
+	_, absent, mismatch, err := kv.MustReplace(r.bucket, entry.ContextRef, crecord, entry.ctxCas, 0)
+	if err != nil {
+		return err
+	}
+	if absent || mismatch {
+		logging.Debugf("%v Timer %v seq %v was cancelled or overridden after it fired, not rearming: %ru", r.log, entry.AlarmDue, entry.alarmSeq, *entry)
+		return nil
+	}
+
+	logging.Tracef("%v Rearmed timer %v seq %v at %v alarm %v", r.log, entry.AlarmDue, entry.alarmSeq, formatInt(due), akey)
+	atomic.AddUint64(&r.stats.SeriesRearmCounter, 1)
+	return nil
+}
+
+// ListSeries returns recurring timers pending in the store, whether they were set
+// through SetRecurring or by a handler
+func (r *TimerStore) ListSeries() ([]common.TimerInfo, error) {
+	series := make([]common.TimerInfo, 0)
+
+	iter := r.ScanRange(0, math.MaxInt64, 0, 0)
+	for {
+		entry, err := iter.ScanNext()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Schedule == nil {
+			continue
+		}
+
+		info := r.Info(entry)
+		info.Reference = entry.Schedule.Ref
+		series = append(series, info)
+	}
+	return series, nil
+}
+
+// liftSchedule picks up schedule of a recurring timer set by a handler. Worker
+// keeps it in context the timer is fired with, as it has no say over the rest
+// of context record.
+func liftSchedule(crecord *ContextRecord) {
+	if crecord.Schedule != nil {
+		return
+	}
+
+	callback, ok := crecord.Context.(map[string]interface{})
+	if !ok {
+		return
+	}
+	context, ok := callback["context"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	spec, ok := context[seriesKey]
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return
+	}
+	schedule := &Schedule{}
+	if err = json.Unmarshal(data, schedule); err != nil || schedule.Validate() != nil {
+		return
+	}
+	crecord.Schedule = schedule
+}
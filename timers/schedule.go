@@ -0,0 +1,217 @@
+package timers
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule of a recurring timer. Either Cron or Interval must be set. Each
+// occurrence is pushed out by a random delay of up to Jitter seconds, so that
+// timers sharing a schedule don't all fire together. Ref is reference of the
+// series, for it to be listed and rearmed under.
+type Schedule struct {
+	Ref      string `json:"ref,omitempty"`
+	Cron     string `json:"cron,omitempty"` // minute hour day-of-month month day-of-week, in UTC
+	Interval int64  `json:"ivl,omitempty"`  // seconds
+	Jitter   int64  `json:"jit,omitempty"`  // seconds
+	Start    int64  `json:"sta,omitempty"`  // occurrences of Interval are aligned to it
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Bounds of cron fields, in the order they appear in expression
+var cronFieldBounds = []struct {
+	name     string
+	min, max uint
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func (s *Schedule) Validate() error {
+	switch {
+	case s.Cron != "" && s.Interval != 0:
+		return fmt.Errorf("schedule can't have both cron and interval")
+	case s.Cron == "" && s.Interval == 0:
+		return fmt.Errorf("schedule needs either cron or interval")
+	case s.Interval != 0 && s.Interval < MinResolution:
+		return fmt.Errorf("interval %vs is shorter than timer resolution %vs", s.Interval, MinResolution)
+	case s.Jitter < 0:
+		return fmt.Errorf("jitter %vs can't be negative", s.Jitter)
+	}
+
+	if s.Cron != "" {
+		_, err := parseCron(s.Cron)
+		return err
+	}
+	return nil
+}
+
+// Next returns due time of first occurrence after now, jitter included
+func (s *Schedule) Next(now int64) (int64, error) {
+	var next int64
+
+	if s.Cron != "" {
+		expr, err := parseCron(s.Cron)
+		if err != nil {
+			return 0, err
+		}
+		t, found := expr.next(time.Unix(now, 0).UTC())
+		if !found {
+			return 0, fmt.Errorf("cron %v has no occurrence in the next 5 years", s.Cron)
+		}
+		next = t.Unix()
+	} else {
+		next = s.Start + s.Interval
+		if now >= next {
+			next += ((now-next)/s.Interval + 1) * s.Interval
+		}
+	}
+
+	if s.Jitter > 0 {
+		next += rand.Int63n(s.Jitter + 1)
+	}
+	return next, nil
+}
+
+func parseCron(spec string) (*cronExpr, error) {
+	spec = strings.TrimSpace(spec)
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFieldBounds) {
+		return nil, fmt.Errorf("cron %q must have %d fields, found %d", spec, len(cronFieldBounds), len(fields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		bounds := cronFieldBounds[i]
+		if bits[i], err = parseCronField(field, bounds.min, bounds.max); err != nil {
+			return nil, fmt.Errorf("cron %q %s: %v", spec, bounds.name, err)
+		}
+	}
+
+	// Both 0 and 7 stand for Sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &cronExpr{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField handles comma separated lists of *, n, n-m along with an
+// optional /step on each
+func parseCronField(field string, min, max uint) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangeSpec, step := part, uint64(1)
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			rangeSpec = part[:idx]
+			step, err = strconv.ParseUint(part[idx+1:], 10, 8)
+			if err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rangeSpec != "*" {
+			bounds := strings.SplitN(rangeSpec, "-", 2)
+			val, err := strconv.ParseUint(bounds[0], 10, 8)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			lo, hi = uint(val), uint(val)
+
+			if len(bounds) == 2 {
+				val, err = strconv.ParseUint(bounds[1], 10, 8)
+				if err != nil {
+					return 0, fmt.Errorf("invalid range in %q", part)
+				}
+				hi = uint(val)
+			} else if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for val := lo; val <= hi; val += uint(step) {
+			bits |= 1 << val
+		}
+	}
+
+	return bits, nil
+}
+
+func (c *cronExpr) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// As in cron, restricting both day fields matches days satisfying either
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns first minute strictly after t matching expression
+func (c *cronExpr) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
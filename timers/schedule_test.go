@@ -0,0 +1,144 @@
+package timers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec   string
+		valid  bool
+		minute uint64
+		dow    uint64
+	}{
+		{"* * * * *", true, 1<<60 - 1, 1<<8 - 1},
+		{"0 0 * * *", true, 1, 1<<8 - 1},
+		{"5,10 * * * *", true, 1<<5 | 1<<10, 1<<8 - 1},
+		{"10-12 * * * *", true, 1<<10 | 1<<11 | 1<<12, 1<<8 - 1},
+		{"*/20 * * * *", true, 1 | 1<<20 | 1<<40, 1<<8 - 1},
+		{"50/5 * * * *", true, 1<<50 | 1<<55, 1<<8 - 1},
+		{"10-20/5 * * * *", true, 1<<10 | 1<<15 | 1<<20, 1<<8 - 1},
+		{"0 0 * * 7", true, 1, 1 | 1<<7},
+		{"0 0 * * 1-5", true, 1, 1<<1 | 1<<2 | 1<<3 | 1<<4 | 1<<5},
+		{"@hourly", true, 1, 1<<8 - 1},
+		{"  @weekly  ", true, 1, 1},
+		{"@every", false, 0, 0},
+		{"", false, 0, 0},
+		{"* * * *", false, 0, 0},
+		{"* * * * * *", false, 0, 0},
+		{"60 * * * *", false, 0, 0},
+		{"* 24 * * *", false, 0, 0},
+		{"* * 0 * *", false, 0, 0},
+		{"* * 32 * *", false, 0, 0},
+		{"* * * 13 *", false, 0, 0},
+		{"* * * * 8", false, 0, 0},
+		{"20-10 * * * *", false, 0, 0},
+		{"*/0 * * * *", false, 0, 0},
+		{"*/x * * * *", false, 0, 0},
+		{"1,,2 * * * *", false, 0, 0},
+		{"a * * * *", false, 0, 0},
+		{"1- * * * *", false, 0, 0},
+		{"-1 * * * *", false, 0, 0},
+	}
+
+	for _, test := range tests {
+		expr, err := parseCron(test.spec)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q: expected error", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.spec, err)
+			continue
+		}
+		if expr.minute != test.minute || expr.dow != test.dow {
+			t.Errorf("%q: expected minute %b dow %b, got minute %b dow %b",
+				test.spec, test.minute, test.dow, expr.minute, expr.dow)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(value string) int64 {
+		tm, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm.Unix()
+	}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		now      string
+		expected string
+	}{
+		{"every minute", Schedule{Cron: "* * * * *"}, "2023-11-14T22:13:20Z", "2023-11-14T22:14:00Z"},
+		{"strictly after now", Schedule{Cron: "* * * * *"}, "2023-11-14T22:14:00Z", "2023-11-14T22:15:00Z"},
+		{"daily", Schedule{Cron: "@daily"}, "2023-11-14T22:13:20Z", "2023-11-15T00:00:00Z"},
+		{"weekdays", Schedule{Cron: "*/15 9-17 * * 1-5"}, "2023-11-17T17:50:00Z", "2023-11-20T09:00:00Z"},
+		{"sunday as 7", Schedule{Cron: "5 4 * * 7"}, "2023-11-14T22:13:20Z", "2023-11-19T04:05:00Z"},
+		{"leap day", Schedule{Cron: "0 0 29 2 *"}, "2023-11-14T22:13:20Z", "2024-02-29T00:00:00Z"},
+		{"day of month or week", Schedule{Cron: "0 0 13 * 5"}, "2023-11-14T22:13:20Z", "2023-11-17T00:00:00Z"},
+		{"month rollover", Schedule{Cron: "0 12 1 * *"}, "2023-12-31T23:59:00Z", "2024-01-01T12:00:00Z"},
+		{"interval", Schedule{Interval: 60, Start: at("2023-11-14T22:13:20Z")}, "2023-11-14T22:13:50Z", "2023-11-14T22:14:20Z"},
+		{"interval catching up", Schedule{Interval: 60, Start: at("2023-11-14T22:13:20Z")}, "2023-11-14T22:16:20Z", "2023-11-14T22:17:20Z"},
+	}
+
+	for _, test := range tests {
+		next, err := test.schedule.Next(at(test.now))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if next != at(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected,
+				time.Unix(next, 0).UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+func TestScheduleNextNeverDue(t *testing.T) {
+	schedule := Schedule{Cron: "0 0 30 2 *"}
+	if _, err := schedule.Next(time.Now().Unix()); err == nil {
+		t.Errorf("expected error for cron that never fires")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	schedule := Schedule{Interval: 60, Jitter: 10, Start: 1000}
+	for i := 0; i < 100; i++ {
+		next, err := schedule.Next(1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next < 1060 || next > 1070 {
+			t.Fatalf("expected next within jitter of 1060, got %v", next)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		schedule Schedule
+		valid    bool
+	}{
+		{Schedule{Cron: "@daily"}, true},
+		{Schedule{Interval: 60, Jitter: 5}, true},
+		{Schedule{}, false},
+		{Schedule{Cron: "@daily", Interval: 60}, false},
+		{Schedule{Interval: -1}, false},
+		{Schedule{Interval: 60, Jitter: -1}, false},
+		{Schedule{Cron: "61 * * * *"}, false},
+	}
+
+	for _, test := range tests {
+		err := test.schedule.Validate()
+		if test.valid != (err == nil) {
+			t.Errorf("%+v: expected valid %v, got err: %v", test.schedule, test.valid, err)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"math/rand"
-	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -15,6 +15,8 @@ import (
 var (
 	stats     = make(map[string]uint64)
 	statsLock = &sync.RWMutex{}
+	backend   timers.Backend
+	config    timers.Config
 )
 
 func run(partn int, load int, pwg *sync.WaitGroup) {
@@ -27,11 +29,7 @@ func run(partn int, load int, pwg *sync.WaitGroup) {
 	dbl := sync.Mutex{}
 	uid := "timertest-" + strconv.FormatInt(time.Now().Unix(), 36)
 	uid = "timertest"
-	cstr := "couchbase://localhost"
-	if len(os.Args) == 2 {
-		cstr = os.Args[1]
-	}
-	timers.Create(uid, partn, cstr, "default")
+	timers.CreateWithBackend(uid, partn, backend, "default", config)
 	store, present := timers.Fetch(uid, partn)
 	if !present {
 		panic("store was absent")
@@ -48,7 +46,7 @@ func run(partn int, load int, pwg *sync.WaitGroup) {
 	go func() {
 		defer wait.Done()
 		for i := 0; i < load; i++ {
-			due := time.Now().Add(time.Duration(rand.Intn(5)+1) * time.Second).Add(time.Second * time.Duration(timers.Resolution))
+			due := time.Now().Add(time.Duration(rand.Intn(5)+1) * time.Second).Add(time.Second * time.Duration(config.Resolution))
 			ref := "item-" + strconv.Itoa(i)
 			ctx := make(map[string]interface{})
 			ctx["Ref"] = ref
@@ -114,7 +112,7 @@ func run(partn int, load int, pwg *sync.WaitGroup) {
 				if dbe.Unix() > time.Now().Unix() {
 					logging.Errorf("Timer %v fired too early at %v", entry, time.Now().Unix())
 				}
-				if time.Now().Unix()-dbe.Unix() > 10*timers.Resolution {
+				if time.Now().Unix()-dbe.Unix() > 10*config.Resolution {
 					logging.Errorf("Timer %v was too late: %+v", dbe.Unix(), entry)
 				}
 				if dbe.Unix() != due {
@@ -127,7 +125,7 @@ func run(partn int, load int, pwg *sync.WaitGroup) {
 				fired++
 			}
 			time.Sleep(1 * time.Second)
-			if finish.Add(time.Second * time.Duration(timers.Resolution) * 2).Before(time.Now()) {
+			if finish.Add(time.Second * time.Duration(config.Resolution) * 2).Before(time.Now()) {
 				break
 			}
 		}
@@ -135,7 +133,7 @@ func run(partn int, load int, pwg *sync.WaitGroup) {
 
 	done := make(chan struct{})
 	go func(store *timers.TimerStore, done chan struct{}) {
-		ticker := time.NewTicker(time.Duration(timers.Resolution) * time.Second)
+		ticker := time.NewTicker(time.Duration(config.Resolution) * time.Second)
 
 		for {
 			select {
@@ -187,11 +185,38 @@ func printStats() {
 }
 
 func main() {
+	kind := flag.String("backend", "couchbase", "timer store backend: couchbase, memory or file")
+	path := flag.String("file", "timertest.log", "log file of file backend")
+	flag.Int64Var(&config.Resolution, "resolution", timers.Resolution, "timer resolution in seconds")
+	flag.Parse()
+
 	load := 10000
 	pwg := sync.WaitGroup{}
 
 	logging.SetLogLevel(logging.Info)
-	timers.SetTestAuth("Administrator", "asdasd")
+
+	switch *kind {
+	case "couchbase":
+		cstr := "couchbase://localhost"
+		if flag.NArg() == 1 {
+			cstr = flag.Arg(0)
+		}
+		timers.SetTestAuth("Administrator", "asdasd")
+		backend = timers.Pool(cstr)
+	case "memory":
+		backend = timers.NewMemBackend()
+	case "file":
+		fileBackend, err := timers.OpenFileBackend(*path)
+		if err != nil {
+			logging.Fatalf("Unable to open file backend %v: %v", *path, err)
+			return
+		}
+		defer fileBackend.Close()
+		backend = fileBackend
+	default:
+		logging.Fatalf("Unknown backend %v", *kind)
+		return
+	}
 
 	for partn := 0; partn <= load/1000; partn++ {
 		pwg.Add(1)
@@ -1,8 +1,10 @@
 package timers
 
-/* This module returns only common.ErrRetryTimeout error */
+/* This module returns only common.ErrRetryTimeout error, along with ErrTimerInPast
+   and config errors on create */
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -13,12 +15,14 @@ import (
 	"github.com/couchbase/eventing/logging"
 	"github.com/couchbase/eventing/util"
 	"golang.org/x/crypto/ripemd160"
-	"gopkg.in/couchbase/gocb.v1"
 )
 
 // Constants
 const (
-	Resolution  = int64(7) // seconds
+	Resolution    = int64(7) // seconds, default of stores
+	MinResolution = int64(1)
+	MaxResolution = int64(60)
+
 	init_seq    = int64(128)
 	tail_time   = int64(60)
 	dict        = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789*&"
@@ -28,8 +32,29 @@ const (
 // Globals
 var (
 	stores *storeMap
+
+	ErrTimerInPast = errors.New("timer is due in the past")
+)
+
+// InPastPolicy decides what becomes of a timer set with due time in the past
+type InPastPolicy string
+
+const (
+	InPastFire   InPastPolicy = "fire"   // moved to the next period of store
+	InPastReject InPastPolicy = "reject" // Set fails with ErrTimerInPast
 )
 
+// Config of a timer store, zero values stand for defaults
+type Config struct {
+	Resolution      int64 // seconds, due times are rounded up to it
+	InPastPolicy    InPastPolicy
+	MissedPolicy    MissedPolicy
+	MissedThreshold int64 // seconds a timer is overdue by before it's missed
+	MissedRate      int64 // timers per second, for rate limit policy
+	ContextSize     int64 // bytes of encoded context kept in record, 0 for no limit
+	ContextOverflow ContextOverflow
+}
+
 type storeMap struct {
 	lock       sync.RWMutex
 	entries    map[string]*TimerStore
@@ -42,9 +67,13 @@ type AlarmRecord struct {
 	ContextRef string `json:"cxr"`
 }
 
+// ContextRecord of a recurring timer also holds its schedule, so that moving it
+// on to next occurrence is a single CAS replace of the record
 type ContextRecord struct {
 	Context  interface{} `json:"ctx"`
 	AlarmRef string      `json:"alr"`
+	Schedule *Schedule   `json:"sch,omitempty"`
+	Overflow *Overflow   `json:"-"`
 }
 
 type TimerEntry struct {
@@ -52,19 +81,21 @@ type TimerEntry struct {
 	ContextRecord
 
 	alarmSeq int64
-	ctxCas   gocb.Cas
-	alrCas   gocb.Cas
+	ctxCas   Cas
+	alrCas   Cas
 }
 
 // This can be used to delete a timer from outside this project, as follows:
 //  1. Delete context_key from bucket with context_cas, ignore any absent/mismatch error
 //  2. Delete alarm_key from bucket with alarm_cas, log any absent/mismatch error
+//  3. If context_key was deleted, delete chunk_keys from bucket, ignore any absent error
 type DeleteToken struct {
-	Bucket     string `json:"bucket"`
-	AlarmKey   string `json:"alarm_key"`
-	AlarmCas   uint64 `json:"alarm_cas"`
-	ContextKey string `json:"context_key"`
-	ContextCas uint64 `json:"context_cas"`
+	Bucket     string   `json:"bucket"`
+	AlarmKey   string   `json:"alarm_key"`
+	AlarmCas   uint64   `json:"alarm_cas"`
+	ContextKey string   `json:"context_key"`
+	ContextCas uint64   `json:"context_cas"`
+	ChunkKeys  []string `json:"chunk_keys,omitempty"`
 }
 
 type rowIter struct {
@@ -77,30 +108,45 @@ type colIter struct {
 	stop    int64
 	current int64
 	topKey  string
-	topCas  gocb.Cas
+	topCas  Cas
 }
 
+// Span of rows in a store, along with resolution the rows are laid out at.
+// Worker leaves resolution out, it's the one configured for function then.
 type Span struct {
-	Start int64 `json:"sta"`
-	Stop  int64 `json:"stp"`
+	Start      int64 `json:"sta"`
+	Stop       int64 `json:"stp"`
+	Resolution int64 `json:"res,omitempty"`
 }
 
 type storeSpan struct {
 	Span
 	empty   bool
 	dirty   bool
-	spanCas gocb.Cas
+	spanCas Cas
 	lock    sync.Mutex
 }
 
 type TimerStore struct {
-	connstr string
-	bucket  string
-	uid     string
-	partn   int
-	log     string
-	span    storeSpan
-	stats   timerStats
+	backend      Backend
+	bucket       string
+	uid          string
+	partn        int
+	log          string
+	resolution   int64
+	inPastPolicy InPastPolicy
+	span         storeSpan
+	stats        timerStats
+
+	missedPolicy    MissedPolicy
+	missedThreshold int64
+	missedRate      int64
+	limiter         missedLimiter
+
+	contextSize     int64
+	contextOverflow ContextOverflow
+
+	metrics timerMetrics
 }
 
 type TimerIter struct {
@@ -108,6 +154,14 @@ type TimerIter struct {
 	row   rowIter
 	col   *colIter
 	entry *TimerEntry
+
+	// Read only iterators don't clean up after fired or cancelled timers
+	readOnly bool
+	afterRow int64 // row and column to resume after
+	afterSeq int64
+
+	// Missed timers by context key, fired at end of scan by latest only policy
+	held map[string]*TimerEntry
 }
 
 type timerStats struct {
@@ -118,6 +172,7 @@ type timerStats struct {
 	SetCounter                  uint64 `json:"meta_set"`
 	SetSuccessCounter           uint64 `json:"meta_set_success"`
 	TimerInPastCounter          uint64 `json:"meta_timer_in_past"`
+	TimerInPastRejectCounter    uint64 `json:"meta_timer_in_past_reject"`
 	TimerInFutureFiredCounter   uint64 `json:"meta_timer_in_future_fired"`
 	AlarmMissingCounter         uint64 `json:"meta_alarm_missing"`
 	ContextMissingCounter       uint64 `json:"meta_context_missing"`
@@ -133,6 +188,21 @@ type timerStats struct {
 	SpanStartChangeCounter    uint64 `json:"meta_span_start_change"`
 	SpanStopChangeCounter     uint64 `json:"meta_span_stop_change"`
 	SpanCasMismatchCounter    uint64 `json:"meta_span_cas_mismatch"`
+	SeriesSetCounter          uint64 `json:"meta_series_set"`
+	SeriesRearmCounter        uint64 `json:"meta_series_rearm"`
+	SeriesRearmFailedCounter  uint64 `json:"meta_series_rearm_failed"`
+	SeriesCancelCounter       uint64 `json:"meta_series_cancel"`
+	OnTimeCounter             uint64 `json:"meta_timer_on_time"`
+	MissedFiredCounter        uint64 `json:"meta_missed_timer_fired"`
+	MissedCoalescedCounter    uint64 `json:"meta_missed_timer_coalesced"`
+	MissedDeferredCounter     uint64 `json:"meta_missed_timer_deferred"`
+	MissedDroppedCounter      uint64 `json:"meta_missed_timer_dropped"`
+	ContextTooLargeCounter     uint64 `json:"meta_context_too_large"`
+	ContextCompressedCounter   uint64 `json:"meta_context_compressed"`
+	ContextChunkedCounter      uint64 `json:"meta_context_chunked"`
+	ContextChunkDocsCounter    uint64 `json:"meta_context_chunk_docs"`
+	ContextChunkMissingCounter uint64 `json:"meta_context_chunk_missing"`
+	ContextUnpackCounter       uint64 `json:"meta_context_unpacked"`
 }
 
 type rebalancer interface {
@@ -147,9 +217,20 @@ func SetRebalancer(r rebalancer) {
 	stores.rebalancer = r
 }
 
+// Create sets up a store persisting timers to given bucket in Couchbase KV,
+// with default config
 func Create(uid string, partn int, connstr string, bucket string) error {
+	return CreateWithBackend(uid, partn, Pool(connstr), bucket, Config{})
+}
+
+// CreateWithBackend sets up a store persisting timers to given backend
+func CreateWithBackend(uid string, partn int, backend Backend, bucket string, config Config) error {
 	logPrefix := "TimerStore::Create"
 
+	if err := config.fillDefaults(); err != nil {
+		return err
+	}
+
 	stores.lock.Lock()
 	defer stores.lock.Unlock()
 
@@ -158,10 +239,11 @@ func Create(uid string, partn int, connstr string, bucket string) error {
 		logging.Warnf("%s Asked to create store %v:%v which exists. Reusing", logPrefix, uid, partn)
 		return nil
 	}
-	store, err := newTimerStore(uid, partn, connstr, bucket)
+	store, err := newTimerStore(uid, partn, backend, bucket, config)
 	if err != nil {
 		return err
 	}
+	store.metrics.batched = true
 	stores.entries[mapLocator(uid, partn)] = store
 	return nil
 }
@@ -187,51 +269,106 @@ func (r *TimerStore) Free(syncSpan bool) {
 	if syncSpan {
 		r.syncSpan()
 	}
+	r.syncPending()
 }
 
+// Set creates a timer, replacing any with the same reference. A timer due in the
+// past is handled as per in past policy of the store.
 func (r *TimerStore) Set(due int64, ref string, context interface{}) error {
-	now := time.Now().Unix()
+	return r.set(due, ref, context, nil)
+}
+
+func (r *TimerStore) set(due int64, ref string, context interface{}, schedule *Schedule) error {
 	atomic.AddUint64(&r.stats.SetCounter, 1)
 
-	if due-now <= Resolution {
-		atomic.AddUint64(&r.stats.TimerInPastCounter, 1)
-		logging.Debugf("%v Moving too close/past timer to next period: %v context %ru", r.log, formatInt(due), context)
-		due = now + Resolution
+	due, err := r.adjustDue(due, context)
+	if err != nil {
+		return err
 	}
-	due = roundUp(due)
-
-	kv := Pool(r.connstr)
-	pos := r.kvLocatorRoot(due)
-	seq, _, err := kv.MustCounter(r.bucket, pos, 1, init_seq, 0)
+	inner, _ := splitContext(context)
+	data, err := r.encodeContext(inner)
 	if err != nil {
 		return err
 	}
-
-	akey := r.kvLocatorAlarm(due, seq)
 	ckey := r.kvLocatorContext(ref)
 
-	arecord := AlarmRecord{AlarmDue: due, ContextRef: ckey}
-	_, err = kv.MustUpsert(r.bucket, akey, arecord, 0)
+	akey, err := r.writeAlarm(due, ckey)
 	if err != nil {
 		return err
 	}
 
-	crecord := ContextRecord{Context: context, AlarmRef: akey}
+	kv := r.kv()
+	crecord := ContextRecord{Context: context, AlarmRef: akey, Schedule: schedule}
+	if data != nil {
+		if crecord.Overflow, err = r.packContext(akey, data); err != nil {
+			return err
+		}
+		crecord.Context = overflowed(context, crecord.Overflow)
+	}
+
+	// Chunks of timer being replaced are only known from its record
+	replaced := ContextRecord{}
+	if r.contextOverflow != OverflowReject {
+		if _, _, err = kv.MustGet(r.bucket, ckey, &replaced); err != nil {
+			return err
+		}
+		liftOverflow(&replaced)
+	}
+
 	_, err = kv.MustUpsert(r.bucket, ckey, crecord, 0)
 	if err != nil {
 		return err
 	}
+	if err = r.removeChunks(replaced.Overflow); err != nil {
+		return err
+	}
 
-	logging.Tracef("%v Creating timer at %v seq %v with ref %ru and context %ru", r.log, seq, formatInt(due), ref, context)
+	logging.Tracef("%v Creating timer at %v alarm %v with ref %ru and context %ru", r.log, formatInt(due), akey, ref, context)
 	r.expandSpan(due)
 	atomic.AddUint64(&r.stats.SetSuccessCounter, 1)
 	return nil
 }
 
+// adjustDue rounds due time up to resolution of store. Timers too close to now
+// are moved to next period, as the row they'd land in may be under scan.
+func (r *TimerStore) adjustDue(due int64, context interface{}) (int64, error) {
+	now := time.Now().Unix()
+	if due < now && r.inPastPolicy == InPastReject {
+		atomic.AddUint64(&r.stats.TimerInPastRejectCounter, 1)
+		logging.Debugf("%v Rejecting past timer: %v context %ru", r.log, formatInt(due), context)
+		return 0, ErrTimerInPast
+	}
+
+	if due-now <= r.resolution {
+		atomic.AddUint64(&r.stats.TimerInPastCounter, 1)
+		logging.Debugf("%v Moving too close/past timer to next period: %v context %ru", r.log, formatInt(due), context)
+		due = now + r.resolution
+	}
+	return r.roundUp(due), nil
+}
+
+func (r *TimerStore) writeAlarm(due int64, ckey string) (string, error) {
+	kv := r.kv()
+	pos := r.kvLocatorRoot(due)
+	seq, _, err := kv.MustCounter(r.bucket, pos, 1, init_seq, 0)
+	if err != nil {
+		return "", err
+	}
+
+	akey := r.kvLocatorAlarm(due, seq)
+	arecord := AlarmRecord{AlarmDue: due, ContextRef: ckey}
+	_, err = kv.MustUpsert(r.bucket, akey, arecord, 0)
+	if err != nil {
+		return "", err
+	}
+	r.countPending(1)
+	return akey, nil
+}
+
 func (r *TimerStore) Delete(entry *TimerEntry) error {
 	logging.Tracef("%v Deleting timer %+v", r.log, entry)
 	atomic.AddUint64(&r.stats.DelCounter, 1)
-	kv := Pool(r.connstr)
+	kv := r.kv()
 
 	_, absent, mismatch, err := kv.MustRemove(r.bucket, entry.AlarmRef, entry.alrCas)
 	if err != nil {
@@ -239,10 +376,16 @@ func (r *TimerStore) Delete(entry *TimerEntry) error {
 	}
 	if absent || mismatch {
 		logging.Debugf("%v Timer %v seq %v is missing alarm in del: %ru", r.log, entry.AlarmDue, entry.alarmSeq, *entry)
+	} else {
+		r.countPending(-1)
 	}
 
 	atomic.AddUint64(&r.stats.DelSuccessCounter, 1)
 
+	if entry.Schedule != nil {
+		return r.rearm(entry)
+	}
+
 	_, absent, mismatch, err = kv.MustRemove(r.bucket, entry.ContextRef, entry.ctxCas)
 	if err != nil {
 		return err
@@ -255,8 +398,11 @@ func (r *TimerStore) Delete(entry *TimerEntry) error {
 		logging.Debugf("%v Timer %v seq %v was overridden after it fired: %ru", r.log, entry.AlarmDue, entry.alarmSeq, *entry)
 		return nil
 	}
+	if absent {
+		return nil
+	}
 
-	return nil
+	return r.removeChunks(entry.Overflow)
 }
 
 func (r *TimerStore) GetToken(e *TimerEntry) *DeleteToken {
@@ -267,16 +413,20 @@ func (r *TimerStore) GetToken(e *TimerEntry) *DeleteToken {
 		ContextCas: uint64(e.ctxCas),
 		AlarmKey:   e.AlarmRef,
 		AlarmCas:   uint64(e.alrCas),
+		ChunkKeys:  e.ChunkKeys(),
 	}
 }
 
+// Cancel removes timer with given reference. For a recurring timer this cancels
+// the whole series.
 func (r *TimerStore) Cancel(ref string) error {
 	atomic.AddUint64(&r.stats.CancelCounter, 1)
 	logging.Tracef("%v Cancelling timer ref %ru", r.log, ref)
 
-	kv := Pool(r.connstr)
+	kv := r.kv()
 	cpos := r.kvLocatorContext(ref)
 
+retryCancel:
 	crecord := ContextRecord{}
 	ccas, absent, err := kv.MustGet(r.bucket, cpos, &crecord)
 	if err != nil {
@@ -287,16 +437,29 @@ func (r *TimerStore) Cancel(ref string) error {
 		logging.Debugf("%v Timer asked to cancel %ru cpos %v does not exist", r.log, ref, cpos)
 		return nil
 	}
+	liftOverflow(&crecord)
+	liftSchedule(&crecord)
 
 	_, absent, mismatch, err := kv.MustRemove(r.bucket, cpos, ccas)
 	if err != nil {
 		return err
 	}
+	// Series may have just moved on to its next occurrence
+	if mismatch && crecord.Schedule != nil {
+		goto retryCancel
+	}
 	if absent || mismatch {
 		logging.Debugf("%v Timer cancel %ru alarmref %v unexpected concurrency on alarm", r.log, ref, crecord.AlarmRef)
 		return nil
 	}
 
+	if crecord.Schedule != nil {
+		atomic.AddUint64(&r.stats.SeriesCancelCounter, 1)
+	}
+	if err = r.removeChunks(crecord.Overflow); err != nil {
+		return err
+	}
+
 	arecord := AlarmRecord{}
 	acas, absent, err := kv.MustGet(r.bucket, crecord.AlarmRef, &arecord)
 	if err != nil {
@@ -316,6 +479,7 @@ func (r *TimerStore) Cancel(ref string) error {
 		logging.Debugf("%v Timer cancel %ru alarmref %v unexpected concurrency on context", r.log, ref, crecord.AlarmRef)
 		return nil
 	}
+	r.countPending(-1)
 
 	atomic.AddUint64(&r.stats.CancelSuccessCounter, 1)
 	return nil
@@ -353,7 +517,7 @@ func ForceSpanSync() {
 
 func (r *TimerStore) ScanDue() *TimerIter {
 	span := r.readSpan()
-	now := roundDown(time.Now().Unix())
+	now := r.roundDown(time.Now().Unix())
 
 	atomic.AddUint64(&r.stats.ScanDueCounter, 1)
 	if span.Start > now {
@@ -385,6 +549,7 @@ func (r *TimerIter) ScanNext() (*TimerEntry, error) {
 		return nil, nil
 	}
 
+	cost := r.startCost()
 	for {
 		logging.Tracef("Scan next iterator: %+v", r)
 
@@ -393,7 +558,18 @@ func (r *TimerIter) ScanNext() (*TimerEntry, error) {
 			return nil, err
 		}
 		if found {
-			return r.entry, nil
+			if r.readOnly {
+				return r.entry, nil
+			}
+			fire, stop, err := r.admit(r.entry)
+			if err != nil || stop {
+				return nil, err
+			}
+			if fire {
+				r.recordFire(r.entry, cost)
+				return r.entry, nil
+			}
+			continue
 		}
 
 		found, err = r.nextRow()
@@ -401,7 +577,11 @@ func (r *TimerIter) ScanNext() (*TimerEntry, error) {
 			return nil, err
 		}
 		if !found {
-			return nil, nil
+			entry := r.release()
+			if entry != nil {
+				r.recordFire(entry, cost)
+			}
+			return entry, nil
 		}
 	}
 }
@@ -409,13 +589,13 @@ func (r *TimerIter) ScanNext() (*TimerEntry, error) {
 func (r *TimerIter) nextRow() (bool, error) {
 	atomic.AddUint64(&r.store.stats.ScanRowCounter, 1)
 	logging.Tracef("%v Looking for row after %+v", r.store.log, r.row)
-	kv := Pool(r.store.connstr)
+	kv := r.store.kv()
 
 	r.col = nil
 	r.entry = nil
 
 	for r.row.current < r.row.stop {
-		r.row.current += Resolution
+		r.row.current += r.store.resolution
 
 		pos := r.store.kvLocatorRoot(r.row.current)
 		seq_end := int64(0)
@@ -426,11 +606,17 @@ func (r *TimerIter) nextRow() (bool, error) {
 		}
 		if !absent {
 			r.col = &colIter{current: init_seq, stop: seq_end, topKey: pos, topCas: cas}
+			if r.afterSeq >= init_seq && r.row.current == r.afterRow {
+				r.col.current = r.afterSeq + 1
+			}
 			logging.Tracef("%v Found row %+v", r.store.log, r.row)
 			return true, nil
 		}
+		if r.readOnly {
+			continue
+		}
 		// below handles shrink when row counter never existed. all others cases go to nextColumn
-		r.store.shrinkSpan(r.row.current)
+		r.shrinkSpan(r.row.current)
 	}
 
 	logging.Tracef("%v Found no more rows looking until %v", r.store.log, r.row.stop)
@@ -446,14 +632,16 @@ func (r *TimerIter) nextColumn() (bool, error) {
 		return false, nil
 	}
 
-	kv := Pool(r.store.connstr)
-	alarm := AlarmRecord{}
-	context := ContextRecord{}
+	kv := r.store.kv()
 
 	for r.col.current <= r.col.stop {
 		current := r.col.current
 		r.col.current++
 
+		// Fresh records, as fields left out of a document aren't reset on decode
+		alarm := AlarmRecord{}
+		context := ContextRecord{}
+
 		key := r.store.kvLocatorAlarm(r.row.current, current)
 
 		atomic.AddUint64(&r.store.stats.ScanColumnLookupCounter, 1)
@@ -471,7 +659,20 @@ func (r *TimerIter) nextColumn() (bool, error) {
 		if err != nil {
 			return false, err
 		}
+		if !absent && context.AlarmRef == key {
+			found, err := r.store.unpackContext(&context)
+			if err != nil {
+				return false, err
+			}
+			// Chunks are gone, so timer is being cancelled or replaced
+			if !found {
+				continue
+			}
+		}
 		if absent || context.AlarmRef != key {
+			if r.readOnly {
+				continue
+			}
 			logging.Debugf("%v Alarm canceled or superseded %v by context %ru, deleting it", r.store.log, alarm, context)
 			_, absent, mismatch, err := kv.MustRemove(r.store.bucket, key, acas)
 			if err != nil {
@@ -479,12 +680,14 @@ func (r *TimerIter) nextColumn() (bool, error) {
 			}
 			if absent || mismatch {
 				logging.Debugf("%v Alarm concurrency %v by context %ru, deleting fail %v, %v", r.store.log, alarm, context, absent, mismatch)
+			} else {
+				r.store.countPending(-1)
 			}
 			continue
 		}
 
 		r.entry = &TimerEntry{AlarmRecord: alarm, ContextRecord: context, alarmSeq: current, ctxCas: ccas, alrCas: acas}
-		if r.entry.AlarmDue > time.Now().Unix() {
+		if !r.readOnly && r.entry.AlarmDue > time.Now().Unix() {
 			atomic.AddUint64(&r.store.stats.TimerInFutureFiredCounter, 1)
 		}
 
@@ -493,7 +696,7 @@ func (r *TimerIter) nextColumn() (bool, error) {
 
 	// row counter exists and but has no timers. shrink logic depends on all chains reducing to this eventually
 	logging.Tracef("%v Column scan finished for %+v at %+v", r.store.log, r, *r.col)
-	if r.col.topCas != 0 {
+	if r.col.topCas != 0 && !r.readOnly {
 		logging.Debugf("%v Row %v was empty, so removing counter", r.store.log, r.col.topKey)
 		_, absent, mismatch, err := kv.MustRemove(r.store.bucket, r.col.topKey, r.col.topCas)
 		if err != nil {
@@ -506,7 +709,7 @@ func (r *TimerIter) nextColumn() (bool, error) {
 		if absent {
 			logging.Debugf("%v Concurrency on %v which is now absent", r.store.log, r.col.topKey)
 		}
-		r.store.shrinkSpan(r.row.current)
+		r.shrinkSpan(r.row.current)
 	}
 
 	return false, nil
@@ -521,7 +724,7 @@ func (r *TimerStore) readSpan() Span {
 func (r *TimerStore) expandSpan(point int64) {
 	r.span.lock.Lock()
 	defer r.span.lock.Unlock()
-	util.Assert(func() bool { return point >= roundDown(time.Now().Unix()) })
+	util.Assert(func() bool { return point >= r.roundDown(time.Now().Unix()) })
 
 	if r.span.Start > point {
 		logging.Tracef("Expanding span start to %v", &r.span)
@@ -538,7 +741,7 @@ func (r *TimerStore) expandSpan(point int64) {
 func (r *TimerStore) shrinkSpan(start int64) {
 	r.span.lock.Lock()
 	defer r.span.lock.Unlock()
-	util.Assert(func() bool { return start <= roundDown(time.Now().Unix()) })
+	util.Assert(func() bool { return start <= r.roundDown(time.Now().Unix()) })
 
 	if r.span.Start < start {
 		r.span.Start = start
@@ -555,7 +758,7 @@ func (r *TimerStore) syncSpan() (bool, error) {
 	defer r.span.lock.Unlock()
 
 	r.span.dirty = false
-	kv := Pool(r.connstr)
+	kv := r.kv()
 	pos := r.kvLocatorSpan()
 	extspan := Span{}
 
@@ -570,7 +773,7 @@ func (r *TimerStore) syncSpan() (bool, error) {
 	// new, not on disk, not on node
 	case absent && r.span.empty:
 		now := time.Now().Unix()
-		r.span.Span = Span{Start: roundDown(now), Stop: roundUp(now)}
+		r.span.Span = Span{Start: r.roundDown(now), Stop: r.roundUp(now), Resolution: r.resolution}
 		wcas, mismatch, err := kv.MustInsert(r.bucket, pos, r.span.Span, 0)
 		if err != nil || mismatch {
 			logging.Debugf("%v Error initializing span %+v: mismatch=%v err=%v", r.log, &r.span, mismatch, err)
@@ -597,6 +800,7 @@ func (r *TimerStore) syncSpan() (bool, error) {
 		r.span.empty = false
 		r.span.Span = extspan
 		r.span.spanCas = rcas
+		r.adoptResolution(extspan.Resolution)
 		logging.Tracef("%v Span read and initialized to %+v", r.log, &r.span)
 		return false, nil
 	}
@@ -653,35 +857,54 @@ func (r *storeMap) syncRoutine() {
 
 		force := time.Now().Unix()-r.conflict < tail_time
 		dirty := make([]*TimerStore, 0)
+		all := make([]*TimerStore, 0)
 		r.lock.RLock()
 
 		for _, store := range r.entries {
 			if force || store.span.dirty {
 				dirty = append(dirty, store)
 			}
+			all = append(all, store)
 		}
 		r.lock.RUnlock()
 		for _, store := range dirty {
 			store.syncSpan()
 		}
+		for _, store := range all {
+			store.syncPending()
+		}
 		time.Sleep(time.Duration(Resolution) * time.Second)
 	}
 }
 
-func newTimerStore(uid string, partn int, connstr string, bucket string) (*TimerStore, error) {
+func newTimerStore(uid string, partn int, backend Backend, bucket string, config Config) (*TimerStore, error) {
 	timerstore := TimerStore{
-		connstr: connstr,
-		bucket:  bucket,
-		uid:     uid,
-		partn:   partn,
-		log:     fmt.Sprintf("timerstore:%v:%v", uid, partn),
-		span:    storeSpan{empty: true, dirty: false},
+		backend:      backend,
+		bucket:       bucket,
+		uid:          uid,
+		partn:        partn,
+		log:          fmt.Sprintf("timerstore:%v:%v", uid, partn),
+		resolution:   config.Resolution,
+		inPastPolicy: config.InPastPolicy,
+		span:         storeSpan{empty: true, dirty: false},
+
+		missedPolicy:    config.MissedPolicy,
+		missedThreshold: config.MissedThreshold,
+		missedRate:      config.MissedRate,
+
+		contextSize:     config.ContextSize,
+		contextOverflow: config.ContextOverflow,
+
+		metrics: newTimerMetrics(),
 	}
 
 	_, err := timerstore.syncSpan()
 	if err != nil {
 		return nil, err
 	}
+	if err = timerstore.readPending(); err != nil {
+		return nil, err
+	}
 
 	logging.Tracef("%v Initialized timerdata store", timerstore.log)
 	return &timerstore, nil
@@ -705,6 +928,15 @@ func hash(val string) string {
 	return string(hash)
 }
 
+func (r *TimerStore) kv() mustBackend {
+	return mustBackend{r.backend}
+}
+
+// KeyPrefix is what keys of all timer documents of uid start with
+func KeyPrefix(uid string) string {
+	return fmt.Sprintf("%v:tm:", uid)
+}
+
 func (r *TimerStore) kvLocatorRoot(due int64) string {
 	return fmt.Sprintf("%v:tm:%v:rt:%v", r.uid, r.partn, formatInt(due))
 }
@@ -717,6 +949,10 @@ func (r *TimerStore) kvLocatorContext(ref string) string {
 	return fmt.Sprintf("%v:tm:%v:cx:%v", r.uid, r.partn, hash(ref))
 }
 
+func (r *TimerStore) kvLocatorPending() string {
+	return fmt.Sprintf("%v:tm:%v:pd", r.uid, r.partn)
+}
+
 func (r *TimerStore) kvLocatorSpan() string {
 	return fmt.Sprintf("%v:tm:%v:sp", r.uid, r.partn)
 }
@@ -756,16 +992,80 @@ func newStores() *storeMap {
 	return smap
 }
 
-func roundUp(val int64) int64 {
-	q := val / Resolution
-	r := val % Resolution
-	if r > 0 {
+// adoptResolution switches store over to resolution rows were persisted with,
+// as rows laid out at any other one would be skipped by scans. Span written by
+// worker leaves it out, worker lays rows out at configured resolution too.
+func (r *TimerStore) adoptResolution(persisted int64) {
+	if persisted == 0 {
+		return
+	}
+	if persisted != r.resolution {
+		logging.Warnf("%v Configured resolution %vs differs from %vs of persisted timers, using latter",
+			r.log, r.resolution, persisted)
+		r.resolution = persisted
+	}
+}
+
+func (r *TimerStore) Resolution() int64 {
+	return r.resolution
+}
+
+func (r *TimerStore) roundUp(val int64) int64 {
+	q := val / r.resolution
+	rem := val % r.resolution
+	if rem > 0 {
 		q++
 	}
-	return q * Resolution
+	return q * r.resolution
+}
+
+func (r *TimerStore) roundDown(val int64) int64 {
+	q := val / r.resolution
+	return q * r.resolution
 }
 
-func roundDown(val int64) int64 {
-	q := val / Resolution
-	return q * Resolution
+func (c *Config) fillDefaults() error {
+	if c.Resolution == 0 {
+		c.Resolution = Resolution
+	}
+	if c.Resolution < MinResolution || c.Resolution > MaxResolution {
+		return fmt.Errorf("timer resolution %vs is outside %vs-%vs", c.Resolution, MinResolution, MaxResolution)
+	}
+
+	switch c.InPastPolicy {
+	case "":
+		c.InPastPolicy = InPastFire
+	case InPastFire, InPastReject:
+	default:
+		return fmt.Errorf("unknown timer in past policy %q", c.InPastPolicy)
+	}
+
+	switch c.MissedPolicy {
+	case "":
+		c.MissedPolicy = MissedFireAll
+	case MissedFireAll, MissedLatestOnly, MissedRateLimit, MissedDrop:
+	default:
+		return fmt.Errorf("unknown missed timer policy %q", c.MissedPolicy)
+	}
+	if c.MissedThreshold == 0 {
+		c.MissedThreshold = MissedThreshold
+	}
+	if c.MissedRate == 0 {
+		c.MissedRate = MissedRate
+	}
+	if c.MissedThreshold < 0 || c.MissedRate < 0 {
+		return fmt.Errorf("missed timer threshold %vs and rate %v/s can't be negative", c.MissedThreshold, c.MissedRate)
+	}
+
+	switch c.ContextOverflow {
+	case "":
+		c.ContextOverflow = OverflowReject
+	case OverflowReject, OverflowCompress, OverflowChunk:
+	default:
+		return fmt.Errorf("unknown context overflow %q", c.ContextOverflow)
+	}
+	if c.ContextSize < 0 {
+		return fmt.Errorf("context size %v can't be negative", c.ContextSize)
+	}
+	return nil
 }
@@ -0,0 +1,226 @@
+package timers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestStore sets up a store of partition 0 at 1s resolution, so that timers
+// fall due without much waiting
+func newTestStore(t *testing.T, backend Backend) *TimerStore {
+	config := Config{Resolution: 1}
+	if err := config.fillDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newTimerStore("test", 0, backend, "bucket", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// withFileBackend runs test over a file backend in a fresh directory, and
+// returns path of its log so that it can be opened again
+func withFileBackend(t *testing.T, test func(backend *FileBackend, path string)) {
+	dir, err := ioutil.TempDir("", "timerstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "timers.log")
+	backend, err := OpenFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	test(backend, path)
+}
+
+// scanAll fires every timer due, returning their contexts
+func scanAll(t *testing.T, store *TimerStore) []interface{} {
+	var contexts []interface{}
+	iter := store.ScanDue()
+	for {
+		entry, err := iter.ScanNext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			return contexts
+		}
+		contexts = append(contexts, entry.Context)
+		if err = store.Delete(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testSetScanDue(t *testing.T, backend Backend) {
+	store := newTestStore(t, backend)
+	due := time.Now().Unix() + 1
+	for _, ref := range []string{"a", "b", "c"} {
+		if err := store.Set(due, ref, "context "+ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Cancel("b"); err != nil {
+		t.Fatal(err)
+	}
+	if pending := store.Metrics().Pending; pending != 2 {
+		t.Errorf("expected 2 timers pending, got %v", pending)
+	}
+
+	if contexts := scanAll(t, store); len(contexts) != 0 {
+		t.Errorf("expected no timers to be due yet, got %v", contexts)
+	}
+
+	time.Sleep(time.Duration(due-time.Now().Unix()+1) * time.Second)
+	contexts := scanAll(t, store)
+	if len(contexts) != 2 || contexts[0] != "context a" || contexts[1] != "context c" {
+		t.Errorf("expected timers a and c to fire, got %v", contexts)
+	}
+	if contexts = scanAll(t, store); len(contexts) != 0 {
+		t.Errorf("expected fired timers to be deleted, got %v", contexts)
+	}
+	if pending := store.Metrics().Pending; pending != 0 {
+		t.Errorf("expected no timers pending, got %v", pending)
+	}
+}
+
+func TestSetScanDueMemBackend(t *testing.T) {
+	testSetScanDue(t, NewMemBackend())
+}
+
+func TestSetScanDueFileBackend(t *testing.T) {
+	withFileBackend(t, func(backend *FileBackend, _ string) {
+		testSetScanDue(t, backend)
+	})
+}
+
+func TestSetReplacesSameRef(t *testing.T) {
+	store := newTestStore(t, NewMemBackend())
+	due := time.Now().Unix() + 1
+	if err := store.Set(due, "a", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(due, "a", "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Duration(due-time.Now().Unix()+1) * time.Second)
+	if contexts := scanAll(t, store); len(contexts) != 1 || contexts[0] != "second" {
+		t.Errorf("expected only latest timer with same ref to fire, got %v", contexts)
+	}
+}
+
+func TestCancelMissingTimer(t *testing.T) {
+	store := newTestStore(t, NewMemBackend())
+	if err := store.Cancel("missing"); err != nil {
+		t.Errorf("expected cancelling missing timer to be a no-op, got %v", err)
+	}
+	if stats := store.Stats(); stats["meta_cancel_context_missing"] != 1 {
+		t.Errorf("expected missing context to be counted, got %v", stats)
+	}
+}
+
+func TestPendingCountBatched(t *testing.T) {
+	backend := NewMemBackend()
+	store := newTestStore(t, backend)
+	store.metrics.batched = true
+
+	due := time.Now().Unix() + 100
+	for _, ref := range []string{"a", "b", "c"} {
+		if err := store.Set(due, ref, "context "+ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Cancel("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	count := int64(0)
+	if _, absent, _ := backend.Get("bucket", store.kvLocatorPending(), &count); !absent {
+		t.Errorf("expected count to be left for sync, got %v", count)
+	}
+	if pending := store.Metrics().Pending; pending != 2 {
+		t.Errorf("expected 2 timers pending before sync, got %v", pending)
+	}
+
+	store.syncPending()
+	if _, _, err := backend.Get("bucket", store.kvLocatorPending(), &count); err != nil || count != 2 {
+		t.Errorf("expected count of 2 to be synced, got %v err: %v", count, err)
+	}
+	if pending := store.Metrics().Pending; pending != 2 {
+		t.Errorf("expected 2 timers pending after sync, got %v", pending)
+	}
+}
+
+func TestSpanPersisted(t *testing.T) {
+	withFileBackend(t, func(backend *FileBackend, path string) {
+		store := newTestStore(t, backend)
+		due := time.Now().Unix() + 100
+		if err := store.Set(due, "a", "context"); err != nil {
+			t.Fatal(err)
+		}
+		span := store.readSpan()
+		if span.Stop < due || span.Resolution != 1 {
+			t.Fatalf("expected span to cover %v at 1s resolution, got %+v", due, span)
+		}
+		if _, err := store.syncSpan(); err != nil {
+			t.Fatal(err)
+		}
+		backend.Close()
+
+		// Store opened again, as by another worker, picks up span and timer
+		reopened, err := OpenFileBackend(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		store = newTestStore(t, reopened)
+		if persisted := store.readSpan(); persisted != span {
+			t.Errorf("expected span %+v to be read back, got %+v", span, persisted)
+		}
+		if pending := store.Metrics().Pending; pending != 1 {
+			t.Errorf("expected 1 timer pending, got %v", pending)
+		}
+
+		crecord := ContextRecord{}
+		if _, absent, err := reopened.Get("bucket", store.kvLocatorContext("a"), &crecord); err != nil || absent {
+			t.Fatalf("expected context of timer to be read back, absent: %v err: %v", absent, err)
+		}
+		if crecord.Context != "context" {
+			t.Errorf("expected context to be read back, got %v", crecord.Context)
+		}
+	})
+}
+
+func TestSpanMergesConcurrentWrites(t *testing.T) {
+	backend := NewMemBackend()
+	first := newTestStore(t, backend)
+	second := newTestStore(t, backend)
+
+	now := time.Now().Unix()
+	if err := first.Set(now+50, "a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Set(now+100, "b", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []*TimerStore{first, second, first} {
+		if _, err := store.syncSpan(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if span := first.readSpan(); span.Stop < now+100 {
+		t.Errorf("expected span to be merged with other store's, got %+v", span)
+	}
+	if stats := first.Stats(); stats["meta_span_cas_mismatch"] == 0 {
+		t.Errorf("expected conflict on span to be counted, got %v", stats)
+	}
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/eventing/dcp"
+	"github.com/couchbase/eventing/logging"
+)
+
+var errInvalidCACert = errors.New("no certificates found in cluster certificate file")
+
+// TLS settings for connections to data service, shared by DCP feeds,
+// memcached connections and SDK connections
+var kvTLS struct {
+	sync.RWMutex
+	enabled  bool
+	certFile string
+	keyFile  string
+	config   *tls.Config // Built on first use, dropped on certificate refresh
+}
+
+func init() {
+	couchbase.KVTLSConfigCallback = KVTLSConfig
+}
+
+// SetKVTLSCertificates sets the cluster certificate chain and key used for
+// verifying data service nodes and for client certificate auth
+func SetKVTLSCertificates(certFile, keyFile string) {
+	kvTLS.Lock()
+	defer kvTLS.Unlock()
+
+	kvTLS.certFile = certFile
+	kvTLS.keyFile = keyFile
+	kvTLS.config = nil
+}
+
+// SetKVTLSEnabled toggles encryption of connections to data service. It
+// applies to connections opened after the change.
+func SetKVTLSEnabled(enabled bool) {
+	kvTLS.Lock()
+	defer kvTLS.Unlock()
+
+	if kvTLS.enabled != enabled {
+		logging.Infof("SetKVTLSEnabled encryption of connections to data service enabled: %t", enabled)
+	}
+	kvTLS.enabled = enabled
+}
+
+// KVTLSEnabled returns true if connections to data service are to be encrypted
+func KVTLSEnabled() bool {
+	kvTLS.RLock()
+	defer kvTLS.RUnlock()
+	return kvTLS.enabled
+}
+
+// RefreshKVTLSConfig drops cached TLS configuration so that certificates and
+// cipher settings are reloaded for subsequent connections
+func RefreshKVTLSConfig() {
+	kvTLS.Lock()
+	defer kvTLS.Unlock()
+	kvTLS.config = nil
+}
+
+// KVTLSConfig returns TLS configuration for connections to data service,
+// nil if they aren't to be encrypted
+func KVTLSConfig() (*tls.Config, error) {
+	kvTLS.RLock()
+	enabled, config := kvTLS.enabled, kvTLS.config
+	kvTLS.RUnlock()
+
+	if !enabled {
+		return nil, nil
+	}
+
+	if config != nil {
+		return config, nil
+	}
+
+	kvTLS.Lock()
+	defer kvTLS.Unlock()
+
+	if kvTLS.config != nil {
+		return kvTLS.config, nil
+	}
+
+	config, err := newKVTLSConfig(kvTLS.certFile, kvTLS.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	kvTLS.config = config
+	return config, nil
+}
+
+func newKVTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	logPrefix := "util::newKVTLSConfig"
+
+	caCert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		logging.Errorf("%s Error in reading cacert file, %v", logPrefix, err)
+		return nil, err
+	}
+
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		logging.Errorf("%s Error in parsing cacert file, %v", logPrefix, errInvalidCACert)
+		return nil, errInvalidCACert
+	}
+
+	cipherSuites, minVersion, err := kvTLSCipherSettings()
+	if err != nil {
+		logging.Errorf("%s Error in getting cbauth tls config: %v", logPrefix, err)
+		return nil, err
+	}
+
+	config := &tls.Config{
+		CipherSuites: cipherSuites,
+		MinVersion:   minVersion,
+		RootCAs:      caCertPool,
+	}
+
+	clientAuthType, err := cbauth.GetClientCertAuthType()
+	if err != nil {
+		logging.Errorf("%s Error in getting client cert auth type, %v", logPrefix, err)
+		return nil, err
+	}
+
+	if clientAuthType != tls.NoClientCert {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			logging.Errorf("%s Error in loading SSL certificate: %v", logPrefix, err)
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// SecureConnStr rewrites an SDK connection string to connect to the TLS ports
+// of data service nodes when connections to data service are to be encrypted
+func SecureConnStr(connstr string) string {
+	kvTLS.RLock()
+	enabled, certFile, keyFile := kvTLS.enabled, kvTLS.certFile, kvTLS.keyFile
+	kvTLS.RUnlock()
+
+	if !enabled || !strings.HasPrefix(connstr, "couchbase://") {
+		return connstr
+	}
+
+	hosts, options := strings.TrimPrefix(connstr, "couchbase://"), ""
+	if idx := strings.Index(hosts, "?"); idx >= 0 {
+		hosts, options = hosts[:idx], hosts[idx+1:]
+	}
+
+	var sslHosts []string
+	for _, host := range strings.Split(hosts, ",") {
+		if sslAddr, ok := couchbase.KVSSLAddr(host); ok {
+			sslHosts = append(sslHosts, sslAddr)
+			continue
+		}
+
+		// Fall back to default TLS port of data service
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if strings.Contains(h, ":") {
+				host = "[" + h + "]"
+			}
+		}
+		sslHosts = append(sslHosts, host)
+	}
+
+	query := "certpath=" + url.QueryEscape(certFile)
+	if clientAuthType, err := cbauth.GetClientCertAuthType(); err == nil && clientAuthType != tls.NoClientCert {
+		query += "&keypath=" + url.QueryEscape(keyFile)
+	}
+
+	if options != "" {
+		query = options + "&" + query
+	}
+
+	return "couchbases://" + strings.Join(sslHosts, ",") + "?" + query
+}
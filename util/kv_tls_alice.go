@@ -0,0 +1,13 @@
+//+build alice
+
+package util
+
+// remove this file when we no longer need to build against Alice
+
+import (
+	"crypto/tls"
+)
+
+func kvTLSCipherSettings() ([]uint16, uint16, error) {
+	return []uint16{tls.TLS_RSA_WITH_AES_256_CBC_SHA}, tls.VersionTLS12, nil
+}
@@ -0,0 +1,17 @@
+// +build !alice
+
+package util
+
+// remove this file when we no longer need to build against Alice
+
+import (
+	"github.com/couchbase/cbauth"
+)
+
+func kvTLSCipherSettings() ([]uint16, uint16, error) {
+	cbauthTLScfg, err := cbauth.GetTLSConfig()
+	if err != nil {
+		return nil, 0, err
+	}
+	return cbauthTLScfg.CipherSuites, cbauthTLScfg.MinVersion, nil
+}
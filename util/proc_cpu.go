@@ -0,0 +1,48 @@
+// +build linux
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock ticks /proc reports cpu times in, fixed at 100 by kernel ABI
+const userHz = 100
+
+// ProcessCPUTime returns user plus system cpu time consumed so far by process
+func ProcessCPUTime(pid int) (time.Duration, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// Process name is in parentheses and may itself contain spaces
+	stat := string(data)
+	idx := strings.LastIndexByte(stat, ')')
+	if idx < 0 {
+		return 0, fmt.Errorf("malformed stat for pid: %d", pid)
+	}
+
+	// Fields following process name start from state, utime and stime
+	// are 14th and 15th fields of stat
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed stat for pid: %d", pid)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(utime+stime) * time.Second / userHz, nil
+}
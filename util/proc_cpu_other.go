@@ -0,0 +1,13 @@
+// +build !linux
+
+package util
+
+import (
+	"errors"
+	"time"
+)
+
+// ProcessCPUTime returns user plus system cpu time consumed so far by process
+func ProcessCPUTime(pid int) (time.Duration, error) {
+	return 0, errors.New("process cpu time not supported on this platform")
+}
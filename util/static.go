@@ -11,6 +11,7 @@ import (
 
 var (
 	ipv4               bool = true
+	ipcType            string
 	localusr           string
 	localkey           string
 	maxFunctionSize    int = 128 * 1024
@@ -53,6 +54,24 @@ func IsIPv6() bool {
 	return !ipv4
 }
 
+// SetIPCType picks the transport used between producer and eventing-consumer on
+// this node, empty value leaves it to producer to pick sockets based on platform
+func SetIPCType(ipc string) {
+	logPrefix := "util::SetIPCType"
+
+	switch ipc {
+	case "", "af_inet", "af_unix", "shm":
+		ipcType = ipc
+		logging.Infof("%s Setting IPC type to %q", logPrefix, ipc)
+	default:
+		logging.Errorf("%s Unknown IPC type %q, ignoring it", logPrefix, ipc)
+	}
+}
+
+func GetIPCType() string {
+	return ipcType
+}
+
 func Localhost() string {
 	if ipv4 {
 		return "127.0.0.1"
@@ -1512,35 +1512,59 @@ func CPUCount(log bool) int {
 }
 
 func ParseXattrData(xattrPrefix string, data []byte) (body, xattr []byte, err error) {
+	body, err = walkXattrs(data, func(key, val []byte) bool {
+		if string(key) == xattrPrefix {
+			xattr = val
+			return false
+		}
+		return true
+	})
+	return body, xattr, err
+}
+
+// ParseXattrs returns all xattrs in xattrs section of a document, keyed by name
+func ParseXattrs(data []byte) (map[string][]byte, error) {
+	xattrs := make(map[string][]byte)
+	_, err := walkXattrs(data, func(key, val []byte) bool {
+		xattrs[string(key)] = val
+		return true
+	})
+	return xattrs, err
+}
+
+// walkXattrs calls fn for each xattr in xattrs section of a document for as long
+// as it returns true, and returns document body that follows the xattrs
+func walkXattrs(data []byte, fn func(key, val []byte) bool) (body []byte, err error) {
 	length := len(data)
 	if length < 4 {
-		return nil, nil, fmt.Errorf("empty xattr metadata")
+		return nil, fmt.Errorf("empty xattr metadata")
 	}
 	xattrLen := binary.BigEndian.Uint32(data[0:4])
-	body = data[xattrLen+4:]
-	if xattrLen == 0 {
-		return body, nil, nil
+	if int(xattrLen)+4 > length {
+		return nil, fmt.Errorf("xattr parse error, unexpected xattr length")
 	}
+	body = data[xattrLen+4:]
 	index := uint32(4)
 	delimeter := []byte("\x00")
-	for index < xattrLen {
+	for index < xattrLen+4 {
+		if int(index+4) > length {
+			return body, fmt.Errorf("xattr parse error, unexpected xattr data")
+		}
 		keyValPairLen := binary.BigEndian.Uint32(data[index : index+4])
+		index += 4
 		if keyValPairLen == 0 || int(index+keyValPairLen) > length {
-			return body, nil, fmt.Errorf("xattr parse error, unexpected xattr data")
+			return body, fmt.Errorf("xattr parse error, unexpected xattr data")
 		}
-		index += 4
-		keyValPairData := data[index : index+keyValPairLen]
-		keyValPair := bytes.Split(keyValPairData, delimeter)
+		keyValPair := bytes.Split(data[index:index+keyValPairLen], delimeter)
 		if len(keyValPair) != 3 {
-			return body, nil, fmt.Errorf("xattr parse error, unexpected number of components")
+			return body, fmt.Errorf("xattr parse error, unexpected number of components")
 		}
-		xattrKey := string(keyValPair[0])
-		if xattrKey == xattrPrefix {
-			return body, keyValPair[1], nil
+		if !fn(keyValPair[0], keyValPair[1]) {
+			break
 		}
 		index += keyValPairLen
 	}
-	return body, nil, nil
+	return body, nil
 }
 
 func MaybeCompress(payload []byte, compressPayload bool) ([]byte, error) {
@@ -8,6 +8,7 @@
 #include <iostream>
 #include <map>
 #include <math.h>
+#include <memory>
 #include <queue>
 #include <signal.h>
 #include <sstream>
@@ -20,6 +21,8 @@
 #include <vector>
 
 #include "parse_deployment.h"
+#include "shm_transport.h"
+#include "stats_table.h"
 #include "v8worker.h"
 
 const size_t MAX_BUF_SIZE = 65536;
@@ -27,13 +30,18 @@ const int HEADER_FRAGMENT_SIZE = 4;  // uint32
 const int PAYLOAD_FRAGMENT_SIZE = 4; // uint32
 const int SIZEOF_UINT32 = 4;
 const size_t MAX_V8_HEAP_SIZE = 1.4 * 1024 * 1024 * 1024;
+const std::chrono::milliseconds COMMIT_ACK_INTERVAL(100);
 
 int64_t timer_context_size;
 
 typedef struct resp_msg_s {
   std::string msg;
+  std::unique_ptr<StatsTable> stats; // Set on stats responses in place of msg
   uint8_t msg_type;
   uint8_t opcode;
+  // Only set on handshake response
+  int16_t protocol_version{0};
+  int16_t min_protocol_version{0};
 } resp_msg_t;
 
 typedef union {
@@ -50,6 +58,8 @@ public:
 
   void FlushToConn(uv_stream_t *stream, char *buffer, int length);
 
+  void WriteResponse(uv_stream_t *stream, const resp_msg_t &resp);
+
   void InitTcpSock(const std::string &function_name,
                    const std::string &function_id,
                    const std::string &user_prefix, const std::string &appname,
@@ -63,6 +73,12 @@ public:
                int batch_size, int feedback_batch_size,
                std::string feedback_sock_path, std::string uds_sock_path);
 
+  void InitShm(const std::string &function_name, const std::string &function_id,
+               const std::string &user_prefix, const std::string &appname,
+               const std::string &worker_id, int batch_size,
+               int feedback_batch_size, const std::string &feedback_shm_path,
+               const std::string &shm_path);
+
   void OnConnect(uv_connect_t *conn, int status);
   void OnFeedbackConnect(uv_connect_t *conn, int status);
 
@@ -78,6 +94,8 @@ public:
   void StartFeedbackUVLoop();
   void StartMainUVLoop();
 
+  void ReadShmLoop(ShmChannel *channel, std::vector<char> *buffer);
+
   void WriteResponses();
 
   void ReadStdinLoop();
@@ -115,6 +133,14 @@ private:
 
   void SendPauseAck(const std::unordered_map<int64_t, uint64_t> &lps_map);
 
+  void NegotiateProtocolVersion(const flatbuf::payload::Payload *payload);
+
+  void RejectMessage(protocol_error_opcode opcode, int8_t event,
+                     int8_t msg_opcode);
+
+  void EnqueueDcpEventBatch(int16_t partition,
+                            const flatbuf::payload::Payload *payload);
+
   std::thread write_responses_thr_;
   std::map<int16_t, V8Worker *> workers_;
   std::chrono::milliseconds checkpoint_interval_;
@@ -146,6 +172,11 @@ private:
   uv_tcp_t tcp_sock_;
   uv_pipe_t uds_sock_;
 
+  // Shared memory segments standing in for the two sockets above when
+  // producer picks shm transport
+  std::unique_ptr<ShmChannel> shm_feedback_conn_;
+  std::unique_ptr<ShmChannel> shm_conn_;
+
   std::string app_name_;
 
   std::string function_name_;
@@ -176,8 +207,15 @@ private:
 
   bool msg_priority_;
 
+  // Protocol version negotiated with producer
+  std::atomic<int16_t> protocol_version_{LEGACY_PROTOCOL_VERSION};
+
   bool using_timer_{false};
 
+  // Checkpoint acks go out more often when seq nos are acked on completion of
+  // handler, so that producer's view of committed seq nos stays tight
+  bool idempotency_keys_{false};
+
   std::vector<char> read_buffer_main_;
 
   std::vector<char> read_buffer_feedback_;
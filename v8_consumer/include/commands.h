@@ -14,6 +14,16 @@
 
 #include <iostream>
 
+// Version of the protocol spoken between Go producer and C++ worker, to be kept
+// in sync with workerProtocolVersion in consumer/protocol.go. Version 2
+// introduced the handshake and typed stats responses, version 3 batched dcp
+// events and checkpoint acks.
+const int16_t PROTOCOL_VERSION = 3;
+const int16_t MIN_PROTOCOL_VERSION = 2;
+
+// Protocol version assumed until producer completes the handshake
+const int16_t LEGACY_PROTOCOL_VERSION = 1;
+
 // Opcodes for incoming messages from Go to C++
 enum event_type {
   eDCP,
@@ -24,6 +34,7 @@ enum event_type {
   eFilter,
   eInternal,
   ePauseConsumer,
+  eHandshake,
   Event_Unknown
 };
 
@@ -45,7 +56,7 @@ enum v8_worker_opcode {
   V8_Worker_Opcode_Unknown
 };
 
-enum dcp_opcode { oDelete, oMutation, DCP_Opcode_Unknown };
+enum dcp_opcode { oDelete, oMutation, oBatch, DCP_Opcode_Unknown };
 
 enum filter_opcode { oVbFilter, oProcessedSeqNo, Filter_Opcode_Unknown };
 
@@ -53,6 +64,7 @@ enum internal_opcode {
   oScanTimer,
   oUpdateV8HeapSize,
   oRunGc,
+  oVbDrainBarrier,
   Internal_Opcode_Unknown
 };
 
@@ -70,6 +82,8 @@ enum timer_opcode { oTimer, oCronTimer, Timer_Opcode_Unknown };
 
 enum debugger_opcode { oDebuggerStart, oDebuggerStop, Debugger_Opcode_Unknown };
 
+enum handshake_opcode { oHandshakeRequest, Handshake_Opcode_Unknown };
+
 event_type getEvent(int8_t event);
 v8_worker_opcode getV8WorkerOpcode(int8_t opcode);
 dcp_opcode getDCPOpcode(int8_t opcode);
@@ -77,6 +91,7 @@ app_worker_setting_opcode getAppWorkerSettingOpcode(int8_t opcode);
 filter_opcode getFilterOpcode(int8_t opcode);
 timer_opcode getTimerOpcode(int8_t opcode);
 debugger_opcode getDebuggerOpcode(int8_t opcode);
+handshake_opcode getHandshakeOpcode(int8_t opcode);
 
 // Opcodes for outgoing messages from C++ to Go
 enum msg_type {
@@ -86,6 +101,8 @@ enum msg_type {
   mBucket_Ops_Response,
   mFilterAck,
   mPauseAck,
+  mHandshake,
+  mProtocolError,
   Msg_Unknown
 };
 
@@ -108,6 +125,18 @@ enum v8_worker_config_opcode {
 
 enum doc_timer_response_opcode { timerResponse };
 
-enum bucket_ops_response_opcode { checkpointResponse };
+enum bucket_ops_response_opcode { checkpointResponse, batchCheckpointResponse };
+
+enum handshake_response_opcode {
+  oHandshakeOpcode,
+  oHandshakeAccepted,
+  oHandshakeRejected
+};
+
+enum protocol_error_opcode {
+  oProtocolErrorOpcode,
+  oUnknownEvent,
+  oUnknownOpcode
+};
 
 #endif
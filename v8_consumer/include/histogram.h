@@ -14,18 +14,29 @@
 
 #include <atomic>
 #include <string>
+#include <vector>
 
+#include "stats_table.h"
+
+// Counts samples into bins of width between from and till, samples outside of
+// them fall in first or last bin
 class Histogram {
 public:
+  Histogram(int64_t from = 100, int64_t till = 1000 * 1000 * 10,
+            int64_t width = 100);
+
   void Add(int64_t sample);
   std::string ToString();
+  // Moves samples counted since the previous call into stats, named after
+  // their bin behind prefix
+  void Drain(StatsTable::StatList &stats, const std::string &prefix = "");
 
 private:
-  static const int64_t from_ = 100;
-  static const int64_t till_ = 1000 * 1000 * 10;
-  static const int64_t width_ = 100;
-  static constexpr int64_t num_buckets_ = 1 + ((till_ - from_) / width_);
-  std::atomic<int64_t> data_[num_buckets_]{};
+  const int64_t from_;
+  const int64_t till_;
+  const int64_t width_;
+  const std::size_t num_buckets_;
+  std::vector<std::atomic<int64_t>> data_;
 };
 
 #endif
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef SHM_TRANSPORT_H
+#define SHM_TRANSPORT_H
+
+#include <atomic>
+#include <chrono>
+#include <cstddef>
+#include <cstdint>
+#include <mutex>
+#include <string>
+
+// Layout of shared memory segment created by eventing-producer, must be kept
+// in sync with shmipc/defs.go
+const uint32_t SHM_SEGMENT_MAGIC = 0x45564950; // "EVIP"
+const uint32_t SHM_SEGMENT_VERSION = 1;
+const size_t SHM_CONTROL_SIZE = 4096;
+
+const size_t SHM_MAGIC_OFFSET = 0;
+const size_t SHM_VERSION_OFFSET = 4;
+const size_t SHM_RING_SIZE_OFFSET = 8;
+const size_t SHM_STATE_OFFSET = 12;
+const size_t SHM_OWNER_PID_OFFSET = 16;
+const size_t SHM_ATTACH_PID_OFFSET = 20;
+const size_t SHM_TO_WORKER_OFFSET = 64;
+const size_t SHM_FROM_WORKER_OFFSET = 256;
+
+const size_t SHM_HEAD_OFFSET = 0;
+const size_t SHM_TAIL_OFFSET = 64;
+const size_t SHM_DATA_SEQ_OFFSET = 128;
+const size_t SHM_SPACE_SEQ_OFFSET = 132;
+const size_t SHM_READER_WAITING_OFFSET = 136;
+const size_t SHM_WRITER_WAITING_OFFSET = 140;
+
+enum shm_state { sIdle, sListening, sAttached, sClosed };
+
+// Parked reader and writer wake up at least this often to notice that either
+// end has gone away
+const std::chrono::milliseconds SHM_POLL_INTERVAL(100);
+
+// Single producer single consumer byte queue in shared memory
+struct ShmRing {
+  uint64_t *head{nullptr};
+  uint64_t *tail{nullptr};
+  uint32_t *data_seq{nullptr};
+  uint32_t *space_seq{nullptr};
+  uint32_t *reader_waiting{nullptr};
+  uint32_t *writer_waiting{nullptr};
+  char *data{nullptr};
+  uint64_t mask{0};
+
+  void Init(char *mem, size_t desc_offset, size_t data_offset, size_t size);
+  uint64_t Readable() const;
+  uint64_t Writable() const;
+  size_t Read(char *buf, size_t len);
+  size_t Write(const char *buf, size_t len);
+};
+
+// Worker end of a shared memory segment, carries the same byte stream as the
+// socket it replaces
+class ShmChannel {
+public:
+  explicit ShmChannel(const std::atomic<bool> &exit_cond)
+      : exit_cond_(exit_cond) {}
+  ~ShmChannel();
+
+  ShmChannel(const ShmChannel &) = delete;
+  ShmChannel &operator=(const ShmChannel &) = delete;
+
+  // Maps segment at path and waits for producer to accept on it
+  bool Attach(const std::string &path, std::chrono::seconds timeout);
+
+  // Blocks until something is available, returns 0 once producer has gone
+  // away or worker is exiting
+  size_t Read(char *buf, size_t len);
+
+  // Blocks until all of buf is written, full ring applies the same
+  // backpressure as a full socket buffer
+  bool Write(const char *buf, size_t len);
+
+  // Marks segment closed so that producer stops using it
+  void Close();
+
+private:
+  bool PeerGone() const;
+
+  const std::atomic<bool> &exit_cond_;
+  std::mutex write_mutex_;
+
+  char *mem_{nullptr};
+  size_t mem_size_{0};
+  uint32_t *state_{nullptr};
+  int owner_pid_{0};
+
+  ShmRing tx_;
+  ShmRing rx_;
+};
+
+#endif
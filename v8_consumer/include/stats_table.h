@@ -0,0 +1,58 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef STATS_TABLE_H
+#define STATS_TABLE_H
+
+#include <cstdint>
+#include <nlohmann/json.hpp>
+#include <string>
+#include <utility>
+#include <vector>
+
+// Stats reported to producer. They're sent as typed stats table from protocol
+// version 2 onwards and JSON encoded before that, so they're limited to what
+// both can carry i.e. integer counters, and groups of them one level deep.
+struct StatsTable {
+  typedef std::vector<std::pair<std::string, int64_t>> StatList;
+
+  StatList counters;
+  std::vector<std::pair<std::string, StatList>> groups;
+  std::string timestamp;
+
+  void Add(const std::string &name, int64_t value) {
+    counters.emplace_back(name, value);
+  }
+
+  StatList &AddGroup(const std::string &name) {
+    groups.emplace_back(name, StatList());
+    return groups.back().second;
+  }
+
+  std::string ToJSON() const {
+    nlohmann::json stats = nlohmann::json::object();
+    for (const auto &counter : counters) {
+      stats[counter.first] = counter.second;
+    }
+    for (const auto &group : groups) {
+      auto &entry = stats[group.first] = nlohmann::json::object();
+      for (const auto &stat : group.second) {
+        entry[stat.first] = stat.second;
+      }
+    }
+    if (!timestamp.empty()) {
+      stats["timestamp"] = timestamp;
+    }
+    return stats.dump();
+  }
+};
+
+#endif
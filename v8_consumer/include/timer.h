@@ -17,6 +17,7 @@
 #include <v8.h>
 
 #include "timer_defs.h"
+#include "timer_schedule.h"
 
 extern thread_local std::mt19937_64 rng;
 
@@ -27,12 +28,18 @@ public:
 
   bool CreateTimerImpl(const v8::FunctionCallbackInfo<v8::Value> &args);
   bool CancelTimerImpl(const v8::FunctionCallbackInfo<v8::Value> &args);
+  bool CreateRecurringTimerImpl(const v8::FunctionCallbackInfo<v8::Value> &args);
+
+  static void FillTimerPartition(timer::TimerInfo& tinfo, const int32_t& num_vbuckets);
 
 private:
   timer::EpochInfo Epoch(const v8::Local<v8::Value> &date_val);
   bool ValidateArgs(const v8::FunctionCallbackInfo<v8::Value> &args);
   bool ValidateCancelTimerArgs(const v8::FunctionCallbackInfo<v8::Value> &args);
-  void FillTimerPartition(timer::TimerInfo& tinfo, const int32_t& num_vbuckets);
+  bool ValidateRecurringTimerArgs(const v8::FunctionCallbackInfo<v8::Value> &args);
+  bool ParseSchedule(const v8::Local<v8::Value> &schedule_val,
+                     timer::Schedule &schedule);
+  bool FitContext(timer::TimerInfo &timer_info);
 
   v8::Isolate *isolate_;
   v8::Persistent<v8::Context> context_;
@@ -40,5 +47,6 @@ private:
 
 void CreateTimer(const v8::FunctionCallbackInfo<v8::Value> &args);
 void CancelTimer(const v8::FunctionCallbackInfo<v8::Value> &args);
+void CreateRecurringTimer(const v8::FunctionCallbackInfo<v8::Value> &args);
 
 #endif
@@ -23,6 +23,9 @@ public:
   Iterator(TimerStore *pstore, int32_t num_vbuckets);
   ~Iterator();
   bool GetNext(TimerEvent &tevent);
+  // Moves on to next partition, leaving rest of current one, and the span over
+  // it, in place for a later scan
+  void SkipPartition();
 
 private:
   std::pair<bool, bool> GetNextTimer(TimerEvent &tevent);
@@ -0,0 +1,76 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef COUCHBASE_TIMER_METRICS_H
+#define COUCHBASE_TIMER_METRICS_H
+
+#include <chrono>
+#include <cstdint>
+#include <libcouchbase/couchbase.h>
+#include <memory>
+#include <mutex>
+#include <string>
+#include <unordered_map>
+
+#include "histogram.h"
+#include "stats_table.h"
+
+namespace timer {
+// Firing metrics of partitions fired by this worker, binned the same way as
+// timers package, so that they're reported along with its own. Fire lag is in
+// milliseconds, scan time in microseconds.
+class FiringMetrics {
+public:
+  // Notes how late a timer due at due (in seconds) is fired, and how long scan
+  // took to get to it since the previous one
+  void RecordFire(int64_t partition, int64_t due,
+                  std::chrono::microseconds scan_time);
+
+  // Notes alarms written to or removed from partition, which are added to its
+  // pending count once per scan
+  void AddPending(int64_t partition, int64_t delta);
+
+  // Returns alarms written less those removed by partition since last call
+  std::unordered_map<int64_t, int64_t> TakePending();
+
+  void SetPending(int64_t partition, int64_t pending);
+
+  void RemovePartition(int64_t partition);
+
+  // Lays out metrics as a flat stats group, with stats named
+  // <partition>:pending, <partition>:fire_lag:<bin> and
+  // <partition>:scan_time:<bin>. Bins only count fires since the previous
+  // call.
+  void AddTo(StatsTable::StatList &group);
+
+private:
+  struct Metrics {
+    int64_t pending{-1}; // not known until counted
+    int64_t unsynced{0}; // alarms written less removed since counted
+    bool removed{false};
+    std::unique_ptr<Histogram> fire_lag;
+    std::unique_ptr<Histogram> scan_time;
+  };
+
+  std::mutex lock_;
+  std::unordered_map<int64_t, Metrics> partitions_;
+};
+
+// Adds delta to count of alarms pending in partition, which is shared with
+// stores of timers package
+lcb_error_t CountPending(lcb_t instance, const std::string &prefix,
+                         int64_t partition, int64_t delta, int64_t &count,
+                         int max_retry_count);
+} // namespace timer
+
+extern timer::FiringMetrics timer_firing_metrics;
+
+#endif // COUCHBASE_TIMER_METRICS_H
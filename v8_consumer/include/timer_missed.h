@@ -0,0 +1,92 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef COUCHBASE_TIMER_MISSED_H
+#define COUCHBASE_TIMER_MISSED_H
+
+#include <atomic>
+#include <chrono>
+#include <cstdint>
+#include <string>
+#include <unordered_map>
+#include <unordered_set>
+#include <vector>
+
+extern std::atomic<int64_t> timer_missed_fired_counter;
+extern std::atomic<int64_t> timer_missed_coalesced_counter;
+extern std::atomic<int64_t> timer_missed_deferred_counter;
+extern std::atomic<int64_t> timer_missed_dropped_counter;
+
+namespace timer {
+// What becomes of timers overdue by more than missed threshold, as happens
+// after a pause or long downtime. Same as missed_timer_policy of timers
+// package.
+enum class MissedPolicy { fire_all, latest_only, rate_limit, drop };
+
+enum class MissedVerdict {
+  fire,
+  drop,  // deleted without firing
+  defer, // left for a later scan, along with rest of its partition
+  hold,  // fired at end of scan, unless a later timer of its reference turns
+         // up in the scan
+};
+
+MissedPolicy ParseMissedPolicy(const std::string &policy);
+
+// Reads partition and due time out of a key built by BuildAlarmKey
+bool ParseAlarmKey(const std::string &alarm_key, int64_t &partition,
+                   int64_t &due);
+
+class MissedTimers {
+public:
+  MissedTimers(MissedPolicy policy, int64_t threshold, int64_t rate)
+      : policy_(policy), threshold_(threshold), rate_(rate) {}
+
+  // Clears timers held and partitions deferred by the previous scan
+  void StartScan() {
+    held_.clear();
+    deferred_.clear();
+  }
+
+  // Timer held earlier in scan and superseded by this one, for latest_only, is
+  // handed back in superseded, to be deleted without firing
+  MissedVerdict Admit(const std::string &alarm_key,
+                      const std::string &context_key, int64_t now,
+                      std::string &superseded);
+
+  // Alarm keys of timers held in scan, earliest due first, to be fired now
+  std::vector<std::string> Release();
+
+private:
+  // Hands out rate worth of tokens every second, to a partition
+  struct Limiter {
+    int64_t tokens{0};
+    std::chrono::steady_clock::time_point refill;
+  };
+
+  // Latest timer of a reference seen in scan
+  struct Held {
+    int64_t due{0};
+    std::string alarm_key;
+  };
+
+  bool Take(int64_t partition);
+
+  MissedPolicy policy_;
+  int64_t threshold_;
+  int64_t rate_;
+  std::unordered_map<std::string, Held> held_;
+  std::unordered_set<int64_t> deferred_;
+  std::unordered_map<int64_t, Limiter> limiters_;
+};
+} // namespace timer
+
+#endif // COUCHBASE_TIMER_MISSED_H
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef COUCHBASE_TIMER_OVERFLOW_H
+#define COUCHBASE_TIMER_OVERFLOW_H
+
+#include <atomic>
+#include <cstdint>
+#include <libcouchbase/couchbase.h>
+#include <string>
+
+extern std::atomic<int64_t> timer_context_compressed_counter;
+extern std::atomic<int64_t> timer_context_chunked_counter;
+extern std::atomic<int64_t> timer_context_chunk_missing_counter;
+
+namespace timer {
+// Key of overflow standing in for a context too large to be kept in context
+// record as is, shared with timers package
+static const char *const overflow_key = "_ovf";
+
+// Size of each chunk document a context is split across, short of the 20MiB
+// KV item limit to leave room for key and metadata. Same as chunkSize of
+// timers package.
+static constexpr int64_t chunk_size = 19 * 1024 * 1024;
+
+// What becomes of a context larger than timer_context_size. Same as
+// timer_context_overflow of timers package.
+enum class OverflowPolicy { reject, compress, chunk };
+
+OverflowPolicy ParseOverflowPolicy(const std::string &policy);
+
+// Overflow holds packed context inline, or split across chunks documents
+// named <chunk_ref>:<index>. Packed context is compressed if it starts with a
+// 0 byte.
+struct Overflow {
+  std::string packed;
+  std::string chunk_ref;
+  int64_t chunks{0};
+  int64_t size{0};
+};
+
+// Same as util.MaybeCompress, raw deflate behind 2 zero bytes if that's
+// smaller than data
+std::string MaybeCompress(const std::string &data);
+
+bool MaybeDecompress(const std::string &packed, std::string &data);
+
+std::string WrapOverflowContext(const Overflow &overflow);
+
+// Returns false if context isn't held in overflow
+bool UnwrapOverflowContext(const std::string &context, Overflow &overflow);
+
+// Replaces context larger than size with overflow holding it, writing chunks
+// under chunk_ref if it doesn't fit even when compressed
+lcb_error_t PackContext(lcb_t instance, OverflowPolicy policy, int64_t size,
+                        const std::string &chunk_ref, std::string &context,
+                        int max_retry_count);
+
+// Reassembles context held in overflow. Found is false if a chunk is missing,
+// which happens when timer is being cancelled or replaced.
+lcb_error_t UnpackContext(lcb_t instance, const Overflow &overflow,
+                          std::string &context, bool &found,
+                          int max_retry_count);
+
+lcb_error_t RemoveChunks(lcb_t instance, const Overflow &overflow,
+                         int max_retry_count);
+} // namespace timer
+
+#endif // COUCHBASE_TIMER_OVERFLOW_H
@@ -0,0 +1,60 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef COUCHBASE_TIMER_SCHEDULE_H
+#define COUCHBASE_TIMER_SCHEDULE_H
+
+#include <cstdint>
+#include <nlohmann/json.hpp>
+#include <random>
+#include <string>
+
+namespace timer {
+// Key of schedule in context of a recurring timer, shared with timers package
+static const char *const series_key = "_series";
+
+// Schedule of a recurring timer. Either cron or interval is set, each
+// occurrence is pushed out by a random delay of up to jitter seconds.
+struct Schedule {
+  std::string reference;
+  std::string cron; // minute hour day-of-month month day-of-week, in UTC
+  int64_t interval{0};
+  int64_t jitter{0};
+  int64_t start{0}; // occurrences of interval are aligned to it
+};
+
+// Parsed cron expression, with a bit set for each value a field matches
+struct CronExpr {
+  uint64_t minute{0}, hour{0}, dom{0}, month{0}, dow{0};
+  bool dom_star{false}, dow_star{false};
+};
+
+bool ParseCron(const std::string &cron, CronExpr &expr, std::string &err);
+
+// Returns an empty string if schedule is valid for a store at resolution,
+// reason otherwise
+std::string ValidateSchedule(const Schedule &schedule, int64_t resolution);
+
+// Computes due time of first occurrence after now, jitter included
+bool NextOccurrence(const Schedule &schedule, int64_t now,
+                    std::mt19937_64 &gen, int64_t &due, std::string &err);
+
+// Wraps context of a recurring timer along with its schedule, so that worker
+// firing it can rearm the series. Context "undefined" is left out.
+std::string WrapSeriesContext(const Schedule &schedule,
+                              const std::string &context);
+
+// Returns false if context isn't that of a recurring timer
+bool UnwrapSeriesContext(const std::string &series_context,
+                         Schedule &schedule, std::string &context);
+} // namespace timer
+
+#endif // COUCHBASE_TIMER_SCHEDULE_H
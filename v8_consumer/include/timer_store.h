@@ -29,9 +29,13 @@ class TimerStore {
 public:
   explicit TimerStore(v8::Isolate *isolate, const std::string &prefix,
                       const std::vector<int64_t> &partitions,
-                      const std::string &metadata_bucket, int32_t num_vbuckets);
+                      const std::string &metadata_bucket, int32_t num_vbuckets,
+                      int64_t resolution);
   ~TimerStore();
 
+  // Seconds due times are rounded up to, as per timer_resolution of handler
+  int64_t Resolution() const { return resolution_; }
+
   lcb_error_t SetTimer(TimerInfo &timer, int max_retry_count);
   lcb_error_t DelTimer(TimerInfo &timer, int max_retry_count);
 
@@ -87,6 +91,7 @@ private:
   lcb_t crud_handle_{nullptr};
   std::mutex store_lock_;
   int32_t num_vbuckets_{1024};
+  int64_t resolution_{resolution};
   friend class Iterator;
 };
 } // namespace timer
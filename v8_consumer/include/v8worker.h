@@ -45,6 +45,9 @@
 #include "js_exception.h"
 #include "log.h"
 #include "parse_deployment.h"
+#include "timer_metrics.h"
+#include "timer_missed.h"
+#include "timer_overflow.h"
 #include "timer_store.h"
 #include "utils.h"
 #include "v8log.h"
@@ -76,13 +79,14 @@ struct MessageHeader {
   ~MessageHeader() = default;
   MessageHeader(MessageHeader &&other) noexcept
       : event(other.event), opcode(other.opcode), partition(other.partition),
-        metadata(std::move(other.metadata)) {}
+        metadata(std::move(other.metadata)), priority(other.priority) {}
 
   MessageHeader &operator=(MessageHeader &&other) noexcept {
     event = other.event;
     opcode = other.opcode;
     partition = other.partition;
     metadata = std::move(other.metadata);
+    priority = other.priority;
     return *this;
   }
   MessageHeader(const MessageHeader &other) = delete;
@@ -97,6 +101,7 @@ struct MessageHeader {
   uint8_t opcode{0};
   int16_t partition{0};
   std::string metadata;
+  bool priority{false}; // DCP event goes through priority lane of worker thread
 };
 
 // Flatbuffer encoded message from Go world
@@ -160,7 +165,14 @@ typedef struct handler_config_s {
   int lcb_inst_capacity;
   bool skip_lcb_bootstrap;
   bool using_timer;
+  bool idempotency_keys;
+  bool reject_timers_in_past;
   int64_t timer_context_size;
+  int64_t timer_resolution;
+  std::string timer_context_overflow;
+  std::string missed_timer_policy;
+  int64_t missed_timer_threshold;
+  int64_t missed_timer_rate;
   std::string n1ql_consistency;
   std::vector<std::string> handler_headers;
   std::vector<std::string> handler_footers;
@@ -206,6 +218,8 @@ extern std::atomic<int64_t> dcp_mutation_msg_counter;
 extern std::atomic<int64_t> timer_msg_counter;
 extern std::atomic<int64_t> timer_create_counter;
 extern std::atomic<int64_t> timer_cancel_counter;
+extern std::atomic<int64_t> timer_series_rearm_counter;
+extern std::atomic<int64_t> timer_series_rearm_failure;
 
 extern std::atomic<int64_t> enqueued_dcp_delete_msg_counter;
 extern std::atomic<int64_t> enqueued_dcp_mutation_msg_counter;
@@ -232,6 +246,16 @@ public:
   int SendUpdate(const std::string &value, const std::string &meta);
   int SendDelete(const std::string &value, const std::string &meta);
   void SendTimer(std::string callback, std::string timer_ctx);
+
+  void ScanTimers();
+  bool ProcessTimer(timer::TimerEvent &evt, bool fire,
+                    std::chrono::microseconds scan_time);
+  bool RearmSeries(const timer::TimerEvent &evt, std::string &context);
+  bool UnpackTimerContext(const timer::Overflow &overflow, std::string &context,
+                          bool &retry);
+  void RemoveTimerChunks(const timer::Overflow &overflow);
+  void SyncPendingTimers();
+
   std::string Compile(std::string handler);
 
   void StartDebugger();
@@ -243,13 +267,17 @@ public:
 
   void PushBack(std::unique_ptr<WorkerMessage> worker_msg);
 
+  // Queues a priority DCP event in priority lane, unless an older event on the
+  // same key is yet to be processed, in which case it waits behind that one
+  void PushBackPriority(std::unique_ptr<WorkerMessage> worker_msg);
+
   void AddLcbException(int err_code);
   void ListLcbExceptions(std::map<int, int64_t> &agg_lcb_exceptions);
 
   void UpdateHistogram(Time::time_point t);
   void UpdateCurlLatencyHistogram(const Time::time_point &start);
 
-  void GetBucketOpsMessages(std::vector<uv_buf_t> &messages);
+  void GetBucketOpsMessages(std::vector<uv_buf_t> &messages, bool batch_ack);
 
   void UpdateVbFilter(int vb_no, uint64_t seq_no);
 
@@ -265,6 +293,10 @@ public:
 
   std::unique_lock<std::mutex> GetAndLockFilterLock();
 
+  // Whether filter request wants its ack held back until vb's queued up events
+  // are out of worker queue
+  bool IsVbDrainRequested(const std::string &metadata_str) const;
+
   int ParseMetadata(const std::string &metadata, int &vb_no,
                     uint64_t &seq_no) const;
   int ParseMetadataWithAck(const std::string &metadata_str, int &vb_no,
@@ -281,6 +313,9 @@ public:
 
   lcb_error_t SetTimer(timer::TimerInfo &tinfo);
   lcb_error_t DelTimer(timer::TimerInfo &tinfo);
+  lcb_error_t PackTimerContext(timer::TimerInfo &tinfo);
+  lcb_error_t GetTimerContext(const timer::TimerInfo &tinfo, bool &found,
+                              std::string &context);
 
   lcb_t GetTimerLcbHandle() const;
   void AddTimerPartition(int vb_no);
@@ -290,6 +325,12 @@ public:
 
   inline std::string GetFunctionInstanceID() { return function_instance_id_; }
 
+  // Key of the DCP event handler is currently running for, empty unless
+  // idempotency keys are enabled
+  inline const std::string &GetIdempotencyKey() const {
+    return idempotency_key_;
+  }
+
   v8::Isolate *GetIsolate() { return isolate_; }
   v8::Persistent<v8::Context> context_;
   v8::Persistent<v8::Function> on_update_;
@@ -308,6 +349,9 @@ public:
 
   uint64_t currently_processed_vb_;
   uint64_t currently_processed_seqno_;
+  bool reject_timers_in_past_{false};
+  timer::OverflowPolicy context_overflow_{timer::OverflowPolicy::reject};
+  int64_t timer_resolution_{timer::resolution};
   Time::time_point execute_start_time_;
 
   std::thread processing_thr_;
@@ -328,11 +372,13 @@ private:
   std::string AddHeadersAndFooters(std::string code);
 
   void UpdateSeqNumLocked(int vb, uint64_t seq_num);
+  void CommitSeqNum(int vb, uint64_t seq_num);
   void HandleDeleteEvent(const std::unique_ptr<WorkerMessage> &msg);
   void HandleMutationEvent(const std::unique_ptr<WorkerMessage> &msg);
   bool IsFilteredEventLocked(int vb, uint64_t seq_num);
   std::tuple<int, uint64_t, bool>
   GetVbAndSeqNum(const std::unique_ptr<WorkerMessage> &msg) const;
+  std::string ParseIdempotencyKey(const std::string &metadata_str) const;
   v8::Local<v8::ObjectTemplate> NewGlobalObj() const;
   void InstallCurlBindings(const std::vector<CurlBinding> &curl_bindings) const;
   void InstallBucketBindings(
@@ -347,10 +393,14 @@ private:
   void FreeCurlBindings();
   std::vector<uv_buf_t> BuildResponse(const std::string &payload,
                                       int8_t msg_type, int8_t response_opcode);
+  std::vector<uv_buf_t>
+  BuildBatchCheckpointResponse(const std::vector<std::pair<int, uint64_t>> &vb_seq_nos);
+  std::vector<uv_buf_t> FrameResponse(flatbuffers::FlatBufferBuilder &builder);
   bool ExecuteScript(const v8::Local<v8::String> &script);
 
   void UpdateV8HeapSize();
   void ForceRunGarbageCollector();
+  void HandleVbDrainBarrier(const std::unique_ptr<WorkerMessage> &msg);
   Histogram *latency_stats_;
   Histogram *curl_latency_stats_;
 
@@ -360,6 +410,13 @@ private:
 
   std::vector<std::vector<uint64_t>> vbfilter_map_;
   std::vector<uint64_t> processed_bucketops_;
+  struct DrainedVb {
+    int vb;
+    uint64_t seq_no;
+    int skip_ack;
+  };
+  // Vbs whose filter ack waits on next flush of bucket ops messages
+  std::vector<DrainedVb> drained_vbs_;
   std::mutex bucketops_lock_;
   std::mutex pause_lock_;
   v8::Isolate *isolate_;
@@ -368,7 +425,10 @@ private:
   std::string function_name_;
   std::string function_id_;
   std::string function_instance_id_;
+  bool idempotency_keys_{false};
+  std::string idempotency_key_;
   std::string user_prefix_;
+  std::string timer_prefix_;
   std::string ns_server_port_;
   timer::TimerStore *timer_store_{nullptr};
   std::atomic<bool> thread_exit_cond_;
@@ -380,6 +440,7 @@ private:
   std::vector<BucketBinding> bucket_bindings_;
   std::vector<std::string> handler_headers_;
   std::vector<std::string> handler_footers_;
+  timer::MissedTimers missed_timers_;
 };
 
 #endif
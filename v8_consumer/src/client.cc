@@ -9,6 +9,7 @@
 // or implied. See the License for the specific language governing
 // permissions and limitations under the License.
 
+#include <algorithm>
 #include <chrono>
 #include <string>
 #include <thread>
@@ -33,60 +34,80 @@ std::atomic<int64_t> uv_msg_parse_failure = {0};
 
 extern std::atomic<int64_t> timer_context_size_exceeded_counter;
 extern std::atomic<int64_t> timer_callback_missing_counter;
+extern std::atomic<int64_t> timer_in_past_rejected_counter;
 
 std::atomic<int64_t> uv_try_write_failure_counter = {0};
 
 std::string executable_img;
 
-std::string GetFailureStats() {
-  nlohmann::json fstats;
-  fstats["bucket_op_exception_count"] = bucket_op_exception_count.load();
-  fstats["n1ql_op_exception_count"] = n1ql_op_exception_count.load();
-  fstats["timeout_count"] = timeout_count.load();
-  fstats["checkpoint_failure_count"] = checkpoint_failure_count.load();
-  fstats["dcp_events_lost"] = e_dcp_lost.load();
-  fstats["v8worker_events_lost"] = e_v8_worker_lost.load();
-  fstats["app_worker_setting_events_lost"] = e_app_worker_setting_lost.load();
-  fstats["timer_events_lost"] = e_timer_lost.load();
-  fstats["debugger_events_lost"] = e_debugger_lost.load();
-  fstats["mutation_events_lost"] = mutation_events_lost.load();
-  fstats["timer_context_size_exceeded_counter"] =
-      timer_context_size_exceeded_counter.load();
-  fstats["timer_callback_missing_counter"] =
-      timer_callback_missing_counter.load();
-  fstats["delete_events_lost"] = delete_events_lost.load();
-  fstats["timer_events_lost"] = timer_events_lost.load();
-  fstats["curl_non_200_response"] = Curl::GetStats().GetCurlFailureStat();
-  fstats["timestamp"] = GetTimestampNow();
-  return fstats.dump();
+StatsTable GetFailureStats() {
+  StatsTable fstats;
+  fstats.Add("bucket_op_exception_count", bucket_op_exception_count.load());
+  fstats.Add("n1ql_op_exception_count", n1ql_op_exception_count.load());
+  fstats.Add("timeout_count", timeout_count.load());
+  fstats.Add("checkpoint_failure_count", checkpoint_failure_count.load());
+  fstats.Add("dcp_events_lost", e_dcp_lost.load());
+  fstats.Add("v8worker_events_lost", e_v8_worker_lost.load());
+  fstats.Add("app_worker_setting_events_lost",
+             e_app_worker_setting_lost.load());
+  fstats.Add("timer_events_lost", e_timer_lost.load());
+  fstats.Add("debugger_events_lost", e_debugger_lost.load());
+  fstats.Add("mutation_events_lost", mutation_events_lost.load());
+  fstats.Add("timer_context_size_exceeded_counter",
+             timer_context_size_exceeded_counter.load());
+  fstats.Add("timer_callback_missing_counter",
+             timer_callback_missing_counter.load());
+  fstats.Add("timer_in_past_rejected_counter",
+             timer_in_past_rejected_counter.load());
+  fstats.Add("timer_series_rearm_failure", timer_series_rearm_failure.load());
+  fstats.Add("timer_missed_coalesced_counter",
+             timer_missed_coalesced_counter.load());
+  fstats.Add("timer_missed_deferred_counter",
+             timer_missed_deferred_counter.load());
+  fstats.Add("timer_missed_dropped_counter",
+             timer_missed_dropped_counter.load());
+  fstats.Add("timer_context_chunk_missing_counter",
+             timer_context_chunk_missing_counter.load());
+  fstats.Add("delete_events_lost", delete_events_lost.load());
+  fstats.Add("timer_events_lost", timer_events_lost.load());
+  fstats.Add("curl_non_200_response", Curl::GetStats().GetCurlFailureStat());
+  fstats.timestamp = GetTimestampNow();
+  return fstats;
 }
 
-std::string GetExecutionStats(const std::map<int16_t, V8Worker *> &workers) {
-  nlohmann::json estats;
-  estats["on_update_success"] = on_update_success.load();
-  estats["on_update_failure"] = on_update_failure.load();
-  estats["on_delete_success"] = on_delete_success.load();
-  estats["on_delete_failure"] = on_delete_failure.load();
-  estats["timer_create_failure"] = timer_create_failure.load();
-  estats["messages_parsed"] = messages_parsed;
-  estats["dcp_delete_msg_counter"] = dcp_delete_msg_counter.load();
-  estats["dcp_mutation_msg_counter"] = dcp_mutation_msg_counter.load();
-  estats["timer_msg_counter"] = timer_msg_counter.load();
-  estats["timer_create_counter"] = timer_create_counter.load();
-  estats["timer_cancel_counter"] = timer_cancel_counter.load();
-  estats["enqueued_dcp_delete_msg_counter"] =
-      enqueued_dcp_delete_msg_counter.load();
-  estats["enqueued_dcp_mutation_msg_counter"] =
-      enqueued_dcp_mutation_msg_counter.load();
-  estats["enqueued_timer_msg_counter"] = enqueued_timer_msg_counter.load();
-  estats["timer_responses_sent"] = timer_responses_sent;
-  estats["uv_try_write_failure_counter"] = uv_try_write_failure_counter.load();
-  estats["lcb_retry_failure"] = lcb_retry_failure.load();
-  estats["dcp_delete_parse_failure"] = dcp_delete_parse_failure.load();
-  estats["dcp_mutation_parse_failure"] = dcp_mutation_parse_failure.load();
-  estats["filtered_dcp_delete_counter"] = filtered_dcp_delete_counter.load();
-  estats["filtered_dcp_mutation_counter"] =
-      filtered_dcp_mutation_counter.load();
+StatsTable GetExecutionStats(const std::map<int16_t, V8Worker *> &workers) {
+  StatsTable estats;
+  estats.Add("on_update_success", on_update_success.load());
+  estats.Add("on_update_failure", on_update_failure.load());
+  estats.Add("on_delete_success", on_delete_success.load());
+  estats.Add("on_delete_failure", on_delete_failure.load());
+  estats.Add("timer_create_failure", timer_create_failure.load());
+  estats.Add("messages_parsed", messages_parsed);
+  estats.Add("dcp_delete_msg_counter", dcp_delete_msg_counter.load());
+  estats.Add("dcp_mutation_msg_counter", dcp_mutation_msg_counter.load());
+  estats.Add("timer_msg_counter", timer_msg_counter.load());
+  estats.Add("timer_create_counter", timer_create_counter.load());
+  estats.Add("timer_cancel_counter", timer_cancel_counter.load());
+  estats.Add("timer_series_rearm_counter", timer_series_rearm_counter.load());
+  estats.Add("timer_missed_fired_counter", timer_missed_fired_counter.load());
+  estats.Add("timer_context_compressed_counter",
+             timer_context_compressed_counter.load());
+  estats.Add("timer_context_chunked_counter",
+             timer_context_chunked_counter.load());
+  estats.Add("enqueued_dcp_delete_msg_counter",
+             enqueued_dcp_delete_msg_counter.load());
+  estats.Add("enqueued_dcp_mutation_msg_counter",
+             enqueued_dcp_mutation_msg_counter.load());
+  estats.Add("enqueued_timer_msg_counter", enqueued_timer_msg_counter.load());
+  estats.Add("timer_responses_sent", timer_responses_sent);
+  estats.Add("uv_try_write_failure_counter",
+             uv_try_write_failure_counter.load());
+  estats.Add("lcb_retry_failure", lcb_retry_failure.load());
+  estats.Add("dcp_delete_parse_failure", dcp_delete_parse_failure.load());
+  estats.Add("dcp_mutation_parse_failure", dcp_mutation_parse_failure.load());
+  estats.Add("filtered_dcp_delete_counter", filtered_dcp_delete_counter.load());
+  estats.Add("filtered_dcp_mutation_counter",
+             filtered_dcp_mutation_counter.load());
   if (!workers.empty()) {
     int64_t agg_queue_memory = 0, agg_queue_size = 0;
     for (const auto &w : workers) {
@@ -94,20 +115,22 @@ std::string GetExecutionStats(const std::map<int16_t, V8Worker *> &workers) {
       agg_queue_memory += w.second->worker_queue_->GetMemory();
     }
 
-    estats["agg_queue_size"] = agg_queue_size;
-    estats["feedback_queue_size"] = 0;
-    estats["agg_queue_memory"] = agg_queue_memory;
-    estats["processed_events_size"] = processed_events_size.load();
-    estats["num_processed_events"] = num_processed_events.load();
+    estats.Add("agg_queue_size", agg_queue_size);
+    estats.Add("feedback_queue_size", 0);
+    estats.Add("agg_queue_memory", agg_queue_memory);
+    estats.Add("processed_events_size", processed_events_size.load());
+    estats.Add("num_processed_events", num_processed_events.load());
   }
-  estats["curl"]["get"] = Curl::GetStats().GetCurlGetStat();
-  estats["curl"]["post"] = Curl::GetStats().GetCurlPostStat();
-  estats["curl"]["delete"] = Curl::GetStats().GetCurlDeleteStat();
-  estats["curl"]["head"] = Curl::GetStats().GetCurlHeadStat();
-  estats["curl"]["put"] = Curl::GetStats().GetCurlPutStat();
-  estats["timestamp"] = GetTimestampNow();
-  estats["uv_msg_parse_failure"] = uv_msg_parse_failure.load();
-  return estats.dump();
+  auto &curl = estats.AddGroup("curl");
+  curl.emplace_back("get", Curl::GetStats().GetCurlGetStat());
+  curl.emplace_back("post", Curl::GetStats().GetCurlPostStat());
+  curl.emplace_back("delete", Curl::GetStats().GetCurlDeleteStat());
+  curl.emplace_back("head", Curl::GetStats().GetCurlHeadStat());
+  curl.emplace_back("put", Curl::GetStats().GetCurlPutStat());
+  timer_firing_metrics.AddTo(estats.AddGroup("timer_firing"));
+  estats.timestamp = GetTimestampNow();
+  estats.Add("uv_msg_parse_failure", uv_msg_parse_failure.load());
+  return estats;
 }
 
 static void alloc_buffer_main(uv_handle_t *handle, size_t suggested_size,
@@ -163,6 +186,7 @@ AppWorker::GetWorkerMessage(int encoded_header_size, int encoded_payload_size,
   worker_msg->header.opcode = header_flatbuf->opcode();
   worker_msg->header.partition = header_flatbuf->partition();
   worker_msg->header.metadata = header_flatbuf->metadata()->str();
+  worker_msg->header.priority = header_flatbuf->priority();
   return {true, std::move(worker_msg)};
 }
 
@@ -272,6 +296,66 @@ void AppWorker::InitUDS(const std::string &function_name,
   main_uv_loop_thr_ = std::move(m_thr);
 }
 
+void AppWorker::InitShm(const std::string &function_name,
+                        const std::string &function_id,
+                        const std::string &user_prefix,
+                        const std::string &appname,
+                        const std::string &worker_id, int bsize, int fbsize,
+                        const std::string &feedback_shm_path,
+                        const std::string &shm_path) {
+  function_name_ = function_name;
+  function_id_ = function_id;
+  user_prefix_ = user_prefix;
+  app_name_ = appname;
+  batch_size_ = bsize;
+  feedback_batch_size_ = fbsize;
+  messages_processed_counter = 0;
+  processed_events_size = 0;
+  num_processed_events = 0;
+
+  LOG(logInfo) << "Starting worker with shm for appname:" << appname
+               << " worker id:" << worker_id << " batch size:" << batch_size_
+               << " feedback batch size:" << fbsize
+               << " feedback shm path:" << RS(feedback_shm_path)
+               << " shm path:" << RS(shm_path) << std::endl;
+
+  std::unique_ptr<ShmChannel> feedback_conn(
+      new ShmChannel(thread_exit_cond_));
+  std::unique_ptr<ShmChannel> conn(new ShmChannel(thread_exit_cond_));
+  if (!feedback_conn->Attach(feedback_shm_path, std::chrono::seconds(60)) ||
+      !conn->Attach(shm_path, std::chrono::seconds(60))) {
+    LOG(logError) << "Connection failed, unable to attach to shared memory"
+                  << std::endl;
+    return;
+  }
+
+  LOG(logInfo) << "Client connected on feedback channel" << std::endl;
+  shm_feedback_conn_ = std::move(feedback_conn);
+  LOG(logInfo) << "Client connected" << std::endl;
+  shm_conn_ = std::move(conn);
+
+  std::thread f_thr(&AppWorker::ReadShmLoop, this, shm_feedback_conn_.get(),
+                    &read_buffer_feedback_);
+  feedback_uv_loop_thr_ = std::move(f_thr);
+
+  std::thread m_thr(&AppWorker::ReadShmLoop, this, shm_conn_.get(),
+                    &read_buffer_main_);
+  main_uv_loop_thr_ = std::move(m_thr);
+}
+
+// Counterpart of uv read callbacks for shm transport, messages read off the
+// segment go through the same parser and responses are written back to it
+void AppWorker::ReadShmLoop(ShmChannel *channel, std::vector<char> *buffer) {
+  for (;;) {
+    auto nread = channel->Read(buffer->data(), buffer->size());
+    if (nread == 0) {
+      break;
+    }
+    ParseValidChunk(nullptr, nread, buffer->data());
+  }
+  channel->Close();
+}
+
 void AppWorker::OnConnect(uv_connect_t *conn, int status) {
   if (status == 0) {
     LOG(logInfo) << "Client connected" << std::endl;
@@ -369,27 +453,16 @@ void AppWorker::ParseValidChunk(uv_stream_t *stream, int nread,
 
           // Reset the message priority flag
           msg_priority_ = false;
-          if (!resp_msg_->msg.empty()) {
-            flatbuffers::FlatBufferBuilder builder;
-
-            auto flatbuf_msg = builder.CreateString(resp_msg_->msg.c_str());
-            auto r = flatbuf::response::CreateResponse(
-                builder, resp_msg_->msg_type, resp_msg_->opcode, flatbuf_msg);
-            builder.Finish(r);
-
-            uint32_t s = builder.GetSize();
-            char *size = (char *)&s;
-            FlushToConn(stream, size, SIZEOF_UINT32);
-
-            // Write payload to socket
-            std::string msg((const char *)builder.GetBufferPointer(),
-                            builder.GetSize());
-            FlushToConn(stream, (char *)msg.c_str(), msg.length());
+          if (!resp_msg_->msg.empty() || resp_msg_->stats) {
+            WriteResponse(stream, *resp_msg_);
 
             // Reset the values
             resp_msg_->msg.clear();
+            resp_msg_->stats.reset();
             resp_msg_->msg_type = 0;
             resp_msg_->opcode = 0;
+            resp_msg_->protocol_version = 0;
+            resp_msg_->min_protocol_version = 0;
           }
 
           // Flush the aggregate item count in queues for all running
@@ -401,29 +474,18 @@ void AppWorker::ParseValidChunk(uv_stream_t *stream, int nread,
               agg_queue_memory += w.second->worker_queue_->GetMemory();
             }
 
-            std::ostringstream queue_stats;
-            queue_stats << R"({"agg_queue_size":)";
-            queue_stats << agg_queue_size << R"(, "feedback_queue_size":)";
-            queue_stats << 0 << R"(, "agg_queue_memory":)";
-            queue_stats << agg_queue_memory << R"(, "processed_events_size":)";
-            queue_stats << processed_events_size
-                        << R"(, "num_processed_events":)";
-            queue_stats << num_processed_events << "}";
-
-            flatbuffers::FlatBufferBuilder builder;
-            auto flatbuf_msg = builder.CreateString(queue_stats.str());
-            auto r = flatbuf::response::CreateResponse(
-                builder, mV8_Worker_Config, oQueueSize, flatbuf_msg);
-            builder.Finish(r);
-
-            uint32_t s = builder.GetSize();
-            char *size = (char *)&s;
-            FlushToConn(stream, size, SIZEOF_UINT32);
-
-            // Write payload to socket
-            std::string msg((const char *)builder.GetBufferPointer(),
-                            builder.GetSize());
-            FlushToConn(stream, (char *)msg.c_str(), msg.length());
+            resp_msg_t queue_resp;
+            queue_resp.stats.reset(new StatsTable());
+            queue_resp.stats->Add("agg_queue_size", agg_queue_size);
+            queue_resp.stats->Add("feedback_queue_size", 0);
+            queue_resp.stats->Add("agg_queue_memory", agg_queue_memory);
+            queue_resp.stats->Add("processed_events_size",
+                                  processed_events_size);
+            queue_resp.stats->Add("num_processed_events",
+                                  num_processed_events);
+            queue_resp.msg_type = mV8_Worker_Config;
+            queue_resp.opcode = oQueueSize;
+            WriteResponse(stream, queue_resp);
           }
         }
       } else {
@@ -446,6 +508,16 @@ void AppWorker::ParseValidChunk(uv_stream_t *stream, int nread,
 }
 
 void AppWorker::FlushToConn(uv_stream_t *stream, char *msg, int length) {
+  if (shm_conn_ != nullptr) {
+    if (!shm_conn_->Write(msg, length)) {
+      LOG(logError) << "Write to shared memory failed while flushing payload "
+                       "content"
+                    << std::endl;
+      uv_try_write_failure_counter++;
+    }
+    return;
+  }
+
   auto buffer = uv_buf_init(msg, length);
 
   unsigned bytes_written = 0;
@@ -471,6 +543,161 @@ void AppWorker::FlushToConn(uv_stream_t *stream, char *msg, int length) {
   }
 }
 
+// Stats responses are sent as typed flatbuffer tables from protocol version 2
+static std::vector<flatbuffers::Offset<flatbuf::response::Stat>>
+CreateStatList(flatbuffers::FlatBufferBuilder &builder,
+               const StatsTable::StatList &stats) {
+  std::vector<flatbuffers::Offset<flatbuf::response::Stat>> stat_list;
+  stat_list.reserve(stats.size());
+  for (const auto &stat : stats) {
+    auto name = builder.CreateString(stat.first);
+    stat_list.push_back(
+        flatbuf::response::CreateStat(builder, name, stat.second));
+  }
+  return stat_list;
+}
+
+static flatbuffers::Offset<flatbuf::response::Stats>
+CreateTypedStats(flatbuffers::FlatBufferBuilder &builder,
+                 const StatsTable &stats) {
+  auto counters = CreateStatList(builder, stats.counters);
+
+  std::vector<flatbuffers::Offset<flatbuf::response::StatGroup>> groups;
+  groups.reserve(stats.groups.size());
+  for (const auto &group : stats.groups) {
+    auto group_stats = CreateStatList(builder, group.second);
+    auto name = builder.CreateString(group.first);
+    auto group_vec = builder.CreateVector(group_stats);
+    groups.push_back(
+        flatbuf::response::CreateStatGroup(builder, name, group_vec));
+  }
+
+  auto counters_vec = builder.CreateVector(counters);
+  auto groups_vec = builder.CreateVector(groups);
+  auto timestamp_str = builder.CreateString(stats.timestamp);
+  return flatbuf::response::CreateStats(builder, counters_vec, groups_vec,
+                                        timestamp_str);
+}
+
+void AppWorker::WriteResponse(uv_stream_t *stream, const resp_msg_t &resp) {
+  flatbuffers::FlatBufferBuilder builder;
+
+  // Stats are JSON encoded in msg for producers older than protocol version 2
+  flatbuffers::Offset<flatbuf::response::Stats> stats = 0;
+  flatbuffers::Offset<flatbuffers::String> flatbuf_msg = 0;
+  if (resp.stats && protocol_version_ >= 2) {
+    stats = CreateTypedStats(builder, *resp.stats);
+  } else if (resp.stats) {
+    flatbuf_msg = builder.CreateString(resp.stats->ToJSON());
+  } else {
+    flatbuf_msg = builder.CreateString(resp.msg.c_str());
+  }
+
+  auto r = flatbuf::response::CreateResponse(
+      builder, resp.msg_type, resp.opcode, flatbuf_msg, stats,
+      resp.protocol_version, resp.min_protocol_version);
+  builder.Finish(r);
+
+  uint32_t s = builder.GetSize();
+  char *size = (char *)&s;
+  FlushToConn(stream, size, SIZEOF_UINT32);
+
+  // Write payload to socket
+  std::string msg((const char *)builder.GetBufferPointer(), builder.GetSize());
+  FlushToConn(stream, (char *)msg.c_str(), msg.length());
+}
+
+void AppWorker::NegotiateProtocolVersion(
+    const flatbuf::payload::Payload *payload) {
+  auto producer_version = payload->protocol_version();
+  auto producer_min_version = payload->min_protocol_version();
+  auto version = std::min(producer_version, PROTOCOL_VERSION);
+
+  std::ostringstream msg;
+  if (version >= std::max(producer_min_version, MIN_PROTOCOL_VERSION)) {
+    protocol_version_ = version;
+    resp_msg_->opcode = oHandshakeAccepted;
+    resp_msg_->protocol_version = version;
+    msg << "accepted protocol version " << version;
+  } else {
+    resp_msg_->opcode = oHandshakeRejected;
+    resp_msg_->protocol_version = PROTOCOL_VERSION;
+    msg << "no common protocol version, producer supports "
+        << producer_min_version << "-" << producer_version
+        << " worker supports " << MIN_PROTOCOL_VERSION << "-"
+        << PROTOCOL_VERSION;
+  }
+
+  resp_msg_->msg = msg.str();
+  resp_msg_->msg_type = mHandshake;
+  resp_msg_->min_protocol_version = MIN_PROTOCOL_VERSION;
+  msg_priority_ = true;
+
+  LOG(logInfo) << "Protocol handshake: " << resp_msg_->msg << std::endl;
+}
+
+// Lets producer know about a message that couldn't be interpreted, instead of
+// dropping it silently
+void AppWorker::RejectMessage(protocol_error_opcode opcode, int8_t event,
+                              int8_t msg_opcode) {
+  std::ostringstream msg;
+  msg << "event: " << static_cast<int16_t>(event)
+      << " opcode: " << static_cast<int16_t>(msg_opcode)
+      << " protocol version: " << protocol_version_.load();
+
+  resp_msg_->msg = msg.str();
+  resp_msg_->msg_type = mProtocolError;
+  resp_msg_->opcode = opcode;
+  msg_priority_ = true;
+}
+
+// Unpacks a batch of dcp events into individual messages, queued up for the
+// worker thread owning the partition in the same order as they were batched
+void AppWorker::EnqueueDcpEventBatch(int16_t partition,
+                                     const flatbuf::payload::Payload *payload) {
+  auto events = payload->dcp_events();
+  if (events == nullptr) {
+    return;
+  }
+
+  auto worker_index = partition_thr_map_[partition];
+  auto worker = workers_[worker_index];
+  if (worker == nullptr) {
+    LOG(logError) << "DCP event batch of size " << events->size()
+                  << " lost: worker " << worker_index << " is null"
+                  << std::endl;
+    e_dcp_lost += events->size();
+    return;
+  }
+
+  for (const auto event : *events) {
+    auto msg = std::make_unique<WorkerMessage>();
+    msg->header.event = eDCP + 1;
+    msg->header.opcode = event->opcode();
+    msg->header.partition = partition;
+    msg->header.metadata = event->metadata()->str();
+    msg->payload.payload.assign(
+        reinterpret_cast<const char *>(event->payload()->data()),
+        event->payload()->size());
+
+    switch (getDCPOpcode(msg->header.opcode)) {
+    case oDelete:
+      enqueued_dcp_delete_msg_counter++;
+      break;
+    case oMutation:
+      enqueued_dcp_mutation_msg_counter++;
+      break;
+    default:
+      LOG(logError) << "Opcode " << getDCPOpcode(msg->header.opcode)
+                    << "is not implemented for eDCP batch" << std::endl;
+      ++e_dcp_lost;
+      continue;
+    }
+
+    worker->PushBack(std::move(msg));
+  }
+}
+
 void AppWorker::RouteMessageWithResponse(
     std::unique_ptr<WorkerMessage> worker_msg) {
   std::string key, val, doc_id, callback_fn, doc_ids_cb_fns, compile_resp;
@@ -479,7 +706,6 @@ void AppWorker::RouteMessageWithResponse(
   handler_config_t *handler_config;
 
   int worker_index;
-  nlohmann::json estats;
   std::map<int, int64_t> agg_lcb_exceptions;
   std::string handler_instance_id;
 
@@ -515,6 +741,16 @@ void AppWorker::RouteMessageWithResponse(
       handler_config->skip_lcb_bootstrap = payload->skip_lcb_bootstrap();
       using_timer_ = payload->using_timer();
       handler_config->using_timer = using_timer_;
+      idempotency_keys_ = payload->idempotency_keys();
+      handler_config->idempotency_keys = idempotency_keys_;
+      handler_config->reject_timers_in_past = payload->reject_timers_in_past();
+      handler_config->timer_resolution = payload->timer_resolution();
+      handler_config->timer_context_overflow =
+          payload->timer_context_overflow()->str();
+      handler_config->missed_timer_policy =
+          payload->missed_timer_policy()->str();
+      handler_config->missed_timer_threshold = payload->missed_timer_threshold();
+      handler_config->missed_timer_rate = payload->missed_timer_rate();
       handler_config->timer_context_size = payload->timer_context_size();
       handler_config->handler_headers =
           ToStringArray(payload->handler_headers());
@@ -575,14 +811,16 @@ void AppWorker::RouteMessageWithResponse(
       break;
 
     case oGetLatencyStats:
-      resp_msg_->msg = latency_stats_.ToString();
+      resp_msg_->stats.reset(new StatsTable());
+      latency_stats_.Drain(resp_msg_->stats->counters);
       resp_msg_->msg_type = mV8_Worker_Config;
       resp_msg_->opcode = oLatencyStats;
       msg_priority_ = true;
       break;
 
     case oGetCurlLatencyStats:
-      resp_msg_->msg = curl_latency_stats_.ToString();
+      resp_msg_->stats.reset(new StatsTable());
+      curl_latency_stats_.Drain(resp_msg_->stats->counters);
       resp_msg_->msg_type = mV8_Worker_Config;
       resp_msg_->opcode = oCurlLatencyStats;
       msg_priority_ = true;
@@ -598,18 +836,18 @@ void AppWorker::RouteMessageWithResponse(
       break;
 
     case oGetFailureStats:
-      LOG(logTrace) << "v8worker failure stats : " << GetFailureStats()
-                    << std::endl;
+      resp_msg_->stats.reset(new StatsTable(GetFailureStats()));
+      LOG(logTrace) << "v8worker failure stats : "
+                    << resp_msg_->stats->ToJSON() << std::endl;
 
-      resp_msg_->msg.assign(GetFailureStats());
       resp_msg_->msg_type = mV8_Worker_Config;
       resp_msg_->opcode = oFailureStats;
       msg_priority_ = true;
       break;
     case oGetExecutionStats:
+      resp_msg_->stats.reset(new StatsTable(GetExecutionStats(workers_)));
       LOG(logTrace) << "v8worker execution stats:"
-                    << GetExecutionStats(workers_) << std::endl;
-      resp_msg_->msg.assign(GetExecutionStats(workers_));
+                    << resp_msg_->stats->ToJSON() << std::endl;
       resp_msg_->msg_type = mV8_Worker_Config;
       resp_msg_->opcode = oExecutionStats;
       msg_priority_ = true;
@@ -629,13 +867,11 @@ void AppWorker::RouteMessageWithResponse(
         w.second->ListLcbExceptions(agg_lcb_exceptions);
       }
 
-      estats.clear();
-
+      resp_msg_->stats.reset(new StatsTable());
       for (auto const &entry : agg_lcb_exceptions) {
-        estats[std::to_string(entry.first)] = entry.second;
+        resp_msg_->stats->Add(std::to_string(entry.first), entry.second);
       }
 
-      resp_msg_->msg.assign(estats.dump());
       resp_msg_->msg_type = mV8_Worker_Config;
       resp_msg_->opcode = oLcbExceptions;
       msg_priority_ = true;
@@ -645,20 +881,25 @@ void AppWorker::RouteMessageWithResponse(
       LOG(logError) << "Opcode " << getV8WorkerOpcode(worker_msg->header.opcode)
                     << "is not implemented for eV8Worker" << std::endl;
       ++e_v8_worker_lost;
+      RejectMessage(oUnknownOpcode, worker_msg->header.event,
+                    worker_msg->header.opcode);
       break;
     }
     break;
   case eDCP:
     payload = flatbuf::payload::GetPayload(
         (const void *)worker_msg->payload.payload.c_str());
-    val.assign(payload->value()->str());
 
     switch (getDCPOpcode(worker_msg->header.opcode)) {
     case oDelete:
       worker_index = partition_thr_map_[worker_msg->header.partition];
       if (workers_[worker_index] != nullptr) {
         enqueued_dcp_delete_msg_counter++;
-        workers_[worker_index]->PushBack(std::move(worker_msg));
+        if (worker_msg->header.priority) {
+          workers_[worker_index]->PushBackPriority(std::move(worker_msg));
+        } else {
+          workers_[worker_index]->PushBack(std::move(worker_msg));
+        }
       } else {
         LOG(logError) << "Delete event lost: worker " << worker_index
                       << " is null" << std::endl;
@@ -669,17 +910,26 @@ void AppWorker::RouteMessageWithResponse(
       worker_index = partition_thr_map_[worker_msg->header.partition];
       if (workers_[worker_index] != nullptr) {
         enqueued_dcp_mutation_msg_counter++;
-        workers_[worker_index]->PushBack(std::move(worker_msg));
+        if (worker_msg->header.priority) {
+          workers_[worker_index]->PushBackPriority(std::move(worker_msg));
+        } else {
+          workers_[worker_index]->PushBack(std::move(worker_msg));
+        }
       } else {
         LOG(logError) << "Mutation event lost: worker " << worker_index
                       << " is null" << std::endl;
         ++mutation_events_lost;
       }
       break;
+    case oBatch:
+      EnqueueDcpEventBatch(worker_msg->header.partition, payload);
+      break;
     default:
       LOG(logError) << "Opcode " << getDCPOpcode(worker_msg->header.opcode)
                     << "is not implemented for eDCP" << std::endl;
       ++e_dcp_lost;
+      RejectMessage(oUnknownOpcode, worker_msg->header.event,
+                    worker_msg->header.opcode);
       break;
     }
     break;
@@ -704,8 +954,18 @@ void AppWorker::RouteMessageWithResponse(
           }
           worker->RemoveTimerPartition(vb_no);
           lck.unlock();
-          SendFilterAck(oVbFilter, mFilterAck, vb_no, last_processed_seq_no,
-                        skip_ack);
+          if (worker->IsVbDrainRequested(worker_msg->header.metadata)) {
+            // Ack waits on event of vb being run right now, and the queued up
+            // ones being dropped, so that next owner doesn't overlap with it
+            std::unique_ptr<WorkerMessage> msg(new WorkerMessage);
+            msg->header.event = eInternal + 1;
+            msg->header.opcode = oVbDrainBarrier;
+            msg->header.metadata = worker_msg->header.metadata;
+            worker->PushBack(std::move(msg));
+          } else {
+            SendFilterAck(oVbFilter, mFilterAck, vb_no, last_processed_seq_no,
+                          skip_ack);
+          }
         }
       } else {
         LOG(logError) << "Filter event lost: worker " << worker_index
@@ -730,6 +990,8 @@ void AppWorker::RouteMessageWithResponse(
     default:
       LOG(logError) << "Opcode " << getFilterOpcode(worker_msg->header.opcode)
                     << "is not implemented for filtering" << std::endl;
+      RejectMessage(oUnknownOpcode, worker_msg->header.event,
+                    worker_msg->header.opcode);
       break;
     }
     break;
@@ -823,6 +1085,8 @@ void AppWorker::RouteMessageWithResponse(
                     << "is not implemented for eApp_Worker_Setting"
                     << std::endl;
       ++e_app_worker_setting_lost;
+      RejectMessage(oUnknownOpcode, worker_msg->header.event,
+                    worker_msg->header.opcode);
       break;
     }
     break;
@@ -852,11 +1116,31 @@ void AppWorker::RouteMessageWithResponse(
       LOG(logError) << "Opcode " << getDebuggerOpcode(worker_msg->header.opcode)
                     << "is not implemented for eDebugger" << std::endl;
       ++e_debugger_lost;
+      RejectMessage(oUnknownOpcode, worker_msg->header.event,
+                    worker_msg->header.opcode);
+      break;
+    }
+    break;
+  case eHandshake:
+    switch (getHandshakeOpcode(worker_msg->header.opcode)) {
+    case oHandshakeRequest:
+      payload = flatbuf::payload::GetPayload(
+          (const void *)worker_msg->payload.payload.c_str());
+      NegotiateProtocolVersion(payload);
+      break;
+    default:
+      LOG(logError) << "Opcode "
+                    << getHandshakeOpcode(worker_msg->header.opcode)
+                    << "is not implemented for eHandshake" << std::endl;
+      RejectMessage(oUnknownOpcode, worker_msg->header.event,
+                    worker_msg->header.opcode);
       break;
     }
     break;
   default:
     LOG(logError) << "Unknown command" << std::endl;
+    RejectMessage(oUnknownEvent, worker_msg->header.event,
+                  worker_msg->header.opcode);
     break;
   }
 }
@@ -886,7 +1170,7 @@ void AppWorker::WriteResponses() {
     for (const auto &w : workers_) {
       std::vector<uv_buf_t> messages;
       std::vector<int> length_prefix_sum;
-      w.second->GetBucketOpsMessages(messages);
+      w.second->GetBucketOpsMessages(messages, protocol_version_ >= 3);
       if (messages.empty()) {
         continue;
       }
@@ -896,13 +1180,25 @@ void AppWorker::WriteResponses() {
         delete[] buf.base;
       }
     }
-    std::this_thread::sleep_for(checkpoint_interval_);
+    std::this_thread::sleep_for(
+        idempotency_keys_ ? std::min(checkpoint_interval_, COMMIT_ACK_INTERVAL)
+                          : checkpoint_interval_);
   }
 }
 
 void AppWorker::WriteResponseWithRetry(uv_stream_t *handle,
                                        std::vector<uv_buf_t> messages,
                                        size_t max_batch_size) {
+  if (shm_feedback_conn_ != nullptr) {
+    for (const auto &buf : messages) {
+      if (!shm_feedback_conn_->Write(buf.base, buf.len)) {
+        uv_try_write_failure_counter++;
+        return;
+      }
+    }
+    return;
+  }
+
   size_t curr_idx = 0, counter = 0;
   while (curr_idx < messages.size()) {
     size_t batch_size = messages.size() - curr_idx;
@@ -1121,7 +1417,7 @@ int main(int argc, char **argv) {
 
   executable_img = argv[0];
   std::string appname(argv[1]);
-  std::string ipc_type(argv[2]); // can be af_unix or af_inet
+  std::string ipc_type(argv[2]); // can be af_unix, af_inet or shm
   std::string port = argv[3];
   std::string feedback_port(argv[4]);
   std::string worker_id(argv[5]);
@@ -1151,6 +1447,9 @@ int main(int argc, char **argv) {
     worker->InitUDS(appname, function_id, user_prefix, appname,
                     Localhost(false), worker_id, batch_size,
                     feedback_batch_size, feedback_port, port);
+  } else if (std::strcmp(ipc_type.c_str(), "shm") == 0) {
+    worker->InitShm(appname, function_id, user_prefix, appname, worker_id,
+                    batch_size, feedback_batch_size, feedback_port, port);
   } else {
     worker->InitTcpSock(appname, function_id, user_prefix, appname,
                         Localhost(false), worker_id, batch_size,
@@ -28,6 +28,8 @@ event_type getEvent(int8_t event) {
     return eInternal;
   if (event == 8)
     return ePauseConsumer;
+  if (event == 9)
+    return eHandshake;
   return Event_Unknown;
 }
 
@@ -66,6 +68,8 @@ dcp_opcode getDCPOpcode(int8_t opcode) {
     return oDelete;
   if (opcode == 2)
     return oMutation;
+  if (opcode == 3)
+    return oBatch;
   return DCP_Opcode_Unknown;
 }
 
@@ -106,3 +110,9 @@ debugger_opcode getDebuggerOpcode(int8_t opcode) {
     return oDebuggerStop;
   return Debugger_Opcode_Unknown;
 }
+
+handshake_opcode getHandshakeOpcode(int8_t opcode) {
+  if (opcode == 1)
+    return oHandshakeRequest;
+  return Handshake_Opcode_Unknown;
+}
@@ -9,10 +9,12 @@
 // or implied. See the License for the specific language governing
 // permissions and limitations under the License.
 
-#include <sstream>
-
 #include "histogram.h"
 
+Histogram::Histogram(int64_t from, int64_t till, int64_t width)
+    : from_(from), till_(till), width_(width),
+      num_buckets_(1 + ((till - from) / width)), data_(num_buckets_) {}
+
 void Histogram::Add(int64_t sample) {
   if (sample <= from_) {
     ++data_[0];
@@ -24,27 +26,27 @@ void Histogram::Add(int64_t sample) {
     return;
   }
 
+  // Samples just short of till share last bin with those past it
   std::size_t index = ((sample - from_) / width_) + 1;
-  if ((index > 0) && (index < (num_buckets_ - 1))) {
-    ++data_[index];
+  if (index > num_buckets_ - 1) {
+    index = num_buckets_ - 1;
   }
+  ++data_[index];
 }
 
 std::string Histogram::ToString() {
-  std::ostringstream out;
-  auto separator = "";
+  StatsTable stats;
+  Drain(stats.counters);
+  return stats.ToJSON();
+}
 
-  out << "{";
+void Histogram::Drain(StatsTable::StatList &stats, const std::string &prefix) {
   for (std::size_t i = 0; i < num_buckets_; ++i) {
     auto data = data_[i].exchange(0);
     if (data == 0) {
       continue;
     }
-    out << separator << R"(")" << (i == 0 ? from_ : i * width_) << R"(":)"
-        << data;
-    separator = ",";
+    stats.emplace_back(prefix + std::to_string(i == 0 ? from_ : i * width_),
+                       data);
   }
-  out << "}";
-
-  return out.str();
 }
@@ -0,0 +1,279 @@
+// Copyright (c) 2017 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#include "log.h"
+#include "shm_transport.h"
+
+#if defined(__linux__)
+#include <algorithm>
+#include <cerrno>
+#include <climits>
+#include <cstring>
+#include <fcntl.h>
+#include <linux/futex.h>
+#include <signal.h>
+#include <sys/mman.h>
+#include <sys/stat.h>
+#include <sys/syscall.h>
+#include <time.h>
+#include <unistd.h>
+
+template <typename T> static T *At(char *mem, size_t offset) {
+  return reinterpret_cast<T *>(mem + offset);
+}
+
+template <typename T> static T Load(const T *addr) {
+  return __atomic_load_n(addr, __ATOMIC_SEQ_CST);
+}
+
+template <typename T> static void Store(T *addr, T val) {
+  __atomic_store_n(addr, val, __ATOMIC_SEQ_CST);
+}
+
+static void FutexWait(uint32_t *addr, uint32_t val) {
+  auto nsecs = std::chrono::duration_cast<std::chrono::nanoseconds>(
+                   SHM_POLL_INTERVAL)
+                   .count();
+  struct timespec ts;
+  ts.tv_sec = nsecs / 1000000000;
+  ts.tv_nsec = nsecs % 1000000000;
+  syscall(SYS_futex, addr, FUTEX_WAIT, val, &ts, nullptr, 0);
+}
+
+static void FutexWake(uint32_t *addr) {
+  syscall(SYS_futex, addr, FUTEX_WAKE, INT_MAX, nullptr, nullptr, 0);
+}
+
+// Waiting flag is raised before ready is rechecked, so that the other end
+// either sees the flag and wakes us up or we see its update and don't sleep
+template <typename F>
+static void Park(uint32_t *seq, uint32_t *waiting, F ready) {
+  Store(waiting, 1u);
+  auto val = Load(seq);
+  if (!ready()) {
+    FutexWait(seq, val);
+  }
+  Store(waiting, 0u);
+}
+
+void ShmRing::Init(char *mem, size_t desc_offset, size_t data_offset,
+                   size_t size) {
+  head = At<uint64_t>(mem, desc_offset + SHM_HEAD_OFFSET);
+  tail = At<uint64_t>(mem, desc_offset + SHM_TAIL_OFFSET);
+  data_seq = At<uint32_t>(mem, desc_offset + SHM_DATA_SEQ_OFFSET);
+  space_seq = At<uint32_t>(mem, desc_offset + SHM_SPACE_SEQ_OFFSET);
+  reader_waiting = At<uint32_t>(mem, desc_offset + SHM_READER_WAITING_OFFSET);
+  writer_waiting = At<uint32_t>(mem, desc_offset + SHM_WRITER_WAITING_OFFSET);
+  data = mem + data_offset;
+  mask = size - 1;
+}
+
+uint64_t ShmRing::Readable() const { return Load(tail) - Load(head); }
+
+uint64_t ShmRing::Writable() const { return mask + 1 - Readable(); }
+
+size_t ShmRing::Read(char *buf, size_t len) {
+  auto count = static_cast<size_t>(std::min<uint64_t>(Readable(), len));
+  if (count == 0) {
+    return 0;
+  }
+
+  auto pos = Load(head);
+  auto offset = static_cast<size_t>(pos & mask);
+  auto first = std::min(count, static_cast<size_t>(mask + 1) - offset);
+  std::memcpy(buf, data + offset, first);
+  std::memcpy(buf + first, data, count - first);
+  Store(head, pos + count);
+
+  __atomic_add_fetch(space_seq, 1, __ATOMIC_SEQ_CST);
+  if (Load(writer_waiting) != 0) {
+    FutexWake(space_seq);
+  }
+  return count;
+}
+
+size_t ShmRing::Write(const char *buf, size_t len) {
+  auto count = static_cast<size_t>(std::min<uint64_t>(Writable(), len));
+  if (count == 0) {
+    return 0;
+  }
+
+  auto pos = Load(tail);
+  auto offset = static_cast<size_t>(pos & mask);
+  auto first = std::min(count, static_cast<size_t>(mask + 1) - offset);
+  std::memcpy(data + offset, buf, first);
+  std::memcpy(data, buf + first, count - first);
+  Store(tail, pos + count);
+
+  // Futex wake is a syscall, skip it unless reader is actually parked
+  __atomic_add_fetch(data_seq, 1, __ATOMIC_SEQ_CST);
+  if (Load(reader_waiting) != 0) {
+    FutexWake(data_seq);
+  }
+  return count;
+}
+
+ShmChannel::~ShmChannel() {
+  if (mem_ != nullptr) {
+    munmap(mem_, mem_size_);
+  }
+}
+
+bool ShmChannel::Attach(const std::string &path,
+                        std::chrono::seconds timeout) {
+  auto fd = open(path.c_str(), O_RDWR);
+  if (fd < 0) {
+    LOG(logError) << "Failed to open shared memory segment: " << RS(path)
+                  << " err: " << strerror(errno) << std::endl;
+    return false;
+  }
+
+  struct stat st;
+  if (fstat(fd, &st) != 0) {
+    LOG(logError) << "Failed to stat shared memory segment: " << RS(path)
+                  << " err: " << strerror(errno) << std::endl;
+    close(fd);
+    return false;
+  }
+
+  auto mem = mmap(nullptr, st.st_size, PROT_READ | PROT_WRITE, MAP_SHARED,
+                  fd, 0);
+  close(fd);
+  if (mem == MAP_FAILED) {
+    LOG(logError) << "Failed to map shared memory segment: " << RS(path)
+                  << " err: " << strerror(errno) << std::endl;
+    return false;
+  }
+  mem_ = static_cast<char *>(mem);
+  mem_size_ = st.st_size;
+
+  auto ring_size = Load(At<uint32_t>(mem_, SHM_RING_SIZE_OFFSET));
+  if (Load(At<uint32_t>(mem_, SHM_MAGIC_OFFSET)) != SHM_SEGMENT_MAGIC ||
+      Load(At<uint32_t>(mem_, SHM_VERSION_OFFSET)) != SHM_SEGMENT_VERSION ||
+      SHM_CONTROL_SIZE + 2 * static_cast<size_t>(ring_size) != mem_size_) {
+    LOG(logError) << "Shared memory segment: " << RS(path)
+                  << " has unexpected layout, size: " << mem_size_
+                  << " ring size: " << ring_size << std::endl;
+    return false;
+  }
+
+  state_ = At<uint32_t>(mem_, SHM_STATE_OFFSET);
+  owner_pid_ = static_cast<int>(Load(At<uint32_t>(mem_, SHM_OWNER_PID_OFFSET)));
+  rx_.Init(mem_, SHM_TO_WORKER_OFFSET, SHM_CONTROL_SIZE, ring_size);
+  tx_.Init(mem_, SHM_FROM_WORKER_OFFSET, SHM_CONTROL_SIZE + ring_size,
+           ring_size);
+
+  auto deadline = std::chrono::steady_clock::now() + timeout;
+  uint32_t expected = sListening;
+  for (;;) {
+    Store(At<uint32_t>(mem_, SHM_ATTACH_PID_OFFSET),
+          static_cast<uint32_t>(getpid()));
+    if (__atomic_compare_exchange_n(state_, &expected, sAttached, false,
+                                    __ATOMIC_SEQ_CST, __ATOMIC_SEQ_CST)) {
+      FutexWake(state_);
+      return true;
+    }
+
+    if (exit_cond_.load() || PeerGone() ||
+        std::chrono::steady_clock::now() > deadline) {
+      LOG(logError) << "Timed out attaching to shared memory segment: "
+                    << RS(path) << " state: " << expected << std::endl;
+      return false;
+    }
+
+    FutexWait(state_, expected);
+    expected = sListening;
+  }
+}
+
+bool ShmChannel::PeerGone() const {
+  return kill(owner_pid_, 0) != 0 && errno == ESRCH;
+}
+
+size_t ShmChannel::Read(char *buf, size_t len) {
+  for (;;) {
+    if (exit_cond_.load()) {
+      return 0;
+    }
+
+    auto count = rx_.Read(buf, len);
+    if (count > 0) {
+      return count;
+    }
+
+    if (Load(state_) != sAttached || PeerGone()) {
+      return 0;
+    }
+
+    Park(rx_.data_seq, rx_.reader_waiting,
+         [this]() { return rx_.Readable() > 0; });
+  }
+}
+
+bool ShmChannel::Write(const char *buf, size_t len) {
+  std::lock_guard<std::mutex> lock(write_mutex_);
+
+  size_t written = 0;
+  while (written < len) {
+    if (exit_cond_.load() || Load(state_) != sAttached) {
+      return false;
+    }
+
+    auto count = tx_.Write(buf + written, len - written);
+    if (count > 0) {
+      written += count;
+      continue;
+    }
+
+    if (PeerGone()) {
+      return false;
+    }
+
+    Park(tx_.space_seq, tx_.writer_waiting,
+         [this]() { return tx_.Writable() > 0; });
+  }
+  return true;
+}
+
+void ShmChannel::Close() {
+  if (state_ == nullptr) {
+    return;
+  }
+
+  uint32_t expected = sAttached;
+  __atomic_compare_exchange_n(state_, &expected, sClosed, false,
+                              __ATOMIC_SEQ_CST, __ATOMIC_SEQ_CST);
+  FutexWake(state_);
+  FutexWake(rx_.data_seq);
+  FutexWake(rx_.space_seq);
+  FutexWake(tx_.data_seq);
+  FutexWake(tx_.space_seq);
+}
+
+#else
+// Producer never picks shm transport on platforms without futexes
+ShmChannel::~ShmChannel() {}
+
+bool ShmChannel::Attach(const std::string &path,
+                        std::chrono::seconds timeout) {
+  LOG(logError) << "Shared memory transport isn't supported on this platform"
+                << std::endl;
+  return false;
+}
+
+bool ShmChannel::PeerGone() const { return true; }
+
+size_t ShmChannel::Read(char *buf, size_t len) { return 0; }
+
+bool ShmChannel::Write(const char *buf, size_t len) { return false; }
+
+void ShmChannel::Close() {}
+#endif
@@ -19,6 +19,7 @@
 #include "crc32.h"
 
 std::atomic<int64_t> timer_context_size_exceeded_counter = {0};
+std::atomic<int64_t> timer_in_past_rejected_counter = {0};
 thread_local std::mt19937_64
     rng(std::random_device{}() +
         std::hash<std::thread::id>()(std::this_thread::get_id()));
@@ -72,6 +73,15 @@ bool Timer::CreateTimerImpl(const v8::FunctionCallbackInfo<v8::Value> &args) {
 
   auto utils = UnwrapData(isolate_)->utils;
   auto v8worker = UnwrapData(isolate_)->v8worker;
+  if (v8worker->reject_timers_in_past_ &&
+      epoch_info.epoch < timer::GetUnixTime()) {
+    js_exception->ThrowEventingError(
+        "Timer can not be created in the past as timer_in_past_policy is "
+        "reject");
+    timer_in_past_rejected_counter++;
+    return false;
+  }
+
   timer::TimerInfo timer_info;
   timer_info.epoch = epoch_info.epoch;
   timer_info.seq_num = v8worker->currently_processed_seqno_;
@@ -88,19 +98,130 @@ bool Timer::CreateTimerImpl(const v8::FunctionCallbackInfo<v8::Value> &args) {
 
   FillTimerPartition(timer_info, v8worker->num_vbuckets_);
 
-  if (timer_info.context.size() > static_cast<unsigned>(timer_context_size)) {
+  if (!FitContext(timer_info)) {
+    return false;
+  }
+  auto err = v8worker->SetTimer(timer_info);
+  if (err != LCB_SUCCESS) {
+    js_exception->ThrowKVError(v8worker->GetTimerLcbHandle(), err);
+    return false;
+  }
+  args.GetReturnValue().Set(v8Str(isolate_, timer_info.reference));
+  return true;
+}
+
+bool Timer::CreateRecurringTimerImpl(
+    const v8::FunctionCallbackInfo<v8::Value> &args) {
+  if (!ValidateRecurringTimerArgs(args)) {
+    return false;
+  }
+
+  v8::HandleScope handle_scope(isolate_);
+
+  auto js_exception = UnwrapData(isolate_)->js_exception;
+  timer::Schedule schedule;
+  if (!ParseSchedule(args[1], schedule)) {
+    return false;
+  }
+
+  auto utils = UnwrapData(isolate_)->utils;
+  auto v8worker = UnwrapData(isolate_)->v8worker;
+  timer::TimerInfo timer_info;
+  timer_info.seq_num = v8worker->currently_processed_seqno_;
+  timer_info.callback = utils->GetFunctionName(args[0]);
+
+  if (args[2]->IsString()) {
+    timer_info.reference = utils->ToCPPString(args[2]);
+  } else {
+    timer_info.reference =
+        std::to_string(rng()) + std::to_string(timer_info.seq_num);
+  }
+
+  auto now = timer::GetUnixTime();
+  if (schedule.interval != 0) {
+    schedule.start = now;
+  }
+  schedule.reference = timer_info.reference;
+
+  std::string err;
+  if (!timer::NextOccurrence(schedule, now, rng, timer_info.epoch, err)) {
+    js_exception->ThrowEventingError(err);
+    return false;
+  }
+
+  // Schedule travels with context, for the series to be rearmed as it fires
+  timer_info.context =
+      timer::WrapSeriesContext(schedule, JSONStringify(isolate_, args[3]));
+
+  FillTimerPartition(timer_info, v8worker->num_vbuckets_);
+
+  if (!FitContext(timer_info)) {
+    return false;
+  }
+  auto lcb_err = v8worker->SetTimer(timer_info);
+  if (lcb_err != LCB_SUCCESS) {
+    js_exception->ThrowKVError(v8worker->GetTimerLcbHandle(), lcb_err);
+    return false;
+  }
+  args.GetReturnValue().Set(v8Str(isolate_, timer_info.reference));
+  return true;
+}
+
+// Context over timer_context_size is rejected, or kept in overflow as per
+// timer_context_overflow of handler
+bool Timer::FitContext(timer::TimerInfo &timer_info) {
+  if (timer_info.context.size() <= static_cast<unsigned>(timer_context_size)) {
+    return true;
+  }
+
+  auto js_exception = UnwrapData(isolate_)->js_exception;
+  auto v8worker = UnwrapData(isolate_)->v8worker;
+  if (v8worker->context_overflow_ == timer::OverflowPolicy::reject) {
     js_exception->ThrowEventingError(
         "The context payload size is more than the configured size:" +
         std::to_string(timer_context_size) + " bytes");
     timer_context_size_exceeded_counter++;
     return false;
   }
-  auto err = v8worker->SetTimer(timer_info);
+
+  auto err = v8worker->PackTimerContext(timer_info);
   if (err != LCB_SUCCESS) {
     js_exception->ThrowKVError(v8worker->GetTimerLcbHandle(), err);
     return false;
   }
-  args.GetReturnValue().Set(v8Str(isolate_, timer_info.reference));
+  return true;
+}
+
+// Schedule is given as {cron: "<expression>"} or {interval: <secs>}, along
+// with an optional jitter in seconds
+bool Timer::ParseSchedule(const v8::Local<v8::Value> &schedule_val,
+                          timer::Schedule &schedule) {
+  auto js_exception = UnwrapData(isolate_)->js_exception;
+  auto spec = nlohmann::json::parse(JSONStringify(isolate_, schedule_val),
+                                    nullptr, false);
+  if (spec.is_discarded() || !spec.is_object()) {
+    js_exception->ThrowEventingError(
+        "Second argument to createRecurringTimer must be a schedule object");
+    return false;
+  }
+
+  try {
+    schedule.cron = spec.value("cron", "");
+    schedule.interval = spec.value("interval", int64_t(0));
+    schedule.jitter = spec.value("jitter", int64_t(0));
+  } catch (const nlohmann::json::type_error &) {
+    js_exception->ThrowEventingError(
+        "Schedule must have cron as a string, interval and jitter as whole "
+        "seconds");
+    return false;
+  }
+
+  auto v8worker = UnwrapData(isolate_)->v8worker;
+  auto err = timer::ValidateSchedule(schedule, v8worker->timer_resolution_);
+  if (!err.empty()) {
+    js_exception->ThrowEventingError(err);
+    return false;
+  }
   return true;
 }
 
@@ -190,6 +311,41 @@ bool Timer::ValidateArgs(const v8::FunctionCallbackInfo<v8::Value> &args) {
   return true;
 }
 
+bool Timer::ValidateRecurringTimerArgs(
+    const v8::FunctionCallbackInfo<v8::Value> &args) {
+  auto js_exception = UnwrapData(isolate_)->js_exception;
+
+  if (args.Length() < 3) {
+    js_exception->ThrowEventingError(
+        "createRecurringTimer needs atleast 3 arguments - callback function, "
+        "schedule, reference");
+    return false;
+  }
+
+  auto utils = UnwrapData(isolate_)->utils;
+  if (!utils->IsFuncGlobal(args[0])) {
+    js_exception->ThrowEventingError(
+        "First argument to createRecurringTimer must be a valid global "
+        "function");
+    return false;
+  }
+
+  if (!args[1]->IsObject()) {
+    js_exception->ThrowEventingError(
+        "Second argument to createRecurringTimer must be a schedule object");
+    return false;
+  }
+
+  if (!args[2]->IsString() && !args[2]->IsNull() && !args[2]->IsUndefined()) {
+    js_exception->ThrowEventingError(
+        "Third argument to createRecurringTimer must be a string (or null to "
+        "generate an ID)");
+    return false;
+  }
+
+  return true;
+}
+
 void Timer::FillTimerPartition(timer::TimerInfo& timer_info, const int32_t& num_vbuckets) {
   auto ref = timer_info.callback + ":" + timer_info.reference;
   uint32_t hash = crc32_8(ref.c_str(), ref.size(), 0 /*crc_in*/);
@@ -220,3 +376,15 @@ void CancelTimer(const v8::FunctionCallbackInfo<v8::Value> &args) {
   ++timer_cancel_counter;
   timer->CancelTimerImpl(args);
 }
+
+void CreateRecurringTimer(const v8::FunctionCallbackInfo<v8::Value> &args) {
+  auto isolate = args.GetIsolate();
+  std::lock_guard<std::mutex> guard(UnwrapData(isolate)->termination_lock_);
+  if (!UnwrapData(isolate)->is_executing_) {
+    return;
+  }
+
+  auto timer = UnwrapData(isolate)->timer;
+  ++timer_create_counter;
+  timer->CreateRecurringTimerImpl(args);
+}
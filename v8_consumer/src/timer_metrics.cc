@@ -0,0 +1,139 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#include "timer_metrics.h"
+#include "lcb_utils.h"
+
+timer::FiringMetrics timer_firing_metrics;
+
+namespace timer {
+namespace {
+constexpr int64_t fire_lag_from = 100;
+constexpr int64_t fire_lag_till = 1000 * 60 * 60;
+constexpr int64_t fire_lag_width = 100;
+
+constexpr int64_t scan_time_from = 100;
+constexpr int64_t scan_time_till = 1000 * 1000 * 10;
+constexpr int64_t scan_time_width = 100;
+} // namespace
+
+void FiringMetrics::RecordFire(int64_t partition, int64_t due,
+                               std::chrono::microseconds scan_time) {
+  auto now = std::chrono::duration_cast<std::chrono::milliseconds>(
+                 std::chrono::system_clock::now().time_since_epoch())
+                 .count();
+
+  std::lock_guard<std::mutex> lock(lock_);
+  auto &metrics = partitions_[partition];
+  if (!metrics.fire_lag) {
+    metrics.fire_lag.reset(
+        new Histogram(fire_lag_from, fire_lag_till, fire_lag_width));
+    metrics.scan_time.reset(
+        new Histogram(scan_time_from, scan_time_till, scan_time_width));
+  }
+  metrics.fire_lag->Add(now - due * 1000);
+  metrics.scan_time->Add(scan_time.count());
+}
+
+void FiringMetrics::AddPending(int64_t partition, int64_t delta) {
+  if (partition < 0) {
+    return;
+  }
+  std::lock_guard<std::mutex> lock(lock_);
+  auto &metrics = partitions_[partition];
+  metrics.unsynced += delta;
+  metrics.removed = false;
+}
+
+std::unordered_map<int64_t, int64_t> FiringMetrics::TakePending() {
+  std::unordered_map<int64_t, int64_t> deltas;
+  std::lock_guard<std::mutex> lock(lock_);
+  for (auto it = partitions_.begin(); it != partitions_.end();) {
+    if (it->second.unsynced != 0) {
+      deltas[it->first] = it->second.unsynced;
+      it->second.unsynced = 0;
+    }
+    // Removed partitions were only kept for their changes
+    if (it->second.removed) {
+      it = partitions_.erase(it);
+    } else {
+      ++it;
+    }
+  }
+  return deltas;
+}
+
+void FiringMetrics::SetPending(int64_t partition, int64_t pending) {
+  std::lock_guard<std::mutex> lock(lock_);
+  auto it = partitions_.find(partition);
+  if (it != partitions_.end() && !it->second.removed) {
+    it->second.pending = pending;
+  }
+}
+
+void FiringMetrics::RemovePartition(int64_t partition) {
+  std::lock_guard<std::mutex> lock(lock_);
+  auto it = partitions_.find(partition);
+  if (it == partitions_.end()) {
+    return;
+  }
+  if (it->second.unsynced == 0) {
+    partitions_.erase(it);
+    return;
+  }
+
+  // Changes not yet added to pending count are left for next scan
+  auto unsynced = it->second.unsynced;
+  it->second = Metrics();
+  it->second.unsynced = unsynced;
+  it->second.removed = true;
+}
+
+void FiringMetrics::AddTo(StatsTable::StatList &group) {
+  std::lock_guard<std::mutex> lock(lock_);
+  for (auto &entry : partitions_) {
+    auto prefix = std::to_string(entry.first);
+    auto &metrics = entry.second;
+    if (metrics.removed) {
+      continue;
+    }
+    if (metrics.pending >= 0) {
+      group.emplace_back(prefix + ":pending",
+                         metrics.pending + metrics.unsynced);
+    }
+    if (metrics.fire_lag) {
+      metrics.fire_lag->Drain(group, prefix + ":fire_lag:");
+      metrics.scan_time->Drain(group, prefix + ":scan_time:");
+    }
+  }
+}
+
+lcb_error_t CountPending(lcb_t instance, const std::string &prefix,
+                         int64_t partition, int64_t delta, int64_t &count,
+                         int max_retry_count) {
+  auto key = prefix + ":tm:" + std::to_string(partition) + ":pd";
+  lcb_CMDCOUNTER cmd = {0};
+  LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
+  cmd.delta = delta;
+  cmd.initial = delta < 0 ? 0 : static_cast<lcb_U64>(delta);
+  cmd.create = 1;
+
+  auto result = RetryLcbCommand(instance, cmd, max_retry_count, LcbGetCounter);
+  if (result.first != LCB_SUCCESS) {
+    return result.first;
+  }
+  if (result.second.rc != LCB_SUCCESS) {
+    return result.second.rc;
+  }
+  count = result.second.counter;
+  return LCB_SUCCESS;
+}
+} // namespace timer
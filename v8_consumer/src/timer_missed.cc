@@ -0,0 +1,154 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#include "timer_missed.h"
+
+#include <algorithm>
+
+std::atomic<int64_t> timer_missed_fired_counter = {0};
+std::atomic<int64_t> timer_missed_coalesced_counter = {0};
+std::atomic<int64_t> timer_missed_deferred_counter = {0};
+std::atomic<int64_t> timer_missed_dropped_counter = {0};
+
+namespace timer {
+namespace {
+bool ParseInt(const std::string &str, int64_t &val) {
+  if (str.empty() || str.size() > 18 ||
+      str.find_first_not_of("0123456789") != std::string::npos) {
+    return false;
+  }
+  val = std::stoll(str);
+  return true;
+}
+} // namespace
+
+MissedPolicy ParseMissedPolicy(const std::string &policy) {
+  if (policy == "latest_only") {
+    return MissedPolicy::latest_only;
+  }
+  if (policy == "rate_limit") {
+    return MissedPolicy::rate_limit;
+  }
+  if (policy == "drop") {
+    return MissedPolicy::drop;
+  }
+  return MissedPolicy::fire_all;
+}
+
+// Alarm keys look like <prefix>:tm:<partition>:al:<due>:<seq>, prefix may
+// have colons of its own
+bool ParseAlarmKey(const std::string &alarm_key, int64_t &partition,
+                   int64_t &due) {
+  auto alarm = alarm_key.rfind(":al:");
+  if (alarm == std::string::npos) {
+    return false;
+  }
+  auto root = alarm_key.rfind(":tm:", alarm);
+  if (root == std::string::npos) {
+    return false;
+  }
+
+  auto due_start = alarm + 4;
+  auto due_end = alarm_key.find(':', due_start);
+  if (due_end == std::string::npos) {
+    return false;
+  }
+
+  return ParseInt(alarm_key.substr(root + 4, alarm - root - 4), partition) &&
+         ParseInt(alarm_key.substr(due_start, due_end - due_start), due);
+}
+
+MissedVerdict MissedTimers::Admit(const std::string &alarm_key,
+                                  const std::string &context_key, int64_t now,
+                                  std::string &superseded) {
+  int64_t partition = 0, due = 0;
+  if (!ParseAlarmKey(alarm_key, partition, due)) {
+    return MissedVerdict::fire;
+  }
+  // Iterator takes timers of a partition in due order, so that nothing after a
+  // deferred timer may fire before it
+  if (deferred_.count(partition) > 0) {
+    return MissedVerdict::defer;
+  }
+  if (now - due <= threshold_) {
+    return MissedVerdict::fire;
+  }
+
+  switch (policy_) {
+  case MissedPolicy::latest_only: {
+    auto &held = held_[context_key];
+    if (!held.alarm_key.empty()) {
+      ++timer_missed_coalesced_counter;
+      if (held.due >= due) {
+        return MissedVerdict::drop;
+      }
+    }
+    superseded = held.alarm_key;
+    held.due = due;
+    held.alarm_key = alarm_key;
+    return MissedVerdict::hold;
+  }
+
+  case MissedPolicy::rate_limit:
+    if (!Take(partition)) {
+      deferred_.insert(partition);
+      ++timer_missed_deferred_counter;
+      return MissedVerdict::defer;
+    }
+    break;
+
+  case MissedPolicy::drop:
+    ++timer_missed_dropped_counter;
+    return MissedVerdict::drop;
+
+  case MissedPolicy::fire_all:
+    break;
+  }
+
+  ++timer_missed_fired_counter;
+  return MissedVerdict::fire;
+}
+
+std::vector<std::string> MissedTimers::Release() {
+  std::vector<Held> held;
+  held.reserve(held_.size());
+  for (auto &entry : held_) {
+    held.emplace_back(std::move(entry.second));
+  }
+  held_.clear();
+
+  std::sort(held.begin(), held.end(), [](const Held &a, const Held &b) {
+    return a.due != b.due ? a.due < b.due : a.alarm_key < b.alarm_key;
+  });
+  std::vector<std::string> alarm_keys;
+  alarm_keys.reserve(held.size());
+  for (auto &entry : held) {
+    alarm_keys.emplace_back(std::move(entry.alarm_key));
+  }
+  timer_missed_fired_counter += static_cast<int64_t>(alarm_keys.size());
+  return alarm_keys;
+}
+
+bool MissedTimers::Take(int64_t partition) {
+  auto &limiter = limiters_[partition];
+  auto now = std::chrono::steady_clock::now();
+  if (now - limiter.refill >= std::chrono::seconds(1)) {
+    limiter.tokens = rate_;
+    limiter.refill = now;
+  }
+
+  if (limiter.tokens <= 0) {
+    return false;
+  }
+  --limiter.tokens;
+  return true;
+}
+} // namespace timer
@@ -0,0 +1,247 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#include <nlohmann/json.hpp>
+#include <stdexcept>
+#include <zlib.h>
+
+#include "lcb_utils.h"
+#include "timer_overflow.h"
+#include "utils.h"
+
+std::atomic<int64_t> timer_context_compressed_counter = {0};
+std::atomic<int64_t> timer_context_chunked_counter = {0};
+std::atomic<int64_t> timer_context_chunk_missing_counter = {0};
+
+namespace timer {
+namespace {
+// Common flags of binary documents, so that timers package reads chunks as
+// bytes
+constexpr uint32_t binary_flags = 0x3000000;
+
+std::string ChunkKey(const std::string &chunk_ref, int64_t index) {
+  return chunk_ref + ":" + std::to_string(index);
+}
+} // namespace
+
+OverflowPolicy ParseOverflowPolicy(const std::string &policy) {
+  if (policy == "compress") {
+    return OverflowPolicy::compress;
+  }
+  if (policy == "chunk") {
+    return OverflowPolicy::chunk;
+  }
+  return OverflowPolicy::reject;
+}
+
+std::string MaybeCompress(const std::string &data) {
+  z_stream stream = {};
+  if (deflateInit2(&stream, Z_BEST_COMPRESSION, Z_DEFLATED, -MAX_WBITS, 8,
+                   Z_DEFAULT_STRATEGY) != Z_OK) {
+    return data;
+  }
+
+  std::string compressed(2 + deflateBound(&stream, data.size()), '\0');
+  stream.next_in =
+      reinterpret_cast<Bytef *>(const_cast<char *>(data.data()));
+  stream.avail_in = static_cast<uInt>(data.size());
+  stream.next_out = reinterpret_cast<Bytef *>(&compressed[2]);
+  stream.avail_out = static_cast<uInt>(compressed.size() - 2);
+
+  auto rc = deflate(&stream, Z_FINISH);
+  deflateEnd(&stream);
+  if (rc != Z_STREAM_END || stream.total_out >= data.size()) {
+    return data;
+  }
+
+  compressed.resize(2 + stream.total_out);
+  return compressed;
+}
+
+bool MaybeDecompress(const std::string &packed, std::string &data) {
+  if (packed.empty() || packed[0] != '\0') {
+    data = packed;
+    return true;
+  }
+  if (packed.size() < 2) {
+    return false;
+  }
+
+  z_stream stream = {};
+  if (inflateInit2(&stream, -MAX_WBITS) != Z_OK) {
+    return false;
+  }
+  stream.next_in =
+      reinterpret_cast<Bytef *>(const_cast<char *>(packed.data() + 2));
+  stream.avail_in = static_cast<uInt>(packed.size() - 2);
+
+  data.clear();
+  char buffer[16384];
+  int rc;
+  do {
+    stream.next_out = reinterpret_cast<Bytef *>(buffer);
+    stream.avail_out = sizeof(buffer);
+    rc = inflate(&stream, Z_NO_FLUSH);
+    if (rc != Z_OK && rc != Z_STREAM_END) {
+      break;
+    }
+    data.append(buffer, sizeof(buffer) - stream.avail_out);
+  } while (rc == Z_OK);
+
+  inflateEnd(&stream);
+  return rc == Z_STREAM_END;
+}
+
+std::string WrapOverflowContext(const Overflow &overflow) {
+  nlohmann::json spec = nlohmann::json::object();
+  if (!overflow.packed.empty()) {
+    spec["pck"] = base64Encode(overflow.packed);
+  }
+  if (!overflow.chunk_ref.empty()) {
+    spec["ref"] = overflow.chunk_ref;
+  }
+  if (overflow.chunks != 0) {
+    spec["chk"] = overflow.chunks;
+  }
+  spec["sz"] = overflow.size;
+
+  nlohmann::json context;
+  context[overflow_key] = spec;
+  return context.dump();
+}
+
+bool UnwrapOverflowContext(const std::string &context, Overflow &overflow) {
+  // Cheap check ahead of parsing context of every timer fired
+  if (context.find(overflow_key) == std::string::npos) {
+    return false;
+  }
+
+  auto parsed = nlohmann::json::parse(context, nullptr, false);
+  if (parsed.is_discarded() || !parsed.is_object() || parsed.size() != 1) {
+    return false;
+  }
+  auto spec = parsed.find(overflow_key);
+  if (spec == parsed.end() || !spec->is_object()) {
+    return false;
+  }
+
+  try {
+    overflow.packed = base64Decode(spec->value("pck", ""));
+    overflow.chunk_ref = spec->value("ref", "");
+    overflow.chunks = spec->value("chk", int64_t(0));
+    overflow.size = spec->value("sz", int64_t(0));
+  } catch (const nlohmann::json::type_error &) {
+    return false;
+  } catch (const std::invalid_argument &) {
+    return false;
+  }
+  return overflow.chunks > 0 ? !overflow.chunk_ref.empty()
+                             : !overflow.packed.empty();
+}
+
+lcb_error_t PackContext(lcb_t instance, OverflowPolicy policy, int64_t size,
+                        const std::string &chunk_ref, std::string &context,
+                        int max_retry_count) {
+  Overflow overflow;
+  overflow.size = static_cast<int64_t>(context.size());
+
+  auto data = context;
+  if (policy == OverflowPolicy::compress) {
+    data = MaybeCompress(context);
+    ++timer_context_compressed_counter;
+    if (static_cast<int64_t>(data.size()) <= size) {
+      overflow.packed = data;
+      context = WrapOverflowContext(overflow);
+      return LCB_SUCCESS;
+    }
+  }
+
+  overflow.chunk_ref = chunk_ref;
+  for (std::size_t start = 0; start < data.size();
+       start += static_cast<std::size_t>(chunk_size)) {
+    auto key = ChunkKey(chunk_ref, overflow.chunks);
+    auto chunk = data.substr(start, static_cast<std::size_t>(chunk_size));
+
+    lcb_CMDSTORE cmd = {0};
+    LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
+    LCB_CMD_SET_VALUE(&cmd, chunk.c_str(), chunk.length());
+    cmd.operation = LCB_SET;
+    cmd.flags = binary_flags;
+
+    auto result = RetryLcbCommand(instance, cmd, max_retry_count, LcbSet);
+    if (result.first != LCB_SUCCESS) {
+      return result.first;
+    }
+    if (result.second.rc != LCB_SUCCESS) {
+      return result.second.rc;
+    }
+    ++overflow.chunks;
+  }
+
+  ++timer_context_chunked_counter;
+  context = WrapOverflowContext(overflow);
+  return LCB_SUCCESS;
+}
+
+lcb_error_t UnpackContext(lcb_t instance, const Overflow &overflow,
+                          std::string &context, bool &found,
+                          int max_retry_count) {
+  found = true;
+  auto data = overflow.packed;
+  if (overflow.chunks > 0) {
+    data.clear();
+    data.reserve(static_cast<std::size_t>(overflow.size));
+    for (int64_t i = 0; i < overflow.chunks; ++i) {
+      auto key = ChunkKey(overflow.chunk_ref, i);
+      lcb_CMDGET cmd = {0};
+      LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
+
+      auto result = RetryLcbCommand(instance, cmd, max_retry_count, LcbGet);
+      if (result.first != LCB_SUCCESS) {
+        return result.first;
+      }
+      if (result.second.rc == LCB_KEY_ENOENT) {
+        ++timer_context_chunk_missing_counter;
+        found = false;
+        return LCB_SUCCESS;
+      }
+      if (result.second.rc != LCB_SUCCESS) {
+        return result.second.rc;
+      }
+      data += result.second.value;
+    }
+  }
+
+  if (!MaybeDecompress(data, context)) {
+    return LCB_EINVAL;
+  }
+  return LCB_SUCCESS;
+}
+
+lcb_error_t RemoveChunks(lcb_t instance, const Overflow &overflow,
+                         int max_retry_count) {
+  for (int64_t i = 0; i < overflow.chunks; ++i) {
+    auto key = ChunkKey(overflow.chunk_ref, i);
+    lcb_CMDREMOVE cmd = {0};
+    LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
+
+    auto result = RetryLcbCommand(instance, cmd, max_retry_count, LcbDelete);
+    if (result.first != LCB_SUCCESS) {
+      return result.first;
+    }
+    if (result.second.rc != LCB_SUCCESS &&
+        result.second.rc != LCB_KEY_ENOENT) {
+      return result.second.rc;
+    }
+  }
+  return LCB_SUCCESS;
+}
+} // namespace timer
@@ -0,0 +1,324 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#include <ctime>
+#include <map>
+#include <sstream>
+#include <vector>
+
+#include "timer_schedule.h"
+
+namespace timer {
+namespace {
+const std::map<std::string, std::string> cron_descriptors = {
+    {"@yearly", "0 0 1 1 *"},   {"@annually", "0 0 1 1 *"},
+    {"@monthly", "0 0 1 * *"},  {"@weekly", "0 0 * * 0"},
+    {"@daily", "0 0 * * *"},    {"@midnight", "0 0 * * *"},
+    {"@hourly", "0 * * * *"}};
+
+struct CronFieldBounds {
+  const char *name;
+  unsigned min, max;
+};
+
+// Bounds of cron fields, in the order they appear in expression
+const CronFieldBounds cron_field_bounds[] = {{"minute", 0, 59},
+                                             {"hour", 0, 23},
+                                             {"day of month", 1, 31},
+                                             {"month", 1, 12},
+                                             {"day of week", 0, 7}};
+
+bool ParseNumber(const std::string &str, unsigned &val) {
+  if (str.empty() || str.size() > 3 ||
+      str.find_first_not_of("0123456789") != std::string::npos) {
+    return false;
+  }
+  val = std::stoul(str);
+  return true;
+}
+
+// Handles comma separated lists of *, n, n-m along with an optional /step on
+// each
+bool ParseCronField(const std::string &field, const CronFieldBounds &bounds,
+                    uint64_t &bits, std::string &err) {
+  bits = 0;
+  std::stringstream parts(field);
+  std::string part;
+
+  while (std::getline(parts, part, ',')) {
+    auto range_spec = part;
+    unsigned step = 1;
+    auto idx = part.find('/');
+    if (idx != std::string::npos) {
+      range_spec = part.substr(0, idx);
+      if (!ParseNumber(part.substr(idx + 1), step) || step == 0) {
+        err = "invalid step in \"" + part + "\"";
+        return false;
+      }
+    }
+
+    auto lo = bounds.min, hi = bounds.max;
+    if (range_spec != "*") {
+      auto dash = range_spec.find('-');
+      if (!ParseNumber(range_spec.substr(0, dash), lo)) {
+        err = "invalid value in \"" + part + "\"";
+        return false;
+      }
+      hi = lo;
+
+      if (dash != std::string::npos) {
+        if (!ParseNumber(range_spec.substr(dash + 1), hi)) {
+          err = "invalid range in \"" + part + "\"";
+          return false;
+        }
+      } else if (step > 1) {
+        hi = bounds.max;
+      }
+    }
+
+    if (lo < bounds.min || hi > bounds.max || lo > hi) {
+      err = "\"" + part + "\" is out of range " + std::to_string(bounds.min) +
+            "-" + std::to_string(bounds.max);
+      return false;
+    }
+
+    for (auto val = lo; val <= hi; val += step) {
+      bits |= uint64_t(1) << val;
+    }
+  }
+
+  return true;
+}
+
+bool DayMatches(const CronExpr &expr, const struct tm &tm) {
+  bool dom_match = expr.dom & (uint64_t(1) << tm.tm_mday);
+  bool dow_match = expr.dow & (uint64_t(1) << tm.tm_wday);
+
+  // As in cron, restricting both day fields matches days satisfying either
+  if (expr.dom_star || expr.dow_star) {
+    return dom_match && dow_match;
+  }
+  return dom_match || dow_match;
+}
+
+// Finds first minute strictly after now matching expression
+bool NextCron(const CronExpr &expr, int64_t now, int64_t &next) {
+  time_t t = (now / 60) * 60 + 60;
+  struct tm tm;
+  gmtime_r(&t, &tm);
+  tm.tm_year += 5;
+  auto limit = timegm(&tm);
+
+  while (t < limit) {
+    gmtime_r(&t, &tm);
+    if (!(expr.month & (uint64_t(1) << (tm.tm_mon + 1)))) {
+      tm.tm_mon += 1;
+      tm.tm_mday = 1;
+      tm.tm_hour = tm.tm_min = tm.tm_sec = 0;
+      t = timegm(&tm);
+      continue;
+    }
+    if (!DayMatches(expr, tm)) {
+      tm.tm_mday += 1;
+      tm.tm_hour = tm.tm_min = tm.tm_sec = 0;
+      t = timegm(&tm);
+      continue;
+    }
+    if (!(expr.hour & (uint64_t(1) << tm.tm_hour))) {
+      t = t - t % 3600 + 3600;
+      continue;
+    }
+    if (!(expr.minute & (uint64_t(1) << tm.tm_min))) {
+      t += 60;
+      continue;
+    }
+    next = t;
+    return true;
+  }
+
+  return false;
+}
+
+nlohmann::json ScheduleToJSON(const Schedule &schedule) {
+  nlohmann::json spec = nlohmann::json::object();
+  if (!schedule.reference.empty()) {
+    spec["ref"] = schedule.reference;
+  }
+  if (!schedule.cron.empty()) {
+    spec["cron"] = schedule.cron;
+  }
+  if (schedule.interval != 0) {
+    spec["ivl"] = schedule.interval;
+  }
+  if (schedule.jitter != 0) {
+    spec["jit"] = schedule.jitter;
+  }
+  if (schedule.start != 0) {
+    spec["sta"] = schedule.start;
+  }
+  return spec;
+}
+
+bool ScheduleFromJSON(const nlohmann::json &spec, Schedule &schedule) {
+  if (!spec.is_object()) {
+    return false;
+  }
+  try {
+    schedule.reference = spec.value("ref", "");
+    schedule.cron = spec.value("cron", "");
+    schedule.interval = spec.value("ivl", int64_t(0));
+    schedule.jitter = spec.value("jit", int64_t(0));
+    schedule.start = spec.value("sta", int64_t(0));
+  } catch (const nlohmann::json::type_error &) {
+    return false;
+  }
+  // Interval was checked against resolution of store when series was created
+  return !schedule.reference.empty() && ValidateSchedule(schedule, 1).empty();
+}
+} // namespace
+
+bool ParseCron(const std::string &cron, CronExpr &expr, std::string &err) {
+  std::string spec = cron;
+  spec.erase(0, spec.find_first_not_of(" \t"));
+  spec.erase(spec.find_last_not_of(" \t") + 1);
+
+  auto descriptor = cron_descriptors.find(spec);
+  if (descriptor != cron_descriptors.end()) {
+    spec = descriptor->second;
+  }
+
+  std::vector<std::string> fields;
+  std::stringstream stream(spec);
+  std::string field;
+  while (stream >> field) {
+    fields.push_back(field);
+  }
+
+  const auto num_fields =
+      sizeof(cron_field_bounds) / sizeof(cron_field_bounds[0]);
+  if (fields.size() != num_fields) {
+    err = "cron \"" + spec + "\" must have " + std::to_string(num_fields) +
+          " fields, found " + std::to_string(fields.size());
+    return false;
+  }
+
+  uint64_t bits[num_fields];
+  for (std::size_t i = 0; i < num_fields; ++i) {
+    std::string field_err;
+    if (!ParseCronField(fields[i], cron_field_bounds[i], bits[i], field_err)) {
+      err = "cron \"" + spec + "\" " + cron_field_bounds[i].name + ": " +
+            field_err;
+      return false;
+    }
+  }
+
+  // Both 0 and 7 stand for Sunday
+  if (bits[4] & (uint64_t(1) << 7)) {
+    bits[4] |= 1;
+  }
+
+  expr.minute = bits[0];
+  expr.hour = bits[1];
+  expr.dom = bits[2];
+  expr.month = bits[3];
+  expr.dow = bits[4];
+  expr.dom_star = fields[2] == "*";
+  expr.dow_star = fields[4] == "*";
+  return true;
+}
+
+std::string ValidateSchedule(const Schedule &schedule, int64_t resolution) {
+  if (!schedule.cron.empty() && schedule.interval != 0) {
+    return "schedule can't have both cron and interval";
+  }
+  if (schedule.cron.empty() && schedule.interval == 0) {
+    return "schedule needs either cron or interval";
+  }
+  if (schedule.interval != 0 && schedule.interval < resolution) {
+    return "interval " + std::to_string(schedule.interval) +
+           "s is shorter than timer resolution " + std::to_string(resolution) +
+           "s";
+  }
+  if (schedule.jitter < 0) {
+    return "jitter " + std::to_string(schedule.jitter) +
+           "s can't be negative";
+  }
+
+  if (!schedule.cron.empty()) {
+    CronExpr expr;
+    std::string err;
+    if (!ParseCron(schedule.cron, expr, err)) {
+      return err;
+    }
+  }
+  return "";
+}
+
+bool NextOccurrence(const Schedule &schedule, int64_t now,
+                    std::mt19937_64 &gen, int64_t &due, std::string &err) {
+  int64_t next = 0;
+
+  if (!schedule.cron.empty()) {
+    CronExpr expr;
+    if (!ParseCron(schedule.cron, expr, err)) {
+      return false;
+    }
+    if (!NextCron(expr, now, next)) {
+      err = "cron " + schedule.cron + " has no occurrence in the next 5 years";
+      return false;
+    }
+  } else {
+    next = schedule.start + schedule.interval;
+    if (now >= next) {
+      next += ((now - next) / schedule.interval + 1) * schedule.interval;
+    }
+  }
+
+  if (schedule.jitter > 0) {
+    std::uniform_int_distribution<int64_t> jitter(0, schedule.jitter);
+    next += jitter(gen);
+  }
+  due = next;
+  return true;
+}
+
+std::string WrapSeriesContext(const Schedule &schedule,
+                              const std::string &context) {
+  nlohmann::json series_context;
+  series_context[series_key] = ScheduleToJSON(schedule);
+  if (context != "undefined") {
+    series_context["context"] = nlohmann::json::parse(context);
+  }
+  return series_context.dump();
+}
+
+bool UnwrapSeriesContext(const std::string &series_context,
+                         Schedule &schedule, std::string &context) {
+  // Cheap check ahead of parsing context of every timer fired
+  if (series_context.find(series_key) == std::string::npos) {
+    return false;
+  }
+
+  auto parsed = nlohmann::json::parse(series_context, nullptr, false);
+  if (parsed.is_discarded() || !parsed.is_object() || parsed.size() > 2 ||
+      (parsed.size() == 2 && parsed.find("context") == parsed.end())) {
+    return false;
+  }
+  auto spec = parsed.find(series_key);
+  if (spec == parsed.end() || !ScheduleFromJSON(*spec, schedule)) {
+    return false;
+  }
+
+  auto inner = parsed.find("context");
+  context = inner == parsed.end() ? "undefined" : inner->dump();
+  return true;
+}
+} // namespace timer
@@ -9,6 +9,7 @@
 // or implied. See the License for the specific language governing
 // permissions and limitations under the License.
 
+#include <cstring>
 #include <mutex>
 #include <nlohmann/json.hpp>
 #include <string>
@@ -51,6 +52,8 @@ std::atomic<int64_t> filtered_dcp_mutation_counter = {0};
 std::atomic<int64_t> timer_msg_counter = {0};
 std::atomic<int64_t> timer_create_counter = {0};
 std::atomic<int64_t> timer_cancel_counter = {0};
+std::atomic<int64_t> timer_series_rearm_counter = {0};
+std::atomic<int64_t> timer_series_rearm_failure = {0};
 
 std::atomic<int64_t> enqueued_dcp_delete_msg_counter = {0};
 std::atomic<int64_t> enqueued_dcp_mutation_msg_counter = {0};
@@ -70,6 +73,8 @@ v8::Local<v8::ObjectTemplate> V8Worker::NewGlobalObj() const {
               v8::FunctionTemplate::New(isolate_, CreateTimer));
   global->Set(v8::String::NewFromUtf8(isolate_, "cancelTimer"),
               v8::FunctionTemplate::New(isolate_, CancelTimer));
+  global->Set(v8::String::NewFromUtf8(isolate_, "createRecurringTimer"),
+              v8::FunctionTemplate::New(isolate_, CreateRecurringTimer));
   global->Set(v8::String::NewFromUtf8(isolate_, "crc64"),
               v8::FunctionTemplate::New(isolate_, Crc64Function));
   global->Set(v8::String::NewFromUtf8(isolate_, "N1QL"),
@@ -183,12 +188,19 @@ V8Worker::V8Worker(v8::Platform *platform, handler_config_t *h_config,
       exception_type_names_(
           {"KVError", "N1QLError", "EventingError", "CurlError"}),
       handler_headers_(h_config->handler_headers),
-      handler_footers_(h_config->handler_footers) {
+      handler_footers_(h_config->handler_footers),
+      missed_timers_(timer::ParseMissedPolicy(h_config->missed_timer_policy),
+                     h_config->missed_timer_threshold,
+                     h_config->missed_timer_rate) {
   auto config = ParseDeployment(h_config->dep_cfg.c_str());
   cb_source_bucket_.assign(config->source_bucket);
   std::ostringstream oss;
   oss << "\"" << function_id << "-" << function_instance_id << "\"";
   function_instance_id_.assign(oss.str());
+  idempotency_keys_ = h_config->idempotency_keys;
+  reject_timers_in_past_ = h_config->reject_timers_in_past;
+  context_overflow_ =
+      timer::ParseOverflowPolicy(h_config->timer_context_overflow);
   thread_exit_cond_.store(false);
   stop_timer_scan_.store(false);
   scan_timer_.store(false);
@@ -230,6 +242,9 @@ V8Worker::V8Worker(v8::Platform *platform, handler_config_t *h_config,
   max_task_duration_ = SECS_TO_NS * h_config->execution_timeout;
 
   timer_context_size = h_config->timer_context_size;
+  timer_resolution_ = h_config->timer_resolution > 0
+                          ? h_config->timer_resolution
+                          : timer::resolution;
 
   LOG(logInfo) << "Initialised V8Worker handle, app_name: "
                << h_config->app_name
@@ -241,6 +256,11 @@ V8Worker::V8Worker(v8::Platform *platform, handler_config_t *h_config,
                << " n1ql_consistency: " << h_config->n1ql_consistency
                << " execution_timeout: " << h_config->execution_timeout
                << " timer_context_size: " << h_config->timer_context_size
+               << " timer_context_overflow: "
+               << h_config->timer_context_overflow
+               << " reject_timers_in_past: " << h_config->reject_timers_in_past
+               << " timer_resolution: " << timer_resolution_
+               << " missed_timer_policy: " << h_config->missed_timer_policy
                << " ns_server_port: " << ns_server_port_
                << " language compatibility: " << h_config->lang_compat
                << " version: " << EventingVer()
@@ -252,9 +272,10 @@ V8Worker::V8Worker(v8::Platform *platform, handler_config_t *h_config,
 
   if (h_config->using_timer) {
     std::vector<int64_t> partitions;
-    auto prefix = user_prefix + "::" + function_id;
-    timer_store_ = new timer::TimerStore(isolate_, prefix, partitions,
-                                         config->metadata_bucket, num_vbuckets_);
+    timer_prefix_ = user_prefix + "::" + function_id;
+    timer_store_ = new timer::TimerStore(isolate_, timer_prefix_, partitions,
+                                         config->metadata_bucket, num_vbuckets_,
+                                         timer_resolution_);
   }
   delete config;
   this->worker_queue_ = new BlockingDeque<std::unique_ptr<WorkerMessage>>();
@@ -464,16 +485,7 @@ void V8Worker::RouteMessage() {
     case eInternal:
       switch (msg->header.opcode) {
       case oScanTimer: {
-        auto iter = timer_store_->GetIterator();
-        timer::TimerEvent evt;
-        while (!stop_timer_scan_.load() && iter.GetNext(evt)) {
-          ++timer_msg_counter;
-          this->SendTimer(evt.callback, evt.context);
-          timer_store_->DeleteTimer(evt);
-        }
-        if (stop_timer_scan_.load()) {
-          timer_store_->SyncSpan();
-        }
+        ScanTimers();
         scan_timer_.store(false);
         break;
       }
@@ -487,6 +499,9 @@ void V8Worker::RouteMessage() {
         run_gc_.store(false);
         break;
       }
+      case oVbDrainBarrier:
+        HandleVbDrainBarrier(msg);
+        break;
       default:
         LOG(logError) << "Received invalid internal opcode" << std::endl;
         break;
@@ -523,6 +538,14 @@ void V8Worker::UpdateSeqNumLocked(const int vb, const uint64_t seq_num) {
   processed_bucketops_[vb] = seq_num;
 }
 
+// With idempotency keys, seq no is acked only once handler has run to
+// completion, so that a restart never skips an event whose side effects
+// weren't all made
+void V8Worker::CommitSeqNum(const int vb, const uint64_t seq_num) {
+  std::lock_guard<std::mutex> guard(bucketops_lock_);
+  UpdateSeqNumLocked(vb, seq_num);
+}
+
 void V8Worker::HandleDeleteEvent(const std::unique_ptr<WorkerMessage> &msg) {
 
   ++dcp_delete_msg_counter;
@@ -537,12 +560,22 @@ void V8Worker::HandleDeleteEvent(const std::unique_ptr<WorkerMessage> &msg) {
     if (IsFilteredEventLocked(vb, seq_num)) {
       return;
     }
-    UpdateSeqNumLocked(vb, seq_num);
+    if (!idempotency_keys_) {
+      UpdateSeqNumLocked(vb, seq_num);
+    }
   }
 
   const auto options = flatbuf::payload::GetPayload(
       static_cast<const void *>(msg->payload.payload.c_str()));
+  if (!idempotency_keys_) {
+    SendDelete(options->value()->str(), msg->header.metadata);
+    return;
+  }
+
+  idempotency_key_ = ParseIdempotencyKey(msg->header.metadata);
   SendDelete(options->value()->str(), msg->header.metadata);
+  idempotency_key_.clear();
+  CommitSeqNum(vb, seq_num);
 }
 
 void V8Worker::HandleMutationEvent(const std::unique_ptr<WorkerMessage> &msg) {
@@ -559,12 +592,22 @@ void V8Worker::HandleMutationEvent(const std::unique_ptr<WorkerMessage> &msg) {
     if (IsFilteredEventLocked(vb, seq_num)) {
       return;
     }
-    UpdateSeqNumLocked(vb, seq_num);
+    if (!idempotency_keys_) {
+      UpdateSeqNumLocked(vb, seq_num);
+    }
   }
 
   const auto doc = flatbuf::payload::GetPayload(
       static_cast<const void *>(msg->payload.payload.c_str()));
+  if (!idempotency_keys_) {
+    SendUpdate(doc->value()->str(), msg->header.metadata);
+    return;
+  }
+
+  idempotency_key_ = ParseIdempotencyKey(msg->header.metadata);
   SendUpdate(doc->value()->str(), msg->header.metadata);
+  idempotency_key_.clear();
+  CommitSeqNum(vb, seq_num);
 }
 
 std::tuple<int, uint64_t, bool>
@@ -887,6 +930,38 @@ void V8Worker::PushBack(std::unique_ptr<WorkerMessage> worker_msg) {
   worker_queue_->PushBack(std::move(worker_msg));
 }
 
+void V8Worker::PushBackPriority(std::unique_ptr<WorkerMessage> worker_msg) {
+  LOG(logTrace) << "Inserting priority event: "
+                << static_cast<int16_t>(worker_msg->header.event) << " opcode: "
+                << static_cast<int16_t>(worker_msg->header.opcode)
+                << " partition: " << worker_msg->header.partition
+                << " metadata: " << RU(worker_msg->header.metadata)
+                << std::endl;
+
+  const auto key_of = [](const std::unique_ptr<WorkerMessage> &msg) {
+    return flatbuf::payload::GetPayload(
+               static_cast<const void *>(msg->payload.payload.c_str()))
+        ->key();
+  };
+
+  const auto key = key_of(worker_msg);
+  if (key == nullptr) {
+    worker_queue_->PushBack(std::move(worker_msg));
+    return;
+  }
+
+  const auto same_key = [&key_of,
+                         key](const std::unique_ptr<WorkerMessage> &msg) {
+    if (getEvent(msg->header.event) != eDCP) {
+      return false;
+    }
+    const auto other_key = key_of(msg);
+    return other_key != nullptr && other_key->size() == key->size() &&
+           std::memcmp(other_key->c_str(), key->c_str(), key->size()) == 0;
+  };
+  worker_queue_->PushBackPriority(std::move(worker_msg), same_key);
+}
+
 CompilationInfo V8Worker::CompileHandler(std::string area_name, std::string handler) {
   v8::Locker locker(isolate_);
   v8::Isolate::Scope isolate_scope(isolate_);
@@ -963,31 +1038,112 @@ std::string V8Worker::Compile(std::string handler) {
   return CompileInfoToString(info);
 }
 
-void V8Worker::GetBucketOpsMessages(std::vector<uv_buf_t> &messages) {
+void V8Worker::GetBucketOpsMessages(std::vector<uv_buf_t> &messages,
+                                    bool batch_ack) {
+  std::vector<std::pair<int, uint64_t>> vb_seq_nos;
   for (int vb = 0; vb < num_vbuckets_; ++vb) {
     auto seq = vb_seq_[vb].get()->load(std::memory_order_seq_cst);
     if (seq > 0) {
-      std::string seq_no = std::to_string(vb) + "::" + std::to_string(seq);
-      auto curr_messages =
-          BuildResponse(seq_no, mBucket_Ops_Response, checkpointResponse);
-      for (auto &msg : curr_messages) {
-        messages.push_back(msg);
+      if (batch_ack) {
+        vb_seq_nos.emplace_back(vb, seq);
+      } else {
+        std::string seq_no = std::to_string(vb) + "::" + std::to_string(seq);
+        auto curr_messages =
+            BuildResponse(seq_no, mBucket_Ops_Response, checkpointResponse);
+        for (auto &msg : curr_messages) {
+          messages.push_back(msg);
+        }
       }
       // Reset the seq no of checkpointed vb to 0
       vb_seq_[vb].get()->compare_exchange_strong(seq, 0);
     }
   }
+
+  // Highest seq no of all vbuckets goes out as a single ack
+  if (!vb_seq_nos.empty()) {
+    auto curr_messages = BuildBatchCheckpointResponse(vb_seq_nos);
+    for (auto &msg : curr_messages) {
+      messages.push_back(msg);
+    }
+  }
+
+  std::vector<DrainedVb> drained_vbs;
+  {
+    std::lock_guard<std::mutex> guard(bucketops_lock_);
+    drained_vbs.swap(drained_vbs_);
+  }
+
+  for (const auto &drained : drained_vbs) {
+    std::ostringstream filter_ack;
+    filter_ack << R"({"vb":)" << drained.vb << R"(, "seq":)" << drained.seq_no
+               << R"(, "skip_ack":)" << drained.skip_ack << "}";
+    auto curr_messages =
+        BuildResponse(filter_ack.str(), mFilterAck, oVbFilter);
+    for (auto &msg : curr_messages) {
+      messages.push_back(msg);
+    }
+  }
+}
+
+// Every event of vb queued up ahead of barrier has been run or filtered out by
+// now, so seq no processed for it is final
+void V8Worker::HandleVbDrainBarrier(const std::unique_ptr<WorkerMessage> &msg) {
+  int vb_no = 0, skip_ack = 0;
+  uint64_t seq_no = 0;
+  if (kSuccess != ParseMetadataWithAck(msg->header.metadata, vb_no, seq_no,
+                                       skip_ack, true)) {
+    LOG(logError) << "Failed to parse vb drain barrier metadata" << std::endl;
+    return;
+  }
+
+  std::lock_guard<std::mutex> guard(bucketops_lock_);
+  seq_no = GetBucketopsSeqno(vb_no);
+  vbfilter_map_[vb_no].clear();
+  drained_vbs_.push_back({vb_no, seq_no, skip_ack});
+  LOG(logInfo) << "vb: " << vb_no << " seqNo: " << seq_no
+               << " drained, filter ack to be sent to Go" << std::endl;
+}
+
+bool V8Worker::IsVbDrainRequested(const std::string &metadata_str) const {
+  auto metadata = nlohmann::json::parse(metadata_str, nullptr, false);
+  if (metadata.is_discarded()) {
+    return false;
+  }
+  return metadata.value("drain", 0) != 0;
 }
 
 std::vector<uv_buf_t> V8Worker::BuildResponse(const std::string &payload,
                                               int8_t msg_type,
                                               int8_t response_opcode) {
-  std::vector<uv_buf_t> messages;
   flatbuffers::FlatBufferBuilder builder;
   auto msg_offset = builder.CreateString(payload);
   auto r = flatbuf::response::CreateResponse(builder, msg_type, response_opcode,
                                              msg_offset);
   builder.Finish(r);
+  return FrameResponse(builder);
+}
+
+std::vector<uv_buf_t> V8Worker::BuildBatchCheckpointResponse(
+    const std::vector<std::pair<int, uint64_t>> &vb_seq_nos) {
+  flatbuffers::FlatBufferBuilder builder;
+  std::vector<flatbuffers::Offset<flatbuf::response::VbSeqNo>> entries;
+  for (const auto &entry : vb_seq_nos) {
+    entries.push_back(
+        flatbuf::response::CreateVbSeqNo(builder, entry.first, entry.second));
+  }
+
+  auto entries_offset = builder.CreateVector(entries);
+  flatbuf::response::ResponseBuilder response_builder(builder);
+  response_builder.add_msg_type(mBucket_Ops_Response);
+  response_builder.add_opcode(batchCheckpointResponse);
+  response_builder.add_vb_seq_nos(entries_offset);
+  builder.Finish(response_builder.Finish());
+  return FrameResponse(builder);
+}
+
+std::vector<uv_buf_t>
+V8Worker::FrameResponse(flatbuffers::FlatBufferBuilder &builder) {
+  std::vector<uv_buf_t> messages;
   uint32_t length = builder.GetSize();
 
   char *header_buffer = new char[sizeof(uint32_t)];
@@ -1003,6 +1159,15 @@ std::vector<uv_buf_t> V8Worker::BuildResponse(const std::string &payload,
   return messages;
 }
 
+std::string
+V8Worker::ParseIdempotencyKey(const std::string &metadata_str) const {
+  auto metadata = nlohmann::json::parse(metadata_str, nullptr, false);
+  if (metadata.is_discarded() || !metadata.contains("idempotency_key")) {
+    return "";
+  }
+  return metadata["idempotency_key"].get<std::string>();
+}
+
 int V8Worker::ParseMetadata(const std::string &metadata, int &vb_no,
                             uint64_t &seq_no) const {
   int skip_ack;
@@ -1050,6 +1215,7 @@ void V8Worker::UpdateBucketopsSeqnoLocked(int vb_no, uint64_t seq_no) {
 void V8Worker::RemoveTimerPartition(int vb_no) {
   if (timer_store_) {
     timer_store_->RemovePartition(vb_no);
+    timer_firing_metrics.RemovePartition(vb_no);
   }
 }
 
@@ -1148,17 +1314,311 @@ std::unordered_set<int64_t> V8Worker::GetPartitions() const {
 }
 
 lcb_error_t V8Worker::SetTimer(timer::TimerInfo &tinfo) {
-  if (timer_store_)
-    return timer_store_->SetTimer(tinfo, data_.lcb_retry_count);
+  if (!timer_store_) {
+    return LCB_SUCCESS;
+  }
+
+  // Whether a timer is being replaced, and its chunks, are only known from
+  // its record, which there's no need to read when contexts never overflow
+  bool replacing = false;
+  std::string replaced;
+  if (context_overflow_ != timer::OverflowPolicy::reject) {
+    auto err = GetTimerContext(tinfo, replacing, replaced);
+    if (err != LCB_SUCCESS) {
+      return err;
+    }
+  }
+
+  auto err = timer_store_->SetTimer(tinfo, data_.lcb_retry_count);
+  if (err != LCB_SUCCESS) {
+    return err;
+  }
+  if (!replacing) {
+    timer_firing_metrics.AddPending(tinfo.vb, 1);
+  }
+
+  timer::Overflow replaced_overflow, overflow;
+  if (timer::UnwrapOverflowContext(replaced, replaced_overflow) &&
+      !(timer::UnwrapOverflowContext(tinfo.context, overflow) &&
+        overflow.chunk_ref == replaced_overflow.chunk_ref)) {
+    RemoveTimerChunks(replaced_overflow);
+  }
   return LCB_SUCCESS;
 }
 
+// Sets next occurrence of a recurring timer ahead of its callback, so that
+// cancelTimer from the callback ends the series. Context is replaced with the
+// one callback was created with, while next occurrence keeps context as it's
+// stored, along with any chunks it has.
+bool V8Worker::RearmSeries(const timer::TimerEvent &evt, std::string &context) {
+  timer::Schedule schedule;
+  auto series_context = context;
+  if (!timer::UnwrapSeriesContext(series_context, schedule, context)) {
+    return false;
+  }
+
+  timer::TimerInfo tinfo;
+  tinfo.callback = evt.callback;
+  tinfo.reference = schedule.reference;
+  tinfo.context = evt.context;
+  tinfo.seq_num = currently_processed_seqno_;
+  std::string err;
+  if (!timer::NextOccurrence(schedule, timer::GetUnixTime(), rng, tinfo.epoch,
+                             err)) {
+    LOG(logError) << "Recurring timer " << RU(schedule.reference)
+                  << " has no next occurrence, ending series: " << err
+                  << std::endl;
+    ++timer_series_rearm_failure;
+    return false;
+  }
+  Timer::FillTimerPartition(tinfo, num_vbuckets_);
+
+  auto lcb_err = SetTimer(tinfo);
+  if (lcb_err != LCB_SUCCESS) {
+    LOG(logError) << "Unable to rearm recurring timer "
+                  << RU(schedule.reference)
+                  << ", err: " << lcb_strerror(GetTimerLcbHandle(), lcb_err)
+                  << std::endl;
+    ++timer_series_rearm_failure;
+    return false;
+  }
+  ++timer_series_rearm_counter;
+  return true;
+}
+
 lcb_error_t V8Worker::DelTimer(timer::TimerInfo &tinfo) {
-  if (timer_store_)
-    return timer_store_->DelTimer(tinfo, data_.lcb_retry_count);
+  if (!timer_store_) {
+    return LCB_SUCCESS;
+  }
+
+  bool found = false;
+  std::string cancelled;
+  if (context_overflow_ != timer::OverflowPolicy::reject) {
+    auto err = GetTimerContext(tinfo, found, cancelled);
+    if (err != LCB_SUCCESS) {
+      return err;
+    }
+  }
+
+  // Store tells if there was no such timer
+  auto err = timer_store_->DelTimer(tinfo, data_.lcb_retry_count);
+  if (err != LCB_SUCCESS) {
+    return err;
+  }
+  timer_firing_metrics.AddPending(tinfo.vb, -1);
+
+  timer::Overflow overflow;
+  if (timer::UnwrapOverflowContext(cancelled, overflow)) {
+    RemoveTimerChunks(overflow);
+  }
+  return LCB_SUCCESS;
+}
+
+// Keeps context over timer_context_size in overflow. Chunks are named after
+// context record along with a random suffix, so that a timer replacing another
+// with the same reference doesn't overwrite chunks being fired.
+lcb_error_t V8Worker::PackTimerContext(timer::TimerInfo &tinfo) {
+  if (!timer_store_) {
+    return LCB_SUCCESS;
+  }
+
+  auto chunk_ref =
+      timer::BuildContextKey(timer_prefix_, tinfo.vb, tinfo.reference) +
+      ":ck:" + std::to_string(rng());
+  return timer::PackContext(GetTimerLcbHandle(), context_overflow_,
+                            timer_context_size, chunk_ref, tinfo.context,
+                            data_.lcb_retry_count);
+}
+
+// Reads context handler set for timer with the same reference, with found
+// false if there's no such timer
+lcb_error_t V8Worker::GetTimerContext(const timer::TimerInfo &tinfo,
+                                      bool &found, std::string &context) {
+  found = false;
+  auto key = timer::BuildContextKey(timer_prefix_, tinfo.vb, tinfo.reference);
+  lcb_CMDGET cmd = {0};
+  LCB_CMD_SET_KEY(&cmd, key.c_str(), key.length());
+
+  auto result = RetryLcbCommand(GetTimerLcbHandle(), cmd,
+                                data_.lcb_retry_count, LcbGet);
+  if (result.first != LCB_SUCCESS) {
+    return result.first;
+  }
+  if (result.second.rc == LCB_KEY_ENOENT) {
+    return LCB_SUCCESS;
+  }
+  if (result.second.rc != LCB_SUCCESS) {
+    return result.second.rc;
+  }
+  found = true;
+
+  auto record = nlohmann::json::parse(result.second.value, nullptr, false);
+  if (record.is_discarded() || !record.is_object()) {
+    return LCB_SUCCESS;
+  }
+  auto callback = record.find("ctx");
+  if (callback == record.end() || !callback->is_object()) {
+    return LCB_SUCCESS;
+  }
+  auto inner = callback->find("context");
+  if (inner != callback->end()) {
+    context = inner->dump();
+  }
   return LCB_SUCCESS;
 }
 
+// Returns false if timer can't be fired, with retry set when it's left for a
+// later scan. Chunks go missing when timer is being cancelled or replaced.
+bool V8Worker::UnpackTimerContext(const timer::Overflow &overflow,
+                                  std::string &context, bool &retry) {
+  bool found = false;
+  auto err = timer::UnpackContext(GetTimerLcbHandle(), overflow, context, found,
+                                  data_.lcb_retry_count);
+  if (err != LCB_SUCCESS) {
+    LOG(logError) << "Unable to unpack timer context " << RU(overflow.chunk_ref)
+                  << ", err: " << lcb_strerror(GetTimerLcbHandle(), err)
+                  << std::endl;
+    retry = err != LCB_EINVAL;
+    return false;
+  }
+  if (!found) {
+    LOG(logDebug) << "Chunks of timer context " << RU(overflow.chunk_ref)
+                  << " are missing, not firing it" << std::endl;
+  }
+  return found;
+}
+
+// Missed timers held for latest_only are fired once the scan is through, even
+// when it's stopped, as iterator has moved past them
+void V8Worker::ScanTimers() {
+  auto iter = timer_store_->GetIterator();
+  timer::TimerEvent evt;
+  std::unordered_map<std::string, timer::TimerEvent> held;
+  missed_timers_.StartScan();
+  auto scan_start = std::chrono::steady_clock::now();
+  while (!stop_timer_scan_.load() && iter.GetNext(evt)) {
+    auto scan_time = std::chrono::duration_cast<std::chrono::microseconds>(
+        std::chrono::steady_clock::now() - scan_start);
+    std::string superseded;
+    auto verdict = missed_timers_.Admit(evt.alarm_key, evt.context_key,
+                                        timer::GetUnixTime(), superseded);
+    // Rest of partition is left for later scans, other partitions go on
+    if (verdict == timer::MissedVerdict::defer) {
+      iter.SkipPartition();
+      continue;
+    }
+    if (verdict == timer::MissedVerdict::hold) {
+      if (!superseded.empty()) {
+        auto it = held.find(superseded);
+        if (it != held.end()) {
+          ProcessTimer(it->second, false, scan_time);
+          held.erase(it);
+        }
+      }
+      held.emplace(evt.alarm_key, evt);
+      scan_start = std::chrono::steady_clock::now();
+      continue;
+    }
+
+    if (!ProcessTimer(evt, verdict == timer::MissedVerdict::fire,
+                      scan_time)) {
+      break;
+    }
+    scan_start = std::chrono::steady_clock::now();
+  }
+
+  for (const auto &alarm_key : missed_timers_.Release()) {
+    auto it = held.find(alarm_key);
+    if (it == held.end()) {
+      continue;
+    }
+    if (!ProcessTimer(it->second, true, std::chrono::microseconds(0))) {
+      break;
+    }
+  }
+  if (stop_timer_scan_.load()) {
+    timer_store_->SyncSpan();
+  }
+  SyncPendingTimers();
+}
+
+// Fires timer, or only deletes it when fire is false. Returns false when
+// context couldn't be read for now, which the scan stops for.
+bool V8Worker::ProcessTimer(timer::TimerEvent &evt, bool fire,
+                            std::chrono::microseconds scan_time) {
+  int64_t partition = -1, due = 0;
+  timer::ParseAlarmKey(evt.alarm_key, partition, due);
+
+  auto context = evt.context;
+  timer::Overflow overflow;
+  auto packed = timer::UnwrapOverflowContext(evt.context, overflow);
+  if (packed) {
+    bool retry = false;
+    if (!UnpackTimerContext(overflow, context, retry)) {
+      if (retry) {
+        return false;
+      }
+      timer_store_->DeleteTimer(evt);
+      timer_firing_metrics.AddPending(partition, -1);
+      return true;
+    }
+  }
+
+  auto rearmed = RearmSeries(evt, context);
+  if (fire) {
+    ++timer_msg_counter;
+    if (partition >= 0) {
+      timer_firing_metrics.RecordFire(partition, due, scan_time);
+    }
+    this->SendTimer(evt.callback, context);
+  }
+  timer_store_->DeleteTimer(evt);
+  // Next occurrence of a series reuses chunks of the one fired, and takes its
+  // place in pending count
+  if (!rearmed) {
+    if (packed) {
+      RemoveTimerChunks(overflow);
+    }
+    timer_firing_metrics.AddPending(partition, -1);
+  }
+  return true;
+}
+
+// Adds alarms written and removed since last scan to pending counts. Counts
+// are only informational, so failing to update one doesn't fail the scan, and
+// the changes are left for next one.
+void V8Worker::SyncPendingTimers() {
+  for (const auto &entry : timer_firing_metrics.TakePending()) {
+    auto partition = entry.first;
+    auto delta = entry.second;
+
+    int64_t count = 0;
+    auto err = timer::CountPending(GetTimerLcbHandle(), timer_prefix_,
+                                   partition, delta, count,
+                                   data_.lcb_retry_count);
+    if (err != LCB_SUCCESS) {
+      LOG(logWarning) << "Unable to update pending timers of partition "
+                      << partition << " by " << delta
+                      << ", err: " << lcb_strerror(GetTimerLcbHandle(), err)
+                      << std::endl;
+      timer_firing_metrics.AddPending(partition, delta);
+      continue;
+    }
+    timer_firing_metrics.SetPending(partition, count);
+  }
+}
+
+void V8Worker::RemoveTimerChunks(const timer::Overflow &overflow) {
+  auto err = timer::RemoveChunks(GetTimerLcbHandle(), overflow,
+                                 data_.lcb_retry_count);
+  if (err != LCB_SUCCESS) {
+    LOG(logError) << "Unable to remove chunks of timer context "
+                  << RU(overflow.chunk_ref)
+                  << ", err: " << lcb_strerror(GetTimerLcbHandle(), err)
+                  << std::endl;
+  }
+}
+
 lcb_t V8Worker::GetTimerLcbHandle() const {
   return timer_store_->GetTimerStoreHandle();
 }
@@ -1185,6 +1645,11 @@ std::string GetFunctionInstanceID(v8::Isolate *isolate) {
   return w->GetFunctionInstanceID();
 }
 
+std::string GetIdempotencyKey(v8::Isolate *isolate) {
+  auto w = UnwrapData(isolate)->v8worker;
+  return w->GetIdempotencyKey();
+}
+
 void UpdateCurlLatencyHistogram(
     v8::Isolate *isolate,
     const std::chrono::high_resolution_clock::time_point &start) {
@@ -0,0 +1,79 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Pins bins to those of histogram of timers package, which timer firing stats
+// of worker are reported along with
+
+#include <string>
+
+#include "histogram.h"
+#include "test_util.h"
+
+namespace {
+void TestDefaultRange() {
+  // Handler latency, in microseconds
+  Histogram histogram;
+  histogram.Add(1000 * 1000 * 100);
+
+  StatsTable::StatList stats;
+  histogram.Drain(stats);
+  EXPECT(stats.size() == 1 && stats[0].first == "9999900",
+         "expected sample past range to fall in last bin");
+}
+
+void TestConfiguredRange() {
+  // Fire lag of timers, in milliseconds
+  Histogram histogram(100, 1000 * 60 * 60, 100);
+  struct {
+    int64_t sample;
+    std::string bin;
+  } tests[] = {
+      {-5000, "100"},
+      {100, "100"},
+      {101, "100"},
+      {250, "200"},
+      {1000 * 60 * 60 - 1, "3599900"},
+      {1000 * 60 * 60 * 24, "3599900"},
+  };
+
+  for (const auto &test : tests) {
+    histogram.Add(test.sample);
+    StatsTable::StatList stats;
+    histogram.Drain(stats, "0:fire_lag:");
+    EXPECT(stats.size() == 1 && stats[0].first == "0:fire_lag:" + test.bin &&
+               stats[0].second == 1,
+           test.sample << ": expected bin " << test.bin << ", got "
+                       << (stats.empty() ? "none" : stats[0].first));
+  }
+}
+
+void TestDrainMovesSamples() {
+  Histogram histogram(1, 10, 1);
+  histogram.Add(3);
+  histogram.Add(3);
+
+  StatsTable::StatList stats;
+  histogram.Drain(stats);
+  EXPECT(stats.size() == 1 && stats[0].first == "3" && stats[0].second == 2,
+         "expected 2 samples in bin 3");
+
+  stats.clear();
+  histogram.Drain(stats);
+  EXPECT(stats.empty(), "expected samples to be drained once");
+}
+} // namespace
+
+int main() {
+  TestDefaultRange();
+  TestConfiguredRange();
+  TestDrainMovesSamples();
+  return test::Failures() == 0 ? 0 : 1;
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+#ifndef COUCHBASE_TEST_UTIL_H
+#define COUCHBASE_TEST_UTIL_H
+
+#include <iostream>
+
+namespace test {
+// Number of checks failed so far, returned by main of each test executable
+inline int &Failures() {
+  static int failures = 0;
+  return failures;
+}
+} // namespace test
+
+// Reports a failed check along with where it is, and carries on with the rest
+#define EXPECT(cond, msg)                                                      \
+  do {                                                                         \
+    if (!(cond)) {                                                             \
+      std::cerr << __FILE__ << ":" << __LINE__ << ": " << msg << std::endl;    \
+      ++test::Failures();                                                      \
+    }                                                                          \
+  } while (0)
+
+#endif // COUCHBASE_TEST_UTIL_H
@@ -0,0 +1,167 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Runs the same cases as missed_test.go of timers package, over verdicts the
+// worker acts on while scanning
+
+#include <string>
+#include <vector>
+
+#include "test_util.h"
+#include "timer_missed.h"
+
+namespace {
+const int64_t now = 10000;
+
+// Same layout as BuildAlarmKey, which lives with the timer store
+std::string AlarmKey(int64_t partition, int64_t due, int64_t seq = 0) {
+  return "eventing:bucket:tm:" + std::to_string(partition) + ":al:" +
+         std::to_string(due) + ":" + std::to_string(seq);
+}
+
+std::string ContextKey(const std::string &ref) {
+  return "eventing:bucket:tm:0:cx:" + ref;
+}
+
+timer::MissedVerdict Admit(timer::MissedTimers &missed,
+                           const std::string &alarm_key,
+                           const std::string &ref = "a") {
+  std::string superseded;
+  return missed.Admit(alarm_key, ContextKey(ref), now, superseded);
+}
+
+void TestParseAlarmKey() {
+  struct {
+    std::string key;
+    bool valid;
+    int64_t partition, due;
+  } tests[] = {
+      {AlarmKey(12, 1570000000, 3), true, 12, 1570000000},
+      {"app:with:colons:tm:1023:al:42:0", true, 1023, 42},
+      {"eventing:tm:1:cx:abc", false, 0, 0},
+      {"eventing:al:42:0", false, 0, 0},
+      {"eventing:tm:x:al:42:0", false, 0, 0},
+      {"eventing:tm:1:al:-42:0", false, 0, 0},
+      {"eventing:tm:1:al:42", false, 0, 0},
+  };
+
+  for (const auto &test : tests) {
+    int64_t partition = 0, due = 0;
+    auto valid = timer::ParseAlarmKey(test.key, partition, due);
+    EXPECT(valid == test.valid,
+           test.key << ": expected valid " << test.valid << ", got " << valid);
+    if (valid && test.valid) {
+      EXPECT(partition == test.partition && due == test.due,
+             test.key << ": expected partition " << test.partition << " due "
+                      << test.due << ", got " << partition << " " << due);
+    }
+  }
+}
+
+void TestParseMissedPolicy() {
+  EXPECT(timer::ParseMissedPolicy("latest_only") ==
+             timer::MissedPolicy::latest_only,
+         "expected latest_only to be parsed");
+  EXPECT(timer::ParseMissedPolicy("rate_limit") ==
+             timer::MissedPolicy::rate_limit,
+         "expected rate_limit to be parsed");
+  EXPECT(timer::ParseMissedPolicy("drop") == timer::MissedPolicy::drop,
+         "expected drop to be parsed");
+  EXPECT(timer::ParseMissedPolicy("unknown") == timer::MissedPolicy::fire_all,
+         "expected unknown policy to fall back to fire_all");
+}
+
+void TestWithinThreshold() {
+  timer::MissedTimers missed(timer::MissedPolicy::drop, 60, 0);
+  missed.StartScan();
+  EXPECT(Admit(missed, AlarmKey(0, now - 60)) == timer::MissedVerdict::fire,
+         "expected timer within threshold to fire");
+  EXPECT(Admit(missed, AlarmKey(0, now - 61)) == timer::MissedVerdict::drop,
+         "expected timer past threshold to be dropped");
+  EXPECT(Admit(missed, "not an alarm key") == timer::MissedVerdict::fire,
+         "expected timer whose key can't be read to fire");
+}
+
+void TestLatestOnly() {
+  timer::MissedTimers missed(timer::MissedPolicy::latest_only, 0, 0);
+  missed.StartScan();
+  auto coalesced = timer_missed_coalesced_counter.load();
+  auto fired = timer_missed_fired_counter.load();
+
+  struct {
+    std::string alarm_key, ref;
+    timer::MissedVerdict verdict;
+    std::string superseded;
+  } tests[] = {
+      {AlarmKey(0, now - 400), "a", timer::MissedVerdict::hold, ""},
+      {AlarmKey(0, now - 300), "b", timer::MissedVerdict::hold, ""},
+      {AlarmKey(0, now - 200), "a", timer::MissedVerdict::hold,
+       AlarmKey(0, now - 400)},
+      {AlarmKey(1, now - 500), "a", timer::MissedVerdict::drop, ""},
+  };
+  for (const auto &test : tests) {
+    std::string superseded;
+    auto verdict =
+        missed.Admit(test.alarm_key, ContextKey(test.ref), now, superseded);
+    EXPECT(verdict == test.verdict && superseded == test.superseded,
+           test.alarm_key << ": expected verdict "
+                          << static_cast<int>(test.verdict) << " superseding \""
+                          << test.superseded << "\", got "
+                          << static_cast<int>(verdict) << " \"" << superseded
+                          << "\"");
+  }
+  EXPECT(timer_missed_coalesced_counter.load() - coalesced == 2,
+         "expected 2 earlier timers of a to be coalesced");
+
+  std::vector<std::string> expected = {AlarmKey(0, now - 300),
+                                       AlarmKey(0, now - 200)};
+  EXPECT(missed.Release() == expected,
+         "expected latest timer of each reference, earliest first");
+  EXPECT(timer_missed_fired_counter.load() - fired == 2,
+         "expected released timers to be counted as fired");
+  EXPECT(missed.Release().empty(), "expected timers to be released once");
+
+  // Held timers don't carry over to the next scan
+  missed.StartScan();
+  EXPECT(Admit(missed, AlarmKey(0, now - 100)) == timer::MissedVerdict::hold,
+         "expected timer to be held afresh in next scan");
+}
+
+void TestRateLimitDefersPartition() {
+  timer::MissedTimers missed(timer::MissedPolicy::rate_limit, 0, 1);
+  missed.StartScan();
+  auto deferred = timer_missed_deferred_counter.load();
+
+  EXPECT(Admit(missed, AlarmKey(0, now - 100)) == timer::MissedVerdict::fire,
+         "expected first missed timer of partition 0 to fire within rate");
+  EXPECT(Admit(missed, AlarmKey(0, now - 90)) == timer::MissedVerdict::defer,
+         "expected timer over rate to be deferred");
+  EXPECT(Admit(missed, AlarmKey(0, now)) == timer::MissedVerdict::defer,
+         "expected timer on time to be deferred behind deferred partition");
+  EXPECT(Admit(missed, AlarmKey(1, now - 100)) == timer::MissedVerdict::fire,
+         "expected other partition to go on firing");
+  EXPECT(timer_missed_deferred_counter.load() - deferred == 1,
+         "expected only timer over rate to be counted as deferred");
+
+  missed.StartScan();
+  EXPECT(Admit(missed, AlarmKey(0, now)) == timer::MissedVerdict::fire,
+         "expected partition to be scanned again in next scan");
+}
+} // namespace
+
+int main() {
+  TestParseAlarmKey();
+  TestParseMissedPolicy();
+  TestWithinThreshold();
+  TestLatestOnly();
+  TestRateLimitDefersPartition();
+  return test::Failures() == 0 ? 0 : 1;
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2019 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+// or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Runs the same cases as schedule_test.go of timers package, so that both
+// cron engines agree on what they accept and when occurrences are due
+
+#include <ctime>
+#include <random>
+#include <string>
+
+#include "test_util.h"
+#include "timer_schedule.h"
+
+namespace {
+int64_t At(const std::string &value) {
+  struct tm tm = {};
+  strptime(value.c_str(), "%Y-%m-%dT%H:%M:%SZ", &tm);
+  return timegm(&tm);
+}
+
+std::string Format(int64_t value) {
+  time_t t = value;
+  struct tm tm;
+  gmtime_r(&t, &tm);
+  char buf[32];
+  strftime(buf, sizeof(buf), "%Y-%m-%dT%H:%M:%SZ", &tm);
+  return buf;
+}
+
+timer::Schedule Cron(const std::string &cron) {
+  timer::Schedule schedule;
+  schedule.cron = cron;
+  return schedule;
+}
+
+timer::Schedule Interval(int64_t interval, int64_t jitter, int64_t start) {
+  timer::Schedule schedule;
+  schedule.interval = interval;
+  schedule.jitter = jitter;
+  schedule.start = start;
+  return schedule;
+}
+
+void TestParseCron() {
+  const uint64_t all_minutes = (uint64_t(1) << 60) - 1;
+  const uint64_t all_days = (uint64_t(1) << 8) - 1;
+  struct {
+    std::string spec;
+    bool valid;
+    uint64_t minute, dow;
+  } tests[] = {
+      {"* * * * *", true, all_minutes, all_days},
+      {"0 0 * * *", true, 1, all_days},
+      {"5,10 * * * *", true, 1 << 5 | 1 << 10, all_days},
+      {"10-12 * * * *", true, 1 << 10 | 1 << 11 | 1 << 12, all_days},
+      {"*/20 * * * *", true, 1 | 1 << 20 | uint64_t(1) << 40, all_days},
+      {"50/5 * * * *", true, uint64_t(1) << 50 | uint64_t(1) << 55, all_days},
+      {"10-20/5 * * * *", true, 1 << 10 | 1 << 15 | 1 << 20, all_days},
+      {"0 0 * * 7", true, 1, 1 | 1 << 7},
+      {"0 0 * * 1-5", true, 1, 1 << 1 | 1 << 2 | 1 << 3 | 1 << 4 | 1 << 5},
+      {"@hourly", true, 1, all_days},
+      {"  @weekly  ", true, 1, 1},
+      {"@every", false, 0, 0},
+      {"", false, 0, 0},
+      {"* * * *", false, 0, 0},
+      {"* * * * * *", false, 0, 0},
+      {"60 * * * *", false, 0, 0},
+      {"* 24 * * *", false, 0, 0},
+      {"* * 0 * *", false, 0, 0},
+      {"* * 32 * *", false, 0, 0},
+      {"* * * 13 *", false, 0, 0},
+      {"* * * * 8", false, 0, 0},
+      {"20-10 * * * *", false, 0, 0},
+      {"*/0 * * * *", false, 0, 0},
+      {"*/x * * * *", false, 0, 0},
+      {"1,,2 * * * *", false, 0, 0},
+      {"a * * * *", false, 0, 0},
+      {"1- * * * *", false, 0, 0},
+      {"-1 * * * *", false, 0, 0},
+  };
+
+  for (const auto &test : tests) {
+    timer::CronExpr expr;
+    std::string err;
+    auto valid = timer::ParseCron(test.spec, expr, err);
+    if (!test.valid) {
+      EXPECT(!valid, '"' << test.spec << "\": expected error");
+      continue;
+    }
+    EXPECT(valid, '"' << test.spec << "\": unexpected error: " << err);
+    EXPECT(expr.minute == test.minute && expr.dow == test.dow,
+           '"' << test.spec << "\": expected minute " << test.minute
+               << " dow " << test.dow << ", got minute " << expr.minute
+               << " dow " << expr.dow);
+  }
+}
+
+void TestNextOccurrence() {
+  struct {
+    std::string name;
+    timer::Schedule schedule;
+    std::string now, expected;
+  } tests[] = {
+      {"every minute", Cron("* * * * *"), "2023-11-14T22:13:20Z",
+       "2023-11-14T22:14:00Z"},
+      {"strictly after now", Cron("* * * * *"), "2023-11-14T22:14:00Z",
+       "2023-11-14T22:15:00Z"},
+      {"daily", Cron("@daily"), "2023-11-14T22:13:20Z",
+       "2023-11-15T00:00:00Z"},
+      {"weekdays", Cron("*/15 9-17 * * 1-5"), "2023-11-17T17:50:00Z",
+       "2023-11-20T09:00:00Z"},
+      {"sunday as 7", Cron("5 4 * * 7"), "2023-11-14T22:13:20Z",
+       "2023-11-19T04:05:00Z"},
+      {"leap day", Cron("0 0 29 2 *"), "2023-11-14T22:13:20Z",
+       "2024-02-29T00:00:00Z"},
+      {"day of month or week", Cron("0 0 13 * 5"), "2023-11-14T22:13:20Z",
+       "2023-11-17T00:00:00Z"},
+      {"month rollover", Cron("0 12 1 * *"), "2023-12-31T23:59:00Z",
+       "2024-01-01T12:00:00Z"},
+      {"interval", Interval(60, 0, At("2023-11-14T22:13:20Z")),
+       "2023-11-14T22:13:50Z", "2023-11-14T22:14:20Z"},
+      {"interval catching up", Interval(60, 0, At("2023-11-14T22:13:20Z")),
+       "2023-11-14T22:16:20Z", "2023-11-14T22:17:20Z"},
+  };
+
+  std::mt19937_64 gen;
+  for (const auto &test : tests) {
+    int64_t due = 0;
+    std::string err;
+    auto ok = timer::NextOccurrence(test.schedule, At(test.now), gen, due, err);
+    EXPECT(ok, test.name << ": unexpected error: " << err);
+    EXPECT(due == At(test.expected), test.name << ": expected "
+                                               << test.expected << ", got "
+                                               << Format(due));
+  }
+}
+
+void TestNextOccurrenceNeverDue() {
+  std::mt19937_64 gen;
+  int64_t due = 0;
+  std::string err;
+  EXPECT(!timer::NextOccurrence(Cron("0 0 30 2 *"), std::time(nullptr), gen,
+                                due, err),
+         "expected error for cron that never fires");
+}
+
+void TestNextOccurrenceJitter() {
+  std::mt19937_64 gen;
+  for (int i = 0; i < 100; ++i) {
+    int64_t due = 0;
+    std::string err;
+    EXPECT(timer::NextOccurrence(Interval(60, 10, 1000), 1000, gen, due, err),
+           "unexpected error: " << err);
+    EXPECT(due >= 1060 && due <= 1070,
+           "expected next within jitter of 1060, got " << due);
+  }
+}
+
+void TestValidateSchedule() {
+  auto both = Cron("@daily");
+  both.interval = 60;
+  struct {
+    timer::Schedule schedule;
+    bool valid;
+  } tests[] = {
+      {Cron("@daily"), true},         {Interval(60, 5, 0), true},
+      {timer::Schedule(), false},     {both, false},
+      {Interval(-1, 0, 0), false},    {Interval(60, -1, 0), false},
+      {Cron("61 * * * *"), false},
+  };
+
+  for (const auto &test : tests) {
+    auto err = timer::ValidateSchedule(test.schedule, 1);
+    EXPECT(test.valid == err.empty(),
+           "cron \"" << test.schedule.cron << "\" interval "
+                     << test.schedule.interval << " jitter "
+                     << test.schedule.jitter << ": expected valid "
+                     << test.valid << ", got err: " << err);
+  }
+
+  EXPECT(!timer::ValidateSchedule(Interval(5, 0, 0), 7).empty(),
+         "expected interval shorter than resolution of store to be rejected");
+}
+} // namespace
+
+int main() {
+  TestParseCron();
+  TestNextOccurrence();
+  TestNextOccurrenceNeverDue();
+  TestNextOccurrenceJitter();
+  TestValidateSchedule();
+  return test::Failures() == 0 ? 0 : 1;
+}